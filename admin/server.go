@@ -0,0 +1,356 @@
+// Package admin serves the allocations of the controller over HTTP for
+// operators.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/audit"
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Paths of the admin API.
+const (
+	// StatePath serves the State of the store.
+	StatePath = "/api/v1/state"
+	// ReleasePath force-releases the allocation given by the allocation
+	// query parameter, of the form namespace/name:port.
+	ReleasePath = "/api/v1/release"
+	// ResyncPath reconciles the Service given by the service query
+	// parameter, of the form namespace/name.
+	ResyncPath = "/api/v1/resync"
+	// AuditPath serves the records of the audit trail selected by the
+	// service, nlb, since, until and limit query parameters, oldest first.
+	// since and until are RFC 3339 times.
+	AuditPath = "/api/v1/audit"
+	// SnapshotPath serves the Snapshot of the store on GET, and imports the
+	// Snapshot in the body of a POST into the store, answering the
+	// ImportResult.
+	SnapshotPath = "/api/v1/snapshot"
+	// VerifyPath serves the Report of a consistency check of the store
+	// against the annotations of the Services and the listeners in AWS.
+	VerifyPath = "/api/v1/verify"
+	// PlanPath serves the AWS changes a controller in dry run did not make,
+	// in the order they were first planned.
+	PlanPath = "/api/v1/plan"
+)
+
+// maxSnapshotSize is the largest snapshot accepted for import.
+const maxSnapshotSize = 64 << 20
+
+// ErrNotFound is returned by Release and Resync for unknown allocations and
+// services.
+var ErrNotFound = errors.New("not found")
+
+// State is the content of the store.
+type State struct {
+	NLBs        []NLBState   `json:"nlbs"`
+	Allocations []Allocation `json:"allocations"`
+}
+
+// NLBState is an NLB of the pool, its utilization and the allocations on its
+// ports.
+type NLBState struct {
+	Name           string         `json:"name"`
+	Host           string         `json:"host"`
+	AllocatedPorts int            `json:"allocatedPorts"`
+	FreePorts      int            `json:"freePorts"`
+	Ports          map[int]string `json:"ports"`
+}
+
+// Allocation is the NLB port and listener allocated to a Service port.
+type Allocation struct {
+	Service     string `json:"service"`
+	NLB         string `json:"nlb"`
+	Port        int    `json:"port"`
+	ListenerArn string `json:"listenerArn"`
+	TargetArn   string `json:"targetArn"`
+}
+
+// Server serves the admin API on Addr to clients presenting Token as a
+// bearer token. It runs on every replica, but only the leader loads the store;
+// the others answer 503.
+type Server struct {
+	Addr  string
+	Token string
+	Store store.Store
+
+	// Release deletes the listener and target group of an allocation and
+	// frees its port, and Resync reconciles a Service. The endpoints of nil
+	// actions are not served.
+	Release func(ctx context.Context, allocation string) error
+	Resync  func(ctx context.Context, service string) error
+
+	// Audit, if set, answers queries of the audit trail.
+	Audit audit.Querier
+
+	// Verify, if set, checks the store for consistency.
+	Verify func(ctx context.Context) (Report, error)
+
+	// Plan, if set, returns the AWS changes of a dry run.
+	Plan func() []aws.Mutation
+
+	// StoreReady, if set, is closed once Store has been loaded.
+	StoreReady <-chan struct{}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves the admin API until ctx is done.
+func (s *Server) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(StatePath, s.authorized(s.state))
+	mux.HandleFunc(SnapshotPath, s.authorized(s.snapshotHandler))
+	if s.Release != nil {
+		mux.HandleFunc(ReleasePath, s.authorized(s.action("allocation", s.Release)))
+	}
+	if s.Resync != nil {
+		mux.HandleFunc(ResyncPath, s.authorized(s.action("service", s.Resync)))
+	}
+	if s.Audit != nil {
+		mux.HandleFunc(AuditPath, s.authorized(s.audit))
+	}
+	if s.Verify != nil {
+		mux.HandleFunc(VerifyPath, s.authorized(s.verify))
+	}
+	if s.Plan != nil {
+		mux.HandleFunc(PlanPath, s.authorized(s.plan))
+	}
+	srv := &http.Server{
+		Addr:              s.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.FromContext(ctx).Info("admin: serving", "addr", s.Addr)
+		errs <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// authorized rejects requests without the bearer token, and requests made
+// before the store is loaded.
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if s.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if s.StoreReady != nil {
+			select {
+			case <-s.StoreReady:
+			default:
+				http.Error(w, "store not loaded, this replica is not the leader", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) state(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.snapshot(r.Context())); err != nil {
+		log.FromContext(r.Context()).Error(err, "admin: unable to write state")
+	}
+}
+
+func (s *Server) snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	var response interface{}
+	switch r.Method {
+	case http.MethodGet:
+		response = Export(r.Context(), s.Store)
+	case http.MethodPost:
+		var snapshot Snapshot
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSnapshotSize)).Decode(&snapshot); err != nil {
+			http.Error(w, "invalid snapshot: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err := Import(r.Context(), s.Store, snapshot)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.FromContext(r.Context()).Info("admin: imported snapshot", "imported", result.Imported, "skipped", result.Skipped, "failed", len(result.Failed))
+		response = result
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.FromContext(r.Context()).Error(err, "admin: unable to write snapshot")
+	}
+}
+
+func (s *Server) verify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report, err := s.Verify(r.Context())
+	if err != nil {
+		log.FromContext(r.Context()).Error(err, "admin: unable to verify store")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.FromContext(r.Context()).Error(err, "admin: unable to write verify report")
+	}
+}
+
+func (s *Server) plan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.Plan()); err != nil {
+		log.FromContext(r.Context()).Error(err, "admin: unable to write plan")
+	}
+}
+
+func (s *Server) audit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := auditQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	records, err := s.Audit.Query(r.Context(), q)
+	if err != nil {
+		log.FromContext(r.Context()).Error(err, "admin: unable to query audit trail")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		log.FromContext(r.Context()).Error(err, "admin: unable to write audit records")
+	}
+}
+
+// auditQuery parses the query parameters of AuditPath.
+func auditQuery(values url.Values) (audit.Query, error) {
+	q := audit.Query{Service: values.Get("service"), NLB: values.Get("nlb")}
+	for param, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := values.Get(param); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s: %w", param, err)
+			}
+			*t = parsed
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// action serves an action on the object named by the query parameter param.
+func (s *Server) action(param string, do func(context.Context, string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := r.URL.Query().Get(param)
+		if name == "" {
+			http.Error(w, "missing "+param, http.StatusBadRequest)
+			return
+		}
+		err := do(r.Context(), name)
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.FromContext(r.Context()).Error(err, "admin: action failed", param, name)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// snapshot collects the state of the store, sorted by name.
+func (s *Server) snapshot(ctx context.Context) State {
+	state := State{NLBs: []NLBState{}, Allocations: []Allocation{}}
+	ports := map[string]map[int]string{}
+	for _, a := range s.Store.ListAllocations(ctx) {
+		state.Allocations = append(state.Allocations, Allocation{
+			Service:     a.ServiceNamespacedName,
+			NLB:         a.NLB,
+			Port:        a.Port,
+			ListenerArn: a.ListenerArn,
+			TargetArn:   a.TargetArn,
+		})
+		if ports[a.NLB] == nil {
+			ports[a.NLB] = map[int]string{}
+		}
+		ports[a.NLB][a.Port] = a.ServiceNamespacedName
+	}
+	sort.Slice(state.Allocations, func(i, j int) bool {
+		return state.Allocations[i].Service < state.Allocations[j].Service
+	})
+
+	for _, nlb := range s.Store.ListNLBs() {
+		allocated, free := s.Store.PoolUsage(nlb)
+		nlbPorts := ports[nlb]
+		if nlbPorts == nil {
+			nlbPorts = map[int]string{}
+		}
+		state.NLBs = append(state.NLBs, NLBState{
+			Name:           nlb,
+			Host:           s.Store.GetNLBHost(nlb),
+			AllocatedPorts: allocated,
+			FreePorts:      free,
+			Ports:          nlbPorts,
+		})
+	}
+	sort.Slice(state.NLBs, func(i, j int) bool {
+		return state.NLBs[i].Name < state.NLBs[j].Name
+	})
+	return state
+}
@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+)
+
+// SnapshotVersion is the version of the Snapshot format.
+const SnapshotVersion = 1
+
+// Snapshot is the allocation state of a store, for restoring it into
+// another controller, such as one with another store backend.
+type Snapshot struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	// NLBs are the NLBs of the pool. They are not imported: the controller
+	// importing the snapshot must manage the NLBs of its allocations.
+	NLBs        []string             `json:"nlbs"`
+	Allocations []SnapshotAllocation `json:"allocations"`
+}
+
+// SnapshotAllocation is an allocation of a Snapshot.
+type SnapshotAllocation struct {
+	Allocation
+	Retained    bool       `json:"retained,omitempty"`
+	Sticky      bool       `json:"sticky,omitempty"`
+	StickyUntil *time.Time `json:"stickyUntil,omitempty"`
+}
+
+// ImportResult reports the allocations of a Snapshot that were imported,
+// skipped because the store already holds them or they expired, and those
+// that failed, such as because their NLB is not managed or their port is
+// allocated to another Service.
+type ImportResult struct {
+	Imported int               `json:"imported"`
+	Skipped  int               `json:"skipped"`
+	Failed   map[string]string `json:"failed,omitempty"`
+}
+
+// Export returns the snapshot of the allocations of s, sorted by name.
+func Export(ctx context.Context, s store.Store) Snapshot {
+	snapshot := Snapshot{Version: SnapshotVersion, Time: time.Now().UTC(), NLBs: s.ListNLBs(), Allocations: []SnapshotAllocation{}}
+	sort.Strings(snapshot.NLBs)
+	for _, a := range s.ListAllocations(ctx) {
+		allocation := SnapshotAllocation{
+			Allocation: Allocation{
+				Service:     a.ServiceNamespacedName,
+				NLB:         a.NLB,
+				Port:        a.Port,
+				ListenerArn: a.ListenerArn,
+				TargetArn:   a.TargetArn,
+			},
+			Retained: a.Retained,
+			Sticky:   a.Sticky,
+		}
+		if a.Sticky {
+			until := a.StickyUntil.UTC()
+			allocation.StickyUntil = &until
+		}
+		snapshot.Allocations = append(snapshot.Allocations, allocation)
+	}
+	sort.Slice(snapshot.Allocations, func(i, j int) bool {
+		return snapshot.Allocations[i].Service < snapshot.Allocations[j].Service
+	})
+	return snapshot
+}
+
+// Import records the allocations of a snapshot in s. Allocations s already
+// holds are skipped, and sticky allocations whose retention is over are
+// dropped. Imported sticky allocations start the sticky retention of s over.
+// Neither listeners nor target groups are created or checked: the next
+// reconciles of the Services do.
+func Import(ctx context.Context, s store.Store, snapshot Snapshot) (ImportResult, error) {
+	result := ImportResult{Failed: map[string]string{}}
+	if snapshot.Version != SnapshotVersion {
+		return result, fmt.Errorf("unsupported snapshot version %d, want %d", snapshot.Version, SnapshotVersion)
+	}
+	now := time.Now()
+	for _, a := range snapshot.Allocations {
+		if a.Sticky && a.StickyUntil != nil && !a.StickyUntil.After(now) {
+			result.Skipped++
+			continue
+		}
+		if existing := s.GetAllocationForSVC(ctx, a.Service); existing != nil && existing.NLB == a.NLB && existing.Port == a.Port &&
+			existing.ListenerArn == a.ListenerArn && existing.TargetArn == a.TargetArn &&
+			existing.Retained == a.Retained && existing.Sticky == a.Sticky {
+			result.Skipped++
+			continue
+		}
+		if err := importAllocation(ctx, s, a); err != nil {
+			result.Failed[a.Service] = err.Error()
+			continue
+		}
+		result.Imported++
+	}
+	return result, nil
+}
+
+func importAllocation(ctx context.Context, s store.Store, a SnapshotAllocation) error {
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, a.NLB, a.Port, a.Service, a.ListenerArn, a.TargetArn); err != nil {
+		return err
+	}
+	switch {
+	case a.Retained:
+		return s.RetainNLBAndPortForService(ctx, a.Service)
+	case a.Sticky:
+		return s.StickNLBAndPortForService(ctx, a.Service)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	nlbs := []store.NLB{
+		{Name: "public", Host: "public.elb.amazonaws.com", PortRange: store.PortRange{Min: 10000, Max: 10099}},
+		{Name: "internal", Host: "internal.elb.amazonaws.com", PortRange: store.PortRange{Min: 10000, Max: 10099}},
+	}
+	source := store.New(nlbs...)
+	source.SetStickyRetention(time.Hour)
+	for _, a := range []Allocation{
+		{Service: "default/web:http", NLB: "public", Port: 10000, ListenerArn: "listener/web", TargetArn: "targetgroup/web"},
+		{Service: "default/old:http", NLB: "public", Port: 10001, ListenerArn: "listener/old", TargetArn: "targetgroup/old"},
+		{Service: "default/gone:http", NLB: "internal", Port: 10005, ListenerArn: "listener/gone", TargetArn: "targetgroup/gone"},
+	} {
+		if err := source.AssignNLBAndPortToServiceInNamespace(ctx, a.NLB, a.Port, a.Service, a.ListenerArn, a.TargetArn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := source.RetainNLBAndPortForService(ctx, "default/old:http"); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.StickNLBAndPortForService(ctx, "default/gone:http"); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(Export(ctx, source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(body, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	target := store.New(nlbs[0])
+	target.SetStickyRetention(time.Hour)
+	if err := target.AssignNLBAndPortToServiceInNamespace(ctx, "public", 10000, "default/web:http", "listener/web", "targetgroup/web"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Import(ctx, target, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 1 || result.Skipped != 1 || len(result.Failed) != 1 || result.Failed["default/gone:http"] == "" {
+		t.Errorf("Import() = %+v, want old imported, web skipped and gone failed on the unmanaged nlb", result)
+	}
+	if got := target.GetAllocationForSVC(ctx, "default/old:http"); got == nil || !got.Retained || got.Port != 10001 || got.ListenerArn != "listener/old" {
+		t.Errorf("imported allocation = %+v, want the retained allocation of port 10001", got)
+	}
+
+	target.AddNLB(nlbs[1])
+	if result, err := Import(ctx, target, snapshot); err != nil || result.Imported != 1 || result.Skipped != 2 {
+		t.Errorf("Import() = %+v, %v again with the nlb of gone, want gone imported", result, err)
+	}
+	if got, want := Export(ctx, target).Allocations, snapshot.Allocations; !reflect.DeepEqual(allocationKeys(got), allocationKeys(want)) {
+		t.Errorf("exported %v after the import, want %v", allocationKeys(got), allocationKeys(want))
+	}
+
+	snapshot.Version = 2
+	if _, err := Import(ctx, target, snapshot); err == nil {
+		t.Error("Import() error = nil for an unsupported version")
+	}
+}
+
+func allocationKeys(allocations []SnapshotAllocation) []string {
+	var keys []string
+	for _, a := range allocations {
+		keys = append(keys, a.Service+"@"+a.NLB)
+	}
+	return keys
+}
@@ -0,0 +1,57 @@
+package admin
+
+import "time"
+
+// MismatchKind is the kind of a Mismatch between the store, the annotations
+// of the Services and the listeners in AWS.
+type MismatchKind string
+
+const (
+	// StoreInconsistent is reported when the store fails its own check.
+	StoreInconsistent MismatchKind = "store-inconsistent"
+	// AnnotationWithoutAllocation is reported for a Service port annotated
+	// with an NLB port the store holds no allocation for.
+	AnnotationWithoutAllocation MismatchKind = "annotation-without-allocation"
+	// AnnotationMismatch is reported for a Service port annotated with
+	// another NLB port or listener than its allocation in the store.
+	AnnotationMismatch MismatchKind = "annotation-mismatch"
+	// AllocationWithoutService is reported for an allocation of a Service
+	// that no longer exists, unless it is retained or sticky.
+	AllocationWithoutService MismatchKind = "allocation-without-service"
+	// AllocationWithoutListener is reported for an allocation whose
+	// listener no longer exists in AWS.
+	AllocationWithoutListener MismatchKind = "allocation-without-listener"
+	// ListenerWithoutService is reported for a listener of the cluster
+	// whose Service no longer exists and that the store holds no
+	// allocation for.
+	ListenerWithoutService MismatchKind = "listener-without-service"
+	// ListenerWithoutAllocation is reported for a listener of the cluster
+	// whose Service exists but whose allocation in the store, if any, has
+	// another listener.
+	ListenerWithoutAllocation MismatchKind = "listener-without-allocation"
+)
+
+// Mismatch is a disagreement between the sources of truth of an allocation,
+// with a suggested remediation.
+type Mismatch struct {
+	Kind MismatchKind `json:"kind"`
+	// Allocation is the allocation key, of the form namespace/name:port.
+	Allocation  string `json:"allocation,omitempty"`
+	NLB         string `json:"nlb,omitempty"`
+	Port        int    `json:"port,omitempty"`
+	ListenerArn string `json:"listenerArn,omitempty"`
+	Detail      string `json:"detail"`
+	Remediation string `json:"remediation"`
+}
+
+// Report is the result of a consistency check of the store against the
+// annotations of the Services and the listeners in AWS.
+type Report struct {
+	Time time.Time `json:"time"`
+	// Services, Allocations and Listeners are the number of annotated
+	// Services, store allocations and listeners of the cluster checked.
+	Services    int        `json:"services"`
+	Allocations int        `json:"allocations"`
+	Listeners   int        `json:"listeners"`
+	Mismatches  []Mismatch `json:"mismatches"`
+}
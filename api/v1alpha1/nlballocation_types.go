@@ -0,0 +1,84 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NLBAllocationSpec defines the NLB listener and target group assigned to a Service
+type NLBAllocationSpec struct {
+	// ServiceName identifies the Service port the allocation belongs to, in the
+	// form namespace/name:port
+	ServiceName string `json:"serviceName"`
+
+	// NLB is the name of the load balancer the port is allocated on
+	NLB string `json:"nlb"`
+
+	// Port is the listener port on the NLB
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int `json:"port"`
+
+	// ListenerArn is the ARN of the listener created for the Service
+	ListenerArn string `json:"listenerArn"`
+
+	// TargetGroupArn is the ARN of the target group the listener forwards to
+	TargetGroupArn string `json:"targetGroupArn"`
+
+	// Retained marks the allocation of a deleted Service whose listener and
+	// target group were left in place by its deletion policy
+	// +optional
+	Retained bool `json:"retained,omitempty"`
+
+	// Sticky marks the allocation of a deleted Service whose listener and
+	// target group were deleted, but whose port stays bound to the Service
+	// until StickyUntil, for a Service of the same name to get it back
+	// +optional
+	Sticky bool `json:"sticky,omitempty"`
+
+	// StickyUntil is when the port of a sticky allocation is freed
+	// +optional
+	StickyUntil *metav1.Time `json:"stickyUntil,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="Service",type=string,JSONPath=`.spec.serviceName`
+//+kubebuilder:printcolumn:name="NLB",type=string,JSONPath=`.spec.nlb`
+//+kubebuilder:printcolumn:name="Port",type=integer,JSONPath=`.spec.port`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NLBAllocation is the Schema for the nlballocations API
+type NLBAllocation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NLBAllocationSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// NLBAllocationList contains a list of NLBAllocation
+type NLBAllocationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NLBAllocation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NLBAllocation{}, &NLBAllocationList{})
+}
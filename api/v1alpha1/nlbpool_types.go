@@ -0,0 +1,168 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NLBPoolPortRange is the inclusive range of listener ports allocated on the NLB
+type NLBPoolPortRange struct {
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Min int `json:"min"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Max int `json:"max"`
+}
+
+// NLBPoolScaleOut lets the controller provision more NLBs like the one of a
+// pool once every port is allocated
+type NLBPoolScaleOut struct {
+	// MaxLoadBalancers is the maximum number of NLBs of the pool, including
+	// the NLB of the pool itself
+	// +kubebuilder:validation:Minimum=1
+	MaxLoadBalancers int `json:"maxLoadBalancers"`
+}
+
+// NLBPoolSpec describes an NLB the controller provisions and allocates ports on
+type NLBPoolSpec struct {
+	// LoadBalancerName is the name of the NLB in AWS. Defaults to the name of
+	// the NLBPool. An existing NLB of that name is adopted.
+	// +optional
+	LoadBalancerName string `json:"loadBalancerName,omitempty"`
+
+	// Subnets are the ids of the subnets the NLB is placed in
+	// +kubebuilder:validation:MinItems=1
+	Subnets []string `json:"subnets"`
+
+	// Scheme is either internet-facing or internal
+	// +kubebuilder:validation:Enum=internet-facing;internal
+	// +kubebuilder:default=internet-facing
+	// +optional
+	Scheme string `json:"scheme,omitempty"`
+
+	// EIPAllocations are the allocation ids of the Elastic IPs attached to the
+	// NLB, one per subnet in the order of Subnets. Only for internet-facing NLBs.
+	// +optional
+	EIPAllocations []string `json:"eipAllocations,omitempty"`
+
+	// PortRange is the range of listener ports allocated on the NLB. Defaults
+	// to NLB_PORT_RANGE.
+	// +optional
+	PortRange *NLBPoolPortRange `json:"portRange,omitempty"`
+
+	// Weight is the share of new allocations the NLB takes relative to the
+	// other NLBs under the weighted NLB selection strategy. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Weight int `json:"weight,omitempty"`
+
+	// ExcludedPorts are ports of PortRange that are never allocated, such as
+	// the ports of listeners created by hand on the NLB
+	// +optional
+	ExcludedPorts []int `json:"excludedPorts,omitempty"`
+
+	// Tags are added to the NLB
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// RoleARN is an IAM role the controller assumes to manage the NLB and
+	// its listeners and target groups, for NLBs in another AWS account.
+	// +kubebuilder:validation:Pattern=`^arn:[^:]+:iam::[0-9]{12}:role/.+$`
+	// +optional
+	RoleARN string `json:"roleARN,omitempty"`
+
+	// ExternalID is passed to STS when assuming RoleARN
+	// +optional
+	ExternalID string `json:"externalID,omitempty"`
+
+	// NamespaceSelector, if set, reserves the NLB for the Services of the
+	// namespaces it selects. Namespaces selected by any pool allocate ports
+	// only on the NLBs of the pools selecting them.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// ScaleOut, if set, makes the controller create NLBPools from this one,
+	// without its EIPAllocations, when no NLB has a free port left
+	// +optional
+	ScaleOut *NLBPoolScaleOut `json:"scaleOut,omitempty"`
+}
+
+// NLBPoolStatus reports the provisioned NLB and its utilization
+type NLBPoolStatus struct {
+	// LoadBalancerArn is the ARN of the NLB
+	// +optional
+	LoadBalancerArn string `json:"loadBalancerArn,omitempty"`
+
+	// DNSName is the DNS name of the NLB
+	// +optional
+	DNSName string `json:"dnsName,omitempty"`
+
+	// AllocatedPorts is the number of ports allocated on the NLB
+	AllocatedPorts int `json:"allocatedPorts"`
+
+	// FreePorts is the number of ports still free in the port range
+	FreePorts int `json:"freePorts"`
+
+	// EmptySince is when the last port of a scaled out NLB was released
+	// +optional
+	EmptySince *metav1.Time `json:"emptySince,omitempty"`
+
+	// Conditions describe the state of the NLB
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="DNS Name",type=string,JSONPath=`.status.dnsName`
+//+kubebuilder:printcolumn:name="Allocated",type=integer,JSONPath=`.status.allocatedPorts`
+//+kubebuilder:printcolumn:name="Free",type=integer,JSONPath=`.status.freePorts`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NLBPool is the Schema for the nlbpools API
+type NLBPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NLBPoolSpec   `json:"spec,omitempty"`
+	Status NLBPoolStatus `json:"status,omitempty"`
+}
+
+// LoadBalancerName returns the name of the NLB of the pool.
+func (p *NLBPool) LoadBalancerName() string {
+	if p.Spec.LoadBalancerName != "" {
+		return p.Spec.LoadBalancerName
+	}
+	return p.Name
+}
+
+//+kubebuilder:object:root=true
+
+// NLBPoolList contains a list of NLBPool
+type NLBPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NLBPool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NLBPool{}, &NLBPoolList{})
+}
@@ -0,0 +1,175 @@
+// Package audit keeps an append-only trail of the ports the controller
+// allocates and releases, for reviews of what was exposed when.
+package audit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Action is what happened to an allocation.
+type Action string
+
+const (
+	// Allocated is recorded when a port of a Service is exposed on an NLB.
+	Allocated Action = "allocated"
+	// Reallocated is recorded when the listener or target group of a port
+	// of a Service is replaced, such as after its allocation drifted or its
+	// NodePort changed.
+	Reallocated Action = "reallocated"
+	// Released is recorded when a port is no longer exposed and has been
+	// freed, or bound to its Service as a sticky allocation.
+	Released Action = "released"
+)
+
+// Actors of the records.
+const (
+	// ActorController is the actor of reconciles of Services.
+	ActorController = "controller"
+	// ActorAdmin is the actor of the actions of the admin API.
+	ActorAdmin = "admin"
+	// ActorJanitor is the actor of stale allocations released by the
+	// store janitor.
+	ActorJanitor = "janitor"
+)
+
+// Record is an entry of the audit trail.
+type Record struct {
+	Time   time.Time `json:"time"`
+	Action Action    `json:"action"`
+	// Cluster is the cluster ID of the controller.
+	Cluster string `json:"cluster"`
+	// Actor is the part of the controller that made the change, and Reason
+	// why it made it, such as a controller releasing the port of a deleted
+	// Service.
+	Actor  string `json:"actor"`
+	Reason string `json:"reason"`
+	// Service is the allocation key, of the form namespace/name:port.
+	Service     string `json:"service"`
+	NLB         string `json:"nlb"`
+	Port        int    `json:"port"`
+	ListenerArn string `json:"listenerArn,omitempty"`
+	TargetArn   string `json:"targetArn,omitempty"`
+}
+
+// Query selects records. Zero fields select every record.
+type Query struct {
+	// Service selects the records of an allocation key, or of every port of
+	// a Service if it is of the form namespace/name.
+	Service string
+	NLB     string
+	// Since and Until select the records of a time range, Until excluded.
+	Since time.Time
+	Until time.Time
+	// Limit is the maximum number of records returned, the latest ones.
+	Limit int
+}
+
+// Matches reports whether a record is selected by q, ignoring Limit.
+func (q Query) Matches(r Record) bool {
+	if q.Service != "" && r.Service != q.Service && !strings.HasPrefix(r.Service, q.Service+":") {
+		return false
+	}
+	if q.NLB != "" && r.NLB != q.NLB {
+		return false
+	}
+	if !q.Since.IsZero() && r.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !r.Time.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// limit returns the last q.Limit records.
+func (q Query) limit(records []Record) []Record {
+	if q.Limit > 0 && len(records) > q.Limit {
+		return records[len(records)-q.Limit:]
+	}
+	return records
+}
+
+// Sink stores records.
+type Sink interface {
+	Append(ctx context.Context, record Record) error
+}
+
+// Querier reads the records of a Sink back, oldest first.
+type Querier interface {
+	Query(ctx context.Context, q Query) ([]Record, error)
+}
+
+// ErrNotQueryable is returned by Trail.Query without a Querier.
+var ErrNotQueryable = errors.New("audit: no queryable sink")
+
+var writeFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "nlb_audit_write_failures_total",
+	Help: "Total number of audit records a sink failed to store",
+})
+
+func init() {
+	metrics.Registry.MustRegister(writeFailuresTotal)
+}
+
+// Trail records to every sink. Records are written before the reconcile that
+// made them goes on, so that the trail does not miss changes the controller
+// made, but failed writes are logged and counted, not retried: an unavailable
+// sink does not hold up allocations.
+type Trail struct {
+	// Cluster is set on every record.
+	Cluster string
+	Sinks   []Sink
+	// Querier answers Query, typically one of Sinks.
+	Querier Querier
+}
+
+// Record stores a record in every sink, with the actor of ctx. A nil Trail
+// discards records.
+func (t *Trail) Record(ctx context.Context, record Record) {
+	if t == nil {
+		return
+	}
+	record.Cluster = t.Cluster
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	if record.Actor == "" {
+		record.Actor = actor(ctx)
+	}
+	for _, sink := range t.Sinks {
+		if err := sink.Append(ctx, record); err != nil {
+			writeFailuresTotal.Inc()
+			log.FromContext(ctx).Error(err, "unable to write audit record", "sink", sink, "action", record.Action, "allocation", record.Service)
+		}
+	}
+}
+
+// Query returns the records selected by q, oldest first.
+func (t *Trail) Query(ctx context.Context, q Query) ([]Record, error) {
+	if t == nil || t.Querier == nil {
+		return nil, ErrNotQueryable
+	}
+	return t.Querier.Query(ctx, q)
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose records are made by actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actor returns the actor of ctx, ActorController by default.
+func actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return ActorController
+}
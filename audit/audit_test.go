@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileQuery(t *testing.T) {
+	ctx := context.Background()
+	file := &File{Path: filepath.Join(t.TempDir(), "audit.log")}
+	trail := &Trail{Cluster: "prod", Sinks: []Sink{file}, Querier: file}
+	start := time.Date(2022, 11, 21, 10, 0, 0, 0, time.UTC)
+	for i, r := range []Record{
+		{Action: Allocated, Service: "default/web:http", NLB: "public", Port: 10000},
+		{Action: Allocated, Service: "default/web:https", NLB: "internal", Port: 10000},
+		{Action: Allocated, Service: "default/webhook:http", NLB: "public", Port: 10001},
+		{Action: Released, Service: "default/web:http", NLB: "public", Port: 10000, Reason: "svc deleted"},
+	} {
+		r.Time = start.Add(time.Duration(i) * time.Minute)
+		trail.Record(WithActor(ctx, ActorAdmin), r)
+	}
+
+	tests := []struct {
+		name  string
+		q     Query
+		ports []string
+	}{
+		{"every record", Query{}, []string{"default/web:http", "default/web:https", "default/webhook:http", "default/web:http"}},
+		{"service", Query{Service: "default/web"}, []string{"default/web:http", "default/web:https", "default/web:http"}},
+		{"allocation", Query{Service: "default/web:http"}, []string{"default/web:http", "default/web:http"}},
+		{"nlb", Query{NLB: "internal"}, []string{"default/web:https"}},
+		{"time range", Query{Since: start.Add(time.Minute), Until: start.Add(3 * time.Minute)}, []string{"default/web:https", "default/webhook:http"}},
+		{"limit", Query{Limit: 1}, []string{"default/web:http"}},
+	}
+	for _, tt := range tests {
+		records, err := trail.Query(ctx, tt.q)
+		if err != nil {
+			t.Fatalf("%s: Query() error = %v", tt.name, err)
+		}
+		var got []string
+		for _, r := range records {
+			got = append(got, r.Service)
+		}
+		if len(got) != len(tt.ports) {
+			t.Errorf("%s: Query() = %v, want %v", tt.name, got, tt.ports)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.ports[i] {
+				t.Errorf("%s: Query() = %v, want %v", tt.name, got, tt.ports)
+				break
+			}
+		}
+	}
+
+	records, _ := trail.Query(ctx, Query{Limit: 1})
+	if r := records[0]; r.Cluster != "prod" || r.Actor != ActorAdmin || r.Action != Released || r.Reason != "svc deleted" {
+		t.Errorf("last record = %+v, want the release by admin in cluster prod", r)
+	}
+}
+
+func TestFileSkipsTruncatedRecords(t *testing.T) {
+	ctx := context.Background()
+	file := &File{Path: filepath.Join(t.TempDir(), "audit.log")}
+	if err := file.Append(ctx, Record{Action: Allocated, Service: "default/web:http"}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(file.Path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"action": "rele`)
+	f.Close()
+	if records, err := file.Query(ctx, Query{}); err != nil || len(records) != 1 {
+		t.Errorf("Query() = %v, %v, want the one complete record", records, err)
+	}
+}
+
+func TestNilTrail(t *testing.T) {
+	var trail *Trail
+	trail.Record(context.Background(), Record{Action: Allocated})
+	if _, err := trail.Query(context.Background(), Query{}); err != ErrNotQueryable {
+		t.Errorf("Query() error = %v, want ErrNotQueryable", err)
+	}
+}
@@ -0,0 +1,37 @@
+package audit
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Events records as Kubernetes Events on the Services of the records, where
+// `kubectl describe` and event exporters find them. Events expire after the
+// event TTL of the cluster, an hour by default, and are not queryable through
+// the controller.
+type Events struct {
+	Recorder record.EventRecorder
+}
+
+var _ Sink = &Events{}
+
+// Append implements Sink. The Event is emitted in the background by
+// Recorder, so Append does not fail.
+func (e *Events) Append(_ context.Context, r Record) error {
+	service, _, _ := strings.Cut(r.Service, ":")
+	namespace, name, _ := strings.Cut(service, "/")
+	ref := &corev1.ObjectReference{APIVersion: "v1", Kind: "Service", Namespace: namespace, Name: name}
+	action := string(r.Action)
+	e.Recorder.Eventf(ref, corev1.EventTypeNormal, "Audit"+strings.ToUpper(action[:1])+action[1:],
+		"%s port %d of nlb %s for %s by %s: %s (listener %s, target group %s)",
+		action, r.Port, r.NLB, r.Service, r.Actor, r.Reason, r.ListenerArn, r.TargetArn)
+	return nil
+}
+
+// String names the sink, for logs.
+func (e *Events) String() string {
+	return "events"
+}
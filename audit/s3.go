@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3API is the part of the S3 client the S3 sink uses.
+type S3API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+// keyTime is the layout of the time in the keys of S3 records. Keys sort in
+// the order of the records.
+const keyTime = "2006/01/02/150405.000000000"
+
+// S3 stores every record as a JSON object of its own in Bucket, under
+// Prefix followed by the UTC time of the record, so records are never
+// overwritten. Bucket versioning or Object Lock keep them from being deleted.
+type S3 struct {
+	Client S3API
+	Bucket string
+	Prefix string
+}
+
+var _ Sink = &S3{}
+var _ Querier = &S3{}
+
+// Append implements Sink.
+func (s *S3) Append(ctx context.Context, record Record) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	_, err = s.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(s.Prefix + record.Time.UTC().Format(keyTime) + "-" + hex.EncodeToString(suffix) + ".json"),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// Query implements Querier. Every object from q.Since on is read, so queries
+// without Since read the whole trail.
+func (s *S3) Query(ctx context.Context, q Query) ([]Record, error) {
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(s.Bucket), Prefix: aws.String(s.Prefix)}
+	if !q.Since.IsZero() {
+		input.StartAfter = aws.String(s.Prefix + q.Since.UTC().Format(keyTime))
+	}
+	var until string
+	if !q.Until.IsZero() {
+		until = s.Prefix + q.Until.UTC().Format(keyTime)
+	}
+
+	records := []Record{}
+	paginator := s3.NewListObjectsV2Paginator(s.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			if until != "" && key >= until {
+				return q.limit(records), nil
+			}
+			record, err := s.get(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if q.Matches(record) {
+				records = append(records, record)
+			}
+		}
+	}
+	return q.limit(records), nil
+}
+
+func (s *S3) get(ctx context.Context, key string) (Record, error) {
+	var record Record
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.Bucket), Key: aws.String(key)})
+	if err != nil {
+		return record, err
+	}
+	defer out.Body.Close()
+	if err := json.NewDecoder(out.Body).Decode(&record); err != nil {
+		return record, fmt.Errorf("audit: unable to decode %s: %w", key, err)
+	}
+	return record, nil
+}
+
+// String returns the bucket and prefix of the sink, for logs.
+func (s *S3) String() string {
+	return "s3 " + s.Bucket + "/" + s.Prefix
+}
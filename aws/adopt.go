@@ -0,0 +1,124 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+)
+
+// AdoptListener takes over an existing listener, such as one created by
+// Terraform, for the Service port of spec. The NLB and port of spec are taken
+// from the listener. The listener must forward to a target group, must match
+// spec like CheckListener checks, and must not carry the tags of another
+// cluster or Service. The listener and its target group are then tagged like
+// the ones the controller creates, so that they are managed, and eventually
+// deleted, like them.
+func (c client) AdoptListener(ctx context.Context, listenerArn string, spec ListenerSpec) (ListenerAllocation, error) {
+	elb := c.elbForArn(listenerArn)
+	listeners, err := elb.DescribeListeners(ctx, &elbv2.DescribeListenersInput{ListenerArns: []string{listenerArn}})
+	if err != nil {
+		return ListenerAllocation{}, err
+	}
+	if len(listeners.Listeners) == 0 {
+		return ListenerAllocation{}, fmt.Errorf("aws: listener %s not found", listenerArn)
+	}
+	listener := listeners.Listeners[0]
+	targetArn := listenerTargetGroupArn(listener)
+	if targetArn == "" {
+		return ListenerAllocation{}, fmt.Errorf("aws: listener %s does not forward to a target group", listenerArn)
+	}
+	nlb, err := nlbNameFromArn(aws.ToString(listener.LoadBalancerArn))
+	if err != nil {
+		return ListenerAllocation{}, err
+	}
+	spec.NLB = nlb
+	spec.Port = int(aws.ToInt32(listener.Port))
+	if err := c.CheckListener(ctx, listenerArn, targetArn, spec); err != nil {
+		return ListenerAllocation{}, err
+	}
+
+	out, err := elb.DescribeTags(ctx, &elbv2.DescribeTagsInput{ResourceArns: []string{listenerArn, targetArn}})
+	if err != nil {
+		return ListenerAllocation{}, err
+	}
+	for _, desc := range out.TagDescriptions {
+		for _, t := range desc.Tags {
+			key, value := aws.ToString(t.Key), aws.ToString(t.Value)
+			if key == TagCluster && value != c.clusterID ||
+				key == TagService && value != spec.ServiceName && aws.ToString(desc.ResourceArn) == listenerArn {
+				return ListenerAllocation{}, fmt.Errorf("%w: %s is tagged %s=%s", ErrNotOwned, aws.ToString(desc.ResourceArn), key, value)
+			}
+		}
+	}
+	_, err = elb.AddTags(ctx, &elbv2.AddTagsInput{
+		ResourceArns: []string{listenerArn, targetArn},
+		Tags:         c.tags(spec.ServiceName),
+	})
+	if err != nil {
+		return ListenerAllocation{}, err
+	}
+	c.cache.invalidateTargetGroup(targetArn)
+	return ListenerAllocation{
+		ServiceNamespacedName: spec.ServiceName,
+		NLB:                   nlb,
+		Port:                  spec.Port,
+		ListenerArn:           listenerArn,
+		TargetArn:             targetArn,
+	}, nil
+}
+
+// reuseListener returns the listener on the port of spec of an NLB, after
+// CreateListener failed because it exists, if the controller created it for
+// spec before, such as in a reconcile that crashed before it recorded the
+// allocation. The listener must forward to targetArn, match spec like
+// CheckListener checks, and carry the tags of this cluster and of the Service
+// of spec.
+func (c client) reuseListener(ctx context.Context, elb *elbv2.Client, nlbArn string, targetArn string, spec ListenerSpec) (string, error) {
+	paginator := elbv2.NewDescribeListenersPaginator(elb, &elbv2.DescribeListenersInput{
+		LoadBalancerArn: aws.String(nlbArn),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, listener := range page.Listeners {
+			if aws.ToInt32(listener.Port) != int32(spec.Port) {
+				continue
+			}
+			listenerArn := aws.ToString(listener.ListenerArn)
+			if err := c.CheckListener(ctx, listenerArn, targetArn, spec); err != nil {
+				return "", err
+			}
+			out, err := elb.DescribeTags(ctx, &elbv2.DescribeTagsInput{ResourceArns: []string{listenerArn}})
+			if err != nil {
+				return "", err
+			}
+			tags := map[string]string{}
+			for _, desc := range out.TagDescriptions {
+				for _, t := range desc.Tags {
+					tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+				}
+			}
+			if !c.ownedBy(tags) || tags[TagService] != spec.ServiceName {
+				return "", fmt.Errorf("%w: listener %s is not tagged for svc %s", ErrNotOwned, listenerArn, spec.ServiceName)
+			}
+			return listenerArn, nil
+		}
+	}
+	return "", fmt.Errorf("%w: listener on port %d", ErrNotFound, spec.Port)
+}
+
+// nlbNameFromArn returns the name of a Network Load Balancer from its ARN,
+// arn:aws:elasticloadbalancing:<region>:<account>:loadbalancer/net/<name>/<id>.
+func nlbNameFromArn(arn string) (string, error) {
+	_, resource, _ := strings.Cut(arn, ":loadbalancer/")
+	parts := strings.Split(resource, "/")
+	if len(parts) != 3 || parts[0] != "net" || parts[1] == "" {
+		return "", fmt.Errorf("aws: %q is not the arn of a network load balancer", arn)
+	}
+	return parts[1], nil
+}
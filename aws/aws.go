@@ -2,209 +2,1167 @@ package aws
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/elbv2"
-	"os"
-	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+	"github.com/aws/smithy-go/middleware"
 )
 
+const (
+	// TagService holds the namespaced name of the Service a resource was created for
+	TagService = "nlb-controller/service"
+	// TagCluster holds the id of the cluster whose controller created a resource
+	TagCluster = "nlb-controller/cluster"
+	// TagServiceName and TagNamespace hold the name and namespace of the
+	// Service a resource was created for, for cost allocation
+	TagServiceName = "kubernetes.io/service-name"
+	TagNamespace   = "kubernetes.io/namespace"
+
+	// describeTagsMaxArns is the maximum number of resources DescribeTags accepts
+	describeTagsMaxArns = 20
+	// targetsPerCall is the number of targets registered or deregistered
+	// per RegisterTargets or DeregisterTargets call
+	targetsPerCall = 100
+)
+
+// ErrNotOwned is returned when asked to delete a resource that was not
+// created by the controller of this cluster.
+var ErrNotOwned = errors.New("aws: resource is not owned by this controller")
+
+// ErrDrifted is returned by CheckListener when a listener or its target group
+// no longer exists or no longer matches its Service port.
+var ErrDrifted = errors.New("aws: listener has drifted")
+
+// ErrTargetPortChanged is returned by CheckListener when the target group of a
+// listener is on another port than its Service port, such as after the
+// NodePort of the Service changed. The listener itself still matches, so it
+// can be kept and pointed at a target group on the new port.
+var ErrTargetPortChanged = fmt.Errorf("%w: target port and node port dont match", ErrDrifted)
+
+// Options configures the client returned by New.
+type Options struct {
+	// ClusterID identifies this cluster in the tags of the resources the
+	// controller creates.
+	ClusterID string
+
+	// VPC is the VPC target groups are created in. If empty, it is the VPC
+	// of the instance the controller runs on, or else the VPC of the subnets
+	// tagged kubernetes.io/cluster/<ClusterName>.
+	VPC string
+	// ClusterName is the EKS cluster name of the subnet tags. Defaults to
+	// ClusterID.
+	ClusterName string
+
+	// Tags are added to every listener and target group the controller
+	// creates. They cannot override the tags the controller sets itself.
+	Tags map[string]string
+
+	// TargetGroupNameTemplate names target groups. It may use the
+	// placeholders {cluster}, {namespace}, {svc}, {port} and {protocol}, where
+	// {port} is the port the target group forwards to. Names longer than
+	// AWS allows are shortened with a hash. If empty, instance target groups
+	// are named after their NodePort. ip target groups only use templates
+	// with both {namespace} and {svc}.
+	TargetGroupNameTemplate string
+
+	// Route53HostedZoneID is the hosted zone DNS records of Services are
+	// created in. If empty, DNS records are not managed.
+	Route53HostedZoneID string
+
+	// Credentials selects where the credentials of the controller come from.
+	Credentials Credentials
+
+	// DisableIMDS keeps the controller from using the instance metadata
+	// service for its region, VPC or credentials. The service is only ever
+	// used with IMDSv2 session tokens.
+	DisableIMDS bool
+
+	// Endpoints override the endpoints of the AWS APIs.
+	Endpoints Endpoints
+
+	// Region is the AWS region of the managed NLBs. If empty, the region is
+	// taken from AWS_REGION or the shared config, and finally from the
+	// instance metadata service.
+	Region string
+
+	// RetryMode is the SDK retry mode, standard or adaptive.
+	RetryMode aws.RetryMode
+	// MaxAttempts is the maximum number of attempts per API call. Zero keeps
+	// the SDK default.
+	MaxAttempts int
+	// RetryMaxBackoff is the longest wait between attempts. Waits grow
+	// exponentially with full jitter up to it. Zero keeps the SDK default.
+	RetryMaxBackoff time.Duration
+
+	// RateLimit is the maximum number of API calls per second, and Burst the
+	// number of calls that may exceed it at once. A zero RateLimit disables
+	// client-side rate limiting.
+	RateLimit float64
+	Burst     int
+
+	// ReadTimeout is the deadline of Describe, Get and List calls and
+	// WriteTimeout the one of every other call, including their retries.
+	// Zero leaves calls without a deadline of their own.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// CircuitBreakerThreshold is the number of ELB API calls in a row that
+	// must fail for mutating calls to stop, for at least
+	// CircuitBreakerCooldown and until a call succeeds again. Zero disables
+	// the circuit breaker.
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+	// OnCircuitBreaker, if set, is called when the circuit breaker opens,
+	// with the error that opened it, and when it closes, with nil.
+	OnCircuitBreaker func(open bool, err error)
+
+	// APIOptions are applied to the middleware stack of every API call.
+	APIOptions []func(*middleware.Stack) error
+
+	// DescribeCacheTTL is how long described NLBs and target groups are
+	// reused before they are described again. Changes the controller makes
+	// invalidate them at once. Zero disables the cache.
+	DescribeCacheTTL time.Duration
+}
+
+// breakerAPIOptions returns the circuit breaker middleware, unless it is
+// disabled.
+func (o Options) breakerAPIOptions() []func(*middleware.Stack) error {
+	if o.CircuitBreakerThreshold <= 0 {
+		return nil
+	}
+	return []func(*middleware.Stack) error{
+		newCircuitBreaker(o.CircuitBreakerThreshold, o.CircuitBreakerCooldown, o.OnCircuitBreaker).middleware,
+	}
+}
+
+// clusterName is the cluster name of the kubernetes.io/cluster tags.
+func (o Options) clusterName() string {
+	if o.ClusterName != "" {
+		return o.ClusterName
+	}
+	return o.ClusterID
+}
+
+// ListenerSpec describes the listener and target group created for a
+// Service port.
+type ListenerSpec struct {
+	// NLB is the name of the load balancer to create the listener on
+	NLB string
+	// Port is the listener port on the NLB
+	Port int
+	// NodePort is the port the target group forwards to
+	NodePort int
+	// ServiceName is the allocation key the resources are tagged with
+	ServiceName string
+	// Protocol is the listener and target group protocol: TCP, UDP, TCP_UDP
+	// or TLS. Empty means TCP.
+	Protocol string
+	// Certificate is the ARN of the certificate of the listener. Listeners
+	// with a certificate terminate TLS and forward to a TCP or TLS target
+	// group of Protocol.
+	Certificate string
+	// TargetType is the target group target type: instance or ip. Empty means
+	// instance. Target groups are created empty and filled with SyncTargets.
+	TargetType string
+	// HealthCheck configures the health check of the target group.
+	HealthCheck HealthCheck
+}
+
+// HealthCheck is the health check configuration of a target group. Zero
+// values keep the AWS defaults.
+type HealthCheck struct {
+	// Protocol is one of TCP, HTTP or HTTPS
+	Protocol string
+	// Port is a port number or traffic-port
+	Port               string
+	Path               string
+	IntervalSeconds    int32
+	HealthyThreshold   int32
+	UnhealthyThreshold int32
+}
+
+// apply sets the health check fields of a CreateTargetGroupInput.
+func (h HealthCheck) apply(input *elbv2.CreateTargetGroupInput) {
+	if h.Protocol != "" {
+		input.HealthCheckProtocol = elbv2types.ProtocolEnum(h.Protocol)
+	}
+	if h.Port != "" {
+		input.HealthCheckPort = aws.String(h.Port)
+	}
+	if h.Path != "" {
+		input.HealthCheckPath = aws.String(h.Path)
+	}
+	if h.IntervalSeconds != 0 {
+		input.HealthCheckIntervalSeconds = aws.Int32(h.IntervalSeconds)
+	}
+	if h.HealthyThreshold != 0 {
+		input.HealthyThresholdCount = aws.Int32(h.HealthyThreshold)
+	}
+	if h.UnhealthyThreshold != 0 {
+		input.UnhealthyThresholdCount = aws.Int32(h.UnhealthyThreshold)
+	}
+}
+
+// modify sets the health check fields of a ModifyTargetGroupInput that
+// differ from group, and reports whether there were any.
+func (h HealthCheck) modify(group elbv2types.TargetGroup, input *elbv2.ModifyTargetGroupInput) bool {
+	changed := false
+	if h.Protocol != "" && elbv2types.ProtocolEnum(h.Protocol) != group.HealthCheckProtocol {
+		input.HealthCheckProtocol = elbv2types.ProtocolEnum(h.Protocol)
+		changed = true
+	}
+	if h.Port != "" && h.Port != aws.ToString(group.HealthCheckPort) {
+		input.HealthCheckPort = aws.String(h.Port)
+		changed = true
+	}
+	if h.Path != "" && h.Path != aws.ToString(group.HealthCheckPath) {
+		input.HealthCheckPath = aws.String(h.Path)
+		changed = true
+	}
+	if h.IntervalSeconds != 0 && h.IntervalSeconds != aws.ToInt32(group.HealthCheckIntervalSeconds) {
+		input.HealthCheckIntervalSeconds = aws.Int32(h.IntervalSeconds)
+		changed = true
+	}
+	if h.HealthyThreshold != 0 && h.HealthyThreshold != aws.ToInt32(group.HealthyThresholdCount) {
+		input.HealthyThresholdCount = aws.Int32(h.HealthyThreshold)
+		changed = true
+	}
+	if h.UnhealthyThreshold != 0 && h.UnhealthyThreshold != aws.ToInt32(group.UnhealthyThresholdCount) {
+		input.UnhealthyThresholdCount = aws.Int32(h.UnhealthyThreshold)
+		changed = true
+	}
+	return changed
+}
+
+func (s ListenerSpec) protocol() elbv2types.ProtocolEnum {
+	if s.Protocol == "" {
+		return elbv2types.ProtocolEnumTcp
+	}
+	return elbv2types.ProtocolEnum(s.Protocol)
+}
+
+// listenerProtocol is the protocol of the listener, which is TLS for
+// listeners with a certificate.
+func (s ListenerSpec) listenerProtocol() elbv2types.ProtocolEnum {
+	if s.Certificate != "" {
+		return elbv2types.ProtocolEnumTls
+	}
+	return s.protocol()
+}
+
+func (s ListenerSpec) targetType() elbv2types.TargetTypeEnum {
+	if s.TargetType == "" {
+		return elbv2types.TargetTypeEnumInstance
+	}
+	return elbv2types.TargetTypeEnum(s.TargetType)
+}
+
+// targetGroupPort is the port of the target group. instance targets listen on
+// the NodePort, ip targets are registered with their own port so the listener
+// port is used.
+func (s ListenerSpec) targetGroupPort() int32 {
+	if s.targetType() == elbv2types.TargetTypeEnumIp {
+		return int32(s.Port)
+	}
+	return int32(s.NodePort)
+}
+
+// ValidTargetType reports whether targetType can be used in a ListenerSpec.
+func ValidTargetType(targetType string) bool {
+	switch elbv2types.TargetTypeEnum(targetType) {
+	case "", elbv2types.TargetTypeEnumInstance, elbv2types.TargetTypeEnumIp:
+		return true
+	}
+	return false
+}
+
+// Target is a target registered in a target group.
+type Target struct {
+	// ID is the instance id or the ip address of the target
+	ID   string
+	Port int
+}
+
+// ValidProtocol reports whether protocol can be used in a ListenerSpec.
+func ValidProtocol(protocol string) bool {
+	switch elbv2types.ProtocolEnum(protocol) {
+	case "", elbv2types.ProtocolEnumTcp, elbv2types.ProtocolEnumUdp, elbv2types.ProtocolEnumTcpUdp, elbv2types.ProtocolEnumTls:
+		return true
+	}
+	return false
+}
+
+// ListenerAllocation is a listener owned by this cluster, as recorded in its tags.
+type ListenerAllocation struct {
+	ServiceNamespacedName string
+	NLB                   string
+	Port                  int
+	ListenerArn           string
+	TargetArn             string
+}
+
 type client struct {
-	Elb        elbv2.ELBV2
-	Ec2Client  *ec2.EC2
-	VPC        string
-	protocol   string
-	actionType string
+	Elb           *elbv2.Client
+	Ec2Client     *ec2.Client
+	Route53       *route53.Client
+	Autoscaling   *autoscaling.Client
+	ServiceQuotas *servicequotas.Client
+	VPC           string
+	clusterID     string
+	// clusterName is the cluster name of the kubernetes.io/cluster tags
+	clusterName  string
+	extraTags    map[string]string
+	tgNames      string
+	hostedZoneID string
+	actionType   elbv2types.ActionTypeEnum
+	// roles are the clients of the roles assumed for NLBs in other accounts
+	roles *roleClients
+	// cache holds described NLBs and target groups
+	cache *describeCache
 }
 
-func (c client) DeleteListenerAndTargetArn(listenerArn string, targetArn string) error {
-	_, err := c.Elb.DeleteListener(&elbv2.DeleteListenerInput{ListenerArn: aws.String(listenerArn)})
+// tags are the tags of the listener and target group created for svcName,
+// an allocation key of the form namespace/name:port, plus the extra tags
+// configured for the client.
+func (c client) tags(svcName string) []elbv2types.Tag {
+	serviceName, _, _ := strings.Cut(svcName, ":")
+	namespace, name, _ := strings.Cut(serviceName, "/")
+	own := map[string]string{
+		TagService:     svcName,
+		TagCluster:     c.clusterID,
+		TagServiceName: name,
+		TagNamespace:   namespace,
+	}
+
+	keys := make([]string, 0, len(c.extraTags))
+	for key := range c.extraTags {
+		if _, ok := own[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	tags := make([]elbv2types.Tag, 0, len(keys)+len(own))
+	for _, key := range keys {
+		tags = append(tags, elbv2types.Tag{Key: aws.String(key), Value: aws.String(c.extraTags[key])})
+	}
+	return append(tags,
+		elbv2types.Tag{Key: aws.String(TagService), Value: aws.String(svcName)},
+		elbv2types.Tag{Key: aws.String(TagCluster), Value: aws.String(c.clusterID)},
+		elbv2types.Tag{Key: aws.String(TagServiceName), Value: aws.String(name)},
+		elbv2types.Tag{Key: aws.String(TagNamespace), Value: aws.String(namespace)},
+	)
+}
+
+// ownedBy reports whether tags carry the cluster tag of this client. The tag
+// must be present, so that untagged resources are never taken for owned ones.
+func (c client) ownedBy(tags map[string]string) bool {
+	v, ok := tags[TagCluster]
+	return ok && c.clusterID != "" && v == c.clusterID
+}
+
+// DeleteListenerAndTargetArn deletes the listener of the allocation
+// serviceName and, unless other listeners still forward to it, its target
+// group. Listeners tagged for another allocation are not deleted. Resources
+// that no longer exist are treated as deleted.
+func (c client) DeleteListenerAndTargetArn(ctx context.Context, serviceName string, listenerArn string, targetArn string) error {
+	listenerGone, err := c.checkOwned(ctx, listenerArn, serviceName)
+	if err != nil {
+		return err
+	}
+	targetGone, err := c.checkOwned(ctx, targetArn, "")
 	if err != nil {
 		return err
 	}
-	_, err = c.Elb.DeleteTargetGroup(&elbv2.DeleteTargetGroupInput{TargetGroupArn: aws.String(targetArn)})
+	elb := c.elbForArn(listenerArn)
+	if !listenerGone {
+		_, err := elb.DeleteListener(ctx, &elbv2.DeleteListenerInput{ListenerArn: aws.String(listenerArn)})
+		if err != nil && !isGone(err) {
+			return err
+		}
+	}
+	if targetGone {
+		return nil
+	}
+	return c.deleteUnusedTargetGroup(ctx, elb, targetArn)
+}
+
+// deleteUnusedTargetGroup deletes a target group unless listeners forward to
+// it. Target groups of a NodePort are shared by every listener forwarding to
+// it, so it is only deleted along with its last listener.
+func (c client) deleteUnusedTargetGroup(ctx context.Context, elb *elbv2.Client, targetArn string) error {
+	groups, err := elb.DescribeTargetGroups(ctx, &elbv2.DescribeTargetGroupsInput{TargetGroupArns: []string{targetArn}})
+	if isGone(err) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	if len(groups.TargetGroups) == 1 && len(groups.TargetGroups[0].LoadBalancerArns) > 0 {
+		loggerFrom(ctx).Info("aws: target group still in use. Keeping it", "targetGroup", targetArn)
+		return nil
+	}
+	_, err = elb.DeleteTargetGroup(ctx, &elbv2.DeleteTargetGroupInput{TargetGroupArn: aws.String(targetArn)})
+	c.cache.invalidateTargetGroup(targetArn)
+	var inUse *elbv2types.ResourceInUseException
+	if errors.As(err, &inUse) {
+		// a listener started forwarding to it since it was described
+		loggerFrom(ctx).Info("aws: target group still in use. Keeping it", "targetGroup", targetArn)
+		return nil
+	}
+	if err != nil && !isGone(err) {
+		return err
+	}
 	return nil
 }
 
+// isGone reports whether err is AWS reporting that a listener or target group
+// does not exist.
+func isGone(err error) bool {
+	var listenerNotFound *elbv2types.ListenerNotFoundException
+	var targetGroupNotFound *elbv2types.TargetGroupNotFoundException
+	return errors.As(err, &listenerNotFound) || errors.As(err, &targetGroupNotFound)
+}
+
+// checkOwned returns ErrNotOwned unless a listener or target group carries
+// the tags of this cluster. Target groups may be shared between services, so
+// only listeners, given with the allocation key serviceName, need to be
+// tagged with their service. It reports whether the resource no longer
+// exists, such as after it was deleted out of band.
+func (c client) checkOwned(ctx context.Context, arn string, serviceName string) (bool, error) {
+	if arn == "" {
+		return true, nil
+	}
+	out, err := c.elbForArn(arn).DescribeTags(ctx, &elbv2.DescribeTagsInput{ResourceArns: []string{arn}})
+	if isGone(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	tags := map[string]string{}
+	for _, desc := range out.TagDescriptions {
+		for _, t := range desc.Tags {
+			tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+		}
+	}
+	if !c.ownedBy(tags) {
+		kind := "target group"
+		if serviceName != "" {
+			kind = "listener"
+		}
+		return false, fmt.Errorf("%w: %s %s", ErrNotOwned, kind, arn)
+	}
+	if serviceName != "" && tags[TagService] != serviceName {
+		return false, fmt.Errorf("%w: listener %s is not tagged for svc %s", ErrNotOwned, arn, serviceName)
+	}
+	return false, nil
+}
+
+// CheckListener checks that a listener and its target group still exist and
+// match spec. It returns an error wrapping ErrDrifted if they do not, and
+// other errors when they could not be checked.
 func (c client) CheckListener(
-	_ context.Context,
+	ctx context.Context,
 	svcListenerArn string,
 	svcTargetGroupArn string,
-	_ string,
-	svcNLBPort int,
-	svcNodePort int,
+	spec ListenerSpec,
 ) error {
-	// TODO: add NLB check
-	listeners, err := c.Elb.DescribeListeners(&elbv2.DescribeListenersInput{
-		ListenerArns: []*string{aws.String(svcListenerArn)},
-		PageSize:     aws.Int64(50),
+	elb := c.elbForArn(svcListenerArn)
+	listeners, err := elb.DescribeListeners(ctx, &elbv2.DescribeListenersInput{
+		ListenerArns: []string{svcListenerArn},
+		PageSize:     aws.Int32(50),
 	})
+	if isGone(err) {
+		return fmt.Errorf("%w: %v", ErrDrifted, err)
+	}
 	if err != nil {
 		return err
 	}
-	if *listeners.Listeners[0].Port != int64(svcNLBPort) {
-		return errors.New("aws: listener port and svcNLBPort dont match")
+	if len(listeners.Listeners) == 0 {
+		return fmt.Errorf("%w: listener %s not found", ErrDrifted, svcListenerArn)
+	}
+	listener := listeners.Listeners[0]
+	if nlb, err := nlbNameFromArn(aws.ToString(listener.LoadBalancerArn)); err != nil || nlb != spec.NLB {
+		return fmt.Errorf("%w: listener nlb and svc nlb %s dont match", ErrDrifted, spec.NLB)
+	}
+	if aws.ToInt32(listener.Port) != int32(spec.Port) {
+		return fmt.Errorf("%w: listener port and svcNLBPort dont match", ErrDrifted)
+	}
+	if listener.Protocol != spec.listenerProtocol() {
+		return fmt.Errorf("%w: listener protocol and svc protocol dont match", ErrDrifted)
 	}
 
-	targetGroupArn := listeners.Listeners[0].DefaultActions[0].ForwardConfig.TargetGroups[0].TargetGroupArn
-	if *targetGroupArn != svcTargetGroupArn {
-		return errors.New("aws: target group arn dont match")
+	targetGroupArn := listenerTargetGroupArn(listener)
+	if targetGroupArn != svcTargetGroupArn {
+		return fmt.Errorf("%w: target group arn dont match", ErrDrifted)
 	}
 
-	groups, err := c.Elb.DescribeTargetGroups(&elbv2.DescribeTargetGroupsInput{
-		LoadBalancerArn: nil,
-		Marker:          nil,
-		Names:           nil,
-		PageSize:        nil,
-		TargetGroupArns: []*string{targetGroupArn},
+	groups, err := elb.DescribeTargetGroups(ctx, &elbv2.DescribeTargetGroupsInput{
+		TargetGroupArns: []string{targetGroupArn},
 	})
+	if isGone(err) {
+		return fmt.Errorf("%w: %v", ErrDrifted, err)
+	}
 	if err != nil {
 		return err
 	}
-	if *groups.TargetGroups[0].Port != int64(svcNodePort) {
-		return errors.New("aws: target port and node port dont match")
+	if len(groups.TargetGroups) == 0 {
+		return fmt.Errorf("%w: target group %s not found", ErrDrifted, targetGroupArn)
+	}
+	if aws.ToInt32(groups.TargetGroups[0].Port) != spec.targetGroupPort() {
+		return ErrTargetPortChanged
+	}
+	if groups.TargetGroups[0].TargetType != spec.targetType() {
+		return fmt.Errorf("%w: target type and svc target type dont match", ErrDrifted)
 	}
 	return nil
 }
 
-func (c client) CreateNLBListenerForPort(
-	nlbName string,
-	port int,
-	nodePort int,
-	svcName string,
-) (string, string, error) {
-	svcName = strings.Replace(svcName, "/", "-", 1)
+// ListenerExists reports whether a listener still exists. Target groups are
+// shared between the listeners of a NodePort, so they are not looked at.
+func (c client) ListenerExists(ctx context.Context, listenerArn string) (bool, error) {
+	if listenerArn == "" {
+		return false, nil
+	}
+	listeners, err := c.elbForArn(listenerArn).DescribeListeners(ctx, &elbv2.DescribeListenersInput{
+		ListenerArns: []string{listenerArn},
+	})
+	if isGone(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(listeners.Listeners) > 0, nil
+}
+
+// listenerTargetGroupArn returns the target group a listener forwards to, or
+// "" if it does not forward to one.
+func listenerTargetGroupArn(listener elbv2types.Listener) string {
+	for _, action := range listener.DefaultActions {
+		if action.TargetGroupArn != nil {
+			return aws.ToString(action.TargetGroupArn)
+		}
+		if action.ForwardConfig != nil && len(action.ForwardConfig.TargetGroups) > 0 {
+			return aws.ToString(action.ForwardConfig.TargetGroups[0].TargetGroupArn)
+		}
+	}
+	return ""
+}
 
-	nlbList, err := c.Elb.DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{Names: []*string{&nlbName}})
+func (c client) CreateNLBListenerForPort(ctx context.Context, spec ListenerSpec) (string, string, error) {
+	logger := listenerLogger(ctx, spec)
+	nlbName := spec.NLB
+	elb := c.elbForNLB(nlbName)
+	nlb, err := c.describeNLB(ctx, nlbName)
 	if err != nil {
 		return "", "", err
 	}
-	if len(nlbList.LoadBalancers) != 1 {
-		return "", "", errors.New(fmt.Sprintf("aws: %s nlb not found", nlbName))
+	if nlb == nil {
+		return "", "", fmt.Errorf("%w: nlb %s", ErrNotFound, nlbName)
 	}
+	logger.V(1).Info("aws: nlb found")
 
-	log.Log.Info("aws: nlb found")
-	nlb := nlbList.LoadBalancers[0]
-
-	targetGroupArn, err := c.GetTargetGroupArn(c.VPC, int64(nodePort))
+	// target groups of NLBs in other accounts are created in the VPC of
+	// their NLB, as the VPC of the controller is in its own account
+	vpc := c.VPC
+	if c.assumesRole(nlbName) {
+		vpc = aws.ToString(nlb.VpcId)
+	}
+	targetGroupArn, err := c.GetTargetGroupArn(ctx, vpc, spec)
 	if err != nil {
 		return "", "", err
 	}
-	log.Log.Info("aws: target group found")
+	logger.V(1).Info("aws: target group found")
 
-	listener, err := c.Elb.CreateListener(&elbv2.CreateListenerInput{
-		DefaultActions: []*elbv2.Action{
+	listener, err := elb.CreateListener(ctx, &elbv2.CreateListenerInput{
+		DefaultActions: []elbv2types.Action{
 			{
 				TargetGroupArn: aws.String(targetGroupArn),
-				Type:           aws.String(c.actionType),
+				Type:           c.actionType,
 			},
 		},
 		LoadBalancerArn: nlb.LoadBalancerArn,
-		Port:            aws.Int64(int64(port)),
-		Protocol:        &c.protocol,
+		Port:            aws.Int32(int32(spec.Port)),
+		Protocol:        spec.listenerProtocol(),
+		Certificates:    spec.certificates(),
+		Tags:            c.tags(spec.ServiceName),
 	})
+	var duplicate *elbv2types.DuplicateListenerException
+	if errors.As(err, &duplicate) {
+		listenerArn, reuseErr := c.reuseListener(ctx, elb, aws.ToString(nlb.LoadBalancerArn), targetGroupArn, spec)
+		if reuseErr != nil {
+			return "", "", fmt.Errorf("%w. Not reusing the existing listener: %v", err, reuseErr)
+		}
+		logger.Info("aws: reusing existing listener", "listener", listenerArn)
+		return listenerArn, targetGroupArn, nil
+	}
 	if err != nil {
 		return "", "", err
 	}
-	log.Log.Info("aws: listener created")
-	return *listener.Listeners[0].ListenerArn, targetGroupArn, nil
+	logger.Info("aws: listener created")
+	return aws.ToString(listener.Listeners[0].ListenerArn), targetGroupArn, nil
+}
+
+// EnsureTargetGroup returns the target group of spec, creating it if it does
+// not exist yet.
+func (c client) EnsureTargetGroup(ctx context.Context, spec ListenerSpec) (string, error) {
+	vpc := c.VPC
+	if c.assumesRole(spec.NLB) {
+		nlb, err := c.describeNLB(ctx, spec.NLB)
+		if err != nil {
+			return "", err
+		}
+		if nlb == nil {
+			return "", fmt.Errorf("%w: nlb %s", ErrNotFound, spec.NLB)
+		}
+		vpc = aws.ToString(nlb.VpcId)
+	}
+	return c.GetTargetGroupArn(ctx, vpc, spec)
 }
 
-func (c client) GetTargetGroupArn(vpcId string, nodePort int64) (string, error) {
-	pageSize := int64(50)
-	targetGroupName := fmt.Sprintf("%d", nodePort)
-	groups, err := c.Elb.DescribeTargetGroups(&elbv2.DescribeTargetGroupsInput{
-		Names:    []*string{&targetGroupName},
-		PageSize: &pageSize,
+// RetargetListener points a listener at targetArn instead of oldTargetArn,
+// keeping its port, and deletes oldTargetArn unless other listeners still
+// forward to it or the controller does not own it.
+func (c client) RetargetListener(ctx context.Context, listenerArn string, oldTargetArn string, targetArn string) error {
+	elb := c.elbForArn(listenerArn)
+	_, err := elb.ModifyListener(ctx, &elbv2.ModifyListenerInput{
+		ListenerArn: aws.String(listenerArn),
+		DefaultActions: []elbv2types.Action{
+			{
+				TargetGroupArn: aws.String(targetArn),
+				Type:           c.actionType,
+			},
+		},
 	})
 	if err != nil {
-		if !strings.Contains(err.Error(), "TargetGroupNotFound") {
-			return "", err
+		return err
+	}
+	if oldTargetArn == "" || oldTargetArn == targetArn {
+		return nil
+	}
+	gone, err := c.checkOwned(ctx, oldTargetArn, "")
+	if errors.Is(err, ErrNotOwned) {
+		loggerFrom(ctx).Info("aws: keeping previous target group", "targetGroup", oldTargetArn, "reason", err.Error())
+		return nil
+	}
+	if err != nil || gone {
+		return err
+	}
+	return c.deleteUnusedTargetGroup(ctx, elb, oldTargetArn)
+}
+
+func (s ListenerSpec) certificates() []elbv2types.Certificate {
+	if s.Certificate == "" {
+		return nil
+	}
+	return []elbv2types.Certificate{{CertificateArn: aws.String(s.Certificate)}}
+}
+
+// SyncListenerCertificate sets the certificate of a TLS listener, so that
+// rotating the certificate of a svc does not recreate its listener.
+func (c client) SyncListenerCertificate(ctx context.Context, listenerArn string, certificate string) error {
+	if certificate == "" {
+		return nil
+	}
+	elb := c.elbForArn(listenerArn)
+	listeners, err := elb.DescribeListeners(ctx, &elbv2.DescribeListenersInput{
+		ListenerArns: []string{listenerArn},
+	})
+	if err != nil {
+		return err
+	}
+	if len(listeners.Listeners) == 0 {
+		return fmt.Errorf("%w: listener %s not found", ErrDrifted, listenerArn)
+	}
+	for _, current := range listeners.Listeners[0].Certificates {
+		if aws.ToString(current.CertificateArn) == certificate {
+			return nil
 		}
 	}
-	if len(groups.TargetGroups) == 1 {
-		return *groups.TargetGroups[0].TargetGroupArn, nil
+	_, err = elb.ModifyListener(ctx, &elbv2.ModifyListenerInput{
+		ListenerArn:  aws.String(listenerArn),
+		Certificates: []elbv2types.Certificate{{CertificateArn: aws.String(certificate)}},
+	})
+	if err != nil {
+		return err
 	}
+	loggerFrom(ctx).Info("aws: listener certificate updated", "listener", listenerArn)
+	return nil
+}
 
-	if len(groups.TargetGroups) == 0 {
-		group, err := c.Elb.CreateTargetGroup(&elbv2.CreateTargetGroupInput{
+// maxTargetGroupName is the maximum length of a target group name.
+const maxTargetGroupName = 32
+
+// targetGroupName names the target group of a listener after the template
+// of the client, or after its NodePort if there is none. TCP target groups
+// keep the bare NodePort as their name. ip target groups are not shared
+// between Services and are named after a hash of the Service port instead,
+// unless the template names the Service.
+func (c client) targetGroupName(spec ListenerSpec) string {
+	ip := spec.targetType() == elbv2types.TargetTypeEnumIp
+	if c.tgNames != "" && (!ip || namesService(c.tgNames)) {
+		return templateTargetGroupName(c.tgNames, c.clusterID, spec)
+	}
+	if ip {
+		sum := sha256.Sum256([]byte(spec.ServiceName + "/" + string(spec.protocol())))
+		return "ip-" + hex.EncodeToString(sum[:])[:24]
+	}
+	if spec.protocol() == elbv2types.ProtocolEnumTcp {
+		return fmt.Sprintf("%d", spec.NodePort)
+	}
+	return fmt.Sprintf("%d-%s", spec.NodePort, strings.ToLower(strings.ReplaceAll(string(spec.protocol()), "_", "-")))
+}
+
+// ValidTargetGroupNameTemplate reports whether template only uses the
+// placeholders of Options.TargetGroupNameTemplate.
+func ValidTargetGroupNameTemplate(template string) bool {
+	return !strings.ContainsAny(targetGroupNameReplacer("", "", "", "", "").Replace(template), "{}")
+}
+
+// namesService reports whether template tells the target groups of
+// different Services apart, which ip target groups need as they hold the pods
+// of a single Service.
+func namesService(template string) bool {
+	return strings.Contains(template, "{namespace}") && strings.Contains(template, "{svc}")
+}
+
+func targetGroupNameReplacer(cluster, namespace, svc, port, protocol string) *strings.Replacer {
+	return strings.NewReplacer(
+		"{cluster}", cluster,
+		"{namespace}", namespace,
+		"{svc}", svc,
+		"{port}", port,
+		"{protocol}", protocol,
+	)
+}
+
+// templateTargetGroupName fills in a target group name template. Characters
+// target group names cannot hold are replaced by hyphens, and names too long
+// are truncated and suffixed with a hash of the full name, keeping them
+// unique.
+func templateTargetGroupName(template string, clusterID string, spec ListenerSpec) string {
+	serviceName, _, _ := strings.Cut(spec.ServiceName, ":")
+	namespace, svc, _ := strings.Cut(serviceName, "/")
+	name := targetGroupNameReplacer(
+		clusterID,
+		namespace,
+		svc,
+		strconv.Itoa(int(spec.targetGroupPort())),
+		strings.ReplaceAll(string(spec.protocol()), "_", "-"),
+	).Replace(template)
+
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(name))
+	name = strings.Trim(name, "-")
+	if len(name) <= maxTargetGroupName {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:8]
+	return strings.TrimRight(name[:maxTargetGroupName-len(hash)-1], "-") + "-" + hash
+}
+
+func (c client) GetTargetGroupArn(ctx context.Context, vpcId string, spec ListenerSpec) (string, error) {
+	targetGroupName := c.targetGroupName(spec)
+	targetGroupPort := spec.targetGroupPort()
+	elb := c.elbForNLB(spec.NLB)
+	groups, err := elb.DescribeTargetGroups(ctx, &elbv2.DescribeTargetGroupsInput{
+		Names:    []string{targetGroupName},
+		PageSize: aws.Int32(50),
+	})
+	var notFound *elbv2types.TargetGroupNotFoundException
+	if err != nil && !errors.As(err, &notFound) {
+		return "", err
+	}
+	if groups != nil && len(groups.TargetGroups) == 1 {
+		return aws.ToString(groups.TargetGroups[0].TargetGroupArn), nil
+	}
+
+	if groups == nil || len(groups.TargetGroups) == 0 {
+		input := &elbv2.CreateTargetGroupInput{
 			Name:       aws.String(targetGroupName),
-			Port:       aws.Int64(nodePort),
-			Protocol:   aws.String(elbv2.ProtocolEnumTcp),
-			TargetType: aws.String(elbv2.TargetTypeEnumInstance),
+			Port:       aws.Int32(targetGroupPort),
+			Protocol:   spec.protocol(),
+			TargetType: spec.targetType(),
 			VpcId:      aws.String(vpcId),
-		})
+			Tags:       c.tags(spec.ServiceName),
+		}
+		spec.HealthCheck.apply(input)
+		group, err := elb.CreateTargetGroup(ctx, input)
 		if err != nil {
 			return "", err
 		}
-		instances, err := c.Ec2Client.DescribeInstances(&ec2.DescribeInstancesInput{
-			Filters: []*ec2.Filter{
-				&ec2.Filter{
-					Name: aws.String("vpc-id"),
-					Values: []*string{
-						aws.String(c.VPC),
-					},
-				},
-			},
+		return aws.ToString(group.TargetGroups[0].TargetGroupArn), nil
+	}
+	return "", errors.New("aws: TargetGroup not found")
+}
+
+// SyncTargetGroupHealthCheck sets the health check of a target group to hc.
+// Fields hc leaves empty are left alone.
+func (c client) SyncTargetGroupHealthCheck(ctx context.Context, targetArn string, hc HealthCheck) error {
+	if hc == (HealthCheck{}) {
+		return nil
+	}
+	group, err := c.describeTargetGroup(ctx, targetArn)
+	if err != nil {
+		return err
+	}
+	input := &elbv2.ModifyTargetGroupInput{TargetGroupArn: aws.String(targetArn)}
+	if !hc.modify(group, input) {
+		return nil
+	}
+	loggerFrom(ctx).Info("aws: updating target group health check", "targetGroup", targetArn)
+	_, err = c.elbForArn(targetArn).ModifyTargetGroup(ctx, input)
+	c.cache.invalidateTargetGroup(targetArn)
+	return err
+}
+
+// describeTargetGroup returns the target group of the given ARN, from the
+// describe cache of the client while fresh.
+func (c client) describeTargetGroup(ctx context.Context, targetArn string) (elbv2types.TargetGroup, error) {
+	if group, ok := c.cache.targetGroup(targetArn); ok {
+		return group, nil
+	}
+	groups, err := c.elbForArn(targetArn).DescribeTargetGroups(ctx, &elbv2.DescribeTargetGroupsInput{
+		TargetGroupArns: []string{targetArn},
+	})
+	if err != nil {
+		return elbv2types.TargetGroup{}, err
+	}
+	if len(groups.TargetGroups) != 1 {
+		return elbv2types.TargetGroup{}, fmt.Errorf("aws: target group %s not found", targetArn)
+	}
+	c.cache.putTargetGroup(targetArn, groups.TargetGroups[0])
+	return groups.TargetGroups[0], nil
+}
+
+// SyncTargetGroupAttributes sets the given target group attributes, keyed by
+// their AWS name, if they differ from the current ones. Attributes not in
+// attributes are left alone.
+func (c client) SyncTargetGroupAttributes(ctx context.Context, targetArn string, attributes map[string]string) error {
+	if len(attributes) == 0 {
+		return nil
+	}
+	elb := c.elbForArn(targetArn)
+	current, err := elb.DescribeTargetGroupAttributes(ctx, &elbv2.DescribeTargetGroupAttributesInput{
+		TargetGroupArn: aws.String(targetArn),
+	})
+	if err != nil {
+		return err
+	}
+	values := map[string]string{}
+	for _, a := range current.Attributes {
+		values[aws.ToString(a.Key)] = aws.ToString(a.Value)
+	}
+
+	var changed []elbv2types.TargetGroupAttribute
+	for key, value := range attributes {
+		if values[key] != value {
+			changed = append(changed, elbv2types.TargetGroupAttribute{Key: aws.String(key), Value: aws.String(value)})
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	loggerFrom(ctx).Info("aws: updating target group attributes", "targetGroup", targetArn, "attributes", len(changed))
+	_, err = elb.ModifyTargetGroupAttributes(ctx, &elbv2.ModifyTargetGroupAttributesInput{
+		TargetGroupArn: aws.String(targetArn),
+		Attributes:     changed,
+	})
+	return err
+}
+
+// SyncTargets makes the targets registered in a target group match targets,
+// registering the missing ones and deregistering the rest, targetsPerCall
+// at a time.
+func (c client) SyncTargets(ctx context.Context, targetArn string, targets []Target) error {
+	elb := c.elbForArn(targetArn)
+	health, err := elb.DescribeTargetHealth(ctx, &elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(targetArn),
+	})
+	if err != nil {
+		return err
+	}
+	wanted := map[Target]bool{}
+	for _, t := range targets {
+		wanted[t] = true
+	}
+	var deregister []elbv2types.TargetDescription
+	for _, desc := range health.TargetHealthDescriptions {
+		t := Target{ID: aws.ToString(desc.Target.Id), Port: int(aws.ToInt32(desc.Target.Port))}
+		if wanted[t] {
+			delete(wanted, t)
+			continue
+		}
+		deregister = append(deregister, *desc.Target)
+	}
+	var register []elbv2types.TargetDescription
+	for t := range wanted {
+		register = append(register, elbv2types.TargetDescription{
+			Id:   aws.String(t.ID),
+			Port: aws.Int32(int32(t.Port)),
+		})
+	}
+
+	for _, chunk := range chunkTargets(register, targetsPerCall) {
+		_, err = elb.RegisterTargets(ctx, &elbv2.RegisterTargetsInput{
+			TargetGroupArn: aws.String(targetArn),
+			Targets:        chunk,
 		})
 		if err != nil {
-			return "", err
-		}
-		targetDescs := []*elbv2.TargetDescription{}
-		for _, i := range instances.Reservations[0].Instances {
-			targetDescs = append(targetDescs, &elbv2.TargetDescription{
-				Id:   i.InstanceId,
-				Port: aws.Int64(nodePort),
-			})
+			return err
 		}
-		_, err = c.Elb.RegisterTargets(&elbv2.RegisterTargetsInput{
-			TargetGroupArn: group.TargetGroups[0].TargetGroupArn,
-			Targets:        targetDescs,
+	}
+	for _, chunk := range chunkTargets(deregister, targetsPerCall) {
+		_, err = elb.DeregisterTargets(ctx, &elbv2.DeregisterTargetsInput{
+			TargetGroupArn: aws.String(targetArn),
+			Targets:        chunk,
 		})
 		if err != nil {
-			return "", err
+			return err
 		}
-		return *group.TargetGroups[0].TargetGroupArn, nil
 	}
-	return "", errors.New("aws: TargetGroup not found")
+	return nil
+}
+
+// chunkTargets splits targets into chunks of at most size targets.
+func chunkTargets(targets []elbv2types.TargetDescription, size int) [][]elbv2types.TargetDescription {
+	var chunks [][]elbv2types.TargetDescription
+	for start := 0; start < len(targets); start += size {
+		end := start + size
+		if end > len(targets) {
+			end = len(targets)
+		}
+		chunks = append(chunks, targets[start:end])
+	}
+	return chunks
 }
 
-func New(_ context.Context) Client {
-	s := session.Must(session.NewSession())
-	s.Config.Region = aws.String("us-west-1")
-	var in *ec2.EC2
-	in = ec2.New(s)
+// ListAllocations returns the listeners on the given NLBs that were created by
+// this cluster, so that the allocation state can be rebuilt from AWS alone.
+func (c client) ListAllocations(ctx context.Context, nlbNames []string) ([]ListenerAllocation, error) {
+	var allocations []ListenerAllocation
+	for _, nlbName := range nlbNames {
+		elb := c.elbForNLB(nlbName)
+		nlb, err := c.describeNLB(ctx, nlbName)
+		if err != nil {
+			return nil, err
+		}
+		if nlb == nil {
+			return nil, fmt.Errorf("%w: nlb %s", ErrNotFound, nlbName)
+		}
+
+		listeners := map[string]elbv2types.Listener{}
+		var listenerArns []string
+		paginator := elbv2.NewDescribeListenersPaginator(elb, &elbv2.DescribeListenersInput{
+			LoadBalancerArn: nlb.LoadBalancerArn,
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, l := range page.Listeners {
+				listeners[aws.ToString(l.ListenerArn)] = l
+				listenerArns = append(listenerArns, aws.ToString(l.ListenerArn))
+			}
+		}
+
+		for start := 0; start < len(listenerArns); start += describeTagsMaxArns {
+			end := start + describeTagsMaxArns
+			if end > len(listenerArns) {
+				end = len(listenerArns)
+			}
+			out, err := elb.DescribeTags(ctx, &elbv2.DescribeTagsInput{ResourceArns: listenerArns[start:end]})
+			if err != nil {
+				return nil, err
+			}
+			for _, desc := range out.TagDescriptions {
+				tags := map[string]string{}
+				for _, t := range desc.Tags {
+					tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+				}
+				if !c.ownedBy(tags) || tags[TagService] == "" {
+					continue
+				}
+				// the target group is read like CheckListener reads it, so
+				// that rebuilt allocations are not taken for drifted ones
+				l := listeners[aws.ToString(desc.ResourceArn)]
+				targetArn := listenerTargetGroupArn(l)
+				if targetArn == "" {
+					continue
+				}
+				allocations = append(allocations, ListenerAllocation{
+					ServiceNamespacedName: tags[TagService],
+					NLB:                   nlbName,
+					Port:                  int(aws.ToInt32(l.Port)),
+					ListenerArn:           aws.ToString(l.ListenerArn),
+					TargetArn:             targetArn,
+				})
+			}
+		}
+	}
+	return allocations, nil
+}
+
+// LoadConfig loads the AWS configuration of opts, with the credentials,
+// retries and rate limit of opts applied, and detects the region if none is
+// configured.
+func LoadConfig(ctx context.Context, opts Options) (aws.Config, error) {
+	loadOptions, err := opts.Credentials.loadOptions()
+	if err != nil {
+		return aws.Config{}, err
+	}
+	imdsClient := newIMDSClient(opts.DisableIMDS)
+	loadOptions = append(loadOptions,
+		config.WithEC2RoleCredentialOptions(func(o *ec2rolecreds.Options) {
+			o.Client = imdsClient
+		}),
+		config.WithRetryer(func() aws.Retryer {
+			return newRetryer(opts)
+		}),
+		config.WithAPIOptions(append([]func(*middleware.Stack) error{
+			newThrottler(opts.RateLimit, opts.Burst).middleware,
+			retryConsistencyErrors,
+			logCalls,
+			recordCalls,
+			classifyErrors,
+			operationTimeouts{read: opts.ReadTimeout, write: opts.WriteTimeout}.middleware,
+		}, append(opts.breakerAPIOptions(), opts.APIOptions...)...)),
+	)
+	if opts.Region != "" {
+		loadOptions = append(loadOptions, config.WithRegion(opts.Region))
+	}
+	if resolver := opts.Endpoints.resolver(); resolver != nil {
+		loadOptions = append(loadOptions, config.WithEndpointResolverWithOptions(resolver))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOptions...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if cfg.Region == "" && opts.DisableIMDS {
+		return aws.Config{}, errors.New("aws: no region configured and instance metadata is disabled")
+	}
+	if cfg.Region == "" {
+		region, err := imdsClient.GetRegion(ctx, &imds.GetRegionInput{})
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("aws: no region configured and unable to detect it from instance metadata: %w", err)
+		}
+		cfg.Region = region.Region
+	}
+	if cfg.Credentials, err = opts.Credentials.provider(cfg); err != nil {
+		return aws.Config{}, err
+	}
+	loggerFrom(ctx).Info("aws: using region", "region", cfg.Region)
+	return cfg, nil
+}
 
+func New(ctx context.Context, opts Options) (Client, error) {
+	if opts.ClusterID == "" {
+		return nil, errors.New("aws: a cluster ID is required to tell the resources of this cluster apart")
+	}
+	cfg, err := LoadConfig(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	ec2Client := ec2.NewFromConfig(cfg)
+	vpc, err := discoverVPC(ctx, ec2Client, opts)
+	if err != nil {
+		return nil, err
+	}
 	return &client{
-		Elb:        *elbv2.New(s),
-		VPC:        os.Getenv("VPC_ID"),
-		Ec2Client:  in,
-		protocol:   "TCP",
-		actionType: elbv2.ActionTypeEnumForward,
+		Elb:           elbv2.NewFromConfig(cfg),
+		VPC:           vpc,
+		Ec2Client:     ec2Client,
+		Route53:       route53.NewFromConfig(cfg),
+		Autoscaling:   autoscaling.NewFromConfig(cfg),
+		ServiceQuotas: servicequotas.NewFromConfig(cfg),
+		clusterID:     opts.ClusterID,
+		clusterName:   opts.clusterName(),
+		extraTags:     opts.Tags,
+		tgNames:       opts.TargetGroupNameTemplate,
+		hostedZoneID:  opts.Route53HostedZoneID,
+		actionType:    elbv2types.ActionTypeEnumForward,
+		roles:         newRoleClients(cfg),
+		cache:         newDescribeCache(opts.DescribeCacheTTL),
+	}, nil
+}
+
+// newRetryer returns the retryer of opts. Besides throttling, server errors
+// and connection errors, which the SDK retries, it retries the errors
+// retryConsistencyErrors marks.
+func newRetryer(opts Options) aws.Retryer {
+	standard := func(o *retry.StandardOptions) {
+		if opts.RetryMaxBackoff > 0 {
+			o.MaxBackoff = opts.RetryMaxBackoff
+		}
+	}
+	var retryer aws.Retryer
+	switch opts.RetryMode {
+	case aws.RetryModeAdaptive:
+		retryer = retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+			o.StandardOptions = append(o.StandardOptions, standard)
+		})
+	default:
+		retryer = retry.NewStandard(standard)
+	}
+	if opts.MaxAttempts > 0 {
+		retryer = retry.AddWithMaxAttempts(retryer, opts.MaxAttempts)
 	}
+	return retryer
 }
 
 type Client interface {
-	CreateNLBListenerForPort(
-		nlb string,
-		port int,
-		nodePort int,
-		svcName string,
-	) (string, string, error)
+	CreateNLBListenerForPort(ctx context.Context, spec ListenerSpec) (string, string, error)
+	AdoptListener(ctx context.Context, listenerArn string, spec ListenerSpec) (ListenerAllocation, error)
+	EnsureTargetGroup(ctx context.Context, spec ListenerSpec) (string, error)
+	RetargetListener(ctx context.Context, listenerArn string, oldTargetArn string, targetArn string) error
 	CheckListener(
 		ctx context.Context,
 		listenerArn string,
 		targetArn string,
-		nlb string,
-		exposedPort int,
-		nodePort int,
+		spec ListenerSpec,
 	) error
-	DeleteListenerAndTargetArn(listenerArn string, targetArn string) error
+	ListenerExists(ctx context.Context, listenerArn string) (bool, error)
+	DeleteListenerAndTargetArn(ctx context.Context, serviceName string, listenerArn string, targetArn string) error
+	SyncTargets(ctx context.Context, targetArn string, targets []Target) error
+	InstanceRegistered(ctx context.Context, targetArn string, instanceID string) (bool, error)
+	PendingTerminations(ctx context.Context, hook string) ([]LifecycleAction, error)
+	CompleteLifecycleAction(ctx context.Context, action LifecycleAction) error
+	ListClusterInstances(ctx context.Context) ([]Instance, error)
+	ListenerQuota(ctx context.Context) (int, error)
+	AssumeRole(nlb string, role Role) error
+	SyncTargetGroupHealthCheck(ctx context.Context, targetArn string, hc HealthCheck) error
+	SyncListenerCertificate(ctx context.Context, listenerArn string, certificate string) error
+	EnsureDNSRecord(ctx context.Context, name string, target string, svc string) error
+	DeleteDNSRecord(ctx context.Context, name string, svc string) error
+	SyncTargetGroupAttributes(ctx context.Context, targetArn string, attributes map[string]string) error
+	EnsureNLB(ctx context.Context, spec NLBSpec) (NLB, error)
+	DeleteNLB(ctx context.Context, pool string, name string) error
+	DiscoverNLBs(ctx context.Context, key string, value string) ([]NLBDescription, error)
+	ListAllocations(ctx context.Context, nlbs []string) ([]ListenerAllocation, error)
+	Ping(ctx context.Context) error
 }
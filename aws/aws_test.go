@@ -0,0 +1,997 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func TestTemplateTargetGroupName(t *testing.T) {
+	spec := ListenerSpec{ServiceName: "default/web:http", NodePort: 30080, Protocol: "TCP"}
+	if got, want := templateTargetGroupName("{cluster}-{namespace}-{svc}-{port}", "prod", spec), "prod-default-web-30080"; got != want {
+		t.Errorf("templateTargetGroupName() = %q, want %q", got, want)
+	}
+	if got, want := templateTargetGroupName("{svc}_{protocol}", "prod", ListenerSpec{ServiceName: "default/web:http", Protocol: "TCP_UDP"}), "web-tcp-udp"; got != want {
+		t.Errorf("templateTargetGroupName() = %q, want %q", got, want)
+	}
+}
+
+func TestTemplateTargetGroupNameTruncatesWithHash(t *testing.T) {
+	template := "{cluster}-{namespace}-{svc}-{port}"
+	long := ListenerSpec{ServiceName: "payments-production/checkout-frontend-service:http", NodePort: 30080}
+	other := ListenerSpec{ServiceName: "payments-production/checkout-frontend-service-v2:http", NodePort: 30080}
+
+	name := templateTargetGroupName(template, "prod-eu-west-1", long)
+	if len(name) > maxTargetGroupName {
+		t.Errorf("templateTargetGroupName() = %q, longer than %d characters", name, maxTargetGroupName)
+	}
+	if strings.HasSuffix(name, "-") || strings.Contains(name, "--") {
+		t.Errorf("templateTargetGroupName() = %q, has stray hyphens", name)
+	}
+	if again := templateTargetGroupName(template, "prod-eu-west-1", long); again != name {
+		t.Errorf("templateTargetGroupName() = %q then %q, want a stable name", name, again)
+	}
+	if otherName := templateTargetGroupName(template, "prod-eu-west-1", other); otherName == name {
+		t.Errorf("templateTargetGroupName() = %q for two services sharing a prefix", name)
+	}
+}
+
+func TestTargetGroupNameOfIPTargets(t *testing.T) {
+	spec := ListenerSpec{ServiceName: "default/web:http", Port: 9000, NodePort: 30080, TargetType: "ip"}
+
+	c := client{clusterID: "prod", tgNames: "{cluster}-{port}"}
+	if got := c.targetGroupName(spec); !strings.HasPrefix(got, "ip-") {
+		t.Errorf("targetGroupName() = %q, want the hashed name for a template without {namespace} and {svc}", got)
+	}
+
+	c.tgNames = "{cluster}-{namespace}-{svc}-{port}"
+	if got, want := c.targetGroupName(spec), "prod-default-web-9000"; got != want {
+		t.Errorf("targetGroupName() = %q, want %q", got, want)
+	}
+}
+
+func TestAccount(t *testing.T) {
+	tests := map[string]string{
+		"arn:aws:iam::123456789012:role/nlb-controller":                                                   "123456789012",
+		"arn:aws:elasticloadbalancing:us-west-1:210987654321:listener/net/shared/50dc6c495c0c9188/f2f7dc": "210987654321",
+		"arn:aws:iam::role":  "",
+		"not-an-arn:a:b:c:d": "",
+	}
+	for arn, want := range tests {
+		if got := account(arn); got != want {
+			t.Errorf("account(%q) = %q, want %q", arn, got, want)
+		}
+	}
+}
+
+func TestStaticCredentialsPickUpRotatedKeys(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, value string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(staticAccessKeyIDFile, "AKIAOLD")
+	write(staticSecretAccessKeyFile, "old-secret")
+
+	provider := staticCredentials{dir: dir}
+	creds, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyID != "AKIAOLD" || creds.SecretAccessKey != "old-secret" || creds.SessionToken != "" {
+		t.Errorf("Retrieve() = %+v, want the old key without a session token", creds)
+	}
+	if !creds.CanExpire {
+		t.Errorf("Retrieve() returned credentials that never expire, so rotated keys would not be read")
+	}
+
+	write(staticAccessKeyIDFile, "AKIANEW")
+	write(staticSecretAccessKeyFile, "new-secret")
+	if creds, err = provider.Retrieve(context.Background()); err != nil || creds.AccessKeyID != "AKIANEW" {
+		t.Errorf("Retrieve() after rotation = %+v, %v, want the new key", creds, err)
+	}
+}
+
+type recordingHTTPClient struct{ requests int }
+
+func (c *recordingHTTPClient) Do(*http.Request) (*http.Response, error) {
+	c.requests++
+	return &http.Response{StatusCode: http.StatusOK}, nil
+}
+
+func TestIMDSv2OnlyRefusesRequestsWithoutToken(t *testing.T) {
+	inner := &recordingHTTPClient{}
+	client := imdsv2Only{client: inner}
+
+	token, _ := http.NewRequest(http.MethodPut, "http://169.254.169.254/latest/api/token", nil)
+	if _, err := client.Do(token); err != nil {
+		t.Errorf("Do(token request) error = %v", err)
+	}
+	v1, _ := http.NewRequest(http.MethodGet, "http://169.254.169.254/latest/meta-data/mac", nil)
+	if _, err := client.Do(v1); !errors.Is(err, errIMDSv1) {
+		t.Errorf("Do(request without token) error = %v, want %v", err, errIMDSv1)
+	}
+	v2, _ := http.NewRequest(http.MethodGet, "http://169.254.169.254/latest/meta-data/mac", nil)
+	v2.Header.Set(imdsTokenHeader, "token")
+	if _, err := client.Do(v2); err != nil {
+		t.Errorf("Do(request with token) error = %v", err)
+	}
+	if inner.requests != 2 {
+		t.Errorf("sent %d requests, want 2", inner.requests)
+	}
+}
+
+func TestChunkTargets(t *testing.T) {
+	targets := make([]elbv2types.TargetDescription, 250)
+	chunks := chunkTargets(targets, 100)
+	if len(chunks) != 3 || len(chunks[0]) != 100 || len(chunks[2]) != 50 {
+		t.Errorf("chunkTargets(250 targets, 100) = %d chunks, want 100, 100 and 50 targets", len(chunks))
+	}
+	if chunks := chunkTargets(nil, 100); len(chunks) != 0 {
+		t.Errorf("chunkTargets(nil, 100) = %d chunks, want none", len(chunks))
+	}
+}
+
+func TestDescribeCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	cache := newDescribeCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.putNLB("shared", elbv2types.LoadBalancer{DNSName: aws.String("shared.elb.amazonaws.com")})
+	if lb, ok := cache.nlb("shared"); !ok || aws.ToString(lb.DNSName) != "shared.elb.amazonaws.com" {
+		t.Errorf("nlb() = %v, %v, want the cached NLB", lb, ok)
+	}
+	cache.invalidateNLB("shared")
+	if _, ok := cache.nlb("shared"); ok {
+		t.Errorf("nlb() found an invalidated NLB")
+	}
+
+	cache.putTargetGroup("arn", elbv2types.TargetGroup{})
+	now = now.Add(time.Minute)
+	if _, ok := cache.targetGroup("arn"); ok {
+		t.Errorf("targetGroup() found an expired target group")
+	}
+
+	var disabled *describeCache
+	disabled.putNLB("shared", elbv2types.LoadBalancer{})
+	if _, ok := disabled.nlb("shared"); ok {
+		t.Errorf("nlb() of a nil cache found an NLB")
+	}
+}
+
+func TestNLBNameFromArn(t *testing.T) {
+	name, err := nlbNameFromArn("arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/net/shared/50dc6c495c0c9188")
+	if err != nil || name != "shared" {
+		t.Errorf("nlbNameFromArn() = %q, %v, want shared", name, err)
+	}
+	for _, arn := range []string{
+		"arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/web/50dc6c495c0c9188",
+		"arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/web/50dc6c495c0c9188",
+		"",
+	} {
+		if _, err := nlbNameFromArn(arn); err == nil {
+			t.Errorf("nlbNameFromArn(%q) error = nil, want an error", arn)
+		}
+	}
+}
+
+func TestListenerTargetGroupArn(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		listener elbv2types.Listener
+		want     string
+	}{
+		{"forward", elbv2types.Listener{DefaultActions: []elbv2types.Action{{TargetGroupArn: aws.String("tg")}}}, "tg"},
+		{"forward config", elbv2types.Listener{DefaultActions: []elbv2types.Action{{ForwardConfig: &elbv2types.ForwardActionConfig{
+			TargetGroups: []elbv2types.TargetGroupTuple{{TargetGroupArn: aws.String("tg")}},
+		}}}}, "tg"},
+		{"no action", elbv2types.Listener{}, ""},
+	} {
+		if got := listenerTargetGroupArn(tc.listener); got != tc.want {
+			t.Errorf("%s: listenerTargetGroupArn() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCallStatusAndRequestID(t *testing.T) {
+	err := &awshttp.ResponseError{
+		ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusBadRequest}},
+			Err:      errors.New("Throttling: Rate exceeded"),
+		},
+		RequestID: "failed-request",
+	}
+	if got := callStatus(middleware.Metadata{}, err); got != http.StatusBadRequest {
+		t.Errorf("callStatus() = %d, want %d", got, http.StatusBadRequest)
+	}
+	if got := callRequestID(middleware.Metadata{}, err); got != "failed-request" {
+		t.Errorf("callRequestID() = %q, want failed-request", got)
+	}
+
+	var metadata middleware.Metadata
+	awsmiddleware.SetRequestIDMetadata(&metadata, "request")
+	if got := callRequestID(metadata, nil); got != "request" {
+		t.Errorf("callRequestID() = %q, want request", got)
+	}
+	if got := callStatus(middleware.Metadata{}, errors.New("dial tcp: connection refused")); got != 0 {
+		t.Errorf("callStatus() = %d without a response, want 0", got)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, "Success"},
+		{&smithy.GenericAPIError{Code: "Throttling"}, "Throttling"},
+		{fmt.Errorf("operation error: %w", &smithy.GenericAPIError{Code: "TargetGroupNotFound"}), "TargetGroupNotFound"},
+		{context.DeadlineExceeded, "Canceled"},
+		{errors.New("dial tcp: connection refused"), "Error"},
+	}
+	for _, tt := range tests {
+		if got := errorCode(tt.err); got != tt.want {
+			t.Errorf("errorCode(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := map[string]error{
+		"Throttling":                    ErrThrottled,
+		"TooManyRequestsException":      ErrThrottled,
+		"ListenerNotFound":              ErrNotFound,
+		"ResourceNotFoundException":     ErrNotFound,
+		"DuplicateListener":             ErrConflict,
+		"ResourceInUse":                 ErrConflict,
+		"TooManyListeners":              ErrQuotaExceeded,
+		"ServiceQuotaExceededException": ErrQuotaExceeded,
+		"ValidationError":               nil,
+	}
+	for code, want := range tests {
+		if got := errorClass(&smithy.GenericAPIError{Code: code}); got != want {
+			t.Errorf("errorClass(%s) = %v, want %v", code, got, want)
+		}
+	}
+
+	err := error(classifiedError{error: &elbv2types.DuplicateListenerException{}, class: ErrConflict})
+	err = fmt.Errorf("operation error: %w", err)
+	var duplicate *elbv2types.DuplicateListenerException
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &duplicate) {
+		t.Errorf("classified error %v lost its class or its api error", err)
+	}
+}
+
+func TestIsCredentialError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("operation error: %w", &smithy.GenericAPIError{Code: "ExpiredToken"}), true},
+		{&smithy.GenericAPIError{Code: "InvalidClientTokenId"}, true},
+		{&v4.SigningError{Err: errors.New("failed to retrieve credentials")}, true},
+		{&smithy.GenericAPIError{Code: "Throttling"}, false},
+		{errors.New("dial tcp: connection refused"), false},
+	}
+	for _, tt := range tests {
+		if got := IsCredentialError(tt.err); got != tt.want {
+			t.Errorf("IsCredentialError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestOperationTimeouts(t *testing.T) {
+	timeouts := operationTimeouts{read: 10 * time.Second, write: 30 * time.Second}
+	tests := map[string]time.Duration{
+		"DescribeLoadBalancers":  10 * time.Second,
+		"GetServiceQuota":        10 * time.Second,
+		"ListResourceRecordSets": 10 * time.Second,
+		"CreateListener":         30 * time.Second,
+		"RegisterTargets":        30 * time.Second,
+	}
+	for operation, want := range tests {
+		if got := timeouts.timeout(operation); got != want {
+			t.Errorf("timeout(%s) = %s, want %s", operation, got, want)
+		}
+	}
+}
+
+func TestConsistencyRetries(t *testing.T) {
+	retryer := newRetryer(Options{MaxAttempts: 5})
+	notFound := &elbv2types.TargetGroupNotFoundException{}
+	if retryer.IsErrorRetryable(notFound) {
+		t.Error("IsErrorRetryable() = true for a target group not found by any call")
+	}
+	err := consistencyError{notFound}
+	if !retryer.IsErrorRetryable(err) {
+		t.Error("IsErrorRetryable() = false for a target group not found right after create")
+	}
+	if !isGone(err) {
+		t.Error("isGone() = false, want the retried error to keep its type")
+	}
+	if !isConsistencyError(&elbv2types.ListenerNotFoundException{}) || isConsistencyError(&elbv2types.DuplicateListenerException{}) {
+		t.Error("isConsistencyError() does not tell not found errors apart")
+	}
+
+	tests := map[string]bool{
+		"CreateListener":    true,
+		"AddTags":           true,
+		"RegisterTargets":   true,
+		"DescribeListeners": false,
+		"DeleteTargetGroup": false,
+		"DeregisterTargets": false,
+		"RemoveTags":        false,
+	}
+	for operation, want := range tests {
+		if got := retriesConsistency(operation); got != want {
+			t.Errorf("retriesConsistency(%s) = %v, want %v", operation, got, want)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var changes []bool
+	b := newCircuitBreaker(3, 0, func(open bool, err error) { changes = append(changes, open) })
+	outage := errors.New("dial tcp: connection refused")
+
+	for i := 0; i < 2; i++ {
+		b.observe(outage)
+	}
+	b.observe(&smithy.GenericAPIError{Code: "Throttling"})
+	if err := b.allow("CreateListener"); err != nil {
+		t.Fatalf("allow() error = %v before the threshold", err)
+	}
+	b.observe(outage)
+	if err := b.allow("CreateListener"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("allow(CreateListener) error = %v, want ErrCircuitOpen", err)
+	}
+	if err := b.allow("DescribeListeners"); err != nil {
+		t.Errorf("allow(DescribeListeners) error = %v, want reads to probe the api", err)
+	}
+
+	b.observe(nil)
+	if err := b.allow("CreateListener"); err != nil {
+		t.Errorf("allow() error = %v after a successful probe", err)
+	}
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("changes = %v, want open then closed", changes)
+	}
+}
+
+type checkingClient struct {
+	Client
+	checked []string
+}
+
+func (c *checkingClient) CheckListener(_ context.Context, listenerArn string, _ string, _ ListenerSpec) error {
+	c.checked = append(c.checked, listenerArn)
+	return ErrDrifted
+}
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	inner := &checkingClient{}
+	d := NewDryRun(inner)
+	spec := ListenerSpec{NLB: "public", Port: 10001, NodePort: 30080, ServiceName: "default/web:http"}
+	for i := 0; i < 2; i++ {
+		listenerArn, targetArn, err := d.CreateNLBListenerForPort(ctx, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.CheckListener(ctx, listenerArn, targetArn, spec); err != nil {
+			t.Errorf("CheckListener() error = %v for a planned listener", err)
+		}
+	}
+	if err := d.CheckListener(ctx, "arn:aws:elasticloadbalancing:listener/net/public/1", "arn:aws:elasticloadbalancing:targetgroup/1", spec); !errors.Is(err, ErrDrifted) {
+		t.Errorf("CheckListener() error = %v for an existing listener, want the error of the wrapped client", err)
+	}
+	if err := d.DeleteListenerAndTargetArn(ctx, "default/web:http", "arn:aws:elasticloadbalancing:listener/net/public/1", "arn:aws:elasticloadbalancing:targetgroup/1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.AdoptListener(ctx, "arn:aws:elasticloadbalancing:listener/net/public/2", spec); !errors.Is(err, ErrDryRun) {
+		t.Errorf("AdoptListener() error = %v, want ErrDryRun", err)
+	}
+
+	mutations := d.Mutations()
+	var actions []string
+	for _, m := range mutations {
+		actions = append(actions, m.Action)
+	}
+	if got, want := strings.Join(actions, ","), "CreateListener,DeleteListener,AdoptListener"; got != want {
+		t.Fatalf("planned %s, want %s", got, want)
+	}
+	if m := mutations[0]; m.Count != 2 || m.Allocation != "default/web:http" || !strings.Contains(m.Detail, "port 10001 of nlb public") {
+		t.Errorf("planned %+v, want the listener of default/web:http on port 10001 of nlb public twice", m)
+	}
+	if len(inner.checked) != 1 {
+		t.Errorf("wrapped client checked %v, want only the existing listener", inner.checked)
+	}
+}
+
+// listenerStub creates numbered listeners that always exist, and counts
+// deletes.
+type listenerStub struct {
+	Client
+	created []string
+	deleted int
+}
+
+func (s *listenerStub) CreateNLBListenerForPort(_ context.Context, spec ListenerSpec) (string, string, error) {
+	listenerArn := fmt.Sprintf("arn:aws:elasticloadbalancing:listener/net/%s/%d", spec.NLB, len(s.created)+1)
+	s.created = append(s.created, listenerArn)
+	return listenerArn, "arn:aws:elasticloadbalancing:targetgroup/1", nil
+}
+
+func (s *listenerStub) ListenerExists(context.Context, string) (bool, error) {
+	return true, nil
+}
+
+func (s *listenerStub) DeleteListenerAndTargetArn(context.Context, string, string, string) error {
+	s.deleted++
+	return nil
+}
+
+func TestParseFaults(t *testing.T) {
+	faults, err := ParseFaults("throttle=0.5,methods=CreateNLBListenerForPort|SyncTargets,partial-create=1,delete-failure=0,consistency-delay=30s,seed=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Faults{Throttle: 0.5, Methods: []string{"CreateNLBListenerForPort", "SyncTargets"}, PartialCreate: 1, ConsistencyDelay: 30 * time.Second, Seed: 7}
+	if fmt.Sprint(faults) != fmt.Sprint(want) {
+		t.Errorf("ParseFaults() = %+v, want %+v", faults, want)
+	}
+	if faults, err := ParseFaults(""); err != nil || faults.Enabled() {
+		t.Errorf("ParseFaults(\"\") = %+v, %v, want no faults", faults, err)
+	}
+	for _, value := range []string{"throttle=2", "throttle", "latency=1s", "consistency-delay=soon"} {
+		if _, err := ParseFaults(value); err == nil {
+			t.Errorf("ParseFaults(%q) error = nil", value)
+		}
+	}
+}
+
+func TestFaultInjector(t *testing.T) {
+	ctx := context.Background()
+	inner := &listenerStub{}
+	f := NewFaultInjector(inner, Faults{Throttle: 1, Methods: []string{"DeleteListenerAndTargetArn"}})
+	spec := ListenerSpec{NLB: "public", Port: 10001, NodePort: 30080, ServiceName: "default/web:http"}
+	if _, _, err := f.CreateNLBListenerForPort(ctx, spec); err != nil {
+		t.Errorf("CreateNLBListenerForPort() error = %v, want only deletes throttled", err)
+	}
+	if err := f.DeleteListenerAndTargetArn(ctx, "default/web:http", "arn", "arn"); !errors.Is(err, ErrThrottled) || !IsThrottlingError(err) {
+		t.Errorf("DeleteListenerAndTargetArn() error = %v, want a throttling error", err)
+	}
+
+	f.SetFaults(Faults{PartialCreate: 1, DeleteFailure: 1, ConsistencyDelay: time.Minute})
+	now := time.Now()
+	f.now = func() time.Time { return now }
+	if _, _, err := f.CreateNLBListenerForPort(ctx, spec); !errors.Is(err, ErrInjected) {
+		t.Errorf("CreateNLBListenerForPort() error = %v, want ErrInjected", err)
+	}
+	if len(inner.created) != 2 {
+		t.Errorf("created %d listeners, want the listener of the partial failure created", len(inner.created))
+	}
+	if err := f.DeleteListenerAndTargetArn(ctx, "default/web:http", "arn", "arn"); !errors.Is(err, ErrInjected) || inner.deleted != 0 {
+		t.Errorf("DeleteListenerAndTargetArn() error = %v with %d deletes, want ErrInjected without deleting", err, inner.deleted)
+	}
+	if exists, err := f.ListenerExists(ctx, inner.created[1]); err != nil || exists {
+		t.Errorf("ListenerExists() = %v, %v right after creating it, want false", exists, err)
+	}
+	if exists, _ := f.ListenerExists(ctx, inner.created[0]); !exists {
+		t.Error("ListenerExists() = false for a listener created before the delay")
+	}
+	now = now.Add(time.Minute)
+	if exists, _ := f.ListenerExists(ctx, inner.created[1]); !exists {
+		t.Error("ListenerExists() = false after the consistency delay")
+	}
+}
+
+// elbStub answers the elbv2 calls of a client from memory, in place of the
+// ELB API. It keeps NLBs, listeners, target groups and their tags.
+type elbStub struct {
+	nlbs         map[string]elbv2types.LoadBalancer
+	listeners    map[string]elbv2types.Listener
+	targetGroups map[string]elbv2types.TargetGroup
+	tags         map[string]map[string]string
+}
+
+const stubArnPrefix = "arn:aws:elasticloadbalancing:us-east-1:123456789012:"
+
+func newELBStub(nlbs ...string) *elbStub {
+	s := &elbStub{
+		nlbs:         map[string]elbv2types.LoadBalancer{},
+		listeners:    map[string]elbv2types.Listener{},
+		targetGroups: map[string]elbv2types.TargetGroup{},
+		tags:         map[string]map[string]string{},
+	}
+	for _, name := range nlbs {
+		s.nlbs[name] = elbv2types.LoadBalancer{
+			LoadBalancerName: aws.String(name),
+			LoadBalancerArn:  aws.String(stubArnPrefix + "loadbalancer/net/" + name + "/1"),
+			VpcId:            aws.String("vpc-1"),
+		}
+	}
+	return s
+}
+
+// client returns a client of the cluster blue on the stub.
+func (s *elbStub) client() client {
+	elb := elbv2.New(elbv2.Options{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+		Retryer:     aws.NopRetryer{},
+		APIOptions:  stubAPIOptions(s.handle),
+	})
+	return client{Elb: elb, VPC: "vpc-1", clusterID: "blue", actionType: elbv2types.ActionTypeEnumForward}
+}
+
+// stubAPIOptions answers every call of an SDK client with handle, which
+// gets the input of the call and returns its output.
+func stubAPIOptions(handle func(params interface{}) (interface{}, error)) []func(*middleware.Stack) error {
+	return []func(*middleware.Stack) error{func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("stub",
+			func(_ context.Context, in middleware.InitializeInput, _ middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				result, err := handle(in.Parameters)
+				return middleware.InitializeOutput{Result: result}, middleware.Metadata{}, err
+			}), middleware.Before)
+	}}
+}
+
+// addListener adds a listener on port of nlb forwarding to a target group on
+// nodePort, both tagged with tags.
+func (s *elbStub) addListener(nlb string, port int, nodePort int, tags map[string]string) (string, string) {
+	targetArn := fmt.Sprintf("%stargetgroup/%d/%d", stubArnPrefix, nodePort, len(s.targetGroups)+1)
+	s.targetGroups[targetArn] = elbv2types.TargetGroup{
+		TargetGroupArn:  aws.String(targetArn),
+		TargetGroupName: aws.String(strconv.Itoa(nodePort)),
+		Port:            aws.Int32(int32(nodePort)),
+		TargetType:      elbv2types.TargetTypeEnumInstance,
+	}
+	s.tags[targetArn] = tags
+	listenerArn := fmt.Sprintf("%slistener/net/%s/1/%d", stubArnPrefix, nlb, len(s.listeners)+1)
+	s.listeners[listenerArn] = elbv2types.Listener{
+		ListenerArn:     aws.String(listenerArn),
+		LoadBalancerArn: s.nlbs[nlb].LoadBalancerArn,
+		Port:            aws.Int32(int32(port)),
+		Protocol:        elbv2types.ProtocolEnumTcp,
+		DefaultActions: []elbv2types.Action{{
+			Type:           elbv2types.ActionTypeEnumForward,
+			TargetGroupArn: aws.String(targetArn),
+		}},
+	}
+	s.tags[listenerArn] = tags
+	return listenerArn, targetArn
+}
+
+func stubTags(tags []elbv2types.Tag) map[string]string {
+	m := map[string]string{}
+	for _, t := range tags {
+		m[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return m
+}
+
+func (s *elbStub) handle(params interface{}) (interface{}, error) {
+	switch in := params.(type) {
+	case *elbv2.DescribeLoadBalancersInput:
+		out := &elbv2.DescribeLoadBalancersOutput{}
+		for _, name := range in.Names {
+			nlb, ok := s.nlbs[name]
+			if !ok {
+				return nil, &elbv2types.LoadBalancerNotFoundException{}
+			}
+			out.LoadBalancers = append(out.LoadBalancers, nlb)
+		}
+		return out, nil
+	case *elbv2.DescribeTargetGroupsInput:
+		out := &elbv2.DescribeTargetGroupsOutput{}
+		for _, group := range s.targetGroups {
+			match := false
+			for _, arn := range in.TargetGroupArns {
+				match = match || arn == aws.ToString(group.TargetGroupArn)
+			}
+			for _, name := range in.Names {
+				match = match || name == aws.ToString(group.TargetGroupName)
+			}
+			if !match {
+				continue
+			}
+			group.LoadBalancerArns = nil
+			for _, listener := range s.listeners {
+				if listenerTargetGroupArn(listener) == aws.ToString(group.TargetGroupArn) {
+					group.LoadBalancerArns = append(group.LoadBalancerArns, aws.ToString(listener.LoadBalancerArn))
+				}
+			}
+			out.TargetGroups = append(out.TargetGroups, group)
+		}
+		if len(out.TargetGroups) == 0 {
+			return nil, &elbv2types.TargetGroupNotFoundException{}
+		}
+		return out, nil
+	case *elbv2.CreateTargetGroupInput:
+		targetArn := fmt.Sprintf("%stargetgroup/%s/%d", stubArnPrefix, aws.ToString(in.Name), len(s.targetGroups)+1)
+		group := elbv2types.TargetGroup{
+			TargetGroupArn:  aws.String(targetArn),
+			TargetGroupName: in.Name,
+			Port:            in.Port,
+			Protocol:        in.Protocol,
+			TargetType:      in.TargetType,
+			VpcId:           in.VpcId,
+		}
+		s.targetGroups[targetArn] = group
+		s.tags[targetArn] = stubTags(in.Tags)
+		return &elbv2.CreateTargetGroupOutput{TargetGroups: []elbv2types.TargetGroup{group}}, nil
+	case *elbv2.CreateListenerInput:
+		for _, listener := range s.listeners {
+			if aws.ToString(listener.LoadBalancerArn) == aws.ToString(in.LoadBalancerArn) && aws.ToInt32(listener.Port) == aws.ToInt32(in.Port) {
+				return nil, &elbv2types.DuplicateListenerException{Message: aws.String("A listener already exists on this port for this load balancer")}
+			}
+		}
+		nlb, _ := nlbNameFromArn(aws.ToString(in.LoadBalancerArn))
+		listenerArn := fmt.Sprintf("%slistener/net/%s/1/%d", stubArnPrefix, nlb, len(s.listeners)+1)
+		listener := elbv2types.Listener{
+			ListenerArn:     aws.String(listenerArn),
+			LoadBalancerArn: in.LoadBalancerArn,
+			Port:            in.Port,
+			Protocol:        in.Protocol,
+			DefaultActions:  in.DefaultActions,
+		}
+		s.listeners[listenerArn] = listener
+		s.tags[listenerArn] = stubTags(in.Tags)
+		return &elbv2.CreateListenerOutput{Listeners: []elbv2types.Listener{listener}}, nil
+	case *elbv2.DescribeListenersInput:
+		out := &elbv2.DescribeListenersOutput{}
+		for _, arn := range in.ListenerArns {
+			listener, ok := s.listeners[arn]
+			if !ok {
+				return nil, &elbv2types.ListenerNotFoundException{}
+			}
+			out.Listeners = append(out.Listeners, listener)
+		}
+		if in.LoadBalancerArn != nil {
+			for _, listener := range s.listeners {
+				if aws.ToString(listener.LoadBalancerArn) == aws.ToString(in.LoadBalancerArn) {
+					out.Listeners = append(out.Listeners, listener)
+				}
+			}
+		}
+		return out, nil
+	case *elbv2.DescribeTagsInput:
+		out := &elbv2.DescribeTagsOutput{}
+		for _, arn := range in.ResourceArns {
+			tags, ok := s.tags[arn]
+			if !ok {
+				return nil, &elbv2types.ListenerNotFoundException{}
+			}
+			desc := elbv2types.TagDescription{ResourceArn: aws.String(arn)}
+			for k, v := range tags {
+				desc.Tags = append(desc.Tags, elbv2types.Tag{Key: aws.String(k), Value: aws.String(v)})
+			}
+			out.TagDescriptions = append(out.TagDescriptions, desc)
+		}
+		return out, nil
+	case *elbv2.DeleteListenerInput:
+		if _, ok := s.listeners[aws.ToString(in.ListenerArn)]; !ok {
+			return nil, &elbv2types.ListenerNotFoundException{}
+		}
+		delete(s.listeners, aws.ToString(in.ListenerArn))
+		delete(s.tags, aws.ToString(in.ListenerArn))
+		return &elbv2.DeleteListenerOutput{}, nil
+	case *elbv2.DeleteTargetGroupInput:
+		delete(s.targetGroups, aws.ToString(in.TargetGroupArn))
+		delete(s.tags, aws.ToString(in.TargetGroupArn))
+		return &elbv2.DeleteTargetGroupOutput{}, nil
+	}
+	return nil, fmt.Errorf("elbStub: unexpected call %T", params)
+}
+
+func TestCreateNLBListenerForPort(t *testing.T) {
+	ctx := context.Background()
+	stub := newELBStub("shared")
+	c := stub.client()
+	spec := ListenerSpec{NLB: "shared", Port: 9000, NodePort: 30080, ServiceName: "default/web:http"}
+
+	listenerArn, targetArn, err := c.CreateNLBListenerForPort(ctx, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := stub.targetGroups[targetArn]
+	if aws.ToString(group.TargetGroupName) != "30080" || aws.ToInt32(group.Port) != 30080 || aws.ToString(group.VpcId) != "vpc-1" {
+		t.Errorf("target group %+v, want 30080 on the NodePort in vpc-1", group)
+	}
+	for _, arn := range []string{listenerArn, targetArn} {
+		if tags := stub.tags[arn]; tags[TagCluster] != "blue" || tags[TagService] != "default/web:http" {
+			t.Errorf("tags of %s = %v, want those of default/web:http of blue", arn, tags)
+		}
+	}
+	if err := c.CheckListener(ctx, listenerArn, targetArn, spec); err != nil {
+		t.Errorf("CheckListener() error = %v for the created listener", err)
+	}
+	moved := spec
+	moved.NodePort = 30081
+	if err := c.CheckListener(ctx, listenerArn, targetArn, moved); !errors.Is(err, ErrTargetPortChanged) {
+		t.Errorf("CheckListener() error = %v after the NodePort changed, want ErrTargetPortChanged", err)
+	}
+
+	missing := spec
+	missing.NLB = "missing"
+	if _, _, err := c.CreateNLBListenerForPort(ctx, missing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CreateNLBListenerForPort() error = %v on an nlb that does not exist, want ErrNotFound", err)
+	}
+}
+
+func TestDeleteListenerAndTargetArnChecksOwnership(t *testing.T) {
+	ctx := context.Background()
+	stub := newELBStub("shared")
+	c := stub.client()
+	tests := map[string]map[string]string{
+		"untagged":              {},
+		"of another cluster":    {TagCluster: "green", TagService: "default/web:http"},
+		"of another allocation": {TagCluster: "blue", TagService: "default/api:http"},
+	}
+	for name, tags := range tests {
+		listenerArn, targetArn := stub.addListener("shared", 9000+len(stub.listeners), 30080+len(stub.listeners), tags)
+		if err := c.DeleteListenerAndTargetArn(ctx, "default/web:http", listenerArn, targetArn); !errors.Is(err, ErrNotOwned) {
+			t.Errorf("DeleteListenerAndTargetArn() error = %v for a listener %s, want ErrNotOwned", err, name)
+		}
+		if _, ok := stub.listeners[listenerArn]; !ok {
+			t.Errorf("listener %s deleted", name)
+		}
+		if _, ok := stub.targetGroups[targetArn]; !ok {
+			t.Errorf("target group of the listener %s deleted", name)
+		}
+	}
+
+	listenerArn, targetArn := stub.addListener("shared", 9100, 30100, map[string]string{TagCluster: "blue", TagService: "default/web:http"})
+	if err := c.DeleteListenerAndTargetArn(ctx, "default/web:http", listenerArn, targetArn); err != nil {
+		t.Fatalf("DeleteListenerAndTargetArn() error = %v for an owned listener", err)
+	}
+	if _, ok := stub.listeners[listenerArn]; ok {
+		t.Error("owned listener kept")
+	}
+	if _, ok := stub.targetGroups[targetArn]; ok {
+		t.Error("owned target group kept")
+	}
+	if err := c.DeleteListenerAndTargetArn(ctx, "default/web:http", listenerArn, targetArn); err != nil {
+		t.Errorf("DeleteListenerAndTargetArn() error = %v for a deleted listener, want it treated as deleted", err)
+	}
+}
+
+func TestCreateNLBListenerForPortReusesDuplicateListener(t *testing.T) {
+	ctx := context.Background()
+	stub := newELBStub("shared")
+	c := stub.client()
+	spec := ListenerSpec{NLB: "shared", Port: 9000, NodePort: 30080, ServiceName: "default/web:http"}
+	// created by an earlier reconcile whose result was lost
+	ownedArn, targetArn := stub.addListener("shared", 9000, 30080, map[string]string{TagCluster: "blue", TagService: "default/web:http"})
+
+	listenerArn, gotTargetArn, err := c.CreateNLBListenerForPort(ctx, spec)
+	if err != nil {
+		t.Fatalf("CreateNLBListenerForPort() error = %v on a port with an owned listener", err)
+	}
+	if listenerArn != ownedArn || gotTargetArn != targetArn {
+		t.Errorf("CreateNLBListenerForPort() = %s, %s, want the existing %s, %s", listenerArn, gotTargetArn, ownedArn, targetArn)
+	}
+	if len(stub.listeners) != 1 {
+		t.Errorf("listeners %v, want the existing one only", stub.listeners)
+	}
+
+	foreign := spec
+	foreign.Port = 9001
+	// a listener of another cluster on the same target group
+	foreignArn := stubArnPrefix + "listener/net/shared/1/foreign"
+	stub.listeners[foreignArn] = elbv2types.Listener{
+		ListenerArn:     aws.String(foreignArn),
+		LoadBalancerArn: stub.nlbs["shared"].LoadBalancerArn,
+		Port:            aws.Int32(9001),
+		Protocol:        elbv2types.ProtocolEnumTcp,
+		DefaultActions:  []elbv2types.Action{{Type: elbv2types.ActionTypeEnumForward, TargetGroupArn: aws.String(targetArn)}},
+	}
+	stub.tags[foreignArn] = map[string]string{TagCluster: "green", TagService: "default/web:http"}
+	// the error is the DuplicateListener of AWS, with the reason the listener
+	// was not reused
+	if listenerArn, _, err := c.CreateNLBListenerForPort(ctx, foreign); err == nil || !strings.Contains(err.Error(), ErrNotOwned.Error()) {
+		t.Errorf("CreateNLBListenerForPort() = %s, %v on a port with the listener of another cluster, want it refused as not owned", listenerArn, err)
+	}
+}
+
+func TestEndpointsResolver(t *testing.T) {
+	if resolver := (Endpoints{}).resolver(); resolver != nil {
+		t.Error("resolver() != nil without endpoints, want the SDK endpoints")
+	}
+
+	resolver := Endpoints{URL: "http://localstack:4566", EC2: "http://moto:5000"}.resolver()
+	tests := map[string]string{
+		elbv2.ServiceID:       "http://localstack:4566",
+		ec2.ServiceID:         "http://moto:5000",
+		route53.ServiceID:     "http://localstack:4566",
+		autoscaling.ServiceID: "http://localstack:4566",
+	}
+	for service, want := range tests {
+		endpoint, err := resolver.ResolveEndpoint(service, "eu-west-1")
+		if err != nil {
+			t.Fatalf("ResolveEndpoint(%s) error = %v", service, err)
+		}
+		if endpoint.URL != want || endpoint.SigningRegion != "eu-west-1" || !endpoint.HostnameImmutable {
+			t.Errorf("ResolveEndpoint(%s) = %+v, want %s signed for eu-west-1", service, endpoint, want)
+		}
+	}
+
+	resolver = Endpoints{ELBv2: "http://localstack:4566"}.resolver()
+	var notFound *aws.EndpointNotFoundError
+	if _, err := resolver.ResolveEndpoint(ec2.ServiceID, "eu-west-1"); !errors.As(err, &notFound) {
+		t.Errorf("ResolveEndpoint(%s) error = %v without its endpoint, want the SDK endpoint", ec2.ServiceID, err)
+	}
+}
+
+// ec2Stub returns an ec2 client whose DescribeSubnets returns subnets in
+// the given VPCs, and whose other calls fail.
+func ec2Stub(vpcs ...string) *ec2.Client {
+	return ec2.New(ec2.Options{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+		Retryer:     aws.NopRetryer{},
+		APIOptions: stubAPIOptions(func(params interface{}) (interface{}, error) {
+			if _, ok := params.(*ec2.DescribeSubnetsInput); !ok {
+				return nil, fmt.Errorf("ec2Stub: unexpected call %T", params)
+			}
+			out := &ec2.DescribeSubnetsOutput{}
+			for _, vpc := range vpcs {
+				out.Subnets = append(out.Subnets, ec2types.Subnet{VpcId: aws.String(vpc)})
+			}
+			return out, nil
+		}),
+	})
+}
+
+func TestDiscoverVPC(t *testing.T) {
+	ctx := context.Background()
+	opts := Options{ClusterID: "blue", ClusterName: "eks-blue", DisableIMDS: true}
+
+	if vpc, err := discoverVPC(ctx, ec2Stub("vpc-tagged"), Options{VPC: "vpc-set", DisableIMDS: true}); err != nil || vpc != "vpc-set" {
+		t.Errorf("discoverVPC() = %s, %v, want the configured vpc", vpc, err)
+	}
+	if vpc, err := discoverVPC(ctx, ec2Stub("vpc-tagged", "vpc-tagged"), opts); err != nil || vpc != "vpc-tagged" {
+		t.Errorf("discoverVPC() = %s, %v, want the vpc of the cluster subnets", vpc, err)
+	}
+	if _, err := discoverVPC(ctx, ec2Stub(), opts); err == nil || !strings.Contains(err.Error(), "no subnets tagged kubernetes.io/cluster/eks-blue") {
+		t.Errorf("discoverVPC() error = %v without tagged subnets", err)
+	}
+	if _, err := discoverVPC(ctx, ec2Stub("vpc-a", "vpc-b"), opts); err == nil || !strings.Contains(err.Error(), "in 2 vpcs") {
+		t.Errorf("discoverVPC() error = %v with tagged subnets in two vpcs", err)
+	}
+}
+
+func TestListClusterInstances(t *testing.T) {
+	ctx := context.Background()
+	reservation := func(ids ...string) ec2types.Reservation {
+		var r ec2types.Reservation
+		for _, id := range ids {
+			r.Instances = append(r.Instances, ec2types.Instance{InstanceId: aws.String(id), PrivateDnsName: aws.String(id + ".ec2.internal")})
+		}
+		return r
+	}
+	pages := map[string]*ec2.DescribeInstancesOutput{
+		"": {
+			Reservations: []ec2types.Reservation{reservation("i-1", "i-2"), reservation("i-3")},
+			NextToken:    aws.String("2"),
+		},
+		"2": {Reservations: []ec2types.Reservation{reservation("i-4")}},
+	}
+	var filters []ec2types.Filter
+	var failPage string
+	c := client{VPC: "vpc-1", clusterName: "eks-blue"}
+	c.Ec2Client = ec2.New(ec2.Options{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+		Retryer:     aws.NopRetryer{},
+		APIOptions: stubAPIOptions(func(params interface{}) (interface{}, error) {
+			in := params.(*ec2.DescribeInstancesInput)
+			filters = in.Filters
+			token := aws.ToString(in.NextToken)
+			if token == failPage {
+				return nil, &smithy.GenericAPIError{Code: "RequestLimitExceeded"}
+			}
+			return pages[token], nil
+		}),
+	})
+
+	failPage = "none"
+	instances, err := c.ListClusterInstances(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, instance := range instances {
+		ids = append(ids, instance.ID)
+	}
+	if got := strings.Join(ids, ","); got != "i-1,i-2,i-3,i-4" {
+		t.Errorf("instances %s, want those of every reservation and page", got)
+	}
+	if instances[0].PrivateDNSName != "i-1.ec2.internal" {
+		t.Errorf("private dns name %q, want i-1.ec2.internal", instances[0].PrivateDNSName)
+	}
+	wanted := map[string]string{"vpc-id": "vpc-1", "instance-state-name": "running", "tag-key": "kubernetes.io/cluster/eks-blue"}
+	for _, filter := range filters {
+		if want := wanted[aws.ToString(filter.Name)]; len(filter.Values) != 1 || filter.Values[0] != want {
+			t.Errorf("filter %s = %v, want %s", aws.ToString(filter.Name), filter.Values, want)
+		}
+	}
+
+	failPage = "2"
+	if _, err := c.ListClusterInstances(ctx); err == nil {
+		t.Error("ListClusterInstances() error = nil with the second page failing")
+	}
+}
+
+type ctxKey struct{}
+
+func TestCallsCarryContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), ctxKey{}, "reconcile-1")
+	stub := newELBStub("shared")
+	c := stub.client()
+	var values []interface{}
+	recordContext := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("recordContext",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				values = append(values, ctx.Value(ctxKey{}))
+				return next.HandleInitialize(ctx, in)
+			}), middleware.Before)
+	}
+	c.Elb = elbv2.New(elbv2.Options{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+		Retryer:     aws.NopRetryer{},
+		APIOptions:  append(stubAPIOptions(stub.handle), recordContext),
+	})
+	spec := ListenerSpec{NLB: "shared", Port: 9000, NodePort: 30080, ServiceName: "default/web:http"}
+
+	listenerArn, targetArn, err := c.CreateNLBListenerForPort(ctx, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CheckListener(ctx, listenerArn, targetArn, spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteListenerAndTargetArn(ctx, "default/web:http", listenerArn, targetArn); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) == 0 {
+		t.Fatal("no api calls recorded")
+	}
+	for i, value := range values {
+		if value != "reconcile-1" {
+			t.Errorf("call %d made with ctx value %v, want the ctx of the caller", i, value)
+		}
+	}
+
+	// a canceled ctx cancels the calls instead of running them
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	c.Elb = elbv2.New(elbv2.Options{Region: "us-east-1", Credentials: aws.AnonymousCredentials{}, Retryer: aws.NopRetryer{}})
+	if _, _, err := c.CreateNLBListenerForPort(canceled, spec); !errors.Is(err, context.Canceled) {
+		t.Errorf("CreateNLBListenerForPort() error = %v with a canceled ctx, want context.Canceled", err)
+	}
+}
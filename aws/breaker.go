@@ -0,0 +1,139 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// ErrCircuitOpen is returned instead of making a mutating ELB API call while
+// the circuit breaker is open.
+var ErrCircuitOpen = errors.New("aws: elb api is failing. Circuit breaker open")
+
+var (
+	circuitOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "aws_circuit_breaker_open",
+		Help: "Whether mutating ELB API calls are stopped because the ELB API keeps failing",
+	})
+	circuitOpenedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "aws_circuit_breaker_opened_total",
+		Help: "Total number of times the circuit breaker of the ELB API opened",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(circuitOpen, circuitOpenedTotal)
+}
+
+// circuitBreaker stops mutating ELB API calls once threshold calls in a row
+// failed, such as during an outage or with expired credentials, so that
+// reconciles do not half-complete changes. Read calls go on and probe the
+// API: the first one that succeeds after cooldown closes the breaker again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	// onChange, if set, is called when the breaker opens, with the error
+	// that opened it, and when it closes, with nil.
+	onChange func(open bool, err error)
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, onChange func(open bool, err error)) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, onChange: onChange}
+}
+
+// allow returns ErrCircuitOpen for mutating operations while the breaker is
+// open.
+func (b *circuitBreaker) allow(operation string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open || isRead(operation) {
+		return nil
+	}
+	return fmt.Errorf("%w since %s", ErrCircuitOpen, b.openedAt.Format(time.RFC3339))
+}
+
+// observe records the outcome of a call.
+func (b *circuitBreaker) observe(err error) {
+	failed, counts := breakerFailure(err)
+	if !counts {
+		return
+	}
+	b.mu.Lock()
+	var changed, open bool
+	switch {
+	case failed:
+		b.failures++
+		if !b.open && b.failures >= b.threshold {
+			b.open, b.openedAt = true, time.Now()
+			changed, open = true, true
+			circuitOpen.Set(1)
+			circuitOpenedTotal.Inc()
+		}
+	default:
+		b.failures = 0
+		if b.open && time.Since(b.openedAt) >= b.cooldown {
+			b.open = false
+			changed = true
+			circuitOpen.Set(0)
+		}
+	}
+	b.mu.Unlock()
+	if changed && b.onChange != nil {
+		if !open {
+			err = nil
+		}
+		b.onChange(open, err)
+	}
+}
+
+// breakerFailure reports whether err shows the API failing, and whether it
+// tells anything about the API at all. Calls that were canceled or throttled
+// do not count. Errors AWS returned for the request itself, such as a missing
+// listener, show the API working.
+func breakerFailure(err error) (failed bool, counts bool) {
+	switch {
+	case err == nil:
+		return false, true
+	case errors.Is(err, context.Canceled), IsThrottlingError(err):
+		return false, false
+	case IsCredentialError(err):
+		return true, true
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode() >= 500, true
+	}
+	// no response, such as a connection error or an expired deadline
+	return true, true
+}
+
+// middleware applies the breaker to every ELB API call, including its
+// retries.
+func (b *circuitBreaker) middleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("NLBControllerCircuitBreaker",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			if awsmiddleware.GetServiceID(ctx) != elbv2.ServiceID {
+				return next.HandleInitialize(ctx, in)
+			}
+			if err := b.allow(awsmiddleware.GetOperationName(ctx)); err != nil {
+				return middleware.InitializeOutput{}, middleware.Metadata{}, err
+			}
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			b.observe(err)
+			return out, metadata, err
+		}), middleware.After)
+}
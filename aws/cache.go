@@ -0,0 +1,111 @@
+package aws
+
+import (
+	"sync"
+	"time"
+
+	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+)
+
+// describeCache holds NLBs described by name and target groups described by
+// ARN for ttl, so that reconciles of many Services do not describe the same
+// resources over and over. Entries are invalidated when the client changes
+// the resource. A nil describeCache caches nothing.
+type describeCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu           sync.Mutex
+	nlbs         map[string]cachedNLB
+	targetGroups map[string]cachedTargetGroup
+}
+
+type cachedNLB struct {
+	nlb     elbv2types.LoadBalancer
+	expires time.Time
+}
+
+type cachedTargetGroup struct {
+	group   elbv2types.TargetGroup
+	expires time.Time
+}
+
+// newDescribeCache returns a cache of entries kept for ttl, or nil if ttl is
+// not positive.
+func newDescribeCache(ttl time.Duration) *describeCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &describeCache{
+		ttl:          ttl,
+		now:          time.Now,
+		nlbs:         map[string]cachedNLB{},
+		targetGroups: map[string]cachedTargetGroup{},
+	}
+}
+
+// nlb returns the cached NLB of the given name.
+func (c *describeCache) nlb(name string) (elbv2types.LoadBalancer, bool) {
+	if c == nil {
+		return elbv2types.LoadBalancer{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.nlbs[name]
+	if !ok || !c.now().Before(entry.expires) {
+		delete(c.nlbs, name)
+		return elbv2types.LoadBalancer{}, false
+	}
+	return entry.nlb, true
+}
+
+func (c *describeCache) putNLB(name string, nlb elbv2types.LoadBalancer) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nlbs[name] = cachedNLB{nlb: nlb, expires: c.now().Add(c.ttl)}
+}
+
+func (c *describeCache) invalidateNLB(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.nlbs, name)
+}
+
+// targetGroup returns the cached target group of the given ARN.
+func (c *describeCache) targetGroup(arn string) (elbv2types.TargetGroup, bool) {
+	if c == nil {
+		return elbv2types.TargetGroup{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.targetGroups[arn]
+	if !ok || !c.now().Before(entry.expires) {
+		delete(c.targetGroups, arn)
+		return elbv2types.TargetGroup{}, false
+	}
+	return entry.group, true
+}
+
+func (c *describeCache) putTargetGroup(arn string, group elbv2types.TargetGroup) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.targetGroups[arn] = cachedTargetGroup{group: group, expires: c.now().Add(c.ttl)}
+}
+
+func (c *describeCache) invalidateTargetGroup(arn string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.targetGroups, arn)
+}
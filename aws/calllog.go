@@ -0,0 +1,68 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// logCalls logs every attempt of an API call at debug level, with its
+// operation, duration, HTTP status and AWS request ID, so that throttling and
+// eventual consistency issues can be diagnosed from the logs. The duration
+// excludes the time the throttler held the call back.
+func logCalls(stack *middleware.Stack) error {
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("NLBControllerLogCalls",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, metadata, err := next.HandleFinalize(ctx, in)
+			logger := loggerFrom(ctx).V(1)
+			if !logger.Enabled() {
+				return out, metadata, err
+			}
+			keysAndValues := []interface{}{
+				"service", awsmiddleware.GetServiceID(ctx),
+				"operation", awsmiddleware.GetOperationName(ctx),
+				"duration", time.Since(start).String(),
+			}
+			if status := callStatus(metadata, err); status != 0 {
+				keysAndValues = append(keysAndValues, "status", status)
+			}
+			if requestID := callRequestID(metadata, err); requestID != "" {
+				keysAndValues = append(keysAndValues, "requestID", requestID)
+			}
+			if err != nil {
+				keysAndValues = append(keysAndValues, "error", err.Error())
+			}
+			logger.Info("aws: api call", keysAndValues...)
+			return out, metadata, err
+		}), throttleMiddlewareID, middleware.After)
+}
+
+// callStatus returns the HTTP status code of an attempt, or 0 if it got no
+// response.
+func callStatus(metadata middleware.Metadata, err error) int {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode()
+	}
+	if resp, ok := awsmiddleware.GetRawResponse(metadata).(*smithyhttp.Response); ok && resp != nil {
+		return resp.StatusCode
+	}
+	return 0
+}
+
+// callRequestID returns the AWS request ID of an attempt, or "" if it got no
+// response.
+func callRequestID(metadata middleware.Metadata, err error) string {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.ServiceRequestID()
+	}
+	requestID, _ := awsmiddleware.GetRequestIDMetadata(metadata)
+	return requestID
+}
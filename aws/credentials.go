@@ -0,0 +1,179 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Sources of the credentials of the controller.
+const (
+	// CredentialsDefault uses the default credential chain of the SDK:
+	// environment, shared config, web identity and instance metadata.
+	CredentialsDefault = "default"
+	// CredentialsIRSA exchanges the service account token of IAM Roles for
+	// Service Accounts for credentials of a role.
+	CredentialsIRSA = "irsa"
+	// CredentialsProfile uses a profile of the shared config and credentials
+	// files.
+	CredentialsProfile = "profile"
+	// CredentialsStatic reads an access key from files, such as a mounted
+	// Secret, and picks up rotated keys.
+	CredentialsStatic = "static"
+)
+
+// staticCredentialsRefresh is how often static credentials are read again,
+// so that rotated keys are used without a restart.
+const staticCredentialsRefresh = time.Minute
+
+// Files of the access key of static credentials, as the keys of a Secret
+// mounted as a directory.
+const (
+	staticAccessKeyIDFile     = "access-key-id"
+	staticSecretAccessKeyFile = "secret-access-key"
+	staticSessionTokenFile    = "session-token"
+)
+
+var (
+	credentialsExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aws_credentials_expiry_timestamp_seconds",
+		Help: "Unix time at which the AWS credentials in use expire and are refreshed",
+	}, []string{"source"})
+	credentialsErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aws_credentials_errors_total",
+		Help: "Total number of failed attempts to retrieve AWS credentials",
+	}, []string{"source"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(credentialsExpiry, credentialsErrorsTotal)
+}
+
+// Credentials selects where the credentials of the controller come from.
+type Credentials struct {
+	// Source is one of CredentialsDefault, CredentialsIRSA,
+	// CredentialsProfile or CredentialsStatic. Empty means CredentialsDefault.
+	Source string
+
+	// Profile is the shared config profile of CredentialsProfile.
+	Profile string
+
+	// RoleARN and WebIdentityTokenFile are the role and the service account
+	// token of CredentialsIRSA.
+	RoleARN              string
+	WebIdentityTokenFile string
+
+	// StaticDir is the directory of CredentialsStatic, holding the files
+	// access-key-id, secret-access-key and optionally session-token.
+	StaticDir string
+}
+
+// loadOptions returns the options of the SDK config the credentials need.
+func (c Credentials) loadOptions() ([]func(*config.LoadOptions) error, error) {
+	switch c.Source {
+	case "", CredentialsDefault, CredentialsIRSA, CredentialsStatic:
+		return nil, nil
+	case CredentialsProfile:
+		if c.Profile == "" {
+			return nil, errors.New("aws: a profile is required for profile credentials")
+		}
+		return []func(*config.LoadOptions) error{config.WithSharedConfigProfile(c.Profile)}, nil
+	default:
+		return nil, fmt.Errorf("aws: unknown credentials source %q", c.Source)
+	}
+}
+
+// provider returns the credentials of cfg for the source, observed by the
+// credential metrics.
+func (c Credentials) provider(cfg aws.Config) (aws.CredentialsProvider, error) {
+	source := c.Source
+	if source == "" {
+		source = CredentialsDefault
+	}
+	provider := cfg.Credentials
+	switch source {
+	case CredentialsIRSA:
+		if c.RoleARN == "" || c.WebIdentityTokenFile == "" {
+			return nil, errors.New("aws: a role ARN and a web identity token file are required for irsa credentials")
+		}
+		provider = aws.NewCredentialsCache(stscreds.NewWebIdentityRoleProvider(
+			sts.NewFromConfig(cfg),
+			c.RoleARN,
+			stscreds.IdentityTokenFile(c.WebIdentityTokenFile),
+			func(o *stscreds.WebIdentityRoleOptions) {
+				o.RoleSessionName = roleSessionName
+			},
+		))
+	case CredentialsStatic:
+		if c.StaticDir == "" {
+			return nil, errors.New("aws: a directory is required for static credentials")
+		}
+		provider = aws.NewCredentialsCache(staticCredentials{dir: c.StaticDir})
+	}
+	if provider == nil {
+		return nil, errors.New("aws: no credentials found")
+	}
+	return observedCredentials{source: source, provider: provider}, nil
+}
+
+// staticCredentials reads an access key from files. The credentials expire
+// after staticCredentialsRefresh so that the files are read again.
+type staticCredentials struct {
+	dir string
+}
+
+func (s staticCredentials) Retrieve(context.Context) (aws.Credentials, error) {
+	read := func(name string, required bool) (string, error) {
+		value, err := os.ReadFile(filepath.Join(s.dir, name))
+		if errors.Is(err, os.ErrNotExist) && !required {
+			return "", nil
+		}
+		return strings.TrimSpace(string(value)), err
+	}
+	creds := aws.Credentials{
+		Source:    "StaticCredentialsFiles",
+		CanExpire: true,
+		Expires:   time.Now().Add(staticCredentialsRefresh),
+	}
+	var err error
+	if creds.AccessKeyID, err = read(staticAccessKeyIDFile, true); err != nil {
+		return aws.Credentials{}, err
+	}
+	if creds.SecretAccessKey, err = read(staticSecretAccessKeyFile, true); err != nil {
+		return aws.Credentials{}, err
+	}
+	if creds.SessionToken, err = read(staticSessionTokenFile, false); err != nil {
+		return aws.Credentials{}, err
+	}
+	return creds, nil
+}
+
+// observedCredentials reports the expiry of the credentials of provider, and
+// failures to retrieve them, in the credential metrics.
+type observedCredentials struct {
+	source   string
+	provider aws.CredentialsProvider
+}
+
+func (o observedCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := o.provider.Retrieve(ctx)
+	if err != nil {
+		credentialsErrorsTotal.WithLabelValues(o.source).Inc()
+		return creds, err
+	}
+	if creds.CanExpire {
+		credentialsExpiry.WithLabelValues(o.source).Set(float64(creds.Expires.Unix()))
+	}
+	return creds, nil
+}
@@ -0,0 +1,147 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	route53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// dnsRecordTTL is the TTL of the CNAME and owner records.
+const dnsRecordTTL = 60
+
+// ErrNoHostedZone is returned when asked to manage DNS records without a
+// hosted zone configured.
+var ErrNoHostedZone = errors.New("aws: no route53 hosted zone configured")
+
+// dnsOwner is the value of the TXT record that marks a CNAME as created by
+// this cluster for svc, in the style of external-dns.
+func (c client) dnsOwner(svc string) string {
+	return fmt.Sprintf(`"heritage=aws-nlb-controller,cluster=%s,service=%s"`, c.clusterID, svc)
+}
+
+// fqdn returns name with the trailing dot Route53 returns record names with.
+func fqdn(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+// dnsRecords returns the CNAME and TXT records of name.
+func (c client) dnsRecords(ctx context.Context, name string) (cname *route53types.ResourceRecordSet, txt *route53types.ResourceRecordSet, err error) {
+	out, err := c.Route53.ListResourceRecordSets(ctx, &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(c.hostedZoneID),
+		StartRecordName: aws.String(fqdn(name)),
+		MaxItems:        aws.Int32(10),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range out.ResourceRecordSets {
+		set := &out.ResourceRecordSets[i]
+		if !strings.EqualFold(aws.ToString(set.Name), fqdn(name)) {
+			continue
+		}
+		switch set.Type {
+		case route53types.RRTypeCname:
+			cname = set
+		case route53types.RRTypeTxt:
+			txt = set
+		}
+	}
+	return cname, txt, nil
+}
+
+// ownsDNSRecord reports whether txt marks a record as created for svc by this
+// cluster.
+func (c client) ownsDNSRecord(txt *route53types.ResourceRecordSet, svc string) bool {
+	if txt == nil {
+		return false
+	}
+	for _, record := range txt.ResourceRecords {
+		if aws.ToString(record.Value) == c.dnsOwner(svc) {
+			return true
+		}
+	}
+	return false
+}
+
+// EnsureDNSRecord points the CNAME name at target, and marks it as owned by
+// svc with a TXT record. Records of the same name that were not created for
+// svc by this cluster are not changed, and ErrNotOwned is returned.
+func (c client) EnsureDNSRecord(ctx context.Context, name string, target string, svc string) error {
+	if c.hostedZoneID == "" {
+		return ErrNoHostedZone
+	}
+	cname, txt, err := c.dnsRecords(ctx, name)
+	if err != nil {
+		return err
+	}
+	if (cname != nil || txt != nil) && !c.ownsDNSRecord(txt, svc) {
+		return fmt.Errorf("%w: dns record %s", ErrNotOwned, name)
+	}
+	if cname != nil && len(cname.ResourceRecords) == 1 && aws.ToString(cname.ResourceRecords[0].Value) == target {
+		return nil
+	}
+	_, err = c.Route53.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(c.hostedZoneID),
+		ChangeBatch: &route53types.ChangeBatch{
+			Comment: aws.String("aws-nlb-controller: " + svc),
+			Changes: []route53types.Change{
+				c.dnsChange(route53types.ChangeActionUpsert, name, route53types.RRTypeTxt, c.dnsOwner(svc)),
+				c.dnsChange(route53types.ChangeActionUpsert, name, route53types.RRTypeCname, target),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	loggerFrom(ctx).Info("aws: dns record updated", "name", name, "target", target)
+	return nil
+}
+
+// DeleteDNSRecord deletes the CNAME name and its TXT record if they were
+// created for svc by this cluster. Records that no longer exist are treated
+// as deleted.
+func (c client) DeleteDNSRecord(ctx context.Context, name string, svc string) error {
+	if c.hostedZoneID == "" {
+		return ErrNoHostedZone
+	}
+	cname, txt, err := c.dnsRecords(ctx, name)
+	if err != nil {
+		return err
+	}
+	if cname == nil && txt == nil {
+		return nil
+	}
+	if !c.ownsDNSRecord(txt, svc) {
+		return fmt.Errorf("%w: dns record %s", ErrNotOwned, name)
+	}
+	changes := []route53types.Change{{Action: route53types.ChangeActionDelete, ResourceRecordSet: txt}}
+	if cname != nil {
+		changes = append(changes, route53types.Change{Action: route53types.ChangeActionDelete, ResourceRecordSet: cname})
+	}
+	_, err = c.Route53.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(c.hostedZoneID),
+		ChangeBatch:  &route53types.ChangeBatch{Changes: changes},
+	})
+	if err != nil {
+		return err
+	}
+	loggerFrom(ctx).Info("aws: dns record deleted", "name", name)
+	return nil
+}
+
+func (c client) dnsChange(action route53types.ChangeAction, name string, recordType route53types.RRType, value string) route53types.Change {
+	return route53types.Change{
+		Action: action,
+		ResourceRecordSet: &route53types.ResourceRecordSet{
+			Name:            aws.String(fqdn(name)),
+			Type:            recordType,
+			TTL:             aws.Int64(dnsRecordTTL),
+			ResourceRecords: []route53types.ResourceRecord{{Value: aws.String(value)}},
+		},
+	}
+}
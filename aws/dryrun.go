@@ -0,0 +1,262 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// dryRunArnPrefix prefixes the ARNs of the resources a DryRun client
+// pretends to create.
+const dryRunArnPrefix = "arn:dry-run:"
+
+// maxMutations is the number of distinct mutations a DryRun client keeps.
+// The oldest are dropped beyond it.
+const maxMutations = 10000
+
+// ErrDryRun is returned by DryRun for changes whose result it cannot make
+// up, such as adopting a listener.
+var ErrDryRun = errors.New("aws: not changed in dry run")
+
+// Mutation is a change in AWS a DryRun client was asked to make and did not.
+type Mutation struct {
+	// Action is the AWS change, such as CreateListener.
+	Action string `json:"action"`
+	// Allocation is the allocation key the change is for, if known.
+	Allocation string `json:"allocation,omitempty"`
+	Detail     string `json:"detail"`
+	// FirstSeen and LastSeen are when the change was first and last
+	// planned, and Count how often, as reconciles plan it again until the
+	// change is made.
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Count     int       `json:"count"`
+
+	// seq orders mutations planned within the resolution of the clock.
+	seq int
+}
+
+// DryRun is a Client that changes nothing in AWS. Reads are passed on to the
+// wrapped Client; changes are logged and kept as Mutations, and answered as
+// if they had succeeded. Listeners and target groups it pretends to create
+// get ARNs of their own, which the reads of DryRun accept as existing and
+// matching. Sync calls are kept as they are made: the wrapped Client would
+// only change what differs.
+type DryRun struct {
+	c Client
+
+	mu        sync.Mutex
+	mutations map[string]*Mutation
+	seq       int
+}
+
+// NewDryRun returns a DryRun client reading through c.
+func NewDryRun(c Client) *DryRun {
+	return &DryRun{c: c, mutations: map[string]*Mutation{}}
+}
+
+var _ Client = &DryRun{}
+
+// Mutations returns the planned changes, in the order they were first
+// planned.
+func (d *DryRun) Mutations() []Mutation {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	mutations := make([]Mutation, 0, len(d.mutations))
+	for _, m := range d.mutations {
+		mutations = append(mutations, *m)
+	}
+	sort.Slice(mutations, func(i, j int) bool {
+		return mutations[i].seq < mutations[j].seq
+	})
+	return mutations
+}
+
+// plan records a change instead of making it.
+func (d *DryRun) plan(ctx context.Context, action string, allocation string, format string, args ...interface{}) {
+	detail := fmt.Sprintf(format, args...)
+	key := action + "\x00" + allocation + "\x00" + detail
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if m, ok := d.mutations[key]; ok {
+		m.LastSeen = now
+		m.Count++
+		log.FromContext(ctx).V(1).Info("dry run: not changing aws", "action", action, "allocation", allocation, "detail", detail)
+		return
+	}
+	if len(d.mutations) >= maxMutations {
+		d.dropOldest()
+	}
+	d.seq++
+	d.mutations[key] = &Mutation{Action: action, Allocation: allocation, Detail: detail, FirstSeen: now, LastSeen: now, Count: 1, seq: d.seq}
+	log.FromContext(ctx).Info("dry run: not changing aws", "action", action, "allocation", allocation, "detail", detail)
+}
+
+// dropOldest drops the mutation first planned. The caller must hold mu.
+func (d *DryRun) dropOldest() {
+	var oldest string
+	for key, m := range d.mutations {
+		if oldest == "" || m.seq < d.mutations[oldest].seq {
+			oldest = key
+		}
+	}
+	delete(d.mutations, oldest)
+}
+
+// planned reports whether an ARN is of a resource DryRun pretended to create.
+func planned(arn string) bool {
+	return strings.HasPrefix(arn, dryRunArnPrefix)
+}
+
+func (d *DryRun) CreateNLBListenerForPort(ctx context.Context, spec ListenerSpec) (string, string, error) {
+	d.plan(ctx, "CreateListener", spec.ServiceName, "create a %s listener on port %d of nlb %s forwarding to a new %s target group on port %d",
+		protocolOrTCP(spec.Protocol), spec.Port, spec.NLB, targetTypeOrInstance(spec.TargetType), spec.NodePort)
+	return fmt.Sprintf("%slistener/%s/%d", dryRunArnPrefix, spec.NLB, spec.Port),
+		fmt.Sprintf("%stargetgroup/%s/%d", dryRunArnPrefix, spec.ServiceName, spec.NodePort), nil
+}
+
+func (d *DryRun) AdoptListener(ctx context.Context, listenerArn string, spec ListenerSpec) (ListenerAllocation, error) {
+	d.plan(ctx, "AdoptListener", spec.ServiceName, "tag listener %s and its target group for the svc", listenerArn)
+	return ListenerAllocation{}, fmt.Errorf("%w: adopting listener %s", ErrDryRun, listenerArn)
+}
+
+func (d *DryRun) EnsureTargetGroup(ctx context.Context, spec ListenerSpec) (string, error) {
+	d.plan(ctx, "CreateTargetGroup", spec.ServiceName, "create a %s target group on port %d for the listener on port %d of nlb %s",
+		targetTypeOrInstance(spec.TargetType), spec.NodePort, spec.Port, spec.NLB)
+	return fmt.Sprintf("%stargetgroup/%s/%d", dryRunArnPrefix, spec.ServiceName, spec.NodePort), nil
+}
+
+func (d *DryRun) RetargetListener(ctx context.Context, listenerArn string, oldTargetArn string, targetArn string) error {
+	d.plan(ctx, "ModifyListener", "", "forward listener %s to target group %s instead of %s", listenerArn, targetArn, oldTargetArn)
+	return nil
+}
+
+func (d *DryRun) CheckListener(ctx context.Context, listenerArn string, targetArn string, spec ListenerSpec) error {
+	if planned(listenerArn) || planned(targetArn) {
+		return nil
+	}
+	return d.c.CheckListener(ctx, listenerArn, targetArn, spec)
+}
+
+func (d *DryRun) ListenerExists(ctx context.Context, listenerArn string) (bool, error) {
+	if planned(listenerArn) {
+		return true, nil
+	}
+	return d.c.ListenerExists(ctx, listenerArn)
+}
+
+func (d *DryRun) DeleteListenerAndTargetArn(ctx context.Context, serviceName string, listenerArn string, targetArn string) error {
+	if planned(listenerArn) && planned(targetArn) {
+		return nil
+	}
+	d.plan(ctx, "DeleteListener", "", "delete listener %s and target group %s", listenerArn, targetArn)
+	return nil
+}
+
+func (d *DryRun) SyncTargets(ctx context.Context, targetArn string, targets []Target) error {
+	ids := make([]string, len(targets))
+	for i, t := range targets {
+		ids[i] = fmt.Sprintf("%s:%d", t.ID, t.Port)
+	}
+	sort.Strings(ids)
+	d.plan(ctx, "SyncTargets", "", "make the targets of target group %s %v", targetArn, ids)
+	return nil
+}
+
+func (d *DryRun) InstanceRegistered(ctx context.Context, targetArn string, instanceID string) (bool, error) {
+	if planned(targetArn) {
+		return false, nil
+	}
+	return d.c.InstanceRegistered(ctx, targetArn, instanceID)
+}
+
+func (d *DryRun) PendingTerminations(ctx context.Context, hook string) ([]LifecycleAction, error) {
+	return d.c.PendingTerminations(ctx, hook)
+}
+
+func (d *DryRun) CompleteLifecycleAction(ctx context.Context, action LifecycleAction) error {
+	d.plan(ctx, "CompleteLifecycleAction", "", "complete lifecycle hook %s of instance %s of auto scaling group %s",
+		action.HookName, action.InstanceID, action.AutoScalingGroupName)
+	return nil
+}
+
+func (d *DryRun) ListClusterInstances(ctx context.Context) ([]Instance, error) {
+	return d.c.ListClusterInstances(ctx)
+}
+
+func (d *DryRun) ListenerQuota(ctx context.Context) (int, error) {
+	return d.c.ListenerQuota(ctx)
+}
+
+func (d *DryRun) AssumeRole(nlb string, role Role) error {
+	return d.c.AssumeRole(nlb, role)
+}
+
+func (d *DryRun) SyncTargetGroupHealthCheck(ctx context.Context, targetArn string, hc HealthCheck) error {
+	d.plan(ctx, "SyncHealthCheck", "", "make the health check of target group %s %+v", targetArn, hc)
+	return nil
+}
+
+func (d *DryRun) SyncListenerCertificate(ctx context.Context, listenerArn string, certificate string) error {
+	d.plan(ctx, "SyncCertificate", "", "make the certificate of listener %s %q", listenerArn, certificate)
+	return nil
+}
+
+func (d *DryRun) EnsureDNSRecord(ctx context.Context, name string, target string, svc string) error {
+	d.plan(ctx, "UpsertDNSRecord", "", "point %s at %s for svc %s", name, target, svc)
+	return nil
+}
+
+func (d *DryRun) DeleteDNSRecord(ctx context.Context, name string, svc string) error {
+	d.plan(ctx, "DeleteDNSRecord", "", "delete %s of svc %s", name, svc)
+	return nil
+}
+
+func (d *DryRun) SyncTargetGroupAttributes(ctx context.Context, targetArn string, attributes map[string]string) error {
+	d.plan(ctx, "SyncTargetGroupAttributes", "", "make the attributes of target group %s %v", targetArn, attributes)
+	return nil
+}
+
+func (d *DryRun) EnsureNLB(ctx context.Context, spec NLBSpec) (NLB, error) {
+	d.plan(ctx, "CreateLoadBalancer", "", "create nlb %s of nlbpool %s in subnets %v", spec.Name, spec.Pool, spec.Subnets)
+	return NLB{Arn: dryRunArnPrefix + "loadbalancer/net/" + spec.Name, DNSName: spec.Name + ".dry-run.invalid"}, nil
+}
+
+func (d *DryRun) DeleteNLB(ctx context.Context, pool string, name string) error {
+	d.plan(ctx, "DeleteLoadBalancer", "", "delete nlb %s of nlbpool %s", name, pool)
+	return nil
+}
+
+func (d *DryRun) DiscoverNLBs(ctx context.Context, key string, value string) ([]NLBDescription, error) {
+	return d.c.DiscoverNLBs(ctx, key, value)
+}
+
+func (d *DryRun) ListAllocations(ctx context.Context, nlbs []string) ([]ListenerAllocation, error) {
+	return d.c.ListAllocations(ctx, nlbs)
+}
+
+func (d *DryRun) Ping(ctx context.Context) error {
+	return d.c.Ping(ctx)
+}
+
+func protocolOrTCP(protocol string) string {
+	if protocol == "" {
+		return "TCP"
+	}
+	return protocol
+}
+
+func targetTypeOrInstance(targetType string) string {
+	if targetType == "" {
+		return "instance"
+	}
+	return targetType
+}
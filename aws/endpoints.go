@@ -0,0 +1,41 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+)
+
+// Endpoints override the endpoints of the AWS APIs, such as to point the
+// controller at LocalStack or moto.
+type Endpoints struct {
+	// URL is the endpoint of every API without an endpoint of its own. Empty
+	// keeps the AWS endpoints.
+	URL string
+	// ELBv2 and EC2 are the endpoints of these APIs.
+	ELBv2 string
+	EC2   string
+}
+
+// resolver returns the endpoint resolver of e, or nil if e overrides no
+// endpoint.
+func (e Endpoints) resolver() aws.EndpointResolverWithOptions {
+	if e == (Endpoints{}) {
+		return nil
+	}
+	services := map[string]string{
+		elbv2.ServiceID: e.ELBv2,
+		ec2.ServiceID:   e.EC2,
+	}
+	return aws.EndpointResolverWithOptionsFunc(func(service, region string, _ ...interface{}) (aws.Endpoint, error) {
+		url := services[service]
+		if url == "" {
+			url = e.URL
+		}
+		if url == "" {
+			// falls back to the endpoint of the SDK
+			return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+		}
+		return aws.Endpoint{URL: url, HostnameImmutable: true, SigningRegion: region}, nil
+	})
+}
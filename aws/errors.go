@@ -0,0 +1,73 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// The classes of errors of AWS API calls. Every error of a Client whose API
+// error falls in a class wraps it, so that callers can choose with errors.Is
+// how to retry without knowing the error codes of each API. The API error
+// itself stays available to errors.As.
+var (
+	// ErrThrottled is AWS rejecting a call because the request rate of the
+	// account is too high.
+	ErrThrottled = errors.New("aws: api call throttled")
+	// ErrNotFound is a resource the call refers to not existing.
+	ErrNotFound = errors.New("aws: resource not found")
+	// ErrConflict is a call conflicting with an existing resource or with
+	// another change in progress, such as a duplicate listener.
+	ErrConflict = errors.New("aws: resource conflict")
+	// ErrQuotaExceeded is a call that would exceed a quota of the account,
+	// such as the number of listeners of an NLB.
+	ErrQuotaExceeded = errors.New("aws: quota exceeded")
+)
+
+// classifiedError is an error of a class.
+type classifiedError struct {
+	error
+	class error
+}
+
+func (e classifiedError) Unwrap() error { return e.error }
+
+func (e classifiedError) Is(target error) bool { return target == e.class }
+
+// errorClass returns the class of err, or nil if it has none.
+func errorClass(err error) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return nil
+	}
+	code := apiErr.ErrorCode()
+	switch {
+	case IsThrottlingError(err):
+		return ErrThrottled
+	case strings.HasSuffix(code, "NotFound"), strings.HasSuffix(code, "NotFoundException"), code == "NoSuchHostedZone":
+		return ErrNotFound
+	case strings.HasPrefix(code, "Duplicate"), strings.HasSuffix(code, "AlreadyExists"),
+		code == "ResourceInUse", code == "PriorityInUse", code == "PriorRequestNotComplete", code == "ConcurrentModification":
+		return ErrConflict
+	case strings.HasPrefix(code, "TooMany"), strings.HasSuffix(code, "LimitExceeded"), code == "ServiceQuotaExceededException":
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// classifyErrors wraps the error of every API call in its class. It runs
+// around the retries of the call, so that only the final error is
+// classified.
+func classifyErrors(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("NLBControllerClassifyErrors",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			if class := errorClass(err); class != nil {
+				err = classifiedError{error: err, class: class}
+			}
+			return out, metadata, err
+		}), middleware.After)
+}
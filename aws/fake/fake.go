@@ -0,0 +1,818 @@
+// Package fake provides an in-memory aws.Client, to test code that manages
+// NLBs, such as the reconcilers of the controller, without AWS. The Client
+// keeps the NLBs, listeners, target groups and DNS records made through it
+// and checks calls against them like AWS and the real client do. It records
+// every call, and fails the calls it is told to fail.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+)
+
+// arnPrefix prefixes the ARNs of the resources of the fake.
+const arnPrefix = "arn:aws:elasticloadbalancing:us-east-1:000000000000:"
+
+// DefaultListenerQuota is the listener quota of a new Client, the default
+// quota of AWS.
+const DefaultListenerQuota = 50
+
+// Listener is a listener of the fake.
+type Listener struct {
+	Arn         string
+	NLB         string
+	Port        int
+	Protocol    string
+	Certificate string
+	TargetArn   string
+	// Service is the allocation key the listener is tagged with, empty if
+	// the controller does not own it.
+	Service string
+}
+
+// TargetGroup is a target group of the fake.
+type TargetGroup struct {
+	Arn         string
+	Port        int
+	Protocol    string
+	TargetType  string
+	Targets     []aws.Target
+	HealthCheck aws.HealthCheck
+	Attributes  map[string]string
+	// Owned is whether the target group carries the tags of the cluster.
+	Owned bool
+}
+
+// Call is a call of a method of the fake, with its arguments other than the
+// context.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+type nlb struct {
+	aws.NLB
+	// pool is the NLBPool that provisioned the NLB with EnsureNLB, empty
+	// for NLBs added with AddNLB.
+	pool string
+	tags map[string]string
+}
+
+type dnsRecord struct {
+	target string
+	svc    string
+}
+
+// Client is an in-memory aws.Client. Its zero value is not usable, use New.
+type Client struct {
+	mu           sync.Mutex
+	seq          int
+	nlbs         map[string]*nlb
+	listeners    map[string]*Listener
+	targetGroups map[string]*TargetGroup
+	dns          map[string]dnsRecord
+	instances    []aws.Instance
+	terminations []aws.LifecycleAction
+	quota        int
+	roles        map[string]aws.Role
+	calls        []Call
+	errs         map[string]error
+	next         map[string][]error
+}
+
+var _ aws.Client = &Client{}
+
+// New returns a Client managing the NLBs named nlbs.
+func New(nlbs ...string) *Client {
+	c := &Client{
+		nlbs:         map[string]*nlb{},
+		listeners:    map[string]*Listener{},
+		targetGroups: map[string]*TargetGroup{},
+		dns:          map[string]dnsRecord{},
+		quota:        DefaultListenerQuota,
+		roles:        map[string]aws.Role{},
+		errs:         map[string]error{},
+		next:         map[string][]error{},
+	}
+	for _, name := range nlbs {
+		c.AddNLB(name, nil)
+	}
+	return c
+}
+
+// AddNLB adds an NLB tagged tags, as if it were created outside of the
+// controller.
+func (c *Client) AddNLB(name string, tags map[string]string) aws.NLB {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.addNLB(name, "", tags)
+}
+
+func (c *Client) addNLB(name string, pool string, tags map[string]string) aws.NLB {
+	if existing, ok := c.nlbs[name]; ok {
+		return existing.NLB
+	}
+	c.seq++
+	n := &nlb{
+		NLB: aws.NLB{
+			Arn:     fmt.Sprintf("%sloadbalancer/net/%s/%d", arnPrefix, name, c.seq),
+			DNSName: fmt.Sprintf("%s-%d.elb.us-east-1.amazonaws.com", name, c.seq),
+		},
+		pool: pool,
+		tags: map[string]string{},
+	}
+	for k, v := range tags {
+		n.tags[k] = v
+	}
+	c.nlbs[name] = n
+	return n.NLB
+}
+
+// AddListener adds a listener and its target group for spec, as if they
+// were created outside of the controller, such as by Terraform. owned tags
+// them like the listeners the controller creates. It returns the ARNs of the
+// listener and the target group.
+func (c *Client) AddListener(spec aws.ListenerSpec, owned bool) (string, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addNLB(spec.NLB, "", nil)
+	var tg *TargetGroup
+	if owned {
+		tg = c.targetGroup(spec)
+	} else {
+		tg = c.newTargetGroup(spec, false)
+	}
+	l := c.addListener(spec, tg.Arn)
+	if !owned {
+		l.Service = ""
+	}
+	return l.Arn, tg.Arn
+}
+
+// DeleteListener deletes a listener out of band, such as by an operator, and
+// keeps its target group.
+func (c *Client) DeleteListener(listenerArn string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.listeners, listenerArn)
+}
+
+// SetInstances sets the instances ListClusterInstances returns.
+func (c *Client) SetInstances(instances ...aws.Instance) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.instances = append([]aws.Instance(nil), instances...)
+}
+
+// SetPendingTerminations sets the lifecycle actions PendingTerminations
+// returns, until they are completed.
+func (c *Client) SetPendingTerminations(actions ...aws.LifecycleAction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.terminations = append([]aws.LifecycleAction(nil), actions...)
+}
+
+// SetListenerQuota sets the number of listeners an NLB can have.
+func (c *Client) SetListenerQuota(quota int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.quota = quota
+}
+
+// SetError makes every call of method fail with err, without changing
+// anything, until it is set to nil.
+func (c *Client) SetError(method string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		delete(c.errs, method)
+		return
+	}
+	c.errs[method] = err
+}
+
+// FailNext makes the next call of method fail with err, without changing
+// anything. Errors of several FailNext calls fail the following calls in
+// turn, before the error of SetError. A nil err lets its call through, to
+// fail a later call.
+func (c *Client) FailNext(method string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next[method] = append(c.next[method], err)
+}
+
+// Calls returns the calls of method, or of every method if method is empty,
+// in the order they were made.
+func (c *Client) Calls(method string) []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var calls []Call
+	for _, call := range c.calls {
+		if method == "" || call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// ResetCalls forgets the calls made so far.
+func (c *Client) ResetCalls() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = nil
+}
+
+// Listeners returns the listeners, by NLB and port.
+func (c *Client) Listeners() []Listener {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	listeners := make([]Listener, 0, len(c.listeners))
+	for _, l := range c.listeners {
+		listeners = append(listeners, *l)
+	}
+	sort.Slice(listeners, func(i, j int) bool {
+		if listeners[i].NLB != listeners[j].NLB {
+			return listeners[i].NLB < listeners[j].NLB
+		}
+		return listeners[i].Port < listeners[j].Port
+	})
+	return listeners
+}
+
+// Listener returns a listener, or false if it does not exist.
+func (c *Client) Listener(listenerArn string) (Listener, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.listeners[listenerArn]
+	if !ok {
+		return Listener{}, false
+	}
+	return *l, true
+}
+
+// TargetGroup returns a target group, or false if it does not exist.
+func (c *Client) TargetGroup(targetArn string) (TargetGroup, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tg, ok := c.targetGroups[targetArn]
+	if !ok {
+		return TargetGroup{}, false
+	}
+	copied := *tg
+	copied.Targets = append([]aws.Target(nil), tg.Targets...)
+	copied.Attributes = map[string]string{}
+	for k, v := range tg.Attributes {
+		copied.Attributes[k] = v
+	}
+	return copied, true
+}
+
+// DNSRecord returns the target of a DNS record, or false if it does not
+// exist.
+func (c *Client) DNSRecord(name string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	record, ok := c.dns[name]
+	return record.target, ok
+}
+
+// call records a call and returns the error it fails with, if any. The
+// caller must hold mu.
+func (c *Client) call(method string, args ...interface{}) error {
+	c.calls = append(c.calls, Call{Method: method, Args: args})
+	if next := c.next[method]; len(next) > 0 {
+		c.next[method] = next[1:]
+		return next[0]
+	}
+	return c.errs[method]
+}
+
+func protocol(spec aws.ListenerSpec) string {
+	if spec.Protocol == "" {
+		return "TCP"
+	}
+	return spec.Protocol
+}
+
+func listenerProtocol(spec aws.ListenerSpec) string {
+	if spec.Certificate != "" {
+		return "TLS"
+	}
+	return protocol(spec)
+}
+
+func targetType(spec aws.ListenerSpec) string {
+	if spec.TargetType == "" {
+		return "instance"
+	}
+	return spec.TargetType
+}
+
+func targetGroupPort(spec aws.ListenerSpec) int {
+	if targetType(spec) == "ip" {
+		return spec.Port
+	}
+	return spec.NodePort
+}
+
+// targetGroup returns the target group of spec, creating it if it does not
+// exist. Like the target groups of the real client, instance target groups
+// are shared by the listeners of a NodePort and ip target groups are not
+// shared. The caller must hold mu.
+func (c *Client) targetGroup(spec aws.ListenerSpec) *TargetGroup {
+	for _, tg := range c.targetGroups {
+		if tg.Port == targetGroupPort(spec) && tg.Protocol == protocol(spec) && tg.TargetType == targetType(spec) && tg.Owned &&
+			(tg.TargetType != "ip" || c.usedBy(tg.Arn, spec.ServiceName)) {
+			return tg
+		}
+	}
+	return c.newTargetGroup(spec, true)
+}
+
+// newTargetGroup adds a target group for spec. The caller must hold mu.
+func (c *Client) newTargetGroup(spec aws.ListenerSpec, owned bool) *TargetGroup {
+	c.seq++
+	tg := &TargetGroup{
+		Arn:         fmt.Sprintf("%stargetgroup/%d/%d", arnPrefix, targetGroupPort(spec), c.seq),
+		Port:        targetGroupPort(spec),
+		Protocol:    protocol(spec),
+		TargetType:  targetType(spec),
+		HealthCheck: spec.HealthCheck,
+		Attributes:  map[string]string{},
+		Owned:       owned,
+	}
+	c.targetGroups[tg.Arn] = tg
+	return tg
+}
+
+// usedBy reports whether a listener of svc forwards to a target group. The
+// caller must hold mu.
+func (c *Client) usedBy(targetArn string, svc string) bool {
+	for _, l := range c.listeners {
+		if l.TargetArn == targetArn && l.Service == svc {
+			return true
+		}
+	}
+	return false
+}
+
+// inUse reports whether a listener forwards to a target group. The caller
+// must hold mu.
+func (c *Client) inUse(targetArn string) bool {
+	for _, l := range c.listeners {
+		if l.TargetArn == targetArn {
+			return true
+		}
+	}
+	return false
+}
+
+// listenerOn returns the listener on a port of an NLB, or nil. The caller
+// must hold mu.
+func (c *Client) listenerOn(nlb string, port int) *Listener {
+	for _, l := range c.listeners {
+		if l.NLB == nlb && l.Port == port {
+			return l
+		}
+	}
+	return nil
+}
+
+// addListener adds the listener of spec. The caller must hold mu.
+func (c *Client) addListener(spec aws.ListenerSpec, targetArn string) *Listener {
+	c.seq++
+	l := &Listener{
+		Arn:         fmt.Sprintf("%slistener/net/%s/%d", arnPrefix, spec.NLB, c.seq),
+		NLB:         spec.NLB,
+		Port:        spec.Port,
+		Protocol:    listenerProtocol(spec),
+		Certificate: spec.Certificate,
+		TargetArn:   targetArn,
+		Service:     spec.ServiceName,
+	}
+	c.listeners[l.Arn] = l
+	return l
+}
+
+// check checks a listener against spec like the real CheckListener. The
+// caller must hold mu.
+func (c *Client) check(listenerArn string, targetArn string, spec aws.ListenerSpec) error {
+	l, ok := c.listeners[listenerArn]
+	if !ok {
+		return fmt.Errorf("%w: listener %s not found", aws.ErrDrifted, listenerArn)
+	}
+	if l.NLB != spec.NLB {
+		return fmt.Errorf("%w: listener nlb and svc nlb %s dont match", aws.ErrDrifted, spec.NLB)
+	}
+	if l.Port != spec.Port {
+		return fmt.Errorf("%w: listener port and svcNLBPort dont match", aws.ErrDrifted)
+	}
+	if l.Protocol != listenerProtocol(spec) {
+		return fmt.Errorf("%w: listener protocol and svc protocol dont match", aws.ErrDrifted)
+	}
+	if l.TargetArn != targetArn {
+		return fmt.Errorf("%w: target group arn dont match", aws.ErrDrifted)
+	}
+	tg, ok := c.targetGroups[targetArn]
+	if !ok {
+		return fmt.Errorf("%w: target group %s not found", aws.ErrDrifted, targetArn)
+	}
+	if tg.Port != targetGroupPort(spec) {
+		return aws.ErrTargetPortChanged
+	}
+	if tg.TargetType != targetType(spec) {
+		return fmt.Errorf("%w: target type and svc target type dont match", aws.ErrDrifted)
+	}
+	return nil
+}
+
+func (c *Client) CreateNLBListenerForPort(_ context.Context, spec aws.ListenerSpec) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("CreateNLBListenerForPort", spec); err != nil {
+		return "", "", err
+	}
+	if _, ok := c.nlbs[spec.NLB]; !ok {
+		return "", "", fmt.Errorf("%w: nlb %s", aws.ErrNotFound, spec.NLB)
+	}
+	if existing := c.listenerOn(spec.NLB, spec.Port); existing != nil {
+		// a listener created for spec before is reused, like the real client
+		if existing.Service == spec.ServiceName && c.check(existing.Arn, existing.TargetArn, spec) == nil {
+			return existing.Arn, existing.TargetArn, nil
+		}
+		return "", "", fmt.Errorf("%w: DuplicateListener: port %d of nlb %s", aws.ErrConflict, spec.Port, spec.NLB)
+	}
+	listeners := 0
+	for _, l := range c.listeners {
+		if l.NLB == spec.NLB {
+			listeners++
+		}
+	}
+	if listeners >= c.quota {
+		return "", "", fmt.Errorf("%w: TooManyListeners: nlb %s", aws.ErrQuotaExceeded, spec.NLB)
+	}
+	tg := c.targetGroup(spec)
+	return c.addListener(spec, tg.Arn).Arn, tg.Arn, nil
+}
+
+func (c *Client) AdoptListener(_ context.Context, listenerArn string, spec aws.ListenerSpec) (aws.ListenerAllocation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("AdoptListener", listenerArn, spec); err != nil {
+		return aws.ListenerAllocation{}, err
+	}
+	l, ok := c.listeners[listenerArn]
+	if !ok {
+		return aws.ListenerAllocation{}, fmt.Errorf("aws: listener %s not found", listenerArn)
+	}
+	if l.TargetArn == "" {
+		return aws.ListenerAllocation{}, fmt.Errorf("aws: listener %s does not forward to a target group", listenerArn)
+	}
+	spec.NLB, spec.Port = l.NLB, l.Port
+	if err := c.check(listenerArn, l.TargetArn, spec); err != nil {
+		return aws.ListenerAllocation{}, err
+	}
+	if l.Service != "" && l.Service != spec.ServiceName {
+		return aws.ListenerAllocation{}, fmt.Errorf("%w: %s is tagged %s=%s", aws.ErrNotOwned, listenerArn, aws.TagService, l.Service)
+	}
+	l.Service = spec.ServiceName
+	c.targetGroups[l.TargetArn].Owned = true
+	return aws.ListenerAllocation{
+		ServiceNamespacedName: spec.ServiceName,
+		NLB:                   l.NLB,
+		Port:                  l.Port,
+		ListenerArn:           l.Arn,
+		TargetArn:             l.TargetArn,
+	}, nil
+}
+
+func (c *Client) EnsureTargetGroup(_ context.Context, spec aws.ListenerSpec) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("EnsureTargetGroup", spec); err != nil {
+		return "", err
+	}
+	return c.targetGroup(spec).Arn, nil
+}
+
+func (c *Client) RetargetListener(_ context.Context, listenerArn string, oldTargetArn string, targetArn string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("RetargetListener", listenerArn, oldTargetArn, targetArn); err != nil {
+		return err
+	}
+	l, ok := c.listeners[listenerArn]
+	if !ok {
+		return fmt.Errorf("%w: listener %s", aws.ErrNotFound, listenerArn)
+	}
+	if _, ok := c.targetGroups[targetArn]; !ok {
+		return fmt.Errorf("%w: target group %s", aws.ErrNotFound, targetArn)
+	}
+	l.TargetArn = targetArn
+	if old, ok := c.targetGroups[oldTargetArn]; ok && old.Owned && oldTargetArn != targetArn && !c.inUse(oldTargetArn) {
+		delete(c.targetGroups, oldTargetArn)
+	}
+	return nil
+}
+
+func (c *Client) CheckListener(_ context.Context, listenerArn string, targetArn string, spec aws.ListenerSpec) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("CheckListener", listenerArn, targetArn, spec); err != nil {
+		return err
+	}
+	return c.check(listenerArn, targetArn, spec)
+}
+
+func (c *Client) ListenerExists(_ context.Context, listenerArn string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("ListenerExists", listenerArn); err != nil {
+		return false, err
+	}
+	_, ok := c.listeners[listenerArn]
+	return ok, nil
+}
+
+func (c *Client) DeleteListenerAndTargetArn(_ context.Context, serviceName string, listenerArn string, targetArn string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("DeleteListenerAndTargetArn", serviceName, listenerArn, targetArn); err != nil {
+		return err
+	}
+	l, listenerExists := c.listeners[listenerArn]
+	if listenerExists && l.Service != serviceName {
+		return fmt.Errorf("%w: listener %s is not tagged for svc %s", aws.ErrNotOwned, listenerArn, serviceName)
+	}
+	tg, targetExists := c.targetGroups[targetArn]
+	if targetExists && !tg.Owned {
+		return fmt.Errorf("%w: target group %s", aws.ErrNotOwned, targetArn)
+	}
+	delete(c.listeners, listenerArn)
+	if targetExists && !c.inUse(targetArn) {
+		delete(c.targetGroups, targetArn)
+	}
+	return nil
+}
+
+func (c *Client) SyncTargets(_ context.Context, targetArn string, targets []aws.Target) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("SyncTargets", targetArn, targets); err != nil {
+		return err
+	}
+	tg, ok := c.targetGroups[targetArn]
+	if !ok {
+		return fmt.Errorf("%w: target group %s", aws.ErrNotFound, targetArn)
+	}
+	tg.Targets = append([]aws.Target(nil), targets...)
+	sort.Slice(tg.Targets, func(i, j int) bool {
+		if tg.Targets[i].ID != tg.Targets[j].ID {
+			return tg.Targets[i].ID < tg.Targets[j].ID
+		}
+		return tg.Targets[i].Port < tg.Targets[j].Port
+	})
+	return nil
+}
+
+func (c *Client) InstanceRegistered(_ context.Context, targetArn string, instanceID string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("InstanceRegistered", targetArn, instanceID); err != nil {
+		return false, err
+	}
+	tg, ok := c.targetGroups[targetArn]
+	if !ok {
+		return false, nil
+	}
+	for _, t := range tg.Targets {
+		if t.ID == instanceID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *Client) PendingTerminations(_ context.Context, hook string) ([]aws.LifecycleAction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("PendingTerminations", hook); err != nil {
+		return nil, err
+	}
+	var actions []aws.LifecycleAction
+	for _, action := range c.terminations {
+		if action.HookName == hook {
+			actions = append(actions, action)
+		}
+	}
+	return actions, nil
+}
+
+func (c *Client) CompleteLifecycleAction(_ context.Context, action aws.LifecycleAction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("CompleteLifecycleAction", action); err != nil {
+		return err
+	}
+	for i, pending := range c.terminations {
+		if pending == action {
+			c.terminations = append(c.terminations[:i], c.terminations[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (c *Client) ListClusterInstances(context.Context) ([]aws.Instance, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("ListClusterInstances"); err != nil {
+		return nil, err
+	}
+	return append([]aws.Instance(nil), c.instances...), nil
+}
+
+func (c *Client) ListenerQuota(context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("ListenerQuota"); err != nil {
+		return 0, err
+	}
+	return c.quota, nil
+}
+
+func (c *Client) AssumeRole(nlb string, role aws.Role) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("AssumeRole", nlb, role); err != nil {
+		return err
+	}
+	c.roles[nlb] = role
+	return nil
+}
+
+func (c *Client) SyncTargetGroupHealthCheck(_ context.Context, targetArn string, hc aws.HealthCheck) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("SyncTargetGroupHealthCheck", targetArn, hc); err != nil {
+		return err
+	}
+	tg, ok := c.targetGroups[targetArn]
+	if !ok {
+		return fmt.Errorf("%w: target group %s", aws.ErrNotFound, targetArn)
+	}
+	tg.HealthCheck = hc
+	return nil
+}
+
+func (c *Client) SyncListenerCertificate(_ context.Context, listenerArn string, certificate string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("SyncListenerCertificate", listenerArn, certificate); err != nil {
+		return err
+	}
+	if certificate == "" {
+		return nil
+	}
+	l, ok := c.listeners[listenerArn]
+	if !ok {
+		return fmt.Errorf("%w: listener %s not found", aws.ErrDrifted, listenerArn)
+	}
+	l.Certificate = certificate
+	return nil
+}
+
+func (c *Client) EnsureDNSRecord(_ context.Context, name string, target string, svc string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("EnsureDNSRecord", name, target, svc); err != nil {
+		return err
+	}
+	if record, ok := c.dns[name]; ok && record.svc != svc {
+		return fmt.Errorf("%w: dns record %s", aws.ErrNotOwned, name)
+	}
+	c.dns[name] = dnsRecord{target: target, svc: svc}
+	return nil
+}
+
+func (c *Client) DeleteDNSRecord(_ context.Context, name string, svc string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("DeleteDNSRecord", name, svc); err != nil {
+		return err
+	}
+	record, ok := c.dns[name]
+	if !ok {
+		return nil
+	}
+	if record.svc != svc {
+		return fmt.Errorf("%w: dns record %s", aws.ErrNotOwned, name)
+	}
+	delete(c.dns, name)
+	return nil
+}
+
+func (c *Client) SyncTargetGroupAttributes(_ context.Context, targetArn string, attributes map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("SyncTargetGroupAttributes", targetArn, attributes); err != nil {
+		return err
+	}
+	tg, ok := c.targetGroups[targetArn]
+	if !ok {
+		return fmt.Errorf("%w: target group %s", aws.ErrNotFound, targetArn)
+	}
+	for k, v := range attributes {
+		tg.Attributes[k] = v
+	}
+	return nil
+}
+
+func (c *Client) EnsureNLB(_ context.Context, spec aws.NLBSpec) (aws.NLB, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("EnsureNLB", spec); err != nil {
+		return aws.NLB{}, err
+	}
+	tags := map[string]string{aws.TagPool: spec.Pool}
+	for k, v := range spec.Tags {
+		tags[k] = v
+	}
+	return c.addNLB(spec.Name, spec.Pool, tags), nil
+}
+
+func (c *Client) DeleteNLB(_ context.Context, pool string, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("DeleteNLB", pool, name); err != nil {
+		return err
+	}
+	n, ok := c.nlbs[name]
+	if !ok || n.pool == "" || n.pool != pool {
+		return nil
+	}
+	delete(c.nlbs, name)
+	for arn, l := range c.listeners {
+		if l.NLB == name {
+			delete(c.listeners, arn)
+		}
+	}
+	return nil
+}
+
+func (c *Client) DiscoverNLBs(_ context.Context, key string, value string) ([]aws.NLBDescription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("DiscoverNLBs", key, value); err != nil {
+		return nil, err
+	}
+	var nlbs []aws.NLBDescription
+	for name, n := range c.nlbs {
+		if v, ok := n.tags[key]; ok && v == value {
+			nlbs = append(nlbs, aws.NLBDescription{Name: name, Arn: n.Arn, DNSName: n.DNSName})
+		}
+	}
+	sort.Slice(nlbs, func(i, j int) bool { return nlbs[i].Name < nlbs[j].Name })
+	return nlbs, nil
+}
+
+func (c *Client) ListAllocations(_ context.Context, nlbs []string) ([]aws.ListenerAllocation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.call("ListAllocations", nlbs); err != nil {
+		return nil, err
+	}
+	wanted := map[string]bool{}
+	for _, name := range nlbs {
+		wanted[name] = true
+	}
+	var allocations []aws.ListenerAllocation
+	for _, l := range c.listeners {
+		if wanted[l.NLB] && l.Service != "" {
+			allocations = append(allocations, aws.ListenerAllocation{
+				ServiceNamespacedName: l.Service,
+				NLB:                   l.NLB,
+				Port:                  l.Port,
+				ListenerArn:           l.Arn,
+				TargetArn:             l.TargetArn,
+			})
+		}
+	}
+	sort.Slice(allocations, func(i, j int) bool {
+		if allocations[i].NLB != allocations[j].NLB {
+			return allocations[i].NLB < allocations[j].NLB
+		}
+		return allocations[i].Port < allocations[j].Port
+	})
+	return allocations, nil
+}
+
+func (c *Client) Ping(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.call("Ping")
+}
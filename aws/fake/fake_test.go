@@ -0,0 +1,83 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+)
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	c := New("shared")
+	spec := aws.ListenerSpec{NLB: "shared", Port: 9000, NodePort: 30080, ServiceName: "default/web:http"}
+	listenerArn, targetArn, err := c.CreateNLBListenerForPort(ctx, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CheckListener(ctx, listenerArn, targetArn, spec); err != nil {
+		t.Errorf("CheckListener() error = %v for the listener of spec", err)
+	}
+	if err := c.CheckListener(ctx, listenerArn, targetArn, aws.ListenerSpec{NLB: "other", Port: 9000, NodePort: 30080}); !errors.Is(err, aws.ErrDrifted) {
+		t.Errorf("CheckListener() error = %v for the listener on another nlb, want ErrDrifted", err)
+	}
+	other := spec
+	other.Port, other.ServiceName = 9001, "default/api:http"
+	otherListenerArn, otherTargetArn, err := c.CreateNLBListenerForPort(ctx, other)
+	if err != nil || otherTargetArn != targetArn {
+		t.Errorf("CreateNLBListenerForPort() = %s, %v, want the target group %s of the NodePort shared", otherTargetArn, err, targetArn)
+	}
+	if _, _, err := c.CreateNLBListenerForPort(ctx, aws.ListenerSpec{NLB: "shared", Port: 9000, NodePort: 30081, ServiceName: "default/db:tcp"}); !errors.Is(err, aws.ErrConflict) {
+		t.Errorf("CreateNLBListenerForPort() error = %v on a taken port, want ErrConflict", err)
+	}
+	if allocations, _ := c.ListAllocations(ctx, []string{"shared"}); len(allocations) != 2 || allocations[0].ListenerArn != listenerArn {
+		t.Errorf("ListAllocations() = %+v, want both listeners", allocations)
+	}
+
+	c.FailNext("DeleteListenerAndTargetArn", errors.New("AccessDenied"))
+	if err := c.DeleteListenerAndTargetArn(ctx, spec.ServiceName, listenerArn, targetArn); err == nil {
+		t.Error("DeleteListenerAndTargetArn() error = nil, want the error of FailNext")
+	}
+	if err := c.DeleteListenerAndTargetArn(ctx, other.ServiceName, listenerArn, targetArn); !errors.Is(err, aws.ErrNotOwned) {
+		t.Errorf("DeleteListenerAndTargetArn() error = %v for the listener of another svc, want ErrNotOwned", err)
+	}
+	if err := c.DeleteListenerAndTargetArn(ctx, spec.ServiceName, listenerArn, targetArn); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.TargetGroup(targetArn); !ok {
+		t.Error("deleted the target group still used by another listener")
+	}
+	if err := c.CheckListener(ctx, listenerArn, targetArn, spec); !errors.Is(err, aws.ErrDrifted) {
+		t.Errorf("CheckListener() error = %v for a deleted listener, want ErrDrifted", err)
+	}
+	if err := c.DeleteListenerAndTargetArn(ctx, other.ServiceName, otherListenerArn, targetArn); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.TargetGroup(targetArn); ok {
+		t.Error("kept the target group of the last listener")
+	}
+	if calls := c.Calls("DeleteListenerAndTargetArn"); len(calls) != 4 || calls[0].Args[1] != listenerArn {
+		t.Errorf("Calls() = %+v, want the 4 deletes", calls)
+	}
+}
+
+func TestClientAdoptListener(t *testing.T) {
+	ctx := context.Background()
+	c := New()
+	spec := aws.ListenerSpec{NLB: "terraform", Port: 443, NodePort: 30443, ServiceName: "default/web:https"}
+	listenerArn, targetArn := c.AddListener(spec, false)
+	if err := c.DeleteListenerAndTargetArn(ctx, spec.ServiceName, listenerArn, targetArn); !errors.Is(err, aws.ErrNotOwned) {
+		t.Errorf("DeleteListenerAndTargetArn() error = %v for a listener that is not owned, want ErrNotOwned", err)
+	}
+	adopted, err := c.AdoptListener(ctx, listenerArn, aws.ListenerSpec{NodePort: 30443, ServiceName: "default/web:https"})
+	if err != nil || adopted.NLB != "terraform" || adopted.Port != 443 || adopted.TargetArn != targetArn {
+		t.Fatalf("AdoptListener() = %+v, %v, want the listener on port 443 of terraform", adopted, err)
+	}
+	if _, err := c.AdoptListener(ctx, listenerArn, aws.ListenerSpec{NodePort: 30443, ServiceName: "default/api:https"}); !errors.Is(err, aws.ErrNotOwned) {
+		t.Errorf("AdoptListener() error = %v for a listener of another svc, want ErrNotOwned", err)
+	}
+	if err := c.DeleteListenerAndTargetArn(ctx, spec.ServiceName, listenerArn, targetArn); err != nil {
+		t.Errorf("DeleteListenerAndTargetArn() error = %v for an adopted listener", err)
+	}
+}
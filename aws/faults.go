@@ -0,0 +1,390 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ErrInjected is a failure injected by a FaultInjector. Injected throttles
+// are ErrThrottled instead, like the throttles of AWS.
+var ErrInjected = errors.New("aws: injected fault")
+
+// Faults are the failures a FaultInjector injects. Rates are the fraction of
+// calls, between 0 and 1, that fail.
+type Faults struct {
+	// Throttle fails calls with a throttling error before they reach AWS.
+	Throttle float64
+	// Methods limits Throttle to the Client methods named, such as
+	// CreateNLBListenerForPort. Every method is throttled if empty.
+	Methods []string
+	// PartialCreate fails calls of CreateNLBListenerForPort after the
+	// listener was created, as if the response had been lost, leaving the
+	// listener behind.
+	PartialCreate float64
+	// DeleteFailure fails calls of DeleteListenerAndTargetArn without
+	// deleting anything.
+	DeleteFailure float64
+	// ConsistencyDelay hides a listener created through the FaultInjector
+	// from CheckListener, ListenerExists and ListAllocations for a while, as
+	// the eventually consistent reads of the ELB API may.
+	ConsistencyDelay time.Duration
+	// Seed seeds the choice of the calls that fail, so that a run can be
+	// repeated.
+	Seed int64
+}
+
+// Enabled reports whether any fault is injected.
+func (f Faults) Enabled() bool {
+	return f.Throttle > 0 || f.PartialCreate > 0 || f.DeleteFailure > 0 || f.ConsistencyDelay > 0
+}
+
+// ParseFaults parses a comma separated list of name=value pairs, such as
+// throttle=0.1,methods=CreateNLBListenerForPort|SyncTargets,partial-create=1,
+// delete-failure=0.5,consistency-delay=30s,seed=42. An empty value injects
+// no faults.
+func ParseFaults(value string) (Faults, error) {
+	var faults Faults
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return Faults{}, fmt.Errorf("%q is not of the form name=value", pair)
+		}
+		var err error
+		switch name {
+		case "throttle":
+			faults.Throttle, err = parseRate(v)
+		case "methods":
+			faults.Methods = strings.Split(v, "|")
+		case "partial-create":
+			faults.PartialCreate, err = parseRate(v)
+		case "delete-failure":
+			faults.DeleteFailure, err = parseRate(v)
+		case "consistency-delay":
+			faults.ConsistencyDelay, err = time.ParseDuration(v)
+		case "seed":
+			faults.Seed, err = strconv.ParseInt(v, 10, 64)
+		default:
+			return Faults{}, fmt.Errorf("unknown fault %q, want throttle, methods, partial-create, delete-failure, consistency-delay or seed", name)
+		}
+		if err != nil {
+			return Faults{}, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return faults, nil
+}
+
+func parseRate(value string) (float64, error) {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("rate %q is not between 0 and 1", value)
+	}
+	return rate, nil
+}
+
+// FaultInjector is a Client that injects Faults into the calls of the
+// wrapped Client, to test how reconciles recover from the failures of AWS.
+// Injected faults bypass the retries, rate limiter and circuit breaker of the
+// wrapped Client. It is not meant for production.
+type FaultInjector struct {
+	c Client
+
+	mu     sync.Mutex
+	faults Faults
+	rand   *rand.Rand
+	// created are the listeners created through the injector that are
+	// hidden from reads until the time they map to.
+	created map[string]time.Time
+	now     func() time.Time
+}
+
+// NewFaultInjector returns a FaultInjector injecting faults into the calls
+// of c.
+func NewFaultInjector(c Client, faults Faults) *FaultInjector {
+	f := &FaultInjector{c: c, created: map[string]time.Time{}, now: time.Now}
+	f.SetFaults(faults)
+	return f
+}
+
+var _ Client = &FaultInjector{}
+
+// SetFaults replaces the injected faults and reseeds their choice, such as
+// to stop injecting once a test has seen its failure.
+func (f *FaultInjector) SetFaults(faults Faults) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = faults
+	f.rand = rand.New(rand.NewSource(faults.Seed))
+}
+
+// inject reports whether a call fails at rate. The caller must hold mu.
+func (f *FaultInjector) inject(rate float64) bool {
+	return rate > 0 && f.rand.Float64() < rate
+}
+
+// throttled returns a throttling error for the calls of method that fail
+// throttled.
+func (f *FaultInjector) throttled(ctx context.Context, method string) error {
+	f.mu.Lock()
+	throttle := f.faults.Throttle > 0 && f.throttles(method) && f.inject(f.faults.Throttle)
+	f.mu.Unlock()
+	if !throttle {
+		return nil
+	}
+	injectedFaultsTotal.WithLabelValues(method, "throttle").Inc()
+	log.FromContext(ctx).V(1).Info("aws: injecting throttle", "method", method)
+	return classifiedError{
+		error: &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded (injected)", Fault: smithy.FaultClient},
+		class: ErrThrottled,
+	}
+}
+
+// throttles reports whether calls of method may be throttled. The caller
+// must hold mu.
+func (f *FaultInjector) throttles(method string) bool {
+	if len(f.faults.Methods) == 0 {
+		return true
+	}
+	for _, m := range f.faults.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// fails reports whether a call of method fails with fault, at rate.
+func (f *FaultInjector) fails(ctx context.Context, method string, fault string, rate func(Faults) float64) bool {
+	f.mu.Lock()
+	fail := f.inject(rate(f.faults))
+	f.mu.Unlock()
+	if fail {
+		injectedFaultsTotal.WithLabelValues(method, fault).Inc()
+		log.FromContext(ctx).V(1).Info("aws: injecting fault", "method", method, "fault", fault)
+	}
+	return fail
+}
+
+// hidden reports whether a listener created through the injector is not
+// visible to reads yet. Listeners whose delay is over are forgotten.
+func (f *FaultInjector) hidden(listenerArn string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	visible, ok := f.created[listenerArn]
+	if !ok {
+		return false
+	}
+	if !f.now().Before(visible) {
+		delete(f.created, listenerArn)
+		return false
+	}
+	return true
+}
+
+func (f *FaultInjector) CreateNLBListenerForPort(ctx context.Context, spec ListenerSpec) (string, string, error) {
+	if err := f.throttled(ctx, "CreateNLBListenerForPort"); err != nil {
+		return "", "", err
+	}
+	listenerArn, targetArn, err := f.c.CreateNLBListenerForPort(ctx, spec)
+	if err != nil {
+		return "", "", err
+	}
+	f.mu.Lock()
+	if delay := f.faults.ConsistencyDelay; delay > 0 {
+		f.created[listenerArn] = f.now().Add(delay)
+	}
+	f.mu.Unlock()
+	if f.fails(ctx, "CreateNLBListenerForPort", "partial-create", func(faults Faults) float64 { return faults.PartialCreate }) {
+		return "", "", fmt.Errorf("%w: response of creating listener %s on port %d of nlb %s lost", ErrInjected, listenerArn, spec.Port, spec.NLB)
+	}
+	return listenerArn, targetArn, nil
+}
+
+func (f *FaultInjector) AdoptListener(ctx context.Context, listenerArn string, spec ListenerSpec) (ListenerAllocation, error) {
+	if err := f.throttled(ctx, "AdoptListener"); err != nil {
+		return ListenerAllocation{}, err
+	}
+	return f.c.AdoptListener(ctx, listenerArn, spec)
+}
+
+func (f *FaultInjector) EnsureTargetGroup(ctx context.Context, spec ListenerSpec) (string, error) {
+	if err := f.throttled(ctx, "EnsureTargetGroup"); err != nil {
+		return "", err
+	}
+	return f.c.EnsureTargetGroup(ctx, spec)
+}
+
+func (f *FaultInjector) RetargetListener(ctx context.Context, listenerArn string, oldTargetArn string, targetArn string) error {
+	if err := f.throttled(ctx, "RetargetListener"); err != nil {
+		return err
+	}
+	return f.c.RetargetListener(ctx, listenerArn, oldTargetArn, targetArn)
+}
+
+func (f *FaultInjector) CheckListener(ctx context.Context, listenerArn string, targetArn string, spec ListenerSpec) error {
+	if err := f.throttled(ctx, "CheckListener"); err != nil {
+		return err
+	}
+	if f.hidden(listenerArn) {
+		return fmt.Errorf("%w: listener %s not found", ErrDrifted, listenerArn)
+	}
+	return f.c.CheckListener(ctx, listenerArn, targetArn, spec)
+}
+
+func (f *FaultInjector) ListenerExists(ctx context.Context, listenerArn string) (bool, error) {
+	if err := f.throttled(ctx, "ListenerExists"); err != nil {
+		return false, err
+	}
+	if f.hidden(listenerArn) {
+		return false, nil
+	}
+	return f.c.ListenerExists(ctx, listenerArn)
+}
+
+func (f *FaultInjector) DeleteListenerAndTargetArn(ctx context.Context, serviceName string, listenerArn string, targetArn string) error {
+	if err := f.throttled(ctx, "DeleteListenerAndTargetArn"); err != nil {
+		return err
+	}
+	if f.fails(ctx, "DeleteListenerAndTargetArn", "delete-failure", func(faults Faults) float64 { return faults.DeleteFailure }) {
+		return fmt.Errorf("%w: deleting listener %s and target group %s", ErrInjected, listenerArn, targetArn)
+	}
+	return f.c.DeleteListenerAndTargetArn(ctx, serviceName, listenerArn, targetArn)
+}
+
+func (f *FaultInjector) SyncTargets(ctx context.Context, targetArn string, targets []Target) error {
+	if err := f.throttled(ctx, "SyncTargets"); err != nil {
+		return err
+	}
+	return f.c.SyncTargets(ctx, targetArn, targets)
+}
+
+func (f *FaultInjector) InstanceRegistered(ctx context.Context, targetArn string, instanceID string) (bool, error) {
+	if err := f.throttled(ctx, "InstanceRegistered"); err != nil {
+		return false, err
+	}
+	return f.c.InstanceRegistered(ctx, targetArn, instanceID)
+}
+
+func (f *FaultInjector) PendingTerminations(ctx context.Context, hook string) ([]LifecycleAction, error) {
+	if err := f.throttled(ctx, "PendingTerminations"); err != nil {
+		return nil, err
+	}
+	return f.c.PendingTerminations(ctx, hook)
+}
+
+func (f *FaultInjector) CompleteLifecycleAction(ctx context.Context, action LifecycleAction) error {
+	if err := f.throttled(ctx, "CompleteLifecycleAction"); err != nil {
+		return err
+	}
+	return f.c.CompleteLifecycleAction(ctx, action)
+}
+
+func (f *FaultInjector) ListClusterInstances(ctx context.Context) ([]Instance, error) {
+	if err := f.throttled(ctx, "ListClusterInstances"); err != nil {
+		return nil, err
+	}
+	return f.c.ListClusterInstances(ctx)
+}
+
+func (f *FaultInjector) ListenerQuota(ctx context.Context) (int, error) {
+	if err := f.throttled(ctx, "ListenerQuota"); err != nil {
+		return 0, err
+	}
+	return f.c.ListenerQuota(ctx)
+}
+
+func (f *FaultInjector) AssumeRole(nlb string, role Role) error {
+	return f.c.AssumeRole(nlb, role)
+}
+
+func (f *FaultInjector) SyncTargetGroupHealthCheck(ctx context.Context, targetArn string, hc HealthCheck) error {
+	if err := f.throttled(ctx, "SyncTargetGroupHealthCheck"); err != nil {
+		return err
+	}
+	return f.c.SyncTargetGroupHealthCheck(ctx, targetArn, hc)
+}
+
+func (f *FaultInjector) SyncListenerCertificate(ctx context.Context, listenerArn string, certificate string) error {
+	if err := f.throttled(ctx, "SyncListenerCertificate"); err != nil {
+		return err
+	}
+	return f.c.SyncListenerCertificate(ctx, listenerArn, certificate)
+}
+
+func (f *FaultInjector) EnsureDNSRecord(ctx context.Context, name string, target string, svc string) error {
+	if err := f.throttled(ctx, "EnsureDNSRecord"); err != nil {
+		return err
+	}
+	return f.c.EnsureDNSRecord(ctx, name, target, svc)
+}
+
+func (f *FaultInjector) DeleteDNSRecord(ctx context.Context, name string, svc string) error {
+	if err := f.throttled(ctx, "DeleteDNSRecord"); err != nil {
+		return err
+	}
+	return f.c.DeleteDNSRecord(ctx, name, svc)
+}
+
+func (f *FaultInjector) SyncTargetGroupAttributes(ctx context.Context, targetArn string, attributes map[string]string) error {
+	if err := f.throttled(ctx, "SyncTargetGroupAttributes"); err != nil {
+		return err
+	}
+	return f.c.SyncTargetGroupAttributes(ctx, targetArn, attributes)
+}
+
+func (f *FaultInjector) EnsureNLB(ctx context.Context, spec NLBSpec) (NLB, error) {
+	if err := f.throttled(ctx, "EnsureNLB"); err != nil {
+		return NLB{}, err
+	}
+	return f.c.EnsureNLB(ctx, spec)
+}
+
+func (f *FaultInjector) DeleteNLB(ctx context.Context, pool string, name string) error {
+	if err := f.throttled(ctx, "DeleteNLB"); err != nil {
+		return err
+	}
+	return f.c.DeleteNLB(ctx, pool, name)
+}
+
+func (f *FaultInjector) DiscoverNLBs(ctx context.Context, key string, value string) ([]NLBDescription, error) {
+	if err := f.throttled(ctx, "DiscoverNLBs"); err != nil {
+		return nil, err
+	}
+	return f.c.DiscoverNLBs(ctx, key, value)
+}
+
+func (f *FaultInjector) ListAllocations(ctx context.Context, nlbs []string) ([]ListenerAllocation, error) {
+	if err := f.throttled(ctx, "ListAllocations"); err != nil {
+		return nil, err
+	}
+	allocations, err := f.c.ListAllocations(ctx, nlbs)
+	if err != nil {
+		return nil, err
+	}
+	var visible []ListenerAllocation
+	for _, allocation := range allocations {
+		if !f.hidden(allocation.ListenerArn) {
+			visible = append(visible, allocation)
+		}
+	}
+	return visible, nil
+}
+
+func (f *FaultInjector) Ping(ctx context.Context) error {
+	if err := f.throttled(ctx, "Ping"); err != nil {
+		return err
+	}
+	return f.c.Ping(ctx)
+}
@@ -0,0 +1,39 @@
+package aws
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/smithy-go"
+)
+
+// Ping describes a single NLB, to check that the ELB API is reachable with
+// the credentials of the client.
+func (c client) Ping(ctx context.Context) error {
+	_, err := c.Elb.DescribeLoadBalancers(ctx, &elbv2.DescribeLoadBalancersInput{PageSize: aws.Int32(1)})
+	return err
+}
+
+// IsCredentialError reports whether err is a call that failed because the
+// credentials of the controller could not be retrieved, have expired or were
+// rejected. Unlike other errors, these do not go away until the controller
+// gets new credentials.
+func IsCredentialError(err error) bool {
+	var signingErr *v4.SigningError
+	if errors.As(err, &signingErr) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "ExpiredToken", "ExpiredTokenException", "InvalidClientTokenId", "UnrecognizedClientException",
+		"SignatureDoesNotMatch", "AuthFailure", "InvalidAccessKeyId", "MissingAuthenticationToken":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package aws
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+const (
+	// imdsTokenHeader carries the IMDSv2 session token of a metadata request
+	imdsTokenHeader = "X-Aws-Ec2-Metadata-Token"
+
+	// imdsDialTimeout and imdsTimeout keep the controller from hanging on
+	// the metadata service when it does not run on EC2.
+	imdsDialTimeout = time.Second
+	imdsTimeout     = 5 * time.Second
+)
+
+// errIMDSv1 is returned for metadata requests without a session token.
+var errIMDSv1 = errors.New("aws: refusing an IMDSv1 request without a session token")
+
+// imdsv2Only sends only the requests of IMDSv2: the request of a session
+// token, and requests carrying one. The SDK falls back to IMDSv1 when it
+// cannot get a token, which nodes requiring IMDSv2 reject anyway and which
+// would otherwise expose the credentials of the node to SSRF.
+type imdsv2Only struct {
+	client imds.HTTPClient
+}
+
+func (c imdsv2Only) Do(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPut && req.Header.Get(imdsTokenHeader) == "" {
+		return nil, errIMDSv1
+	}
+	return c.client.Do(req)
+}
+
+// newIMDSClient returns the client the controller reads instance metadata
+// and instance credentials with. It only speaks IMDSv2, and makes no request
+// at all if disabled.
+func newIMDSClient(disabled bool) *imds.Client {
+	opts := imds.Options{
+		HTTPClient: imdsv2Only{client: awshttp.NewBuildableClient().
+			WithTimeout(imdsTimeout).
+			WithDialerOptions(func(d *net.Dialer) {
+				d.Timeout = imdsDialTimeout
+			})},
+	}
+	if disabled {
+		opts.ClientEnableState = imds.ClientDisabled
+	}
+	return imds.New(opts)
+}
@@ -0,0 +1,45 @@
+package aws
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Instance is an EC2 instance of the cluster.
+type Instance struct {
+	ID string
+	// PrivateDNSName is the name of the node of the instance on EKS
+	PrivateDNSName string
+}
+
+// ListClusterInstances returns the running instances in the VPC of the
+// client that are tagged kubernetes.io/cluster/<cluster name>, from every
+// reservation and page of DescribeInstances.
+func (c client) ListClusterInstances(ctx context.Context) ([]Instance, error) {
+	var instances []Instance
+	paginator := ec2.NewDescribeInstancesPaginator(c.Ec2Client, &ec2.DescribeInstancesInput{
+		Filters: []ec2types.Filter{
+			{Name: aws.String("vpc-id"), Values: []string{c.VPC}},
+			{Name: aws.String("instance-state-name"), Values: []string{string(ec2types.InstanceStateNameRunning)}},
+			{Name: aws.String("tag-key"), Values: []string{clusterTagPrefix + c.clusterName}},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				instances = append(instances, Instance{
+					ID:             aws.ToString(instance.InstanceId),
+					PrivateDNSName: aws.ToString(instance.PrivateDnsName),
+				})
+			}
+		}
+	}
+	return instances, nil
+}
@@ -0,0 +1,99 @@
+package aws
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+)
+
+const (
+	// lifecycleStateTerminatingWait is the state of an instance held by a
+	// termination lifecycle hook.
+	lifecycleStateTerminatingWait = "Terminating:Wait"
+	// lifecycleTransitionTerminating is the transition of termination
+	// lifecycle hooks.
+	lifecycleTransitionTerminating = "autoscaling:EC2_INSTANCE_TERMINATING"
+)
+
+// LifecycleAction is the termination of an Auto Scaling instance, held by a
+// lifecycle hook until it is completed.
+type LifecycleAction struct {
+	AutoScalingGroupName string
+	HookName             string
+	InstanceID           string
+}
+
+// PendingTerminations returns the instances held in Terminating:Wait by the
+// termination lifecycle hook hook of their Auto Scaling group.
+func (c client) PendingTerminations(ctx context.Context, hook string) ([]LifecycleAction, error) {
+	waiting := map[string][]string{}
+	paginator := autoscaling.NewDescribeAutoScalingInstancesPaginator(c.Autoscaling, &autoscaling.DescribeAutoScalingInstancesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, instance := range page.AutoScalingInstances {
+			if aws.ToString(instance.LifecycleState) != lifecycleStateTerminatingWait {
+				continue
+			}
+			group := aws.ToString(instance.AutoScalingGroupName)
+			waiting[group] = append(waiting[group], aws.ToString(instance.InstanceId))
+		}
+	}
+
+	var actions []LifecycleAction
+	for group, instances := range waiting {
+		hooks, err := c.Autoscaling.DescribeLifecycleHooks(ctx, &autoscaling.DescribeLifecycleHooksInput{
+			AutoScalingGroupName: aws.String(group),
+			LifecycleHookNames:   []string{hook},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range hooks.LifecycleHooks {
+			if aws.ToString(h.LifecycleTransition) != lifecycleTransitionTerminating {
+				continue
+			}
+			for _, instance := range instances {
+				actions = append(actions, LifecycleAction{
+					AutoScalingGroupName: group,
+					HookName:             hook,
+					InstanceID:           instance,
+				})
+			}
+		}
+	}
+	return actions, nil
+}
+
+// CompleteLifecycleAction lets the termination of the instance of action
+// proceed.
+func (c client) CompleteLifecycleAction(ctx context.Context, action LifecycleAction) error {
+	_, err := c.Autoscaling.CompleteLifecycleAction(ctx, &autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName:  aws.String(action.AutoScalingGroupName),
+		LifecycleHookName:     aws.String(action.HookName),
+		InstanceId:            aws.String(action.InstanceID),
+		LifecycleActionResult: aws.String("CONTINUE"),
+	})
+	return err
+}
+
+// InstanceRegistered reports whether the instance is a target of the target
+// group, including while its connections drain after deregistration.
+func (c client) InstanceRegistered(ctx context.Context, targetArn string, instanceID string) (bool, error) {
+	health, err := c.elbForArn(targetArn).DescribeTargetHealth(ctx, &elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(targetArn),
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, desc := range health.TargetHealthDescriptions {
+		if aws.ToString(desc.Target.Id) == instanceID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,20 @@
+package aws
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// loggerFrom returns the logger of ctx named aws, so that the level of the aws
+// package can be set on its own with --log-levels.
+func loggerFrom(ctx context.Context) logr.Logger {
+	return log.FromContext(ctx).WithName("aws")
+}
+
+// listenerLogger returns the logger of ctx with the allocation, NLB and port
+// of a listener.
+func listenerLogger(ctx context.Context, spec ListenerSpec) logr.Logger {
+	return loggerFrom(ctx).WithValues("allocation", spec.ServiceName, "nlb", spec.NLB, "nlbPort", spec.Port)
+}
@@ -0,0 +1,71 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	apiCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aws_api_call_duration_seconds",
+		Help:    "Duration of attempts of AWS API calls by service and operation",
+		Buckets: []float64{0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"service", "operation"})
+	apiCallsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aws_api_calls_total",
+		Help: "Total number of attempts of AWS API calls by service, operation and error code, Success if they succeeded",
+	}, []string{"service", "operation", "code"})
+	apiThrottledTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aws_api_throttled_total",
+		Help: "Total number of attempts of AWS API calls rejected by AWS because of the request rate",
+	}, []string{"service", "operation"})
+	injectedFaultsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aws_injected_faults_total",
+		Help: "Total number of failures injected into calls of the AWS client by method and fault",
+	}, []string{"method", "fault"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(apiCallDuration, apiCallsTotal, apiThrottledTotal, injectedFaultsTotal)
+}
+
+// recordCalls records the duration and outcome of every attempt of an API
+// call. Like logCalls, the duration excludes the time the throttler held the
+// call back.
+func recordCalls(stack *middleware.Stack) error {
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("NLBControllerRecordCalls",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, metadata, err := next.HandleFinalize(ctx, in)
+			service, operation := awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)
+			apiCallDuration.WithLabelValues(service, operation).Observe(time.Since(start).Seconds())
+			apiCallsTotal.WithLabelValues(service, operation, errorCode(err)).Inc()
+			if IsThrottlingError(err) {
+				apiThrottledTotal.WithLabelValues(service, operation).Inc()
+			}
+			return out, metadata, err
+		}), throttleMiddlewareID, middleware.After)
+}
+
+// errorCode returns the AWS error code of err, Success if there is none, or
+// Error if the call got no response from AWS.
+func errorCode(err error) string {
+	if err == nil {
+		return "Success"
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return "Canceled"
+	}
+	return "Error"
+}
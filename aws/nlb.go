@@ -0,0 +1,255 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+)
+
+// TagPool holds the name of the NLBPool an NLB was provisioned for
+const TagPool = "nlb-controller/pool"
+
+// NLBSpec describes an NLB provisioned by the controller.
+type NLBSpec struct {
+	// Pool is the name of the NLBPool the NLB belongs to
+	Pool string
+	// Name is the name of the NLB
+	Name    string
+	Subnets []string
+	// Scheme is internet-facing or internal. Empty means internet-facing.
+	Scheme string
+	// EIPAllocations are attached to the NLB in the order of Subnets
+	EIPAllocations []string
+	Tags           map[string]string
+}
+
+// NLB is a provisioned NLB.
+type NLB struct {
+	Arn     string
+	DNSName string
+}
+
+// NLBDescription is an NLB found by DiscoverNLBs.
+type NLBDescription struct {
+	Name    string
+	Arn     string
+	DNSName string
+}
+
+func (s NLBSpec) subnetMappings() []elbv2types.SubnetMapping {
+	mappings := make([]elbv2types.SubnetMapping, 0, len(s.Subnets))
+	for i, subnet := range s.Subnets {
+		mapping := elbv2types.SubnetMapping{SubnetId: aws.String(subnet)}
+		if i < len(s.EIPAllocations) {
+			mapping.AllocationId = aws.String(s.EIPAllocations[i])
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings
+}
+
+func (s NLBSpec) scheme() elbv2types.LoadBalancerSchemeEnum {
+	if s.Scheme == "" {
+		return elbv2types.LoadBalancerSchemeEnumInternetFacing
+	}
+	return elbv2types.LoadBalancerSchemeEnum(s.Scheme)
+}
+
+// userTags are the tags of spec.Tags, without the tags identifying the
+// controller that created an NLB.
+func (spec NLBSpec) userTags() []elbv2types.Tag {
+	keys := make([]string, 0, len(spec.Tags))
+	for key := range spec.Tags {
+		if key != TagCluster && key != TagPool {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	tags := make([]elbv2types.Tag, 0, len(keys))
+	for _, key := range keys {
+		tags = append(tags, elbv2types.Tag{Key: aws.String(key), Value: aws.String(spec.Tags[key])})
+	}
+	return tags
+}
+
+// ownerTags identify an NLB as created by this controller for a pool. Only
+// NLBs the controller creates carry them, so that DeleteNLB leaves adopted
+// NLBs alone.
+func (c client) ownerTags(spec NLBSpec) []elbv2types.Tag {
+	return []elbv2types.Tag{
+		{Key: aws.String(TagCluster), Value: aws.String(c.clusterID)},
+		{Key: aws.String(TagPool), Value: aws.String(spec.Pool)},
+	}
+}
+
+// describeNLB returns the NLB of the given name, or nil if there is none.
+// NLBs are served from the describe cache of the client while fresh.
+func (c client) describeNLB(ctx context.Context, name string) (*elbv2types.LoadBalancer, error) {
+	if lb, ok := c.cache.nlb(name); ok {
+		return &lb, nil
+	}
+	out, err := c.elbForNLB(name).DescribeLoadBalancers(ctx, &elbv2.DescribeLoadBalancersInput{Names: []string{name}})
+	var notFound *elbv2types.LoadBalancerNotFoundException
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(out.LoadBalancers) != 1 {
+		return nil, nil
+	}
+	c.cache.putNLB(name, out.LoadBalancers[0])
+	return &out.LoadBalancers[0], nil
+}
+
+// EnsureNLB creates the NLB described by spec, or brings an existing NLB of
+// the same name in line with it.
+func (c client) EnsureNLB(ctx context.Context, spec NLBSpec) (NLB, error) {
+	logger := loggerFrom(ctx).WithValues("nlb", spec.Name)
+	elb := c.elbForNLB(spec.Name)
+	lb, err := c.describeNLB(ctx, spec.Name)
+	if err != nil {
+		return NLB{}, err
+	}
+
+	if lb == nil {
+		out, err := elb.CreateLoadBalancer(ctx, &elbv2.CreateLoadBalancerInput{
+			Name:           aws.String(spec.Name),
+			Type:           elbv2types.LoadBalancerTypeEnumNetwork,
+			Scheme:         spec.scheme(),
+			SubnetMappings: spec.subnetMappings(),
+			Tags:           append(spec.userTags(), c.ownerTags(spec)...),
+		})
+		if err != nil {
+			return NLB{}, err
+		}
+		logger.Info("aws: nlb created")
+		lb := out.LoadBalancers[0]
+		return NLB{Arn: aws.ToString(lb.LoadBalancerArn), DNSName: aws.ToString(lb.DNSName)}, nil
+	}
+
+	if lb.Type != elbv2types.LoadBalancerTypeEnumNetwork {
+		return NLB{}, fmt.Errorf("aws: %s is not a network load balancer", spec.Name)
+	}
+	if lb.Scheme != spec.scheme() {
+		return NLB{}, fmt.Errorf("aws: scheme of %s is %s and cannot be changed to %s", spec.Name, lb.Scheme, spec.scheme())
+	}
+
+	current := map[string]bool{}
+	for _, az := range lb.AvailabilityZones {
+		current[aws.ToString(az.SubnetId)] = true
+	}
+	changed := len(current) != len(spec.Subnets)
+	for _, subnet := range spec.Subnets {
+		changed = changed || !current[subnet]
+	}
+	if changed {
+		_, err := elb.SetSubnets(ctx, &elbv2.SetSubnetsInput{
+			LoadBalancerArn: lb.LoadBalancerArn,
+			SubnetMappings:  spec.subnetMappings(),
+		})
+		if err != nil {
+			return NLB{}, err
+		}
+		c.cache.invalidateNLB(spec.Name)
+		logger.Info("aws: nlb subnets updated")
+	}
+
+	// AddTags overwrites existing values, so it both adds and updates tags.
+	// The owner tags are left as they are, as the NLB may have been adopted.
+	if tags := spec.userTags(); len(tags) > 0 {
+		_, err = elb.AddTags(ctx, &elbv2.AddTagsInput{
+			ResourceArns: []string{aws.ToString(lb.LoadBalancerArn)},
+			Tags:         tags,
+		})
+		if err != nil {
+			return NLB{}, err
+		}
+	}
+	return NLB{Arn: aws.ToString(lb.LoadBalancerArn), DNSName: aws.ToString(lb.DNSName)}, nil
+}
+
+// DeleteNLB deletes an NLB provisioned for the given pool by this cluster.
+// NLBs that were not, such as NLBs created by operators and adopted by a
+// pool, are left in place.
+func (c client) DeleteNLB(ctx context.Context, pool string, name string) error {
+	lb, err := c.describeNLB(ctx, name)
+	if err != nil || lb == nil {
+		return err
+	}
+	elb := c.elbForNLB(name)
+	out, err := elb.DescribeTags(ctx, &elbv2.DescribeTagsInput{ResourceArns: []string{aws.ToString(lb.LoadBalancerArn)}})
+	if err != nil {
+		return err
+	}
+	tags := map[string]string{}
+	for _, desc := range out.TagDescriptions {
+		for _, t := range desc.Tags {
+			tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+		}
+	}
+	if v, ok := tags[TagPool]; !c.ownedBy(tags) || !ok || v != pool {
+		loggerFrom(ctx).Info("aws: nlb not provisioned by this controller. Keeping it", "nlb", name)
+		return nil
+	}
+
+	_, err = elb.DeleteLoadBalancer(ctx, &elbv2.DeleteLoadBalancerInput{LoadBalancerArn: lb.LoadBalancerArn})
+	if err != nil {
+		return err
+	}
+	c.cache.invalidateNLB(name)
+	loggerFrom(ctx).Info("aws: nlb deleted", "nlb", name)
+	return nil
+}
+
+// DiscoverNLBs returns the network load balancers tagged key=value.
+func (c client) DiscoverNLBs(ctx context.Context, key string, value string) ([]NLBDescription, error) {
+	lbs := map[string]elbv2types.LoadBalancer{}
+	var arns []string
+	paginator := elbv2.NewDescribeLoadBalancersPaginator(c.Elb, &elbv2.DescribeLoadBalancersInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, lb := range page.LoadBalancers {
+			if lb.Type != elbv2types.LoadBalancerTypeEnumNetwork {
+				continue
+			}
+			lbs[aws.ToString(lb.LoadBalancerArn)] = lb
+			arns = append(arns, aws.ToString(lb.LoadBalancerArn))
+		}
+	}
+
+	var nlbs []NLBDescription
+	for start := 0; start < len(arns); start += describeTagsMaxArns {
+		end := start + describeTagsMaxArns
+		if end > len(arns) {
+			end = len(arns)
+		}
+		out, err := c.Elb.DescribeTags(ctx, &elbv2.DescribeTagsInput{ResourceArns: arns[start:end]})
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range out.TagDescriptions {
+			for _, t := range desc.Tags {
+				if aws.ToString(t.Key) == key && aws.ToString(t.Value) == value {
+					lb := lbs[aws.ToString(desc.ResourceArn)]
+					nlbs = append(nlbs, NLBDescription{
+						Name:    aws.ToString(lb.LoadBalancerName),
+						Arn:     aws.ToString(lb.LoadBalancerArn),
+						DNSName: aws.ToString(lb.DNSName),
+					})
+					break
+				}
+			}
+		}
+	}
+	return nlbs, nil
+}
@@ -0,0 +1,33 @@
+package aws
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+)
+
+const (
+	// serviceCodeELB is the Service Quotas code of Elastic Load Balancing.
+	serviceCodeELB = "elasticloadbalancing"
+	// quotaCodeListenersPerNLB is the Service Quotas code of the number of
+	// listeners per Network Load Balancer.
+	quotaCodeListenersPerNLB = "L-57A373D6"
+)
+
+// ListenerQuota returns the number of listeners an NLB of the account
+// supports, as applied by Service Quotas.
+func (c client) ListenerQuota(ctx context.Context) (int, error) {
+	out, err := c.ServiceQuotas.GetServiceQuota(ctx, &servicequotas.GetServiceQuotaInput{
+		ServiceCode: aws.String(serviceCodeELB),
+		QuotaCode:   aws.String(quotaCodeListenersPerNLB),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if out.Quota == nil || out.Quota.Value == nil {
+		return 0, errors.New("aws: listeners per nlb quota has no value")
+	}
+	return int(aws.ToFloat64(out.Quota.Value)), nil
+}
@@ -0,0 +1,111 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"golang.org/x/time/rate"
+)
+
+// throttleMiddlewareID identifies the middleware of the throttler in the
+// middleware stack of API calls.
+const throttleMiddlewareID = "NLBControllerThrottle"
+
+const (
+	// minThrottlePause and maxThrottlePause bound the pause of all API calls
+	// after a call was throttled. The pause doubles with every throttled call
+	// and resets after a call succeeds.
+	minThrottlePause = time.Second
+	maxThrottlePause = 30 * time.Second
+)
+
+// IsThrottlingError reports whether err is AWS rejecting a call because the
+// request rate of the account is too high.
+func IsThrottlingError(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException", "RequestThrottled":
+		return true
+	}
+	return false
+}
+
+// throttler limits the rate of API calls shared by all clients, and pauses
+// every call for a while once one of them has been throttled, instead of
+// letting each caller back off on its own.
+type throttler struct {
+	limiter *rate.Limiter
+
+	mu          sync.Mutex
+	pause       time.Duration
+	pausedUntil time.Time
+}
+
+func newThrottler(limit float64, burst int) *throttler {
+	if limit <= 0 {
+		return &throttler{limiter: rate.NewLimiter(rate.Inf, 0)}
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &throttler{limiter: rate.NewLimiter(rate.Limit(limit), burst)}
+}
+
+// wait blocks until a call may be made.
+func (t *throttler) wait(ctx context.Context) error {
+	t.mu.Lock()
+	pause := time.Until(t.pausedUntil)
+	t.mu.Unlock()
+	if pause > 0 {
+		timer := time.NewTimer(pause)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return t.limiter.Wait(ctx)
+}
+
+// observe records the outcome of a call.
+func (t *throttler) observe(ctx context.Context, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !IsThrottlingError(err) {
+		if err == nil {
+			t.pause = 0
+		}
+		return
+	}
+	t.pause *= 2
+	if t.pause < minThrottlePause {
+		t.pause = minThrottlePause
+	}
+	if t.pause > maxThrottlePause {
+		t.pause = maxThrottlePause
+	}
+	t.pausedUntil = time.Now().Add(t.pause)
+	loggerFrom(ctx).Info("aws: api call throttled. Pausing all calls", "pause", t.pause.String())
+}
+
+// middleware waits for the throttler before every attempt of an API call,
+// including retries.
+func (t *throttler) middleware(stack *middleware.Stack) error {
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc(throttleMiddlewareID,
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			if err := t.wait(ctx); err != nil {
+				return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+			}
+			out, metadata, err := next.HandleFinalize(ctx, in)
+			t.observe(ctx, err)
+			return out, metadata, err
+		}), "Retry", middleware.After)
+}
@@ -0,0 +1,71 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// consistencyErrorCodes are the errors the ELB API returns for resources it
+// does not see yet right after they were created, such as a target group
+// passed to CreateListener.
+var consistencyErrorCodes = map[string]bool{
+	"LoadBalancerNotFound": true,
+	"TargetGroupNotFound":  true,
+	"ListenerNotFound":     true,
+}
+
+// consistencyError marks an error of eventual consistency as retryable by
+// the retryers of the SDK.
+type consistencyError struct {
+	error
+}
+
+func (e consistencyError) Unwrap() error { return e.error }
+
+// RetryableError implements the interface retry.RetryableError looks for.
+func (consistencyError) RetryableError() bool { return true }
+
+// retriesConsistency reports whether an operation is retried when a resource
+// it refers to is not found. Reads and deletes report missing resources to
+// their callers, which treat them as gone.
+func retriesConsistency(operation string) bool {
+	if isRead(operation) {
+		return false
+	}
+	for _, prefix := range []string{"Delete", "Deregister", "Remove"} {
+		if strings.HasPrefix(operation, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// isConsistencyError reports whether err is the ELB API not finding a
+// resource.
+func isConsistencyError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && consistencyErrorCodes[apiErr.ErrorCode()]
+}
+
+// retryConsistencyErrors makes the retryer retry ELB API calls that change a
+// resource which is not found, so that a listener created right after its
+// target group does not fail the whole reconcile, and roll it back, for a
+// moment of eventual consistency. It wraps every attempt, inside the retry
+// loop.
+func retryConsistencyErrors(stack *middleware.Stack) error {
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("NLBControllerConsistencyRetry",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleFinalize(ctx, in)
+			if err != nil && awsmiddleware.GetServiceID(ctx) == elbv2.ServiceID &&
+				retriesConsistency(awsmiddleware.GetOperationName(ctx)) && isConsistencyError(err) {
+				err = consistencyError{err}
+			}
+			return out, metadata, err
+		}), "Retry", middleware.After)
+}
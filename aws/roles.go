@@ -0,0 +1,120 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// roleSessionName names the sessions of assumed roles in CloudTrail.
+const roleSessionName = "aws-nlb-controller"
+
+// Role is an IAM role assumed to manage the NLBs of a pool that lives in
+// another AWS account.
+type Role struct {
+	ARN        string
+	ExternalID string
+}
+
+// account returns the AWS account of an ARN, or "" if arn is not an ARN.
+func account(arn string) string {
+	fields := strings.SplitN(arn, ":", 6)
+	if len(fields) < 6 || fields[0] != "arn" {
+		return ""
+	}
+	return fields[4]
+}
+
+// roleClients holds the elbv2 clients of assumed roles. NLBs are managed
+// with the client of the role of their pool, and listeners and target groups
+// with the client of the role of their account.
+type roleClients struct {
+	cfg aws.Config
+
+	mu        sync.Mutex
+	byRole    map[Role]*elbv2.Client
+	byNLB     map[string]*elbv2.Client
+	byAccount map[string]*elbv2.Client
+}
+
+func newRoleClients(cfg aws.Config) *roleClients {
+	return &roleClients{
+		cfg:       cfg,
+		byRole:    map[Role]*elbv2.Client{},
+		byNLB:     map[string]*elbv2.Client{},
+		byAccount: map[string]*elbv2.Client{},
+	}
+}
+
+// AssumeRole makes the client manage the NLB nlb, and the listeners and
+// target groups in the account of role, with the credentials of role. The
+// credentials are refreshed before they expire. An empty role ARN manages
+// the NLB with the credentials of the controller again.
+func (c client) AssumeRole(nlb string, role Role) error {
+	c.cache.invalidateNLB(nlb)
+	r := c.roles
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if role.ARN == "" {
+		delete(r.byNLB, nlb)
+		return nil
+	}
+	roleAccount := account(role.ARN)
+	if roleAccount == "" {
+		return fmt.Errorf("aws: %q is not a role ARN", role.ARN)
+	}
+	elb, ok := r.byRole[role]
+	if !ok {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(r.cfg), role.ARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = roleSessionName
+			if role.ExternalID != "" {
+				o.ExternalID = aws.String(role.ExternalID)
+			}
+		})
+		elb = elbv2.NewFromConfig(r.cfg, func(o *elbv2.Options) {
+			o.Credentials = aws.NewCredentialsCache(observedCredentials{source: role.ARN, provider: provider})
+		})
+		r.byRole[role] = elb
+	}
+	r.byNLB[nlb] = elb
+	r.byAccount[roleAccount] = elb
+	return nil
+}
+
+// elbForNLB returns the elbv2 client managing the NLB of the given name.
+func (c client) elbForNLB(nlb string) *elbv2.Client {
+	if c.roles == nil {
+		return c.Elb
+	}
+	c.roles.mu.Lock()
+	defer c.roles.mu.Unlock()
+	if elb, ok := c.roles.byNLB[nlb]; ok {
+		return elb
+	}
+	return c.Elb
+}
+
+// assumesRole reports whether the NLB of the given name is managed with an
+// assumed role.
+func (c client) assumesRole(nlb string) bool {
+	return c.elbForNLB(nlb) != c.Elb
+}
+
+// elbForArn returns the elbv2 client managing the listener or target group
+// arn, which is the client of the role assumed for its account.
+func (c client) elbForArn(arn string) *elbv2.Client {
+	if c.roles == nil {
+		return c.Elb
+	}
+	c.roles.mu.Lock()
+	defer c.roles.mu.Unlock()
+	if elb, ok := c.roles.byAccount[account(arn)]; ok {
+		return elb
+	}
+	return c.Elb
+}
@@ -0,0 +1,51 @@
+package aws
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// operationTimeouts are the deadlines of API calls, including their retries.
+type operationTimeouts struct {
+	// read is the deadline of Describe, Get and List calls
+	read time.Duration
+	// write is the deadline of every other call
+	write time.Duration
+}
+
+// isRead reports whether an operation only reads.
+func isRead(operation string) bool {
+	for _, prefix := range []string{"Describe", "Get", "List"} {
+		if strings.HasPrefix(operation, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// timeout returns the deadline of an operation, or zero for none.
+func (t operationTimeouts) timeout(operation string) time.Duration {
+	if isRead(operation) {
+		return t.read
+	}
+	return t.write
+}
+
+// middleware puts a deadline on every API call, so that a single slow call
+// cannot hold up a reconcile, and with it the work queue, for minutes. It
+// runs at the end of the initialize step, once the operation name is known.
+func (t operationTimeouts) middleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("NLBControllerTimeout",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			if timeout := t.timeout(awsmiddleware.GetOperationName(ctx)); timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			return next.HandleInitialize(ctx, in)
+		}), middleware.After)
+}
@@ -0,0 +1,210 @@
+package aws
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/chinmayrelkar/aws-nlb-controller/aws")
+
+// WithTracing returns a Client that records a span for every call of c, so
+// that the time reconciles spend in the AWS APIs shows in their traces.
+func WithTracing(c Client) Client {
+	return tracedClient{c: c}
+}
+
+type tracedClient struct {
+	c Client
+}
+
+// startSpan starts the span of a Client method.
+func startSpan(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "aws."+method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func listenerAttributes(spec ListenerSpec) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("allocation", spec.ServiceName),
+		attribute.String("nlb", spec.NLB),
+		attribute.Int("nlb.port", spec.Port),
+	}
+}
+
+func (t tracedClient) CreateNLBListenerForPort(ctx context.Context, spec ListenerSpec) (string, string, error) {
+	ctx, span := startSpan(ctx, "CreateNLBListenerForPort", listenerAttributes(spec)...)
+	listenerArn, targetArn, err := t.c.CreateNLBListenerForPort(ctx, spec)
+	endSpan(span, err)
+	return listenerArn, targetArn, err
+}
+
+func (t tracedClient) AdoptListener(ctx context.Context, listenerArn string, spec ListenerSpec) (ListenerAllocation, error) {
+	ctx, span := startSpan(ctx, "AdoptListener", append(listenerAttributes(spec), attribute.String("listener", listenerArn))...)
+	allocation, err := t.c.AdoptListener(ctx, listenerArn, spec)
+	endSpan(span, err)
+	return allocation, err
+}
+
+func (t tracedClient) EnsureTargetGroup(ctx context.Context, spec ListenerSpec) (string, error) {
+	ctx, span := startSpan(ctx, "EnsureTargetGroup", listenerAttributes(spec)...)
+	targetArn, err := t.c.EnsureTargetGroup(ctx, spec)
+	endSpan(span, err)
+	return targetArn, err
+}
+
+func (t tracedClient) RetargetListener(ctx context.Context, listenerArn string, oldTargetArn string, targetArn string) error {
+	ctx, span := startSpan(ctx, "RetargetListener", attribute.String("listener", listenerArn), attribute.String("targetGroup", targetArn))
+	err := t.c.RetargetListener(ctx, listenerArn, oldTargetArn, targetArn)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedClient) CheckListener(ctx context.Context, listenerArn string, targetArn string, spec ListenerSpec) error {
+	ctx, span := startSpan(ctx, "CheckListener", listenerAttributes(spec)...)
+	err := t.c.CheckListener(ctx, listenerArn, targetArn, spec)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedClient) ListenerExists(ctx context.Context, listenerArn string) (bool, error) {
+	ctx, span := startSpan(ctx, "ListenerExists", attribute.String("listener", listenerArn))
+	exists, err := t.c.ListenerExists(ctx, listenerArn)
+	endSpan(span, err)
+	return exists, err
+}
+
+func (t tracedClient) DeleteListenerAndTargetArn(ctx context.Context, serviceName string, listenerArn string, targetArn string) error {
+	ctx, span := startSpan(ctx, "DeleteListenerAndTargetArn", attribute.String("listener", listenerArn), attribute.String("targetGroup", targetArn))
+	err := t.c.DeleteListenerAndTargetArn(ctx, serviceName, listenerArn, targetArn)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedClient) SyncTargets(ctx context.Context, targetArn string, targets []Target) error {
+	ctx, span := startSpan(ctx, "SyncTargets", attribute.String("targetGroup", targetArn), attribute.Int("targets", len(targets)))
+	err := t.c.SyncTargets(ctx, targetArn, targets)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedClient) InstanceRegistered(ctx context.Context, targetArn string, instanceID string) (bool, error) {
+	ctx, span := startSpan(ctx, "InstanceRegistered", attribute.String("targetGroup", targetArn), attribute.String("instance", instanceID))
+	registered, err := t.c.InstanceRegistered(ctx, targetArn, instanceID)
+	endSpan(span, err)
+	return registered, err
+}
+
+func (t tracedClient) PendingTerminations(ctx context.Context, hook string) ([]LifecycleAction, error) {
+	ctx, span := startSpan(ctx, "PendingTerminations", attribute.String("hook", hook))
+	actions, err := t.c.PendingTerminations(ctx, hook)
+	endSpan(span, err)
+	return actions, err
+}
+
+func (t tracedClient) CompleteLifecycleAction(ctx context.Context, action LifecycleAction) error {
+	ctx, span := startSpan(ctx, "CompleteLifecycleAction", attribute.String("hook", action.HookName), attribute.String("instance", action.InstanceID))
+	err := t.c.CompleteLifecycleAction(ctx, action)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedClient) ListClusterInstances(ctx context.Context) ([]Instance, error) {
+	ctx, span := startSpan(ctx, "ListClusterInstances")
+	instances, err := t.c.ListClusterInstances(ctx)
+	endSpan(span, err)
+	return instances, err
+}
+
+func (t tracedClient) ListenerQuota(ctx context.Context) (int, error) {
+	ctx, span := startSpan(ctx, "ListenerQuota")
+	quota, err := t.c.ListenerQuota(ctx)
+	endSpan(span, err)
+	return quota, err
+}
+
+// AssumeRole makes no API call and is not traced.
+func (t tracedClient) AssumeRole(nlb string, role Role) error {
+	return t.c.AssumeRole(nlb, role)
+}
+
+func (t tracedClient) SyncTargetGroupHealthCheck(ctx context.Context, targetArn string, hc HealthCheck) error {
+	ctx, span := startSpan(ctx, "SyncTargetGroupHealthCheck", attribute.String("targetGroup", targetArn))
+	err := t.c.SyncTargetGroupHealthCheck(ctx, targetArn, hc)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedClient) SyncListenerCertificate(ctx context.Context, listenerArn string, certificate string) error {
+	ctx, span := startSpan(ctx, "SyncListenerCertificate", attribute.String("listener", listenerArn))
+	err := t.c.SyncListenerCertificate(ctx, listenerArn, certificate)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedClient) EnsureDNSRecord(ctx context.Context, name string, target string, svc string) error {
+	ctx, span := startSpan(ctx, "EnsureDNSRecord", attribute.String("dns.name", name), attribute.String("svc", svc))
+	err := t.c.EnsureDNSRecord(ctx, name, target, svc)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedClient) DeleteDNSRecord(ctx context.Context, name string, svc string) error {
+	ctx, span := startSpan(ctx, "DeleteDNSRecord", attribute.String("dns.name", name), attribute.String("svc", svc))
+	err := t.c.DeleteDNSRecord(ctx, name, svc)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedClient) SyncTargetGroupAttributes(ctx context.Context, targetArn string, attributes map[string]string) error {
+	ctx, span := startSpan(ctx, "SyncTargetGroupAttributes", attribute.String("targetGroup", targetArn))
+	err := t.c.SyncTargetGroupAttributes(ctx, targetArn, attributes)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedClient) EnsureNLB(ctx context.Context, spec NLBSpec) (NLB, error) {
+	ctx, span := startSpan(ctx, "EnsureNLB", attribute.String("nlb", spec.Name), attribute.String("nlbpool", spec.Pool))
+	nlb, err := t.c.EnsureNLB(ctx, spec)
+	endSpan(span, err)
+	return nlb, err
+}
+
+func (t tracedClient) DeleteNLB(ctx context.Context, pool string, name string) error {
+	ctx, span := startSpan(ctx, "DeleteNLB", attribute.String("nlb", name), attribute.String("nlbpool", pool))
+	err := t.c.DeleteNLB(ctx, pool, name)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedClient) DiscoverNLBs(ctx context.Context, key string, value string) ([]NLBDescription, error) {
+	ctx, span := startSpan(ctx, "DiscoverNLBs", attribute.String("tag", key+"="+value))
+	nlbs, err := t.c.DiscoverNLBs(ctx, key, value)
+	endSpan(span, err)
+	return nlbs, err
+}
+
+func (t tracedClient) ListAllocations(ctx context.Context, nlbs []string) ([]ListenerAllocation, error) {
+	ctx, span := startSpan(ctx, "ListAllocations", attribute.StringSlice("nlbs", nlbs))
+	allocations, err := t.c.ListAllocations(ctx, nlbs)
+	endSpan(span, err)
+	return allocations, err
+}
+
+func (t tracedClient) Ping(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "Ping")
+	err := t.c.Ping(ctx)
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,101 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// clusterTagPrefix is the prefix of the tag EKS and eksctl put on the
+// subnets of a cluster, followed by the cluster name.
+const clusterTagPrefix = "kubernetes.io/cluster/"
+
+// discoverVPC returns the VPC target groups are created in: the configured
+// one, else the VPC of the instance the controller runs on, else the VPC of
+// the subnets tagged for the cluster.
+func discoverVPC(ctx context.Context, ec2Client *ec2.Client, opts Options) (string, error) {
+	logger := loggerFrom(ctx)
+	if opts.VPC != "" {
+		return opts.VPC, nil
+	}
+
+	vpc, imdsErr := instanceVPC(ctx, opts.DisableIMDS)
+	if imdsErr == nil {
+		logger.Info("aws: using vpc of instance", "vpc", vpc)
+		return vpc, nil
+	}
+
+	clusterName := opts.clusterName()
+	vpc, tagErr := clusterVPC(ctx, ec2Client, clusterName)
+	if tagErr == nil {
+		logger.Info("aws: using vpc of cluster subnets", "vpc", vpc, "cluster", clusterName)
+		return vpc, nil
+	}
+	return "", fmt.Errorf("aws: no vpc configured. Set VPC_ID: instance metadata: %v; cluster tags: %v", imdsErr, tagErr)
+}
+
+// instanceVPC returns the VPC of the primary network interface of the
+// instance, as reported by the instance metadata service.
+func instanceVPC(ctx context.Context, disabled bool) (string, error) {
+	if disabled {
+		return "", errors.New("instance metadata is disabled")
+	}
+	client := newIMDSClient(false)
+	mac, err := metadata(ctx, client, "mac")
+	if err != nil {
+		return "", err
+	}
+	return metadata(ctx, client, "network/interfaces/macs/"+mac+"/vpc-id")
+}
+
+func metadata(ctx context.Context, client *imds.Client, path string) (string, error) {
+	out, err := client.GetMetadata(ctx, &imds.GetMetadataInput{Path: path})
+	if err != nil {
+		return "", err
+	}
+	defer out.Content.Close()
+	value, err := io.ReadAll(out.Content)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+// clusterVPC returns the VPC of the subnets tagged for the cluster.
+func clusterVPC(ctx context.Context, ec2Client *ec2.Client, clusterName string) (string, error) {
+	if clusterName == "" {
+		return "", errors.New("no cluster name")
+	}
+	vpcs := map[string]bool{}
+	paginator := ec2.NewDescribeSubnetsPaginator(ec2Client, &ec2.DescribeSubnetsInput{
+		Filters: []ec2types.Filter{{
+			Name:   aws.String("tag-key"),
+			Values: []string{clusterTagPrefix + clusterName},
+		}},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, subnet := range page.Subnets {
+			vpcs[aws.ToString(subnet.VpcId)] = true
+		}
+	}
+	switch len(vpcs) {
+	case 0:
+		return "", fmt.Errorf("no subnets tagged %s%s", clusterTagPrefix, clusterName)
+	case 1:
+		for vpc := range vpcs {
+			return vpc, nil
+		}
+	}
+	return "", fmt.Errorf("subnets tagged %s%s are in %d vpcs", clusterTagPrefix, clusterName, len(vpcs))
+}
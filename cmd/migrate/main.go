@@ -0,0 +1,294 @@
+// Command migrate moves Services of type LoadBalancer, each with an in-tree
+// load balancer of its own, onto the shared NLBs of the controller.
+//
+// By default every Service is converted in place: it becomes a NodePort
+// Service annotated for the controller, and the cloud provider deletes its
+// load balancer. With --keep-load-balancer a NodePort copy of the Service,
+// named after it with --suffix, is created instead, and the Service and its
+// load balancer keep serving until migrate --cutover deletes them, once
+// clients moved to the NLB. Services are read from the cluster of the
+// current kubeconfig context.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const usage = `Usage: migrate [flags] <namespace/name>...
+
+Converts the given Services of type LoadBalancer to NodePort Services that
+the controller allocates NLB ports for, and prints the NLB host and port of
+every Service port once they are allocated.
+
+Flags:
+`
+
+// Annotations of the controller read and written by the migration.
+const (
+	serviceAnnotation     = "github.com/chinmayrelkar/service"
+	nlbAnnotationNLBName  = "service-nlb-name"
+	nlbAnnotationNLBHost  = "service-nlb-host"
+	nlbAnnotationPort     = "service-nlb-port"
+	nlbAnnotationListener = "service-nlb-listener"
+	nlbAnnotationTarget   = "service-nlb-target"
+
+	lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+)
+
+// allocationAnnotations are the annotations the controller records the
+// allocation of a port in, unsuffixed or suffixed with the port.
+var allocationAnnotations = map[string]bool{
+	nlbAnnotationNLBName:  true,
+	nlbAnnotationNLBHost:  true,
+	nlbAnnotationPort:     true,
+	nlbAnnotationListener: true,
+	nlbAnnotationTarget:   true,
+}
+
+type options struct {
+	keepLoadBalancer bool
+	suffix           string
+	cutover          bool
+	wait             bool
+}
+
+func main() {
+	var opts options
+	var timeout time.Duration
+	flag.BoolVar(&opts.keepLoadBalancer, "keep-load-balancer", false,
+		"Create a NodePort copy of every Service instead of converting it, leaving the Service and its load balancer running until --cutover.")
+	flag.StringVar(&opts.suffix, "suffix", "-nlb", "The suffix of the names of the copies made with --keep-load-balancer.")
+	flag.BoolVar(&opts.cutover, "cutover", false,
+		"Delete the Services copied by an earlier run with --keep-load-balancer, and with them their load balancers, once their copies are allocated.")
+	flag.BoolVar(&opts.wait, "wait", true, "Wait for the controller to allocate NLB ports for the converted Services.")
+	flag.DurationVar(&timeout, "timeout", 5*time.Minute, "How long to wait for the migration to complete.")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c, err := newClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		os.Exit(1)
+	}
+	failed := false
+	for _, name := range flag.Args() {
+		if err := migrate(ctx, c, opts, name, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %s: %s\n", name, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func newClient() (client.Client, error) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(config, client.Options{Scheme: scheme})
+}
+
+// migrate migrates the Service namespace/name as opts say.
+func migrate(ctx context.Context, c client.Client, opts options, name string, out io.Writer) error {
+	namespace, name, found := strings.Cut(name, "/")
+	if !found {
+		return errors.New("not of the form namespace/name")
+	}
+	var svc corev1.Service
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &svc); err != nil {
+		return err
+	}
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return fmt.Errorf("service is of type %s, not LoadBalancer", svc.Spec.Type)
+	}
+	if opts.cutover {
+		return cutover(ctx, c, &svc, opts.suffix, out)
+	}
+
+	target := &svc
+	if opts.keepLoadBalancer {
+		target = nodePortCopy(&svc, opts.suffix)
+		err := c.Create(ctx, target)
+		if apierrors.IsAlreadyExists(err) {
+			// a previous run created it
+			err = c.Get(ctx, client.ObjectKeyFromObject(target), target)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s/%s: created %s, leaving the load balancer running\n", namespace, name, target.Name)
+	} else {
+		convert(target)
+		if err := c.Update(ctx, target); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s/%s: converted to NodePort\n", namespace, name)
+	}
+	if !opts.wait {
+		return nil
+	}
+	if err := waitForAllocation(ctx, c, target); err != nil {
+		return fmt.Errorf("waiting for nlb ports of %s: %w", target.Name, err)
+	}
+	return printAllocation(out, target)
+}
+
+// cutover deletes a Service of type LoadBalancer, and with it its load
+// balancer, once its NodePort copy is allocated.
+func cutover(ctx context.Context, c client.Client, svc *corev1.Service, suffix string, out io.Writer) error {
+	var copied corev1.Service
+	if err := c.Get(ctx, types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name + suffix}, &copied); err != nil {
+		return fmt.Errorf("no copy to cut over to: %w", err)
+	}
+	if !allocated(&copied) {
+		return fmt.Errorf("%s has no nlb port for every port yet", copied.Name)
+	}
+	if err := c.Delete(ctx, svc, client.Preconditions{UID: &svc.UID}); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s/%s: deleted, %s serves on the nlb\n", svc.Namespace, svc.Name, copied.Name)
+	return nil
+}
+
+// convert turns a Service of type LoadBalancer into a NodePort Service
+// managed by the controller. Its node ports are kept.
+func convert(svc *corev1.Service) {
+	svc.Spec.Type = corev1.ServiceTypeNodePort
+	svc.Spec.LoadBalancerIP = ""
+	svc.Spec.LoadBalancerSourceRanges = nil
+	svc.Spec.LoadBalancerClass = nil
+	svc.Spec.AllocateLoadBalancerNodePorts = nil
+	svc.Spec.HealthCheckNodePort = 0
+	if svc.Annotations == nil {
+		svc.Annotations = map[string]string{}
+	}
+	svc.Annotations[serviceAnnotation] = "true"
+}
+
+// nodePortCopy returns a NodePort Service managed by the controller that
+// selects the same pods on the same ports as svc. It carries the labels and
+// annotations of svc, so that the controller reads the AWS load balancer
+// annotations of svc for it too. The allocation annotations of svc, set when
+// the controller already serves it through its loadBalancerClass, are left
+// out: the copy gets NLB ports of its own.
+func nodePortCopy(svc *corev1.Service, suffix string) *corev1.Service {
+	annotations := map[string]string{}
+	for k, v := range svc.Annotations {
+		if k != lastAppliedAnnotation && !isAllocationAnnotation(k) {
+			annotations[k] = v
+		}
+	}
+	annotations[serviceAnnotation] = "true"
+	labels := map[string]string{}
+	for k, v := range svc.Labels {
+		labels[k] = v
+	}
+	ports := make([]corev1.ServicePort, len(svc.Spec.Ports))
+	for i, port := range svc.Spec.Ports {
+		port.NodePort = 0
+		ports[i] = port
+	}
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   svc.Namespace,
+			Name:        svc.Name + suffix,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:                  corev1.ServiceTypeNodePort,
+			Selector:              svc.Spec.Selector,
+			Ports:                 ports,
+			ExternalTrafficPolicy: svc.Spec.ExternalTrafficPolicy,
+			SessionAffinity:       svc.Spec.SessionAffinity,
+		},
+	}
+}
+
+// isAllocationAnnotation reports whether key is an allocation annotation of
+// the controller.
+func isAllocationAnnotation(key string) bool {
+	annotation, _, _ := strings.Cut(key, ".")
+	return allocationAnnotations[annotation]
+}
+
+// waitForAllocation polls svc until every port of it has an NLB port.
+func waitForAllocation(ctx context.Context, c client.Client, svc *corev1.Service) error {
+	return wait.PollImmediateUntilWithContext(ctx, 2*time.Second, func(ctx context.Context) (bool, error) {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(svc), svc); err != nil {
+			return false, err
+		}
+		return allocated(svc), nil
+	})
+}
+
+// allocated reports whether every port of svc has an NLB port.
+func allocated(svc *corev1.Service) bool {
+	for idx, port := range svc.Spec.Ports {
+		if portAnnotation(svc, nlbAnnotationNLBHost, port, idx) == "" {
+			return false
+		}
+	}
+	return len(svc.Spec.Ports) > 0
+}
+
+// portAnnotation returns an annotation the controller writes for a port,
+// which is suffixed with the name or index of the port. Unsuffixed
+// annotations belong to the first port.
+func portAnnotation(svc *corev1.Service, annotation string, port corev1.ServicePort, idx int) string {
+	key := port.Name
+	if key == "" {
+		key = strconv.Itoa(idx)
+	}
+	if value, ok := svc.Annotations[annotation+"."+key]; ok {
+		return value
+	}
+	if idx == 0 {
+		return svc.Annotations[annotation]
+	}
+	return ""
+}
+
+func printAllocation(out io.Writer, svc *corev1.Service) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tPORT\tNLB HOST\tNLB PORT")
+	for idx, port := range svc.Spec.Ports {
+		fmt.Fprintf(w, "%s/%s\t%d\t%s\t%s\n", svc.Namespace, svc.Name, port.Port,
+			portAnnotation(svc, nlbAnnotationNLBHost, port, idx), portAnnotation(svc, nlbAnnotationPort, port, idx))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func loadBalancerService() *corev1.Service {
+	class := "chinmayrelkar.github.com/nlb"
+	allocate := true
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "web",
+			Labels:    map[string]string{"app": "web"},
+			Annotations: map[string]string{
+				"service.beta.kubernetes.io/aws-load-balancer-type": "nlb",
+				lastAppliedAnnotation:                               "{}",
+				nlbAnnotationNLBName + ".http":                      "shared",
+				nlbAnnotationNLBHost + ".http":                      "shared.elb.amazonaws.com",
+				nlbAnnotationPort + ".http":                         "9000",
+				nlbAnnotationListener + ".http":                     "arn:listener",
+				nlbAnnotationTarget + ".http":                       "arn:targetgroup",
+				nlbAnnotationListener:                               "arn:listener",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:                          corev1.ServiceTypeLoadBalancer,
+			Selector:                      map[string]string{"app": "web"},
+			Ports:                         []corev1.ServicePort{{Name: "http", Port: 80, NodePort: 30080}},
+			LoadBalancerClass:             &class,
+			LoadBalancerSourceRanges:      []string{"10.0.0.0/8"},
+			AllocateLoadBalancerNodePorts: &allocate,
+			HealthCheckNodePort:           30999,
+			ExternalTrafficPolicy:         corev1.ServiceExternalTrafficPolicyTypeLocal,
+		},
+	}
+}
+
+func TestConvert(t *testing.T) {
+	svc := loadBalancerService()
+	convert(svc)
+	if svc.Spec.Type != corev1.ServiceTypeNodePort {
+		t.Errorf("type %s, want NodePort", svc.Spec.Type)
+	}
+	if svc.Spec.LoadBalancerClass != nil || svc.Spec.LoadBalancerSourceRanges != nil ||
+		svc.Spec.AllocateLoadBalancerNodePorts != nil || svc.Spec.HealthCheckNodePort != 0 {
+		t.Errorf("spec %+v keeps load balancer fields", svc.Spec)
+	}
+	if svc.Spec.Ports[0].NodePort != 30080 {
+		t.Errorf("node port %d, want 30080 kept", svc.Spec.Ports[0].NodePort)
+	}
+	if svc.Annotations[serviceAnnotation] != "true" {
+		t.Errorf("annotations %v, want the svc annotation of the controller", svc.Annotations)
+	}
+	// the svc keeps its own allocation
+	if svc.Annotations[nlbAnnotationListener+".http"] != "arn:listener" {
+		t.Errorf("annotations %v, want the allocation of the svc kept", svc.Annotations)
+	}
+}
+
+func TestNodePortCopy(t *testing.T) {
+	svc := loadBalancerService()
+	copied := nodePortCopy(svc, "-nlb")
+	if copied.Name != "web-nlb" || copied.Namespace != "default" {
+		t.Errorf("copy %s/%s, want default/web-nlb", copied.Namespace, copied.Name)
+	}
+	if copied.Spec.Type != corev1.ServiceTypeNodePort || copied.Spec.LoadBalancerClass != nil {
+		t.Errorf("spec %+v, want a NodePort svc without load balancer class", copied.Spec)
+	}
+	if copied.Spec.Ports[0].NodePort != 0 || copied.Spec.Ports[0].Port != 80 {
+		t.Errorf("ports %+v, want port 80 on a new node port", copied.Spec.Ports)
+	}
+	if copied.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal || copied.Spec.Selector["app"] != "web" {
+		t.Errorf("spec %+v, want the selector and traffic policy of web", copied.Spec)
+	}
+	if copied.Labels["app"] != "web" {
+		t.Errorf("labels %v, want the labels of web", copied.Labels)
+	}
+	want := map[string]string{
+		"service.beta.kubernetes.io/aws-load-balancer-type": "nlb",
+		serviceAnnotation: "true",
+	}
+	if len(copied.Annotations) != len(want) {
+		t.Errorf("annotations %v, want %v", copied.Annotations, want)
+	}
+	for k, v := range want {
+		if copied.Annotations[k] != v {
+			t.Errorf("annotation %s = %q, want %q", k, copied.Annotations[k], v)
+		}
+	}
+	if allocated(copied) {
+		t.Error("copy taken for allocated before the controller allocated it")
+	}
+	if svc.Spec.Ports[0].NodePort != 30080 || svc.Annotations[nlbAnnotationPort+".http"] != "9000" {
+		t.Error("copying changed web")
+	}
+}
@@ -0,0 +1,499 @@
+// Command nlbctl inspects and manages the allocations of the controller.
+//
+// It talks to the admin API of the controller when --server is set, and
+// otherwise reads the NLBAllocation and NLBPool resources of the cluster of
+// the current kubeconfig context, which only works with --store=crd.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/admin"
+	nlbv1alpha1 "github.com/chinmayrelkar/aws-nlb-controller/api/v1alpha1"
+	"github.com/chinmayrelkar/aws-nlb-controller/audit"
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const usage = `Usage: nlbctl [flags] <command> [args]
+
+Commands:
+  allocations                    list the allocations of every service port
+  pools                          show the utilization of every NLB
+  release <namespace/name:port>  delete the listener of an allocation and free its port
+  resync <namespace/name>        reconcile a service
+  audit [namespace/name[:port]]  list the allocations and releases of the audit trail
+  export                         write a snapshot of every allocation as JSON to stdout
+  import <file>                  import the allocations of a snapshot, - for stdin
+  verify                         cross-check the store, service annotations and listeners in AWS
+  plan                           list the AWS changes of a controller running with --dry-run
+
+release, resync, audit, import, verify and plan require --server.
+
+Flags:
+`
+
+func main() {
+	var server string
+	var token string
+	var timeout time.Duration
+	var auditSince time.Duration
+	var auditLimit int
+	flag.StringVar(&server, "server", os.Getenv("NLBCTL_SERVER"),
+		"The URL of the admin API, such as http://localhost:8082. If empty, the NLBAllocation resources are read instead.")
+	flag.StringVar(&token, "token", os.Getenv("ADMIN_TOKEN"), "The bearer token of the admin API.")
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "How long to wait for the command to complete.")
+	flag.DurationVar(&auditSince, "since", 0, "List the audit records of this long ago on. 0 lists every record.")
+	flag.IntVar(&auditLimit, "limit", 100, "The number of latest audit records listed. 0 lists every record.")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	query := url.Values{"limit": {strconv.Itoa(auditLimit)}}
+	if auditSince > 0 {
+		query.Set("since", time.Now().Add(-auditSince).UTC().Format(time.RFC3339))
+	}
+	if err := run(ctx, server, token, query, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "nlbctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, server string, token string, auditQuery url.Values, args []string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		return errors.New("no command given")
+	}
+	api := &adminClient{server: strings.TrimSuffix(server, "/"), token: token}
+
+	switch command := args[0]; command {
+	case "allocations", "pools":
+		var state admin.State
+		var err error
+		if server != "" {
+			state, err = api.state(ctx)
+		} else {
+			state, err = crdState(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		if command == "allocations" {
+			return printAllocations(os.Stdout, state)
+		}
+		return printPools(os.Stdout, state)
+	case "release", "resync":
+		if len(args) != 2 {
+			return fmt.Errorf("%s takes exactly one argument", command)
+		}
+		if server == "" {
+			return fmt.Errorf("%s requires --server", command)
+		}
+		if command == "release" {
+			return api.post(ctx, admin.ReleasePath, "allocation", args[1])
+		}
+		return api.post(ctx, admin.ResyncPath, "service", args[1])
+	case "export":
+		var snapshot admin.Snapshot
+		var err error
+		if server != "" {
+			snapshot, err = api.snapshot(ctx)
+		} else {
+			snapshot, err = crdSnapshot(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(snapshot)
+	case "import":
+		if len(args) != 2 {
+			return fmt.Errorf("%s takes exactly one argument", command)
+		}
+		if server == "" {
+			return fmt.Errorf("%s requires --server", command)
+		}
+		in := os.Stdin
+		if args[1] != "-" {
+			f, err := os.Open(args[1])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		result, err := api.importSnapshot(ctx, in)
+		if err != nil {
+			return err
+		}
+		return printImport(os.Stdout, result)
+	case "plan":
+		if server == "" {
+			return fmt.Errorf("%s requires --server", command)
+		}
+		mutations, err := api.plan(ctx)
+		if err != nil {
+			return err
+		}
+		return printPlan(os.Stdout, mutations)
+	case "verify":
+		if server == "" {
+			return fmt.Errorf("%s requires --server", command)
+		}
+		report, err := api.verify(ctx)
+		if err != nil {
+			return err
+		}
+		return printReport(os.Stdout, report)
+	case "audit":
+		if len(args) > 2 {
+			return fmt.Errorf("%s takes at most one argument", command)
+		}
+		if server == "" {
+			return fmt.Errorf("%s requires --server", command)
+		}
+		if len(args) == 2 {
+			auditQuery.Set("service", args[1])
+		}
+		records, err := api.audit(ctx, auditQuery)
+		if err != nil {
+			return err
+		}
+		return printAudit(os.Stdout, records)
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// adminClient calls the admin API.
+type adminClient struct {
+	server string
+	token  string
+}
+
+func (c *adminClient) state(ctx context.Context) (admin.State, error) {
+	var state admin.State
+	body, err := c.do(ctx, http.MethodGet, admin.StatePath, nil, nil)
+	if err != nil {
+		return state, err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(&state); err != nil {
+		return state, fmt.Errorf("unable to decode state: %w", err)
+	}
+	return state, nil
+}
+
+func (c *adminClient) audit(ctx context.Context, query url.Values) ([]audit.Record, error) {
+	var records []audit.Record
+	body, err := c.do(ctx, http.MethodGet, admin.AuditPath, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(&records); err != nil {
+		return nil, fmt.Errorf("unable to decode audit records: %w", err)
+	}
+	return records, nil
+}
+
+func (c *adminClient) post(ctx context.Context, path string, param string, value string) error {
+	body, err := c.do(ctx, http.MethodPost, path, url.Values{param: {value}}, nil)
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
+
+func (c *adminClient) plan(ctx context.Context) ([]aws.Mutation, error) {
+	var mutations []aws.Mutation
+	body, err := c.do(ctx, http.MethodGet, admin.PlanPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(&mutations); err != nil {
+		return nil, fmt.Errorf("unable to decode plan: %w", err)
+	}
+	return mutations, nil
+}
+
+func (c *adminClient) verify(ctx context.Context) (admin.Report, error) {
+	var report admin.Report
+	body, err := c.do(ctx, http.MethodGet, admin.VerifyPath, nil, nil)
+	if err != nil {
+		return report, err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(&report); err != nil {
+		return report, fmt.Errorf("unable to decode verify report: %w", err)
+	}
+	return report, nil
+}
+
+func (c *adminClient) snapshot(ctx context.Context) (admin.Snapshot, error) {
+	var snapshot admin.Snapshot
+	body, err := c.do(ctx, http.MethodGet, admin.SnapshotPath, nil, nil)
+	if err != nil {
+		return snapshot, err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(&snapshot); err != nil {
+		return snapshot, fmt.Errorf("unable to decode snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+func (c *adminClient) importSnapshot(ctx context.Context, snapshot io.Reader) (admin.ImportResult, error) {
+	var result admin.ImportResult
+	body, err := c.do(ctx, http.MethodPost, admin.SnapshotPath, nil, snapshot)
+	if err != nil {
+		return result, err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(&result); err != nil {
+		return result, fmt.Errorf("unable to decode import result: %w", err)
+	}
+	return result, nil
+}
+
+func (c *adminClient) do(ctx context.Context, method string, path string, query url.Values, reqBody io.Reader) (io.ReadCloser, error) {
+	u := c.server + path
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
+
+// crdState builds the state from the NLBAllocation and NLBPool resources.
+// NLBs of NLB_LIST have no resource and are only listed if ports are
+// allocated on them, without their utilization.
+func crdState(ctx context.Context) (admin.State, error) {
+	state := admin.State{NLBs: []admin.NLBState{}, Allocations: []admin.Allocation{}}
+	c, err := crdClient()
+	if err != nil {
+		return state, err
+	}
+
+	var allocations nlbv1alpha1.NLBAllocationList
+	if err := c.List(ctx, &allocations); err != nil {
+		return state, fmt.Errorf("unable to list nlballocations: %w", err)
+	}
+	nlbs := map[string]*admin.NLBState{}
+	for _, item := range allocations.Items {
+		spec := item.Spec
+		state.Allocations = append(state.Allocations, admin.Allocation{
+			Service:     spec.ServiceName,
+			NLB:         spec.NLB,
+			Port:        spec.Port,
+			ListenerArn: spec.ListenerArn,
+			TargetArn:   spec.TargetGroupArn,
+		})
+		nlb, ok := nlbs[spec.NLB]
+		if !ok {
+			nlb = &admin.NLBState{Name: spec.NLB, Ports: map[int]string{}}
+			nlbs[spec.NLB] = nlb
+		}
+		nlb.Ports[spec.Port] = spec.ServiceName
+		nlb.AllocatedPorts++
+	}
+	sort.Slice(state.Allocations, func(i, j int) bool {
+		return state.Allocations[i].Service < state.Allocations[j].Service
+	})
+
+	var pools nlbv1alpha1.NLBPoolList
+	if err := c.List(ctx, &pools); err != nil {
+		return state, fmt.Errorf("unable to list nlbpools: %w", err)
+	}
+	for _, pool := range pools.Items {
+		name := pool.LoadBalancerName()
+		nlb, ok := nlbs[name]
+		if !ok {
+			nlb = &admin.NLBState{Name: name, Ports: map[int]string{}}
+			nlbs[name] = nlb
+		}
+		nlb.Host = pool.Status.DNSName
+		nlb.AllocatedPorts = pool.Status.AllocatedPorts
+		nlb.FreePorts = pool.Status.FreePorts
+	}
+	for _, nlb := range nlbs {
+		state.NLBs = append(state.NLBs, *nlb)
+	}
+	sort.Slice(state.NLBs, func(i, j int) bool {
+		return state.NLBs[i].Name < state.NLBs[j].Name
+	})
+	return state, nil
+}
+
+// crdSnapshot builds a snapshot from the NLBAllocation and NLBPool
+// resources, such as of a cluster whose controller is gone. NLBs of NLB_LIST
+// are only listed if ports are allocated on them.
+func crdSnapshot(ctx context.Context) (admin.Snapshot, error) {
+	snapshot := admin.Snapshot{Version: admin.SnapshotVersion, Time: time.Now().UTC(), NLBs: []string{}, Allocations: []admin.SnapshotAllocation{}}
+	c, err := crdClient()
+	if err != nil {
+		return snapshot, err
+	}
+
+	var allocations nlbv1alpha1.NLBAllocationList
+	if err := c.List(ctx, &allocations); err != nil {
+		return snapshot, fmt.Errorf("unable to list nlballocations: %w", err)
+	}
+	nlbs := map[string]bool{}
+	for _, item := range allocations.Items {
+		spec := item.Spec
+		allocation := admin.SnapshotAllocation{
+			Allocation: admin.Allocation{
+				Service:     spec.ServiceName,
+				NLB:         spec.NLB,
+				Port:        spec.Port,
+				ListenerArn: spec.ListenerArn,
+				TargetArn:   spec.TargetGroupArn,
+			},
+			Retained: spec.Retained,
+			Sticky:   spec.Sticky,
+		}
+		if spec.StickyUntil != nil {
+			until := spec.StickyUntil.UTC()
+			allocation.StickyUntil = &until
+		}
+		snapshot.Allocations = append(snapshot.Allocations, allocation)
+		nlbs[spec.NLB] = true
+	}
+	sort.Slice(snapshot.Allocations, func(i, j int) bool {
+		return snapshot.Allocations[i].Service < snapshot.Allocations[j].Service
+	})
+
+	var pools nlbv1alpha1.NLBPoolList
+	if err := c.List(ctx, &pools); err != nil {
+		return snapshot, fmt.Errorf("unable to list nlbpools: %w", err)
+	}
+	for _, pool := range pools.Items {
+		nlbs[pool.LoadBalancerName()] = true
+	}
+	for nlb := range nlbs {
+		snapshot.NLBs = append(snapshot.NLBs, nlb)
+	}
+	sort.Strings(snapshot.NLBs)
+	return snapshot, nil
+}
+
+// crdClient returns a client of the NLBAllocation and NLBPool resources of
+// the cluster of the current kubeconfig context.
+func crdClient() (client.Client, error) {
+	scheme := runtime.NewScheme()
+	if err := nlbv1alpha1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(config, client.Options{Scheme: scheme})
+}
+
+func printPlan(out io.Writer, mutations []aws.Mutation) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FIRST SEEN\tCOUNT\tACTION\tALLOCATION\tDETAIL")
+	for _, m := range mutations {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", m.FirstSeen.Format(time.RFC3339), m.Count, m.Action, m.Allocation, m.Detail)
+	}
+	return w.Flush()
+}
+
+// printReport prints the mismatches of a report, and fails if there are any.
+func printReport(out io.Writer, report admin.Report) error {
+	fmt.Fprintf(out, "checked %d services, %d allocations and %d listeners: %d mismatches\n",
+		report.Services, report.Allocations, report.Listeners, len(report.Mismatches))
+	if len(report.Mismatches) == 0 {
+		return nil
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tALLOCATION\tNLB\tPORT\tDETAIL\tREMEDIATION")
+	for _, m := range report.Mismatches {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", m.Kind, m.Allocation, m.NLB, m.Port, m.Detail, m.Remediation)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return errors.New("the store is not consistent")
+}
+
+func printImport(out io.Writer, result admin.ImportResult) error {
+	fmt.Fprintf(out, "imported %d allocations, skipped %d, %d failed\n", result.Imported, result.Skipped, len(result.Failed))
+	names := make([]string, 0, len(result.Failed))
+	for name := range result.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "%s: %s\n", name, result.Failed[name])
+	}
+	if len(result.Failed) > 0 {
+		return errors.New("some allocations were not imported")
+	}
+	return nil
+}
+
+func printAllocations(out io.Writer, state admin.State) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tNLB\tPORT\tLISTENER")
+	for _, a := range state.Allocations {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", a.Service, a.NLB, a.Port, a.ListenerArn)
+	}
+	return w.Flush()
+}
+
+func printAudit(out io.Writer, records []audit.Record) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTION\tACTOR\tSERVICE\tNLB\tPORT\tLISTENER\tREASON")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			r.Time.Format(time.RFC3339), r.Action, r.Actor, r.Service, r.NLB, r.Port, r.ListenerArn, r.Reason)
+	}
+	return w.Flush()
+}
+
+func printPools(out io.Writer, state admin.State) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NLB\tHOST\tALLOCATED\tFREE")
+	for _, nlb := range state.NLBs {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", nlb.Name, nlb.Host, nlb.AllocatedPorts, nlb.FreePorts)
+	}
+	return w.Flush()
+}
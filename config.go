@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+)
+
+// The version and kind of the config file read by --config.
+const (
+	configAPIVersion = "nlb.chinmayrelkar.github.com/v1alpha1"
+	configKind       = "ControllerConfig"
+)
+
+// controllerConfig is the config file read by --config, in YAML or JSON. It
+// holds the settings that are otherwise given by env vars. Env vars and flags
+// that are set take precedence over it, so that existing deployments keep
+// working while they move to the file.
+type controllerConfig struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	// ClusterID is the default of --cluster-id, like CLUSTER_ID.
+	ClusterID string `json:"clusterID,omitempty"`
+	// ClusterName is the default of --cluster-name, like CLUSTER_NAME.
+	ClusterName string `json:"clusterName,omitempty"`
+	// VPCID is the default of --vpc-id, like VPC_ID.
+	VPCID string `json:"vpcID,omitempty"`
+	// Region is the default of --aws-region, like AWS_REGION.
+	Region string `json:"region,omitempty"`
+
+	// PortRange is the listener port range of the NLBs listed without one,
+	// like NLB_PORT_RANGE.
+	PortRange string `json:"portRange,omitempty"`
+	// NLBs are the managed NLBs, like NLB_LIST.
+	NLBs []nlbConfig `json:"nlbs,omitempty"`
+}
+
+// nlbConfig is an NLB of the config file.
+type nlbConfig struct {
+	Name      string `json:"name"`
+	Host      string `json:"host"`
+	PortRange string `json:"portRange,omitempty"`
+	// Weight is the share of new allocations of the NLB under the weighted
+	// NLB selection strategy. Defaults to 1.
+	Weight int `json:"weight,omitempty"`
+	// ExcludedPorts are ports of the range that are never allocated, such as
+	// the ports of listeners created by hand.
+	ExcludedPorts []int `json:"excludedPorts,omitempty"`
+}
+
+// loadConfig reads and validates the config file at path. Unknown fields are
+// errors, so that misspelled settings are not silently ignored.
+func loadConfig(path string) (*controllerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg controllerConfig
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// validate reports every invalid field of the config, not just the first.
+func (c *controllerConfig) validate() error {
+	var errs field.ErrorList
+	if c.APIVersion != configAPIVersion {
+		errs = append(errs, field.NotSupported(field.NewPath("apiVersion"), c.APIVersion, []string{configAPIVersion}))
+	}
+	if c.Kind != configKind {
+		errs = append(errs, field.NotSupported(field.NewPath("kind"), c.Kind, []string{configKind}))
+	}
+	if c.VPCID != "" && !strings.HasPrefix(c.VPCID, "vpc-") {
+		errs = append(errs, field.Invalid(field.NewPath("vpcID"), c.VPCID, "must be a VPC ID, such as vpc-07495dd1ca70abb71"))
+	}
+	if c.PortRange != "" {
+		if _, err := store.ParsePortRange(c.PortRange); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("portRange"), c.PortRange, err.Error()))
+		}
+	}
+	names := map[string]bool{}
+	for i, nlb := range c.NLBs {
+		path := field.NewPath("nlbs").Index(i)
+		switch {
+		case nlb.Name == "":
+			errs = append(errs, field.Required(path.Child("name"), ""))
+		case names[nlb.Name]:
+			errs = append(errs, field.Duplicate(path.Child("name"), nlb.Name))
+		}
+		names[nlb.Name] = true
+		if nlb.Host == "" {
+			errs = append(errs, field.Required(path.Child("host"), "the DNS name of the NLB"))
+		}
+		if nlb.PortRange != "" {
+			if _, err := store.ParsePortRange(nlb.PortRange); err != nil {
+				errs = append(errs, field.Invalid(path.Child("portRange"), nlb.PortRange, err.Error()))
+			}
+		}
+		if nlb.Weight < 0 {
+			errs = append(errs, field.Invalid(path.Child("weight"), nlb.Weight, "must not be negative"))
+		}
+		for j, port := range nlb.ExcludedPorts {
+			if port < 1 || port > 65535 {
+				errs = append(errs, field.Invalid(path.Child("excludedPorts").Index(j), port, "must be within 1-65535"))
+			}
+		}
+	}
+	return errs.ToAggregate()
+}
+
+// storeNLBs returns the NLBs of the config. NLBs without a port range get the
+// one of the config, unless NLB_PORT_RANGE overrides it. The config is valid.
+func (c *controllerConfig) storeNLBs() []store.NLB {
+	var portRange store.PortRange
+	if c.PortRange != "" && os.Getenv("NLB_PORT_RANGE") == "" {
+		portRange, _ = store.ParsePortRange(c.PortRange)
+	}
+	nlbs := make([]store.NLB, 0, len(c.NLBs))
+	for _, nlb := range c.NLBs {
+		storeNLB := store.NLB{Name: nlb.Name, Host: nlb.Host, PortRange: portRange, Weight: nlb.Weight, ExcludedPorts: nlb.ExcludedPorts}
+		if nlb.PortRange != "" {
+			storeNLB.PortRange, _ = store.ParsePortRange(nlb.PortRange)
+		}
+		nlbs = append(nlbs, storeNLB)
+	}
+	return nlbs
+}
+
+// configDefault sets *value to the value of the config unless the flag name
+// or the env var env is set, which take precedence.
+func configDefault(value *string, configValue string, name string, env string, setFlags map[string]bool) {
+	if configValue == "" || setFlags[name] || os.Getenv(env) != "" {
+		return
+	}
+	*value = configValue
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("NLB_PORT_RANGE", "")
+	cfg, err := loadConfig(writeConfig(t, `
+apiVersion: nlb.chinmayrelkar.github.com/v1alpha1
+kind: ControllerConfig
+clusterID: my-cluster
+vpcID: vpc-07495dd1ca70abb71
+portRange: 9000-9049
+nlbs:
+  - name: public
+    host: public.elb.amazonaws.com
+  - name: internal
+    host: internal.elb.amazonaws.com
+    portRange: 9100-9199
+    weight: 3
+    excludedPorts: [9100, 9101]
+`))
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if cfg.ClusterID != "my-cluster" || cfg.VPCID != "vpc-07495dd1ca70abb71" {
+		t.Errorf("loadConfig() = %+v", cfg)
+	}
+	want := []store.NLB{
+		{Name: "public", Host: "public.elb.amazonaws.com", PortRange: store.PortRange{Min: 9000, Max: 9049}},
+		{Name: "internal", Host: "internal.elb.amazonaws.com", PortRange: store.PortRange{Min: 9100, Max: 9199}, Weight: 3, ExcludedPorts: []int{9100, 9101}},
+	}
+	nlbs := cfg.storeNLBs()
+	if len(nlbs) != len(want) {
+		t.Fatalf("storeNLBs() = %+v, want %+v", nlbs, want)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(nlbs[i], want[i]) {
+			t.Errorf("storeNLBs()[%d] = %+v, want %+v", i, nlbs[i], want[i])
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "unknown field",
+			content: "apiVersion: nlb.chinmayrelkar.github.com/v1alpha1\nkind: ControllerConfig\nvpc: vpc-1\n",
+			want:    []string{`unknown field "vpc"`},
+		},
+		{
+			name:    "wrong version",
+			content: "apiVersion: v1\nkind: ControllerConfig\n",
+			want:    []string{"apiVersion"},
+		},
+		{
+			name: "every invalid nlb",
+			content: `
+apiVersion: nlb.chinmayrelkar.github.com/v1alpha1
+kind: ControllerConfig
+nlbs:
+  - name: public
+    host: public.elb.amazonaws.com
+    portRange: "9000"
+    weight: -1
+  - name: public
+`,
+			want: []string{"nlbs[0].portRange", "nlbs[0].weight", "nlbs[1].name", "nlbs[1].host"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, tt.content))
+			if err == nil {
+				t.Fatal("loadConfig() error = nil, want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("loadConfig() error = %v, want it to mention %s", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigDefault(t *testing.T) {
+	t.Setenv("VPC_ID", "vpc-env")
+	value := ""
+	configDefault(&value, "vpc-config", "vpc-id", "VPC_ID", nil)
+	if value != "" {
+		t.Errorf("config overrode env var, got %q", value)
+	}
+	t.Setenv("VPC_ID", "")
+	configDefault(&value, "vpc-config", "vpc-id", "VPC_ID", map[string]bool{"vpc-id": true})
+	if value != "" {
+		t.Errorf("config overrode flag, got %q", value)
+	}
+	configDefault(&value, "vpc-config", "vpc-id", "VPC_ID", nil)
+	if value != "vpc-config" {
+		t.Errorf("configDefault() = %q, want vpc-config", value)
+	}
+}
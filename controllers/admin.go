@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/admin"
+	"github.com/chinmayrelkar/aws-nlb-controller/audit"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// ServiceAdmin implements the actions of the admin API on services.
+type ServiceAdmin struct {
+	Client     client.Reader
+	Reconciler *ServiceReconciler
+
+	// Resync receives the services to reconcile. It must be the channel the
+	// Resync of Reconciler receives from.
+	Resync chan<- event.GenericEvent
+}
+
+// Release deletes the listener and target group of an allocation and frees
+// its port. The svc is then reconciled, which allocates a new port for it.
+func (a *ServiceAdmin) Release(ctx context.Context, allocationKey string) error {
+	allocation := a.Reconciler.Store.GetAllocationForSVC(ctx, allocationKey)
+	if allocation == nil {
+		return fmt.Errorf("allocation %s: %w", allocationKey, admin.ErrNotFound)
+	}
+
+	serviceKey, _ := splitAllocationKey(allocationKey)
+	svc, err := a.service(ctx, serviceKey)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err := a.Reconciler.releaseAllocation(audit.WithActor(ctx, audit.ActorAdmin), svc, allocation, "released through the admin api"); err != nil {
+		return err
+	}
+	if svc == nil {
+		return nil
+	}
+	return a.enqueue(ctx, svc)
+}
+
+// ResyncService reconciles a svc.
+func (a *ServiceAdmin) ResyncService(ctx context.Context, serviceName string) error {
+	namespace, name, ok := strings.Cut(serviceName, "/")
+	if !ok {
+		return fmt.Errorf("service %q is not of the form namespace/name: %w", serviceName, admin.ErrNotFound)
+	}
+	svc, err := a.service(ctx, types.NamespacedName{Namespace: namespace, Name: name})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("service %s: %w", serviceName, admin.ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	return a.enqueue(ctx, svc)
+}
+
+func (a *ServiceAdmin) service(ctx context.Context, key types.NamespacedName) (*corev1.Service, error) {
+	var svc corev1.Service
+	if err := a.Client.Get(ctx, key, &svc); err != nil {
+		return nil, err
+	}
+	return &svc, nil
+}
+
+func (a *ServiceAdmin) enqueue(ctx context.Context, svc *corev1.Service) error {
+	select {
+	case a.Resync <- event.GenericEvent{Object: svc}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
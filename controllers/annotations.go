@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Health check annotations configure the target groups created for a svc.
+const (
+	nlbAnnotationHealthCheckProtocol           = "service-nlb-healthcheck-protocol"
+	nlbAnnotationHealthCheckPort               = "service-nlb-healthcheck-port"
+	nlbAnnotationHealthCheckPath               = "service-nlb-healthcheck-path"
+	nlbAnnotationHealthCheckInterval           = "service-nlb-healthcheck-interval"
+	nlbAnnotationHealthCheckHealthyThreshold   = "service-nlb-healthcheck-healthy-threshold"
+	nlbAnnotationHealthCheckUnhealthyThreshold = "service-nlb-healthcheck-unhealthy-threshold"
+)
+
+// Target group attribute annotations, and the target group attributes they set.
+const (
+	nlbAnnotationDeregistrationDelay = "service-nlb-deregistration-delay"
+	nlbAnnotationProxyProtocolV2     = "service-nlb-proxy-protocol-v2"
+	nlbAnnotationPreserveClientIP    = "service-nlb-preserve-client-ip"
+
+	attributeDeregistrationDelay = "deregistration_delay.timeout_seconds"
+	attributeProxyProtocolV2     = "proxy_protocol_v2.enabled"
+	attributePreserveClientIP    = "preserve_client_ip.enabled"
+)
+
+// nlbAnnotations are the per-port annotations the controller writes on a Service.
+var nlbAnnotations = []string{
+	nlbAnnotationNLBName,
+	nlbAnnotationNLBHost,
+	nlbAnnotationPort,
+	nlbAnnotationListener,
+	nlbAnnotationTarget,
+}
+
+// portKey identifies a port of a Service: its name, or its index in spec.ports
+// for unnamed ports.
+func portKey(port corev1.ServicePort, idx int) string {
+	if port.Name != "" {
+		return port.Name
+	}
+	return strconv.Itoa(idx)
+}
+
+// allocationKey is the key under which the allocation for one port of a
+// Service is kept in the store.
+func allocationKey(serviceName string, portKey string) string {
+	return serviceName + ":" + portKey
+}
+
+// splitAllocationKey returns the Service and port key of an allocation key.
+func splitAllocationKey(key string) (types.NamespacedName, string) {
+	serviceName, portKey, _ := strings.Cut(key, ":")
+	namespace, name, _ := strings.Cut(serviceName, "/")
+	return types.NamespacedName{Namespace: namespace, Name: name}, portKey
+}
+
+// annotationKey returns the annotation holding the given nlb annotation for a port.
+func annotationKey(annotation string, portKey string) string {
+	return annotation + "." + portKey
+}
+
+// getPortAnnotation returns an nlb annotation of a port. Services annotated
+// before multi-port support carry unsuffixed annotations, which belong to
+// the first port.
+func getPortAnnotation(svc *corev1.Service, annotation string, portKey string, idx int) string {
+	if value, ok := svc.Annotations[annotationKey(annotation, portKey)]; ok {
+		return value
+	}
+	if idx == 0 {
+		return svc.Annotations[annotation]
+	}
+	return ""
+}
+
+// isClassLoadBalancer reports whether the svc is a LoadBalancer Service of
+// loadBalancerClass. Such Services are managed without the service
+// annotation.
+func isClassLoadBalancer(svc *corev1.Service, loadBalancerClass string) bool {
+	return loadBalancerClass != "" &&
+		svc.Spec.Type == corev1.ServiceTypeLoadBalancer &&
+		svc.Spec.LoadBalancerClass != nil && *svc.Spec.LoadBalancerClass == loadBalancerClass
+}
+
+// isManagedService reports whether the controller allocates NLB ports for
+// the svc.
+func isManagedService(svc *corev1.Service, loadBalancerClass string) bool {
+	return svc.Annotations[serviceAnnotation] == "true" || isClassLoadBalancer(svc, loadBalancerClass)
+}
+
+// isTrackedService reports whether the controller manages the svc or
+// allocated ports for it before, which it has to release.
+func isTrackedService(svc *corev1.Service, loadBalancerClass string) bool {
+	return isManagedService(svc, loadBalancerClass) ||
+		hasNLBAnnotations(svc) ||
+		controllerutil.ContainsFinalizer(svc, serviceFinalizer)
+}
+
+// isPaused reports whether the svc is paused by the paused annotation.
+func isPaused(svc *corev1.Service) bool {
+	return svc.Annotations[nlbAnnotationPaused] == "true"
+}
+
+// hasNodePorts reports whether every port of the svc has a NodePort, which
+// instance targets forward to. LoadBalancer Services may be created without
+// them.
+func hasNodePorts(svc *corev1.Service) bool {
+	for _, port := range svc.Spec.Ports {
+		if port.NodePort == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// loadBalancerStatus returns the status of the svc with one ingress per NLB
+// host the ports of the svc are allocated on, listing the NLB ports.
+func loadBalancerStatus(svc *corev1.Service) corev1.LoadBalancerStatus {
+	var status corev1.LoadBalancerStatus
+	hosts := map[string]int{}
+	for idx, port := range svc.Spec.Ports {
+		key := portKey(port, idx)
+		host := getPortAnnotation(svc, nlbAnnotationNLBHost, key, idx)
+		nlbPort, err := strconv.Atoi(getPortAnnotation(svc, nlbAnnotationPort, key, idx))
+		if host == "" || err != nil {
+			continue
+		}
+		i, ok := hosts[host]
+		if !ok {
+			i = len(status.Ingress)
+			hosts[host] = i
+			status.Ingress = append(status.Ingress, corev1.LoadBalancerIngress{Hostname: host})
+		}
+		status.Ingress[i].Ports = append(status.Ingress[i].Ports, corev1.PortStatus{
+			Port:     int32(nlbPort),
+			Protocol: port.Protocol,
+		})
+	}
+	return status
+}
+
+// setPortAnnotations writes the nlb annotations of a port. The first port
+// also keeps the unsuffixed annotations, which consumers of single-port
+// Services read.
+func setPortAnnotations(svc *corev1.Service, portKey string, idx int, values map[string]string) {
+	for _, annotation := range nlbAnnotations {
+		svc.Annotations[annotationKey(annotation, portKey)] = values[annotation]
+		if idx == 0 {
+			svc.Annotations[annotation] = values[annotation]
+		}
+	}
+}
+
+// removePortAnnotations deletes the nlb annotations of a port.
+func removePortAnnotations(svc *corev1.Service, portKey string) {
+	for _, annotation := range nlbAnnotations {
+		delete(svc.Annotations, annotationKey(annotation, portKey))
+	}
+}
+
+// hasNLBAnnotations reports whether any port of the svc has nlb annotations.
+func hasNLBAnnotations(svc *corev1.Service) bool {
+	for key := range svc.Annotations {
+		if isNLBAnnotation(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeNLBAnnotations deletes the nlb annotations of every port, including
+// ports no longer in the spec of the svc.
+func removeNLBAnnotations(svc *corev1.Service) {
+	for key := range svc.Annotations {
+		if isNLBAnnotation(key) {
+			delete(svc.Annotations, key)
+		}
+	}
+}
+
+// isNLBAnnotation reports whether key is an nlb annotation of a port.
+func isNLBAnnotation(key string) bool {
+	for _, annotation := range nlbAnnotations {
+		if key == annotation || strings.HasPrefix(key, annotation+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// healthCheck reads the health check annotations of a svc, rejecting
+// combinations AWS does not accept for network load balancers.
+func healthCheck(svc *corev1.Service) (aws.HealthCheck, error) {
+	var hc aws.HealthCheck
+	hc.Protocol, _ = annotation(svc, nlbAnnotationHealthCheckProtocol)
+	hc.Port, _ = annotation(svc, nlbAnnotationHealthCheckPort)
+	hc.Path, _ = annotation(svc, nlbAnnotationHealthCheckPath)
+	switch hc.Protocol {
+	case "", "TCP", "HTTP", "HTTPS":
+	default:
+		return hc, fmt.Errorf("%s: unsupported protocol %q", nlbAnnotationHealthCheckProtocol, hc.Protocol)
+	}
+	if hc.Port != "" && hc.Port != "traffic-port" {
+		if port, err := strconv.Atoi(hc.Port); err != nil || port < 1 || port > 65535 {
+			return hc, fmt.Errorf("%s: %q is not a port or traffic-port", nlbAnnotationHealthCheckPort, hc.Port)
+		}
+	}
+	if hc.Path != "" {
+		if hc.Protocol != "HTTP" && hc.Protocol != "HTTPS" {
+			return hc, fmt.Errorf("%s: only HTTP and HTTPS health checks have a path", nlbAnnotationHealthCheckPath)
+		}
+		if !strings.HasPrefix(hc.Path, "/") || len(hc.Path) > 1024 {
+			return hc, fmt.Errorf("%s: %q is not an absolute path of at most 1024 characters", nlbAnnotationHealthCheckPath, hc.Path)
+		}
+	}
+
+	var err error
+	if hc.IntervalSeconds, err = int32Annotation(svc, nlbAnnotationHealthCheckInterval, 5, 300); err != nil {
+		return hc, err
+	}
+	if hc.HealthyThreshold, err = int32Annotation(svc, nlbAnnotationHealthCheckHealthyThreshold, 2, 10); err != nil {
+		return hc, err
+	}
+	if hc.UnhealthyThreshold, err = int32Annotation(svc, nlbAnnotationHealthCheckUnhealthyThreshold, 2, 10); err != nil {
+		return hc, err
+	}
+	return hc, nil
+}
+
+// annotation returns the value of a native annotation of the svc. In AWS
+// annotation compatibility mode the matching AWS annotation is read when the
+// native one is not set.
+func annotation(svc *corev1.Service, name string) (string, bool) {
+	if value, ok := svc.Annotations[name]; ok {
+		return value, true
+	}
+	if compat, ok := awsAnnotations[name]; ok && awsAnnotationCompat {
+		if value, ok := svc.Annotations[compat.name]; ok {
+			return compat.translate(value), true
+		}
+	}
+	return "", false
+}
+
+// int32Annotation parses an integer annotation within min-max. A missing
+// annotation is 0.
+func int32Annotation(svc *corev1.Service, name string, min int64, max int64) (int32, error) {
+	value, ok := annotation(svc, name)
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s: %q is not an integer within %d-%d", name, value, min, max)
+	}
+	return int32(n), nil
+}
+
+// targetGroupAttributes reads the target group attribute annotations of a svc.
+// Attributes without an annotation are not included.
+func targetGroupAttributes(svc *corev1.Service) (map[string]string, error) {
+	attributes := map[string]string{}
+	if value, ok := annotation(svc, nlbAnnotationDeregistrationDelay); ok {
+		if delay, err := strconv.Atoi(value); err != nil || delay < 0 || delay > 3600 {
+			return nil, fmt.Errorf("%s: %q is not a number of seconds within 0-3600", nlbAnnotationDeregistrationDelay, value)
+		}
+		attributes[attributeDeregistrationDelay] = value
+	}
+	for name, attribute := range map[string]string{
+		nlbAnnotationProxyProtocolV2:  attributeProxyProtocolV2,
+		nlbAnnotationPreserveClientIP: attributePreserveClientIP,
+	} {
+		value, ok := annotation(svc, name)
+		if !ok {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is not a boolean", name, value)
+		}
+		attributes[attribute] = strconv.FormatBool(enabled)
+	}
+	return attributes, nil
+}
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRemoveNLBAnnotations(t *testing.T) {
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		serviceAnnotation:                            "true",
+		nlbAnnotationNLBName:                         "shared",
+		annotationKey(nlbAnnotationNLBName, "http"):  "shared",
+		annotationKey(nlbAnnotationPort, "http"):     "9000",
+		annotationKey(nlbAnnotationListener, "gone"): "arn",
+		nlbAnnotationProtocol:                        "TCP",
+	}}}
+	if !hasNLBAnnotations(svc) {
+		t.Fatalf("hasNLBAnnotations() = false, want true")
+	}
+	removeNLBAnnotations(svc)
+	if hasNLBAnnotations(svc) {
+		t.Errorf("hasNLBAnnotations() = true after removeNLBAnnotations, annotations %v", svc.Annotations)
+	}
+	for _, kept := range []string{serviceAnnotation, nlbAnnotationProtocol} {
+		if _, ok := svc.Annotations[kept]; !ok {
+			t.Errorf("removeNLBAnnotations() removed %s", kept)
+		}
+	}
+}
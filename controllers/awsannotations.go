@@ -0,0 +1,55 @@
+package controllers
+
+import "strings"
+
+// awsAnnotationPrefix is the prefix of the Service annotations of the in-tree
+// AWS cloud provider and the AWS Load Balancer Controller.
+const awsAnnotationPrefix = "service.beta.kubernetes.io/aws-load-balancer-"
+
+// awsAnnotation is an AWS annotation read in place of a native one, and how
+// its value translates to the value of the native annotation.
+type awsAnnotation struct {
+	name      string
+	translate func(string) string
+}
+
+func sameValue(value string) string { return value }
+
+// awsAnnotations are the AWS annotations understood in compatibility mode,
+// by the native annotation they stand in for.
+var awsAnnotations = map[string]awsAnnotation{
+	nlbAnnotationHealthCheckProtocol:           {awsAnnotationPrefix + "healthcheck-protocol", strings.ToUpper},
+	nlbAnnotationHealthCheckPort:               {awsAnnotationPrefix + "healthcheck-port", sameValue},
+	nlbAnnotationHealthCheckPath:               {awsAnnotationPrefix + "healthcheck-path", sameValue},
+	nlbAnnotationHealthCheckInterval:           {awsAnnotationPrefix + "healthcheck-interval", sameValue},
+	nlbAnnotationHealthCheckHealthyThreshold:   {awsAnnotationPrefix + "healthcheck-healthy-threshold", sameValue},
+	nlbAnnotationHealthCheckUnhealthyThreshold: {awsAnnotationPrefix + "healthcheck-unhealthy-threshold", sameValue},
+	// "*" enables proxy protocol on every port, the only value AWS supports
+	nlbAnnotationProxyProtocolV2: {awsAnnotationPrefix + "proxy-protocol", func(value string) string {
+		if value == "*" {
+			return "true"
+		}
+		return value
+	}},
+	nlbAnnotationCertificate: {awsAnnotationPrefix + "ssl-cert", sameValue},
+	// ssl is the backend protocol of TLS target groups
+	nlbAnnotationProtocol: {awsAnnotationPrefix + "backend-protocol", func(value string) string {
+		if strings.EqualFold(value, "ssl") {
+			return "TLS"
+		}
+		return strings.ToUpper(value)
+	}},
+}
+
+// awsAnnotationCompat enables awsAnnotations. It is set before the controllers
+// start and not changed afterwards.
+var awsAnnotationCompat bool
+
+// EnableAWSAnnotations makes the controllers read a subset of the
+// service.beta.kubernetes.io/aws-load-balancer-* annotations for Services that
+// do not set the native annotation: the health check settings,
+// proxy-protocol, ssl-cert and backend-protocol. It must be called before
+// the controllers start.
+func EnableAWSAnnotations() {
+	awsAnnotationCompat = true
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAnnotationReadsAWSAnnotations(t *testing.T) {
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		awsAnnotationPrefix + "healthcheck-protocol": "http",
+		awsAnnotationPrefix + "proxy-protocol":       "*",
+		awsAnnotationPrefix + "backend-protocol":     "ssl",
+		awsAnnotationPrefix + "healthcheck-path":     "/aws",
+		nlbAnnotationHealthCheckPath:                 "/native",
+	}}}
+
+	awsAnnotationCompat = false
+	if _, ok := annotation(svc, nlbAnnotationHealthCheckProtocol); ok {
+		t.Errorf("annotation() read an AWS annotation without compatibility mode")
+	}
+
+	awsAnnotationCompat = true
+	defer func() { awsAnnotationCompat = false }()
+	for name, want := range map[string]string{
+		nlbAnnotationHealthCheckProtocol: "HTTP",
+		nlbAnnotationProxyProtocolV2:     "true",
+		nlbAnnotationProtocol:            "TLS",
+		nlbAnnotationHealthCheckPath:     "/native",
+	} {
+		if got, _ := annotation(svc, name); got != want {
+			t.Errorf("annotation(%s) = %q, want %q", name, got, want)
+		}
+	}
+	if _, ok := annotation(svc, nlbAnnotationCertificate); ok {
+		t.Errorf("annotation(%s) is set without an ssl-cert annotation", nlbAnnotationCertificate)
+	}
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	"github.com/aws/smithy-go"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// errorClass groups reconcile errors that are retried on the same schedule.
+type errorClass string
+
+const (
+	errorClassThrottled errorClass = "throttled"
+	errorClassNotFound  errorClass = "notfound"
+	errorClassConflict  errorClass = "conflict"
+	errorClassTransient errorClass = "transient"
+	errorClassExhausted errorClass = "exhausted"
+	// errorClassTerminal errors are not fixed by retrying, such as a listener
+	// to adopt that another owner tagged. Their reconciles are not retried
+	// until the svc changes.
+	errorClassTerminal errorClass = "terminal"
+)
+
+// backoffSchedule is an exponential backoff between Base and Max.
+type backoffSchedule struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// backoffSchedules are the schedules of each error class. Throttling backs off
+// hardest so that an account's API quota can recover; resources not found
+// right after they were created usually show up within seconds, and conflicts
+// with another writer resolve as soon as it is done. Ports only free up when
+// services go away or NLBs are added, so exhausted port pools and quotas are
+// retried slowly.
+var backoffSchedules = map[errorClass]backoffSchedule{
+	errorClassThrottled: {Base: 10 * time.Second, Max: 10 * time.Minute},
+	errorClassNotFound:  {Base: 2 * time.Second, Max: 2 * time.Minute},
+	errorClassConflict:  {Base: 500 * time.Millisecond, Max: 30 * time.Second},
+	errorClassTransient: {Base: time.Second, Max: 5 * time.Minute},
+	errorClassExhausted: {Base: time.Minute, Max: 30 * time.Minute},
+}
+
+// classifyError returns the class of a reconcile error, from the classes the
+// aws and store packages wrap their errors in.
+func classifyError(err error) errorClass {
+	switch {
+	case errors.Is(err, aws.ErrNotOwned):
+		return errorClassTerminal
+	case errors.Is(err, aws.ErrThrottled), errors.Is(err, store.ErrThrottled),
+		apierrors.IsTooManyRequests(err), aws.IsThrottlingError(err):
+		return errorClassThrottled
+	case errors.Is(err, aws.ErrNotFound), errors.Is(err, store.ErrNotFound), apierrors.IsNotFound(err):
+		return errorClassNotFound
+	case errors.Is(err, aws.ErrQuotaExceeded), errors.Is(err, store.ErrQuotaExceeded):
+		return errorClassExhausted
+	case errors.Is(err, aws.ErrConflict), errors.Is(err, store.ErrConflict), apierrors.IsConflict(err):
+		return errorClassConflict
+	}
+	// API errors that did not go through an aws.Client
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code := apiErr.ErrorCode()
+		if strings.HasSuffix(code, "NotFound") || strings.HasSuffix(code, "NotFoundException") {
+			return errorClassNotFound
+		}
+	}
+	return errorClassTransient
+}
+
+// requeueBackoff is the rate limiter of the service controller's workqueue.
+// Each failure of a request doubles its delay, on the schedule of the class of
+// its latest error, and a successful reconcile resets it.
+type requeueBackoff struct {
+	mu       sync.Mutex
+	failures map[types.NamespacedName]int
+	errs     map[types.NamespacedName]error
+}
+
+var _ workqueue.RateLimiter = &requeueBackoff{}
+
+// observe records the error a reconcile of req failed with. The workqueue asks
+// When for the delay right after Reconcile returns.
+func (b *requeueBackoff) observe(req types.NamespacedName, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.errs == nil {
+		b.errs = map[types.NamespacedName]error{}
+	}
+	b.errs[req] = err
+}
+
+// When returns the delay before the next attempt of a request, based on the
+// error it last failed with.
+func (b *requeueBackoff) When(item interface{}) time.Duration {
+	req := item.(reconcile.Request).NamespacedName
+	b.mu.Lock()
+	err := b.errs[req]
+	b.mu.Unlock()
+	return b.next(req, err)
+}
+
+// Forget resets the backoff of a request.
+func (b *requeueBackoff) Forget(item interface{}) {
+	b.forget(item.(reconcile.Request).NamespacedName)
+}
+
+// NumRequeues returns the number of failures of a request since it last
+// succeeded.
+func (b *requeueBackoff) NumRequeues(item interface{}) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures[item.(reconcile.Request).NamespacedName]
+}
+
+// next returns the delay before the next attempt of a request that failed
+// with err. The delay is jittered by up to half so that requests failing
+// together do not retry together.
+func (b *requeueBackoff) next(req types.NamespacedName, err error) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures == nil {
+		b.failures = map[types.NamespacedName]int{}
+	}
+	failures := b.failures[req]
+	b.failures[req] = failures + 1
+
+	schedule := backoffSchedules[classifyError(err)]
+	delay := schedule.Max
+	if failures < 32 {
+		if d := schedule.Base << failures; d > 0 && d < schedule.Max {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// forget resets the backoff of a request.
+func (b *requeueBackoff) forget(req types.NamespacedName) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failures, req)
+	delete(b.errs, req)
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	"github.com/aws/smithy-go"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestClassifyError(t *testing.T) {
+	svc := schema.GroupResource{Resource: "services"}
+	tests := []struct {
+		name string
+		err  error
+		want errorClass
+	}{
+		{"kubernetes throttling", apierrors.NewTooManyRequests("slow down", 1), errorClassThrottled},
+		{"aws throttling", &smithy.GenericAPIError{Code: "Throttling"}, errorClassThrottled},
+		{"kubernetes not found", apierrors.NewNotFound(svc, "web"), errorClassNotFound},
+		{"aws not found", &smithy.GenericAPIError{Code: "TargetGroupNotFound"}, errorClassNotFound},
+		{"aws not found exception", &smithy.GenericAPIError{Code: "ResourceNotFoundException"}, errorClassNotFound},
+		{"wrapped aws not found", fmt.Errorf("check listener: %w", &smithy.GenericAPIError{Code: "ListenerNotFound"}), errorClassNotFound},
+		{"aws other", &smithy.GenericAPIError{Code: "ValidationError"}, errorClassTransient},
+		{"no vacancy", store.ErrNoVacancy, errorClassExhausted},
+		{"listener quota", fmt.Errorf("%w: every nlb with free ports has 50 listeners", store.ErrListenerQuota), errorClassExhausted},
+		{"store throttled", fmt.Errorf("store: unable to update configmap: %w", store.ErrThrottled), errorClassThrottled},
+		{"aws classified not found", fmt.Errorf("%w: nlb web", aws.ErrNotFound), errorClassNotFound},
+		{"store not found", store.ErrNotFound, errorClassNotFound},
+		{"aws quota", aws.ErrQuotaExceeded, errorClassExhausted},
+		{"aws conflict", aws.ErrConflict, errorClassConflict},
+		{"store conflict", fmt.Errorf("%w: port 9000 of nlb web is claimed elsewhere", store.ErrUnavailable), errorClassConflict},
+		{"kubernetes conflict", apierrors.NewConflict(svc, "web", errors.New("modified")), errorClassConflict},
+		{"not owned", fmt.Errorf("%w: listener is tagged elsewhere", aws.ErrNotOwned), errorClassTerminal},
+		{"plain", errors.New("boom"), errorClassTransient},
+	}
+	for _, tt := range tests {
+		if got := classifyError(tt.err); got != tt.want {
+			t.Errorf("%s: classifyError() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRequeueBackoffNext(t *testing.T) {
+	var b requeueBackoff
+	req := types.NamespacedName{Namespace: "default", Name: "web"}
+	err := errors.New("boom")
+	schedule := backoffSchedules[errorClassTransient]
+
+	for failures := 0; failures < 40; failures++ {
+		delay := schedule.Max
+		if failures < 32 && schedule.Base<<failures < schedule.Max {
+			delay = schedule.Base << failures
+		}
+		got := b.next(req, err)
+		if got < delay/2 || got > delay {
+			t.Fatalf("failure %d: next() = %s, want within [%s, %s]", failures, got, delay/2, delay)
+		}
+	}
+
+	b.forget(req)
+	if got := b.next(req, err); got > schedule.Base {
+		t.Errorf("next() after forget = %s, want at most %s", got, schedule.Base)
+	}
+}
+
+func TestRequeueBackoffUsesClassOfLastError(t *testing.T) {
+	var b requeueBackoff
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+
+	b.observe(req.NamespacedName, &smithy.GenericAPIError{Code: "Throttling"})
+	throttled := backoffSchedules[errorClassThrottled]
+	if got := b.When(req); got < throttled.Base/2 || got > throttled.Base {
+		t.Errorf("When() = %s, want within [%s, %s]", got, throttled.Base/2, throttled.Base)
+	}
+	if got := b.NumRequeues(req); got != 1 {
+		t.Errorf("NumRequeues() = %d, want 1", got)
+	}
+
+	b.Forget(req)
+	if got := b.NumRequeues(req); got != 0 {
+		t.Errorf("NumRequeues() after Forget = %d, want 0", got)
+	}
+	if got := b.When(req); got > time.Second {
+		t.Errorf("When() after Forget = %s, want the transient base delay", got)
+	}
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	nlbv1alpha1 "github.com/chinmayrelkar/aws-nlb-controller/api/v1alpha1"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// poolBinding is the set of NLBPools the Services of a namespace allocate
+// ports from.
+type poolBinding struct {
+	// bound are the pools whose namespaceSelector selects the namespace
+	bound map[string]bool
+	// boundNLBs are the NLBs of bound
+	boundNLBs map[string]bool
+	// reservedNLBs are the NLBs of every pool with a namespaceSelector
+	reservedNLBs map[string]bool
+}
+
+// namespaceBinding returns the pools bound to a namespace.
+func namespaceBinding(ctx context.Context, c client.Reader, namespace string) (poolBinding, error) {
+	binding := poolBinding{bound: map[string]bool{}, boundNLBs: map[string]bool{}, reservedNLBs: map[string]bool{}}
+	var pools nlbv1alpha1.NLBPoolList
+	if err := c.List(ctx, &pools); err != nil {
+		return binding, err
+	}
+	var ns *corev1.Namespace
+	for i := range pools.Items {
+		pool := &pools.Items[i]
+		if pool.Spec.NamespaceSelector == nil {
+			continue
+		}
+		binding.reservedNLBs[pool.LoadBalancerName()] = true
+		if ns == nil {
+			ns = &corev1.Namespace{}
+			if err := c.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+				return binding, err
+			}
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pool.Spec.NamespaceSelector)
+		if err != nil {
+			return binding, fmt.Errorf("invalid namespaceSelector of nlbpool %s: %w", pool.Name, err)
+		}
+		if selector.Matches(labels.Set(ns.Labels)) {
+			binding.bound[pool.Name] = true
+			binding.boundNLBs[pool.LoadBalancerName()] = true
+		}
+	}
+	return binding, nil
+}
+
+// allowsNLB reports whether the namespace may allocate ports on an NLB: only
+// on the NLBs of the pools bound to it if there are any, and otherwise on
+// every NLB not reserved for other namespaces.
+func (b poolBinding) allowsNLB(nlb string) bool {
+	if len(b.bound) > 0 {
+		return b.boundNLBs[nlb]
+	}
+	return !b.reservedNLBs[nlb]
+}
+
+// allowsPool reports whether the namespace may allocate ports on the NLB of a
+// pool.
+func (b poolBinding) allowsPool(pool *nlbv1alpha1.NLBPool) bool {
+	if len(b.bound) > 0 {
+		return b.bound[pool.Name]
+	}
+	return pool.Spec.NamespaceSelector == nil
+}
+
+// filter is the store filter of the NLBs the namespace may allocate on.
+func (b poolBinding) filter() store.NLBFilter {
+	if len(b.bound) == 0 && len(b.reservedNLBs) == 0 {
+		return nil
+	}
+	return b.allowsNLB
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"testing"
+
+	nlbv1alpha1 "github.com/chinmayrelkar/aws-nlb-controller/api/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPoolBinding(t *testing.T) {
+	unbound := poolBinding{
+		bound:        map[string]bool{},
+		boundNLBs:    map[string]bool{},
+		reservedNLBs: map[string]bool{"public": true},
+	}
+	if unbound.allowsNLB("public") || !unbound.allowsNLB("shared") {
+		t.Errorf("unbound namespace: allowsNLB(public), allowsNLB(shared) = %v, %v, want false, true",
+			unbound.allowsNLB("public"), unbound.allowsNLB("shared"))
+	}
+	reserved := &nlbv1alpha1.NLBPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "public"},
+		Spec:       nlbv1alpha1.NLBPoolSpec{NamespaceSelector: &metav1.LabelSelector{}},
+	}
+	if unbound.allowsPool(reserved) {
+		t.Errorf("unbound namespace: allowsPool(public) = true, want false")
+	}
+
+	bound := poolBinding{
+		bound:        map[string]bool{"public": true},
+		boundNLBs:    map[string]bool{"public": true},
+		reservedNLBs: map[string]bool{"public": true},
+	}
+	if !bound.allowsNLB("public") || bound.allowsNLB("shared") {
+		t.Errorf("bound namespace: allowsNLB(public), allowsNLB(shared) = %v, %v, want true, false",
+			bound.allowsNLB("public"), bound.allowsNLB("shared"))
+	}
+	if !bound.allowsPool(reserved) {
+		t.Errorf("bound namespace: allowsPool(public) = false, want true")
+	}
+
+	if (poolBinding{}).filter() != nil {
+		t.Errorf("filter() without namespace selectors is not nil")
+	}
+}
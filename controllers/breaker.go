@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// CircuitBreakerEvents returns the aws.Options.OnCircuitBreaker func that
+// records the circuit breaker opening and closing as Events on the controller
+// Pod namespace/name, so that `kubectl get events` in the namespace of the
+// controller shows that it stopped changing AWS. Without a Pod the changes are
+// only logged.
+func CircuitBreakerEvents(recorder record.EventRecorder, namespace string, name string) func(open bool, err error) {
+	logger := ctrl.Log.WithName("aws")
+	pod := &corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: namespace, Name: name}
+	return func(open bool, err error) {
+		if open {
+			logger.Error(err, "elb api keeps failing. Stopped changing listeners and target groups")
+		} else {
+			logger.Info("elb api works again. Resumed changing listeners and target groups")
+		}
+		if namespace == "" || name == "" {
+			return
+		}
+		if open {
+			recorder.Eventf(pod, corev1.EventTypeWarning, "CircuitBreakerOpen",
+				"the elb api keeps failing: %s. Stopped changing listeners and target groups", err)
+			return
+		}
+		recorder.Event(pod, corev1.EventTypeNormal, "CircuitBreakerClosed",
+			"the elb api works again. Resumed changing listeners and target groups")
+	}
+}
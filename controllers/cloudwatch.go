@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// metricDataPerCall is the number of metrics published per PutMetricData call
+const metricDataPerCall = 20
+
+// CloudWatchAPI is the part of the CloudWatch client the CloudWatchPublisher
+// uses.
+type CloudWatchAPI interface {
+	PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+// CloudWatchPublisher periodically publishes the port utilization of every
+// NLB of Store as CloudWatch custom metrics, so that alarms and dashboards
+// can be built in AWS without scraping the controller. Every NLB gets
+// AllocatedPorts, FreePorts and PortUtilization metrics with Cluster and NLB
+// dimensions, and the totals over all NLBs are published with the Cluster
+// dimension only.
+type CloudWatchPublisher struct {
+	CloudWatch CloudWatchAPI
+	Store      store.Store
+
+	// Namespace is the CloudWatch namespace of the metrics.
+	Namespace string
+	// ClusterID is the value of the Cluster dimension.
+	ClusterID string
+	Period    time.Duration
+
+	// StoreReady, if set, is closed once Store has been loaded.
+	StoreReady <-chan struct{}
+}
+
+// Start publishes the metrics every Period until ctx is done. Only the
+// leader publishes, so that replicas do not publish the same metrics.
+func (p *CloudWatchPublisher) Start(ctx context.Context) error {
+	if p.StoreReady != nil {
+		select {
+		case <-p.StoreReady:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	ticker := time.NewTicker(p.Period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.Publish(ctx); err != nil {
+				log.FromContext(ctx).Error(err, "unable to publish cloudwatch metrics")
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Publish publishes the current port utilization of the NLBs once.
+func (p *CloudWatchPublisher) Publish(ctx context.Context) error {
+	data := p.metricData(time.Now())
+	for len(data) > 0 {
+		n := metricDataPerCall
+		if n > len(data) {
+			n = len(data)
+		}
+		_, err := p.CloudWatch.PutMetricData(ctx, &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(p.Namespace),
+			MetricData: data[:n],
+		})
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// metricData returns the metrics of every NLB and their totals at now.
+func (p *CloudWatchPublisher) metricData(now time.Time) []cwtypes.MetricDatum {
+	cluster := cwtypes.Dimension{Name: aws.String("Cluster"), Value: aws.String(p.ClusterID)}
+	var data []cwtypes.MetricDatum
+	totalAllocated, totalFree := 0, 0
+	nlbs := p.Store.ListNLBs()
+	sort.Strings(nlbs)
+	for _, nlb := range nlbs {
+		allocated, free := p.Store.PoolUsage(nlb)
+		totalAllocated += allocated
+		totalFree += free
+		data = append(data, utilizationData(now, allocated, free,
+			cluster, cwtypes.Dimension{Name: aws.String("NLB"), Value: aws.String(nlb)})...)
+	}
+	return append(data, utilizationData(now, totalAllocated, totalFree, cluster)...)
+}
+
+// utilizationData returns the metrics of allocated and free ports with the
+// dimensions.
+func utilizationData(now time.Time, allocated int, free int, dimensions ...cwtypes.Dimension) []cwtypes.MetricDatum {
+	utilization := 0.0
+	if allocated+free > 0 {
+		utilization = 100 * float64(allocated) / float64(allocated+free)
+	}
+	datum := func(name string, value float64, unit cwtypes.StandardUnit) cwtypes.MetricDatum {
+		return cwtypes.MetricDatum{
+			MetricName: aws.String(name),
+			Dimensions: dimensions,
+			Timestamp:  aws.Time(now),
+			Value:      aws.Float64(value),
+			Unit:       unit,
+		}
+	}
+	return []cwtypes.MetricDatum{
+		datum("AllocatedPorts", float64(allocated), cwtypes.StandardUnitCount),
+		datum("FreePorts", float64(free), cwtypes.StandardUnitCount),
+		datum("PortUtilization", utilization, cwtypes.StandardUnitPercent),
+	}
+}
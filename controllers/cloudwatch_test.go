@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+)
+
+type fakeCloudWatch struct {
+	inputs []*cloudwatch.PutMetricDataInput
+}
+
+func (f *fakeCloudWatch) PutMetricData(_ context.Context, params *cloudwatch.PutMetricDataInput, _ ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	f.inputs = append(f.inputs, params)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func TestCloudWatchPublisher(t *testing.T) {
+	s := store.New(
+		store.NLB{Name: "public", Host: "public.elb.amazonaws.com", PortRange: store.PortRange{Min: 10000, Max: 10003}},
+		store.NLB{Name: "internal", Host: "internal.elb.amazonaws.com", PortRange: store.PortRange{Min: 20000, Max: 20000}},
+	)
+	ctx := context.Background()
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "public", 10000, "default/web:http", "listener", "target"); err != nil {
+		t.Fatal(err)
+	}
+
+	cw := &fakeCloudWatch{}
+	p := &CloudWatchPublisher{CloudWatch: cw, Store: s, Namespace: "NLBController", ClusterID: "prod"}
+	if err := p.Publish(ctx); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if len(cw.inputs) != 1 {
+		t.Fatalf("PutMetricData calls = %d, want 1", len(cw.inputs))
+	}
+	if got := aws.ToString(cw.inputs[0].Namespace); got != "NLBController" {
+		t.Errorf("Namespace = %q, want NLBController", got)
+	}
+
+	type key struct{ metric, nlb string }
+	got := map[key]float64{}
+	for _, datum := range cw.inputs[0].MetricData {
+		k := key{metric: aws.ToString(datum.MetricName)}
+		for _, dimension := range datum.Dimensions {
+			switch aws.ToString(dimension.Name) {
+			case "NLB":
+				k.nlb = aws.ToString(dimension.Value)
+			case "Cluster":
+				if v := aws.ToString(dimension.Value); v != "prod" {
+					t.Errorf("Cluster dimension = %q, want prod", v)
+				}
+			}
+		}
+		got[k] = aws.ToFloat64(datum.Value)
+	}
+	want := map[key]float64{
+		{"AllocatedPorts", "public"}:    1,
+		{"FreePorts", "public"}:         3,
+		{"PortUtilization", "public"}:   25,
+		{"AllocatedPorts", "internal"}:  0,
+		{"FreePorts", "internal"}:       1,
+		{"PortUtilization", "internal"}: 0,
+		{"AllocatedPorts", ""}:          1,
+		{"FreePorts", ""}:               4,
+		{"PortUtilization", ""}:         20,
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d metrics, want %d: %v", len(got), len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s of nlb %q = %v, want %v", k.metric, k.nlb, got[k], v)
+		}
+	}
+}
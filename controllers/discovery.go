@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// NLBDiscoverer adds the NLBs carrying a tag to the store, and refreshes them
+// periodically so that tagged NLBs are picked up and untagged NLBs dropped
+// without a restart.
+type NLBDiscoverer struct {
+	AwsClient aws.Client
+	Store     store.Store
+	TagKey    string
+	TagValue  string
+	Interval  time.Duration
+
+	// StoreReady, if set, is closed once Store has been loaded.
+	StoreReady <-chan struct{}
+
+	// discovered are the NLBs added to the store by the discoverer, the only
+	// ones it removes again
+	discovered map[string]bool
+}
+
+// Start refreshes the discovered NLBs every Interval until ctx is done.
+func (d *NLBDiscoverer) Start(ctx context.Context) error {
+	if d.StoreReady != nil {
+		select {
+		case <-d.StoreReady:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.Refresh(ctx); err != nil {
+				log.FromContext(ctx).Error(err, "unable to discover nlbs", "tag", d.TagKey+"="+d.TagValue)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Refresh adds the NLBs carrying the tag to Store, and removes the NLBs it
+// added before that no longer carry it. NLBs Store already manages from
+// another source, such as NLB_LIST or an NLBPool, are left to that source.
+func (d *NLBDiscoverer) Refresh(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithValues("tag", d.TagKey+"="+d.TagValue)
+	found, err := d.AwsClient.DiscoverNLBs(ctx, d.TagKey, d.TagValue)
+	if err != nil {
+		return err
+	}
+
+	if d.discovered == nil {
+		d.discovered = map[string]bool{}
+	}
+	managed := map[string]bool{}
+	for _, nlb := range d.Store.ListNLBs() {
+		managed[nlb] = true
+	}
+	current := map[string]bool{}
+	for _, nlb := range found {
+		current[nlb.Name] = true
+		if managed[nlb.Name] && !d.discovered[nlb.Name] {
+			continue
+		}
+		if !d.discovered[nlb.Name] {
+			logger.Info("nlb discovered", "nlb", nlb.Name)
+		}
+		d.Store.AddNLB(store.NLB{Name: nlb.Name, Host: nlb.DNSName})
+		d.discovered[nlb.Name] = true
+	}
+	for nlb := range d.discovered {
+		if current[nlb] {
+			continue
+		}
+		// an nlb that lost its tag keeps serving its allocations until they
+		// are released
+		if err := d.Store.RemoveNLB(nlb); err != nil {
+			logger.Info("nlb no longer tagged but still in use", "nlb", nlb, "reason", err.Error())
+			continue
+		}
+		logger.Info("nlb no longer tagged. Removed", "nlb", nlb)
+		delete(d.discovered, nlb)
+	}
+	return nil
+}
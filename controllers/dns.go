@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// nlbAnnotationDNSName asks for a Route53 CNAME of this name pointing at
+	// the NLB of the svc.
+	nlbAnnotationDNSName = "service-nlb-dns-name"
+	// nlbAnnotationDNSRecord is the CNAME the controller created for the svc,
+	// which is deleted when the svc or its dns-name annotation goes away.
+	nlbAnnotationDNSRecord = "service-nlb-dns-record"
+
+	// external-dns creates records of the hostname annotation pointing at
+	// the target annotation.
+	externalDNSAnnotationHostname = "external-dns.alpha.kubernetes.io/hostname"
+	externalDNSAnnotationTarget   = "external-dns.alpha.kubernetes.io/target"
+)
+
+// reconcileDNS points the CNAME of the dns-name annotation of the svc at the
+// NLB host of its first port, and deletes the CNAME it created before if the
+// annotation changed or was removed. The annotations of the svc are updated
+// but not saved.
+func (r *ServiceReconciler) reconcileDNS(ctx context.Context, svc *corev1.Service, serviceName string) error {
+	if !r.ManageDNS {
+		return nil
+	}
+	logger := log.FromContext(ctx)
+	wanted := svc.Annotations[nlbAnnotationDNSName]
+	if current := svc.Annotations[nlbAnnotationDNSRecord]; current != "" && current != wanted {
+		if err := r.deleteDNS(ctx, svc, serviceName); err != nil {
+			return err
+		}
+	}
+	if wanted == "" || len(svc.Spec.Ports) == 0 {
+		return nil
+	}
+	host := getPortAnnotation(svc, nlbAnnotationNLBHost, portKey(svc.Spec.Ports[0], 0), 0)
+	if host == "" {
+		return nil
+	}
+	err := r.AwsClient.EnsureDNSRecord(ctx, wanted, host, serviceName)
+	if errors.Is(err, aws.ErrNotOwned) {
+		if r.Recorder != nil {
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, "DNSRecordTaken",
+				"dns record %s exists and was not created for this svc", wanted)
+		}
+		return nil
+	}
+	if err != nil {
+		logger.Error(err, "unable to update dns record", "name", wanted)
+		return err
+	}
+	svc.Annotations[nlbAnnotationDNSRecord] = wanted
+	return nil
+}
+
+// deleteDNS deletes the CNAME the controller created for the svc.
+func (r *ServiceReconciler) deleteDNS(ctx context.Context, svc *corev1.Service, serviceName string) error {
+	current := svc.Annotations[nlbAnnotationDNSRecord]
+	if !r.ManageDNS || current == "" {
+		return nil
+	}
+	err := r.AwsClient.DeleteDNSRecord(ctx, current, serviceName)
+	if errors.Is(err, aws.ErrNotOwned) {
+		r.refusedDelete(svc, err)
+		err = nil
+	}
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to delete dns record", "name", current)
+		return err
+	}
+	delete(svc.Annotations, nlbAnnotationDNSRecord)
+	return nil
+}
+
+// setExternalDNSAnnotations points the external-dns target of the svc at the
+// NLB host of its first port, and sets the external-dns hostname to its
+// dns-name annotation if it has one. The annotations of the svc are updated
+// but not saved.
+func (r *ServiceReconciler) setExternalDNSAnnotations(svc *corev1.Service) {
+	if !r.ExternalDNSAnnotations || len(svc.Spec.Ports) == 0 {
+		return
+	}
+	host := getPortAnnotation(svc, nlbAnnotationNLBHost, portKey(svc.Spec.Ports[0], 0), 0)
+	if host == "" {
+		return
+	}
+	svc.Annotations[externalDNSAnnotationTarget] = host
+	if name := svc.Annotations[nlbAnnotationDNSName]; name != "" {
+		svc.Annotations[externalDNSAnnotationHostname] = name
+	}
+}
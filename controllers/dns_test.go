@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestReconcileManagesDNSRecord(t *testing.T) {
+	ctx := context.Background()
+	svc := nodePortService(corev1.ServicePort{Name: "http", Port: 80, NodePort: 30080})
+	svc.Annotations[nlbAnnotationDNSName] = "web.example.com"
+	r, awsClient, _ := newTestReconciler(t, svc)
+	r.ManageDNS = true
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+
+	awsClient.SetError("EnsureDNSRecord", errors.New("Throttling"))
+	if _, err := r.Reconcile(ctx, req); err == nil {
+		t.Fatal("Reconcile() error = nil with the dns record failing to update")
+	}
+	awsClient.SetError("EnsureDNSRecord", nil)
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if target, ok := awsClient.DNSRecord("web.example.com"); !ok || target != "shared.elb.amazonaws.com" {
+		t.Errorf("dns record web.example.com = %q, want the host of the nlb", target)
+	}
+
+	// renaming the record moves it
+	if err := r.Get(ctx, req.NamespacedName, svc); err != nil {
+		t.Fatal(err)
+	}
+	if got := svc.Annotations[nlbAnnotationDNSRecord]; got != "web.example.com" {
+		t.Errorf("dns record annotation %q, want web.example.com", got)
+	}
+	svc.Annotations[nlbAnnotationDNSName] = "www.example.com"
+	if err := r.Update(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := awsClient.DNSRecord("web.example.com"); ok {
+		t.Error("dns record web.example.com kept after the svc was renamed")
+	}
+	if _, ok := awsClient.DNSRecord("www.example.com"); !ok {
+		t.Error("dns record www.example.com not created")
+	}
+
+	// the record is deleted with the svc
+	if err := r.Get(ctx, req.NamespacedName, svc); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := awsClient.DNSRecord("www.example.com"); ok {
+		t.Error("dns record www.example.com kept after the svc was deleted")
+	}
+}
+
+func TestReconcileLeavesDNSRecordOfOtherService(t *testing.T) {
+	ctx := context.Background()
+	svc := nodePortService(corev1.ServicePort{Name: "http", Port: 80, NodePort: 30080})
+	svc.Annotations[nlbAnnotationDNSName] = "web.example.com"
+	r, awsClient, _ := newTestReconciler(t, svc)
+	r.ManageDNS = true
+	if err := awsClient.EnsureDNSRecord(ctx, "web.example.com", "other.example.com", "default/other"); err != nil {
+		t.Fatal(err)
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v with the dns name taken, want the svc served without it", err)
+	}
+	if target, _ := awsClient.DNSRecord("web.example.com"); target != "other.example.com" {
+		t.Errorf("dns record web.example.com = %q, want the record of default/other kept", target)
+	}
+	if err := r.Get(ctx, req.NamespacedName, svc); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := svc.Annotations[nlbAnnotationDNSRecord]; ok {
+		t.Errorf("dns record annotation %q for a record of another svc", got)
+	}
+}
+
+func TestReconcileSetsExternalDNSAnnotations(t *testing.T) {
+	ctx := context.Background()
+	svc := nodePortService(corev1.ServicePort{Name: "http", Port: 80, NodePort: 30080})
+	svc.Annotations[nlbAnnotationDNSName] = "web.example.com"
+	r, awsClient, _ := newTestReconciler(t, svc)
+	r.ExternalDNSAnnotations = true
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Get(ctx, req.NamespacedName, svc); err != nil {
+		t.Fatal(err)
+	}
+	if got := svc.Annotations[externalDNSAnnotationTarget]; got != "shared.elb.amazonaws.com" {
+		t.Errorf("external-dns target %q, want the host of the nlb", got)
+	}
+	if got := svc.Annotations[externalDNSAnnotationHostname]; got != "web.example.com" {
+		t.Errorf("external-dns hostname %q, want the dns name of the svc", got)
+	}
+	if calls := awsClient.Calls("EnsureDNSRecord"); len(calls) != 0 {
+		t.Errorf("dns records %v managed with external-dns annotations only", calls)
+	}
+
+	// the annotations go with the allocations once the svc opts out
+	delete(svc.Annotations, serviceAnnotation)
+	if err := r.Update(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, req.NamespacedName, svc); err != nil {
+		t.Fatal(err)
+	}
+	for _, annotation := range []string{externalDNSAnnotationTarget, externalDNSAnnotationHostname} {
+		if got, ok := svc.Annotations[annotation]; ok {
+			t.Errorf("annotation %s = %q kept after the svc opted out", annotation, got)
+		}
+	}
+}
+
+func TestReconcileKeepsExternalDNSHostnameOfUser(t *testing.T) {
+	ctx := context.Background()
+	svc := nodePortService(corev1.ServicePort{Name: "http", Port: 80, NodePort: 30080})
+	svc.Annotations[externalDNSAnnotationHostname] = "web.example.com"
+	r, _, _ := newTestReconciler(t, svc)
+	r.ExternalDNSAnnotations = true
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Get(ctx, req.NamespacedName, svc); err != nil {
+		t.Fatal(err)
+	}
+	delete(svc.Annotations, serviceAnnotation)
+	if err := r.Update(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, req.NamespacedName, svc); err != nil {
+		t.Fatal(err)
+	}
+	if got := svc.Annotations[externalDNSAnnotationHostname]; got != "web.example.com" {
+		t.Errorf("external-dns hostname %q after the svc opted out, want the one set by the user kept", got)
+	}
+}
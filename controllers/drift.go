@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// DriftDetector periodically checks the listener and target group of every
+// allocation against AWS and hands the services whose resources were deleted
+// or modified out of band to the ServiceReconciler, which repairs them.
+type DriftDetector struct {
+	Client    client.Reader
+	Store     store.Store
+	AwsClient aws.Client
+	Period    time.Duration
+
+	// Drifted receives the services to reconcile.
+	Drifted chan<- event.GenericEvent
+
+	// StoreReady, if set, is closed once Store has been loaded.
+	StoreReady <-chan struct{}
+}
+
+// Start checks all allocations every Period until ctx is done.
+func (d *DriftDetector) Start(ctx context.Context) error {
+	if d.StoreReady != nil {
+		select {
+		case <-d.StoreReady:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	ticker := time.NewTicker(d.Period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.check(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (d *DriftDetector) check(ctx context.Context) {
+	logger := log.FromContext(ctx)
+	drifted := map[string]*corev1.Service{}
+	for _, allocation := range d.Store.ListAllocations(ctx) {
+		// sticky allocations have no listener until their svc is recreated
+		if allocation.Sticky {
+			continue
+		}
+		serviceKey, key := splitAllocationKey(allocation.ServiceNamespacedName)
+		if _, ok := drifted[serviceKey.String()]; ok {
+			continue
+		}
+
+		var svc corev1.Service
+		if err := d.Client.Get(ctx, serviceKey, &svc); err != nil {
+			if !apierrors.IsNotFound(err) {
+				logger.Error(err, "unable to fetch svc", "svc", serviceKey.String())
+			}
+			continue
+		}
+		if isPaused(&svc) {
+			continue
+		}
+		for idx, port := range svc.Spec.Ports {
+			if portKey(port, idx) != key {
+				continue
+			}
+			spec := listenerSpec(&svc, allocation.ServiceNamespacedName, allocation.NLB, allocation.Port, int(port.NodePort))
+			err := d.AwsClient.CheckListener(ctx, allocation.ListenerArn, allocation.TargetArn, spec)
+			if errors.Is(err, aws.ErrDrifted) {
+				logger.Info("allocation drifted", "allocation", allocation.ServiceNamespacedName, "reason", err.Error())
+				drifted[serviceKey.String()] = &svc
+			} else if err != nil {
+				logger.Error(err, "unable to check allocation", "allocation", allocation.ServiceNamespacedName)
+			}
+		}
+	}
+
+	for _, svc := range drifted {
+		select {
+		case d.Drifted <- event.GenericEvent{Object: svc}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const targetTypeIP = "ip"
+
+func isIPTargetType(svc *corev1.Service) bool {
+	return svc.Annotations[nlbAnnotationTargetType] == targetTypeIP
+}
+
+// serviceForEndpointSlice maps an EndpointSlice to the svc it belongs to, so
+// that ip target groups follow the pods of the svc.
+func serviceForEndpointSlice(obj client.Object) []reconcile.Request {
+	name := obj.GetLabels()[discoveryv1.LabelServiceName]
+	if name == "" {
+		return nil
+	}
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}},
+	}
+}
+
+// ipTargets returns the ready endpoints of a port of the svc.
+func ipTargets(ctx context.Context, c client.Reader, svc *corev1.Service, port corev1.ServicePort) ([]aws.Target, error) {
+	var slices discoveryv1.EndpointSliceList
+	err := c.List(ctx, &slices,
+		client.InNamespace(svc.Namespace),
+		client.MatchingLabels{discoveryv1.LabelServiceName: svc.Name},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []aws.Target
+	for _, slice := range slices.Items {
+		if slice.AddressType != discoveryv1.AddressTypeIPv4 {
+			continue
+		}
+		targetPort := endpointSlicePort(slice, port)
+		if targetPort == 0 {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			if !endpointReady(endpoint) {
+				continue
+			}
+			for _, address := range endpoint.Addresses {
+				targets = append(targets, aws.Target{ID: address, Port: targetPort})
+			}
+		}
+	}
+	return targets, nil
+}
+
+// endpointNodes returns the names of the nodes hosting a ready endpoint of
+// the svc.
+func endpointNodes(ctx context.Context, c client.Reader, svc *corev1.Service) (map[string]bool, error) {
+	var slices discoveryv1.EndpointSliceList
+	err := c.List(ctx, &slices,
+		client.InNamespace(svc.Namespace),
+		client.MatchingLabels{discoveryv1.LabelServiceName: svc.Name},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := map[string]bool{}
+	for _, slice := range slices.Items {
+		for _, endpoint := range slice.Endpoints {
+			if endpointReady(endpoint) && endpoint.NodeName != nil {
+				nodes[*endpoint.NodeName] = true
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// endpointReady reports whether an endpoint can receive traffic. A nil ready
+// condition means ready.
+func endpointReady(endpoint discoveryv1.Endpoint) bool {
+	return endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready
+}
+
+// endpointSlicePort returns the pod port an EndpointSlice lists for a port of
+// the svc, or 0 if it lists none. EndpointSlice ports carry the name of the
+// svc port they serve.
+func endpointSlicePort(slice discoveryv1.EndpointSlice, port corev1.ServicePort) int {
+	for _, p := range slice.Ports {
+		if p.Port == nil {
+			continue
+		}
+		name := ""
+		if p.Name != nil {
+			name = *p.Name
+		}
+		if name == port.Name {
+			return int(*p.Port)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+)
+
+// pingTimeout is the deadline of the ELB API call of the health checks
+const pingTimeout = 5 * time.Second
+
+// HealthChecks are the healthz and readyz checks of the AWS API and the
+// store. The liveness check only fails on credential errors and on an
+// inconsistent store, which a restart fixes, and not while AWS is merely
+// unreachable. The readiness check fails on any AWS error.
+type HealthChecks struct {
+	AwsClient aws.Client
+	// Store is checked once StoreReady is closed.
+	Store      store.Store
+	StoreReady <-chan struct{}
+
+	// Interval is how long the result of pinging AWS is reused, so that
+	// probes do not add to the API calls of the controller.
+	Interval time.Duration
+
+	mu      sync.Mutex
+	pinged  time.Time
+	pingErr error
+}
+
+// Live is the healthz check.
+func (h *HealthChecks) Live(req *http.Request) error {
+	if err := h.ping(req.Context()); aws.IsCredentialError(err) {
+		return fmt.Errorf("aws credentials: %w", err)
+	}
+	return h.checkStore()
+}
+
+// Ready is the readyz check.
+func (h *HealthChecks) Ready(req *http.Request) error {
+	if err := h.ping(req.Context()); err != nil {
+		return fmt.Errorf("elb api: %w", err)
+	}
+	return h.checkStore()
+}
+
+// ping calls the ELB API, unless it was called less than Interval ago.
+func (h *HealthChecks) ping(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.pinged.IsZero() && time.Since(h.pinged) < h.Interval {
+		return h.pingErr
+	}
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	h.pingErr = h.AwsClient.Ping(ctx)
+	h.pinged = time.Now()
+	return h.pingErr
+}
+
+// checkStore checks the store once it has been loaded. The store is only
+// loaded by the leader, so it is not checked on other replicas.
+func (h *HealthChecks) checkStore() error {
+	if h.StoreReady == nil {
+		return nil
+	}
+	select {
+	case <-h.StoreReady:
+	default:
+		return nil
+	}
+	if err := h.Store.Check(); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+
+	"github.com/aws/smithy-go"
+)
+
+type pingClient struct {
+	aws.Client
+	err   error
+	calls int
+}
+
+func (c *pingClient) Ping(context.Context) error {
+	c.calls++
+	return c.err
+}
+
+func TestHealthChecks(t *testing.T) {
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	tests := []struct {
+		name      string
+		err       error
+		wantLive  bool
+		wantReady bool
+	}{
+		{"reachable", nil, true, true},
+		{"unreachable", errors.New("dial tcp: i/o timeout"), true, false},
+		{"expired credentials", &smithy.GenericAPIError{Code: "ExpiredToken"}, false, false},
+	}
+	for _, tt := range tests {
+		h := &HealthChecks{AwsClient: &pingClient{err: tt.err}}
+		if err := h.Live(req); (err == nil) != tt.wantLive {
+			t.Errorf("%s: Live() error = %v, want live %v", tt.name, err, tt.wantLive)
+		}
+		if err := h.Ready(req); (err == nil) != tt.wantReady {
+			t.Errorf("%s: Ready() error = %v, want ready %v", tt.name, err, tt.wantReady)
+		}
+	}
+}
+
+func TestHealthChecksReusePing(t *testing.T) {
+	c := &pingClient{}
+	h := &HealthChecks{AwsClient: c, Interval: time.Minute}
+	req := httptest.NewRequest("GET", "/readyz", nil)
+	for i := 0; i < 3; i++ {
+		if err := h.Ready(req); err != nil {
+			t.Fatalf("Ready() error = %v", err)
+		}
+	}
+	if c.calls != 1 {
+		t.Errorf("Ping calls = %d, want 1", c.calls)
+	}
+}
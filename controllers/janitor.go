@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/audit"
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// StoreJanitor periodically releases the allocations whose Service has been
+// gone for longer than TTL and whose listener no longer exists in AWS, such
+// as allocations left behind by deletes that were missed or only half done.
+// It keeps the store from growing without bound in clusters with high
+// service churn. Sticky allocations, which expire on their own, are left
+// alone.
+type StoreJanitor struct {
+	Client    client.Reader
+	Store     store.Store
+	AwsClient aws.Client
+	Period    time.Duration
+	TTL       time.Duration
+
+	// Audit, if set, records the released allocations.
+	Audit *audit.Trail
+
+	// StoreReady, if set, is closed once Store has been loaded.
+	StoreReady <-chan struct{}
+
+	// missingSince is when the svc of an allocation was first found gone.
+	// Restarts start over, which only delays releasing.
+	missingSince map[string]time.Time
+	now          func() time.Time
+}
+
+// Start cleans up the store every Period until ctx is done.
+func (j *StoreJanitor) Start(ctx context.Context) error {
+	if j.StoreReady != nil {
+		select {
+		case <-j.StoreReady:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	ticker := time.NewTicker(j.Period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			j.clean(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (j *StoreJanitor) clean(ctx context.Context) {
+	logger := log.FromContext(ctx)
+	if j.missingSince == nil {
+		j.missingSince = map[string]time.Time{}
+	}
+	now := time.Now()
+	if j.now != nil {
+		now = j.now()
+	}
+	missing := map[string]bool{}
+	released := 0
+	for _, allocation := range j.Store.ListAllocations(ctx) {
+		if allocation.Sticky {
+			continue
+		}
+		name := allocation.ServiceNamespacedName
+		serviceKey, _ := splitAllocationKey(name)
+		var svc corev1.Service
+		if err := j.Client.Get(ctx, serviceKey, &svc); err == nil {
+			continue
+		} else if !apierrors.IsNotFound(err) {
+			logger.Error(err, "unable to fetch svc", "svc", serviceKey.String())
+			continue
+		}
+		missing[name] = true
+		since, ok := j.missingSince[name]
+		if !ok {
+			j.missingSince[name] = now
+			continue
+		}
+		if now.Sub(since) < j.TTL {
+			continue
+		}
+		exists, err := j.AwsClient.ListenerExists(ctx, allocation.ListenerArn)
+		if err != nil {
+			logger.Error(err, "unable to check listener of stale allocation", "allocation", name)
+			continue
+		}
+		if exists {
+			continue
+		}
+		logger.Info("releasing stale allocation", "allocation", name, "nlb", allocation.NLB, "nlbPort", allocation.Port, "missingSince", since)
+		j.Store.ReleaseNLBAndPortForService(ctx, name, allocation.NLB, allocation.Port)
+		staleAllocationsReleasedTotal.Inc()
+		j.Audit.Record(audit.WithActor(ctx, audit.ActorJanitor), audit.Record{
+			Action: audit.Released, Reason: fmt.Sprintf("svc gone since %s and listener gone", since.Format(time.RFC3339)),
+			Service: name, NLB: allocation.NLB, Port: allocation.Port, ListenerArn: allocation.ListenerArn, TargetArn: allocation.TargetArn,
+		})
+		delete(j.missingSince, name)
+		released++
+	}
+	for name := range j.missingSince {
+		if !missing[name] {
+			delete(j.missingSince, name)
+		}
+	}
+	logger.Info("cleaned up store", "released", released, "missing", len(j.missingSince))
+}
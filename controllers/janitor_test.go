@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type listenerClient struct {
+	aws.Client
+	listeners map[string]bool
+	// tagged are the listeners tagged for the cluster.
+	tagged []aws.ListenerAllocation
+}
+
+func (c *listenerClient) ListenerExists(_ context.Context, listenerArn string) (bool, error) {
+	return c.listeners[listenerArn], nil
+}
+
+func (c *listenerClient) ListAllocations(context.Context, []string) ([]aws.ListenerAllocation, error) {
+	return c.tagged, nil
+}
+
+func TestStoreJanitor(t *testing.T) {
+	ctx := context.Background()
+	s := store.New(store.NLB{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: store.PortRange{Min: 9000, Max: 9099}})
+	for _, name := range []string{"default/web:http", "default/gone:http", "default/retained:http"} {
+		nlb, port, err := s.GetVacantNLBAndPortForService(ctx, name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AssignNLBAndPortToServiceInNamespace(ctx, nlb, port, name, "arn:listener/"+name, "arn:targetgroup/"+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	web := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}}
+	now := time.Now()
+	j := &StoreJanitor{
+		Client:    fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(web).Build(),
+		Store:     s,
+		AwsClient: &listenerClient{listeners: map[string]bool{"arn:listener/default/retained:http": true}},
+		TTL:       time.Hour,
+		now:       func() time.Time { return now },
+	}
+
+	j.clean(ctx)
+	if got := len(s.ListAllocations(ctx)); got != 3 {
+		t.Fatalf("%d allocations after the first check, want 3", got)
+	}
+	now = now.Add(time.Hour)
+	j.clean(ctx)
+	allocations := map[string]bool{}
+	for _, allocation := range s.ListAllocations(ctx) {
+		allocations[allocation.ServiceNamespacedName] = true
+	}
+	if !allocations["default/web:http"] || !allocations["default/retained:http"] || allocations["default/gone:http"] {
+		t.Errorf("allocations %v after the ttl, want default/web:http and default/retained:http", allocations)
+	}
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// LifecycleHooks drains instances that an Auto Scaling group is scaling in.
+// A termination lifecycle hook holds such instances in Terminating:Wait;
+// LifecycleHooks deregisters them from every instance target group and
+// completes the hook only once they are no longer targets of any, that is
+// once their deregistration delay has elapsed, so that open connections end
+// cleanly before the instance goes away.
+type LifecycleHooks struct {
+	client.Reader
+	AwsClient aws.Client
+
+	// LoadBalancerClass is the class of the LoadBalancer Services the
+	// ServiceReconciler claims.
+	LoadBalancerClass string
+
+	// Hook is the name of the termination lifecycle hook of the Auto
+	// Scaling groups of the nodes.
+	Hook string
+	// Interval is how often terminating instances are looked for.
+	Interval time.Duration
+
+	// Resync receives an event whenever instances start terminating, for
+	// the NodeReconciler to deregister them.
+	Resync chan<- event.GenericEvent
+
+	mu sync.Mutex
+	// terminating are the ids of the instances held by Hook
+	terminating map[string]bool
+}
+
+// Start polls for terminating instances every Interval until ctx is done.
+func (h *LifecycleHooks) Start(ctx context.Context) error {
+	ticker := time.NewTicker(h.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := h.Refresh(ctx); err != nil {
+				log.FromContext(ctx).Error(err, "unable to drain terminating instances", "hook", h.Hook)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Refresh deregisters the instances Hook newly holds, and completes Hook for
+// the instances no target group references anymore.
+func (h *LifecycleHooks) Refresh(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithValues("hook", h.Hook)
+	actions, err := h.AwsClient.PendingTerminations(ctx, h.Hook)
+	if err != nil {
+		return err
+	}
+
+	terminating := map[string]bool{}
+	for _, action := range actions {
+		terminating[action.InstanceID] = true
+	}
+	h.mu.Lock()
+	var added bool
+	for instanceID := range terminating {
+		if !h.terminating[instanceID] {
+			logger.Info("draining terminating instance", "instance", instanceID)
+			added = true
+		}
+	}
+	h.terminating = terminating
+	h.mu.Unlock()
+	if added {
+		select {
+		case h.Resync <- event.GenericEvent{Object: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: h.Hook}}}:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+
+	targetArns, err := h.targetGroups(ctx)
+	if err != nil {
+		return err
+	}
+	for _, action := range actions {
+		drained, err := h.drained(ctx, targetArns, action.InstanceID)
+		if err != nil {
+			return err
+		}
+		if !drained {
+			continue
+		}
+		if err := h.AwsClient.CompleteLifecycleAction(ctx, action); err != nil {
+			return err
+		}
+		logger.Info("terminating instance drained", "instance", action.InstanceID, "asg", action.AutoScalingGroupName)
+	}
+	return nil
+}
+
+// drained reports whether none of the target groups references the
+// instance.
+func (h *LifecycleHooks) drained(ctx context.Context, targetArns []string, instanceID string) (bool, error) {
+	for _, targetArn := range targetArns {
+		registered, err := h.AwsClient.InstanceRegistered(ctx, targetArn, instanceID)
+		if err != nil || registered {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// targetGroups returns the instance target groups of the managed Services.
+func (h *LifecycleHooks) targetGroups(ctx context.Context) ([]string, error) {
+	var services corev1.ServiceList
+	if err := h.List(ctx, &services); err != nil {
+		return nil, err
+	}
+	var targetArns []string
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if !isManagedService(svc, h.LoadBalancerClass) || isIPTargetType(svc) || isPaused(svc) {
+			continue
+		}
+		for idx, port := range svc.Spec.Ports {
+			if targetArn := getPortAnnotation(svc, nlbAnnotationTarget, portKey(port, idx), idx); targetArn != "" {
+				targetArns = append(targetArns, targetArn)
+			}
+		}
+	}
+	return targetArns, nil
+}
+
+// NodeSource returns the nodes of source that are not terminating.
+func (h *LifecycleHooks) NodeSource(source NodeSource) NodeSource {
+	return withoutTerminating{source: source, hooks: h}
+}
+
+// withoutTerminating leaves the instances held by a lifecycle hook out of
+// the nodes of source.
+type withoutTerminating struct {
+	source NodeSource
+	hooks  *LifecycleHooks
+}
+
+func (s withoutTerminating) Nodes(ctx context.Context) ([]Node, error) {
+	nodes, err := s.source.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+	kept := nodes[:0]
+	for _, node := range nodes {
+		if !s.hooks.terminating[node.InstanceID] {
+			kept = append(kept, node)
+		}
+	}
+	return kept, nil
+}
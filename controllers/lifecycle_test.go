@@ -0,0 +1,26 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type staticNodes []Node
+
+func (s staticNodes) Nodes(context.Context) ([]Node, error) {
+	return append([]Node(nil), s...), nil
+}
+
+func TestLifecycleHooksLeaveOutTerminatingNodes(t *testing.T) {
+	hooks := &LifecycleHooks{terminating: map[string]bool{"i-b": true}}
+	source := hooks.NodeSource(staticNodes{{Name: "a", InstanceID: "i-a"}, {Name: "b", InstanceID: "i-b"}})
+	got, err := source.Nodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Node{{Name: "a", InstanceID: "i-a"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Nodes() = %v, want %v", got, want)
+	}
+}
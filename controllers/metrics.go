@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Phases of a service reconcile, timed by nlb_reconcile_phase_duration_seconds.
+const (
+	phaseFetchService   = "fetch_svc"
+	phaseCheckListener  = "check_listener"
+	phaseAllocate       = "allocate"
+	phaseCreateListener = "create_listener"
+	phaseUpdateService  = "update_svc"
+)
+
+var (
+	reconcilePhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "nlb_reconcile_phase_duration_seconds",
+		Help:    "Duration of the phases of service reconciles: fetch_svc, check_listener, allocate, create_listener and update_svc",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"phase"})
+	reconcileErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nlb_reconcile_errors_total",
+		Help: "Total number of failed service reconciles by error class: throttled, notfound, exhausted or transient",
+	}, []string{"class"})
+	staleAllocationsReleasedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nlb_stale_allocations_released_total",
+		Help: "Total number of allocations released by the store janitor because their service and listener were gone",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(reconcilePhaseDuration, reconcileErrorsTotal, staleAllocationsReleasedTotal)
+}
+
+// observePhase records the duration of a reconcile phase that began at start.
+func observePhase(phase string, start time.Time) {
+	reconcilePhaseDuration.WithLabelValues(phase).Observe(time.Since(start).Seconds())
+}
+
+var allocationsDesc = prometheus.NewDesc(
+	"nlb_allocations",
+	"Number of ports allocated on an NLB to the services of a namespace",
+	[]string{"nlb", "namespace"}, nil,
+)
+
+// AllocationCollector exports the allocations of Store by NLB and namespace,
+// so that namespaces that take up most of an NLB show. They are counted when
+// scraped.
+type AllocationCollector struct {
+	Store store.Store
+}
+
+var _ prometheus.Collector = &AllocationCollector{}
+
+// Describe implements prometheus.Collector.
+func (c *AllocationCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- allocationsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *AllocationCollector) Collect(ch chan<- prometheus.Metric) {
+	type nlbNamespace struct{ nlb, namespace string }
+	counts := map[nlbNamespace]int{}
+	for _, allocation := range c.Store.ListAllocations(context.Background()) {
+		serviceKey, _ := splitAllocationKey(allocation.ServiceNamespacedName)
+		counts[nlbNamespace{allocation.NLB, serviceKey.Namespace}]++
+	}
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(allocationsDesc, prometheus.GaugeValue, float64(count), key.nlb, key.namespace)
+	}
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAllocationCollector(t *testing.T) {
+	s := store.New(
+		store.NLB{Name: "public", Host: "public.elb.amazonaws.com"},
+		store.NLB{Name: "internal", Host: "internal.elb.amazonaws.com"},
+	)
+	ctx := context.Background()
+	for _, allocation := range []struct {
+		nlb string
+		svc string
+	}{
+		{"public", "default/web:http"},
+		{"public", "default/web:https"},
+		{"public", "shop/cart:http"},
+		{"internal", "shop/db:postgres"},
+	} {
+		_, port, err := s.GetVacantNLBAndPortForService(ctx, allocation.svc, func(nlb string) bool { return nlb == allocation.nlb })
+		if err != nil {
+			t.Fatalf("GetVacantNLBAndPortForService(%s) error = %v", allocation.svc, err)
+		}
+		if err := s.AssignNLBAndPortToServiceInNamespace(ctx, allocation.nlb, port, allocation.svc, "listener", "target"); err != nil {
+			t.Fatalf("AssignNLBAndPortToServiceInNamespace(%s) error = %v", allocation.svc, err)
+		}
+	}
+
+	want := `
+# HELP nlb_allocations Number of ports allocated on an NLB to the services of a namespace
+# TYPE nlb_allocations gauge
+nlb_allocations{namespace="default",nlb="public"} 2
+nlb_allocations{namespace="shop",nlb="internal"} 1
+nlb_allocations{namespace="shop",nlb="public"} 1
+`
+	if err := testutil.CollectAndCompare(&AllocationCollector{Store: s}, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// nlbListKey is the key of the NLB list in the ConfigMap, in the format
+	// of NLB_LIST
+	nlbListKey = "nlbs"
+
+	// nlbListDrainPeriod is how often an NLB removed from the list is checked
+	// for allocations that are still on it
+	nlbListDrainPeriod = 30 * time.Second
+)
+
+// NLBListReconciler manages the NLBs listed in a ConfigMap, so that NLBs are
+// added and removed without a restart. An NLB removed from the list is
+// drained: no port is allocated on it anymore, and its allocations are
+// released, which moves their services to the other NLBs. The NLB is removed
+// from the store once it is empty.
+type NLBListReconciler struct {
+	client.Client
+	Store store.Store
+	Key   types.NamespacedName
+
+	// Admin releases the allocations of drained NLBs.
+	Admin *ServiceAdmin
+
+	// StoreReady, if set, is closed once Store has been loaded.
+	StoreReady <-chan struct{}
+
+	// listed are the NLBs added to the store from the ConfigMap, the only
+	// ones the reconciler drains and removes again
+	listed map[string]bool
+}
+
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+
+// Reconcile adds the NLBs of the ConfigMap to Store, and drains the NLBs it
+// added before that are no longer listed.
+func (r *NLBListReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("configmap", req.NamespacedName.String())
+	ctx = log.IntoContext(ctx, logger)
+
+	if r.StoreReady != nil {
+		select {
+		case <-r.StoreReady:
+		case <-ctx.Done():
+			return ctrl.Result{}, ctx.Err()
+		}
+	}
+
+	var cm corev1.ConfigMap
+	if err := r.Get(ctx, r.Key, &cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			// a deleted configmap does not drain every nlb
+			logger.Info("configmap not found. Keeping the current nlbs")
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "unable to fetch configmap")
+		return ctrl.Result{Requeue: true}, err
+	}
+	nlbs, err := store.ParseNLBList(cm.Data[nlbListKey])
+	if err != nil {
+		// the list is fixed by editing the configmap, which reconciles again
+		logger.Error(err, "invalid nlb list. Keeping the current nlbs")
+		return ctrl.Result{}, nil
+	}
+
+	if r.listed == nil {
+		r.listed = map[string]bool{}
+	}
+	current := map[string]bool{}
+	for _, nlb := range nlbs {
+		current[nlb.Name] = true
+		if !r.listed[nlb.Name] {
+			logger.Info("nlb listed", "nlb", nlb.Name)
+		}
+		r.Store.AddNLB(nlb)
+		r.listed[nlb.Name] = true
+	}
+
+	draining := false
+	for nlb := range r.listed {
+		if current[nlb] {
+			continue
+		}
+		removed, err := r.drain(ctx, nlb)
+		if err != nil {
+			return ctrl.Result{Requeue: true}, err
+		}
+		if !removed {
+			draining = true
+			continue
+		}
+		logger.Info("nlb no longer listed. Removed", "nlb", nlb)
+		delete(r.listed, nlb)
+	}
+	if draining {
+		return ctrl.Result{RequeueAfter: nlbListDrainPeriod}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// drain stops allocating ports on an NLB and releases its allocations, and
+// reports whether the NLB is empty and has been removed from the store.
+func (r *NLBListReconciler) drain(ctx context.Context, nlb string) (bool, error) {
+	logger := log.FromContext(ctx).WithValues("nlb", nlb)
+	r.Store.DrainNLB(nlb)
+	for _, allocation := range r.Store.ListAllocations(ctx) {
+		if allocation.NLB != nlb {
+			continue
+		}
+		logger.Info("moving allocation off draining nlb", "allocation", allocation.ServiceNamespacedName)
+		if err := r.Admin.Release(ctx, allocation.ServiceNamespacedName); err != nil {
+			logger.Error(err, "unable to release allocation", "allocation", allocation.ServiceNamespacedName)
+			return false, err
+		}
+	}
+	if allocated, _ := r.Store.PoolUsage(nlb); allocated > 0 {
+		logger.Info("nlb still has allocations. Waiting", "allocated", allocated)
+		return false, nil
+	}
+	if err := r.Store.RemoveNLB(nlb); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ListedNLBs returns the NLBs listed in the ConfigMap key, so that
+// allocations on them are kept when the store is loaded. A missing ConfigMap
+// lists no NLBs.
+func ListedNLBs(ctx context.Context, reader client.Reader, key types.NamespacedName) ([]store.NLB, error) {
+	var cm corev1.ConfigMap
+	if err := reader.Get(ctx, key, &cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return store.ParseNLBList(cm.Data[nlbListKey])
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NLBListReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("nlblist").
+		For(&corev1.ConfigMap{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return client.ObjectKeyFromObject(obj) == r.Key
+		}))).
+		Complete(traced("NLBList", r))
+}
@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	nlbv1alpha1 "github.com/chinmayrelkar/aws-nlb-controller/api/v1alpha1"
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// nlbPoolFinalizer blocks deletion of an NLBPool until its NLB has no
+	// allocations and has been deleted
+	nlbPoolFinalizer = "nlb.chinmayrelkar.github.com/nlb"
+
+	// nlbPoolConditionReady reports whether the NLB of a pool is provisioned
+	// and ports are allocated on it
+	nlbPoolConditionReady = "Ready"
+
+	// nlbPoolResyncPeriod is how often pool utilization is written to status
+	nlbPoolResyncPeriod = time.Minute
+)
+
+// NLBPoolReconciler provisions the NLB of every NLBPool and adds it to the
+// ports the ServiceReconciler allocates from.
+type NLBPoolReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	Store     store.Store
+	AwsClient aws.Client
+
+	// StoreReady, if set, is closed once Store has been loaded.
+	StoreReady <-chan struct{}
+
+	// ScaleInCooldown is how long the NLB of a pool scaled out from another
+	// stays empty before the pool is deleted. Zero keeps such pools.
+	ScaleInCooldown time.Duration
+}
+
+// +kubebuilder:rbac:groups=nlb.chinmayrelkar.github.com,resources=nlbpools,verbs=get;list;watch;update;patch;delete
+// +kubebuilder:rbac:groups=nlb.chinmayrelkar.github.com,resources=nlbpools/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=nlb.chinmayrelkar.github.com,resources=nlbpools/finalizers,verbs=update
+
+// Reconcile creates or updates the NLB of an NLBPool and reports it in status.
+func (r *NLBPoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("nlbpool", req.Name)
+	ctx = log.IntoContext(ctx, logger)
+
+	if r.StoreReady != nil {
+		select {
+		case <-r.StoreReady:
+		case <-ctx.Done():
+			return ctrl.Result{}, ctx.Err()
+		}
+	}
+
+	var pool nlbv1alpha1.NLBPool
+	if err := r.Get(ctx, req.NamespacedName, &pool); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "unable to fetch nlbpool")
+		return ctrl.Result{Requeue: true}, err
+	}
+	name := pool.LoadBalancerName()
+
+	// the nlb of a pool in another account is managed with its role, also
+	// while it is being deleted
+	if err := r.AwsClient.AssumeRole(name, poolRole(&pool)); err != nil {
+		logger.Error(err, "unable to assume role")
+		return ctrl.Result{}, r.setReady(ctx, &pool, metav1.ConditionFalse, "InvalidRole", err.Error())
+	}
+
+	// pool is being deleted. the nlb can only go once no svc uses it
+	if !pool.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&pool, nlbPoolFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if allocated, _ := r.Store.PoolUsage(name); allocated > 0 {
+			logger.Info("nlb still has allocations. Waiting", "allocated", allocated)
+			return ctrl.Result{RequeueAfter: nlbPoolResyncPeriod}, r.setReady(ctx, &pool, metav1.ConditionFalse, "Draining", "the nlb still has allocated ports")
+		}
+		if err := r.Store.RemoveNLB(name); err != nil {
+			return ctrl.Result{Requeue: true}, err
+		}
+		if err := r.AwsClient.DeleteNLB(ctx, pool.Name, name); err != nil {
+			logger.Error(err, "unable to delete nlb")
+			return ctrl.Result{Requeue: true}, err
+		}
+		controllerutil.RemoveFinalizer(&pool, nlbPoolFinalizer)
+		if err := r.Update(ctx, &pool); err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{Requeue: true}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(&pool, nlbPoolFinalizer) {
+		controllerutil.AddFinalizer(&pool, nlbPoolFinalizer)
+		if err := r.Update(ctx, &pool); err != nil {
+			return ctrl.Result{Requeue: true}, err
+		}
+	}
+
+	nlb, err := r.AwsClient.EnsureNLB(ctx, aws.NLBSpec{
+		Pool:           pool.Name,
+		Name:           name,
+		Subnets:        pool.Spec.Subnets,
+		Scheme:         pool.Spec.Scheme,
+		EIPAllocations: pool.Spec.EIPAllocations,
+		Tags:           pool.Spec.Tags,
+	})
+	if err != nil {
+		logger.Error(err, "unable to provision nlb")
+		if err := r.setReady(ctx, &pool, metav1.ConditionFalse, "ProvisioningFailed", err.Error()); err != nil {
+			return ctrl.Result{Requeue: true}, err
+		}
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	r.Store.AddNLB(poolNLB(&pool, nlb.DNSName))
+	scaledIn, err := r.scaleIn(ctx, &pool)
+	if err != nil {
+		logger.Error(err, "unable to scale in nlbpool")
+		return ctrl.Result{Requeue: true}, err
+	}
+	if scaledIn {
+		return ctrl.Result{}, nil
+	}
+	pool.Status.LoadBalancerArn = nlb.Arn
+	pool.Status.DNSName = nlb.DNSName
+	if err := r.setReady(ctx, &pool, metav1.ConditionTrue, "Provisioned", "ports are allocated on the nlb"); err != nil {
+		return ctrl.Result{Requeue: true}, err
+	}
+	return ctrl.Result{RequeueAfter: nlbPoolResyncPeriod}, nil
+}
+
+// scaleIn deletes a pool scaled out from another once its NLB has been empty
+// for ScaleInCooldown, and reports whether it did. The NLB is removed from the
+// store first, so that no port is allocated on it while it is deleted. Pools
+// created by operators are never scaled in.
+func (r *NLBPoolReconciler) scaleIn(ctx context.Context, pool *nlbv1alpha1.NLBPool) (bool, error) {
+	name := pool.LoadBalancerName()
+	allocated, _ := r.Store.PoolUsage(name)
+	if r.ScaleInCooldown <= 0 || pool.Labels[labelScaledFrom] == "" || allocated > 0 {
+		pool.Status.EmptySince = nil
+		return false, nil
+	}
+	if pool.Status.EmptySince == nil {
+		now := metav1.Now()
+		pool.Status.EmptySince = &now
+		return false, nil
+	}
+	if time.Since(pool.Status.EmptySince.Time) < r.ScaleInCooldown {
+		return false, nil
+	}
+	if err := r.Store.RemoveNLB(name); err != nil {
+		// a port was allocated since PoolUsage
+		pool.Status.EmptySince = nil
+		return false, nil
+	}
+	log.FromContext(ctx).Info("nlb empty for the scale-in cooldown. Deleting nlbpool", "since", pool.Status.EmptySince.Time)
+	if err := r.Delete(ctx, pool); err != nil && !apierrors.IsNotFound(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// setReady writes the Ready condition and the utilization of the pool to its
+// status.
+func (r *NLBPoolReconciler) setReady(ctx context.Context, pool *nlbv1alpha1.NLBPool, status metav1.ConditionStatus, reason string, message string) error {
+	pool.Status.AllocatedPorts, pool.Status.FreePorts = r.Store.PoolUsage(pool.LoadBalancerName())
+	meta.SetStatusCondition(&pool.Status.Conditions, metav1.Condition{
+		Type:               nlbPoolConditionReady,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: pool.Generation,
+	})
+	if err := r.Status().Update(ctx, pool); err != nil {
+		log.FromContext(ctx).Error(err, "unable to update nlbpool status")
+		return err
+	}
+	return nil
+}
+
+// poolNLB is the store entry of the NLB of a pool.
+func poolNLB(pool *nlbv1alpha1.NLBPool, host string) store.NLB {
+	nlb := store.NLB{Name: pool.LoadBalancerName(), Host: host, Weight: pool.Spec.Weight, ExcludedPorts: pool.Spec.ExcludedPorts}
+	if pool.Spec.PortRange != nil {
+		nlb.PortRange = store.PortRange{Min: pool.Spec.PortRange.Min, Max: pool.Spec.PortRange.Max}
+	}
+	return nlb
+}
+
+// poolRole is the role the NLB of a pool is managed with.
+func poolRole(pool *nlbv1alpha1.NLBPool) aws.Role {
+	return aws.Role{ARN: pool.Spec.RoleARN, ExternalID: pool.Spec.ExternalID}
+}
+
+// AssumePoolRoles assumes the roles of the NLBPools in other accounts, so
+// that their NLBs can be read before the pools are reconciled.
+func AssumePoolRoles(ctx context.Context, reader client.Reader, awsClient aws.Client) error {
+	var pools nlbv1alpha1.NLBPoolList
+	if err := reader.List(ctx, &pools); err != nil {
+		return err
+	}
+	for i := range pools.Items {
+		pool := &pools.Items[i]
+		if err := awsClient.AssumeRole(pool.LoadBalancerName(), poolRole(pool)); err != nil {
+			log.FromContext(ctx).Error(err, "unable to assume role", "nlbpool", pool.Name)
+		}
+	}
+	return nil
+}
+
+// PoolNLBs returns the NLBs of the NLBPools that have been provisioned, so
+// that allocations on them are kept when the store is loaded.
+func PoolNLBs(ctx context.Context, reader client.Reader) ([]store.NLB, error) {
+	var pools nlbv1alpha1.NLBPoolList
+	if err := reader.List(ctx, &pools); err != nil {
+		return nil, err
+	}
+	var nlbs []store.NLB
+	for i := range pools.Items {
+		pool := &pools.Items[i]
+		if pool.Status.DNSName == "" {
+			continue
+		}
+		nlbs = append(nlbs, poolNLB(pool, pool.Status.DNSName))
+	}
+	return nlbs, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NLBPoolReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&nlbv1alpha1.NLBPool{}).
+		Complete(traced("NLBPool", r))
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	nlbv1alpha1 "github.com/chinmayrelkar/aws-nlb-controller/api/v1alpha1"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+	storefake "github.com/chinmayrelkar/aws-nlb-controller/store/fake"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestScaleInIdlePool(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := nlbv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	newPool := func(name string, scaledFrom string) *nlbv1alpha1.NLBPool {
+		pool := &nlbv1alpha1.NLBPool{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if scaledFrom != "" {
+			pool.Labels = map[string]string{labelScaledFrom: scaledFrom}
+		}
+		return pool
+	}
+	scaled, busy, operator := newPool("shared-2", "shared"), newPool("shared-3", "shared"), newPool("shared", "")
+	s := storefake.New(
+		store.NLB{Name: "shared", Host: "shared.elb.amazonaws.com"},
+		store.NLB{Name: "shared-2", Host: "shared-2.elb.amazonaws.com"},
+		store.NLB{Name: "shared-3", Host: "shared-3.elb.amazonaws.com"},
+	)
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "shared-3", 9000, "default/web:http", "listener-web", "target-web"); err != nil {
+		t.Fatal(err)
+	}
+	r := &NLBPoolReconciler{
+		Client:          fake.NewClientBuilder().WithScheme(scheme).WithObjects(scaled, busy, operator).Build(),
+		Scheme:          scheme,
+		Store:           s,
+		ScaleInCooldown: time.Minute,
+	}
+
+	for _, pool := range []*nlbv1alpha1.NLBPool{scaled, busy, operator} {
+		if scaledIn, err := r.scaleIn(ctx, pool); err != nil || scaledIn {
+			t.Fatalf("scaleIn(%s) = %v, %v on the first reconcile, want the cooldown to start", pool.Name, scaledIn, err)
+		}
+	}
+	if scaled.Status.EmptySince == nil {
+		t.Error("empty since not set on an empty scaled out pool")
+	}
+	if busy.Status.EmptySince != nil || operator.Status.EmptySince != nil {
+		t.Error("empty since set on a pool with allocations or created by an operator")
+	}
+
+	// a port allocated while the cooldown ran out keeps the pool
+	past := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	scaled.Status.EmptySince = &past
+	s.FailNext("RemoveNLB", errors.New("nlb has allocations"))
+	if scaledIn, err := r.scaleIn(ctx, scaled); err != nil || scaledIn {
+		t.Errorf("scaleIn() = %v, %v with the nlb failing to be removed, want the pool kept", scaledIn, err)
+	}
+	if scaled.Status.EmptySince != nil {
+		t.Error("empty since kept after the nlb failed to be removed")
+	}
+
+	scaled.Status.EmptySince = &past
+	if scaledIn, err := r.scaleIn(ctx, scaled); err != nil || !scaledIn {
+		t.Fatalf("scaleIn() = %v, %v after the cooldown, want the pool deleted", scaledIn, err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(scaled), &nlbv1alpha1.NLBPool{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Get() error = %v for the scaled in pool, want it deleted", err)
+	}
+	for _, nlb := range s.ListNLBs() {
+		if nlb == "shared-2" {
+			t.Error("nlb of the scaled in pool still in the store")
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// NodeReconciler keeps the instance target groups of every managed svc in
+// sync with the nodes of the cluster, so that nodes added after a target
+// group was created receive traffic and removed nodes stop receiving it.
+type NodeReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	AwsClient aws.Client
+
+	// LoadBalancerClass is the class of the LoadBalancer Services the
+	// ServiceReconciler claims.
+	LoadBalancerClass string
+
+	// Nodes lists the nodes registered as targets. Defaults to the Nodes of
+	// the cluster.
+	Nodes NodeSource
+
+	// Resync, if set, delivers syncs outside of Node events, such as when
+	// instances start terminating.
+	Resync <-chan event.GenericEvent
+
+	// TargetSyncConcurrency is the number of target groups synced in
+	// parallel. Defaults to 1.
+	TargetSyncConcurrency int
+}
+
+// targetSync is the sync of the target group of a port of a svc.
+type targetSync struct {
+	svc       string
+	port      string
+	targetArn string
+	targets   []aws.Target
+}
+
+// Reconcile syncs all instance target groups. Any node event can change the
+// targets of every target group, so the request itself is not used. The
+// nodes are listed once, and the target groups synced TargetSyncConcurrency
+// at a time.
+func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("node", req.Name)
+
+	var services corev1.ServiceList
+	if err := r.List(ctx, &services); err != nil {
+		logger.Error(err, "unable to list services")
+		return ctrl.Result{Requeue: true}, err
+	}
+	nodes, err := nodeSource(r.Nodes, r).Nodes(ctx)
+	if err != nil {
+		logger.Error(err, "unable to list nodes")
+		return ctrl.Result{Requeue: true}, err
+	}
+
+	var syncs []targetSync
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if !isManagedService(svc, r.LoadBalancerClass) || isIPTargetType(svc) || isPaused(svc) {
+			continue
+		}
+		for idx, port := range svc.Spec.Ports {
+			key := portKey(port, idx)
+			targetArn := getPortAnnotation(svc, nlbAnnotationTarget, key, idx)
+			if targetArn == "" {
+				continue
+			}
+			targets, err := nodeTargets(ctx, r, nodes, svc, port)
+			if err != nil {
+				logger.Error(err, "unable to list targets")
+				return ctrl.Result{Requeue: true}, err
+			}
+			syncs = append(syncs, targetSync{
+				svc:       client.ObjectKeyFromObject(svc).String(),
+				port:      key,
+				targetArn: targetArn,
+				targets:   targets,
+			})
+		}
+	}
+	if err := r.syncTargets(ctx, syncs); err != nil {
+		return ctrl.Result{Requeue: true}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// syncTargets runs syncs on a pool of TargetSyncConcurrency workers, and
+// returns the first error once all are done.
+func (r *NodeReconciler) syncTargets(ctx context.Context, syncs []targetSync) error {
+	workers := r.TargetSyncConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	pending := make(chan targetSync)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range pending {
+				if err := r.AwsClient.SyncTargets(ctx, s.targetArn, s.targets); err != nil {
+					log.FromContext(ctx).Error(err, "unable to sync targets", "svc", s.svc, "port", s.port)
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, s := range syncs {
+		pending <- s
+	}
+	close(pending)
+	wg.Wait()
+	return firstErr
+}
+
+// nodeSource returns nodes, or the Nodes of the cluster if it is nil.
+func nodeSource(nodes NodeSource, c client.Reader) NodeSource {
+	if nodes == nil {
+		return ClusterNodes{Reader: c}
+	}
+	return nodes
+}
+
+// instanceTargets returns the NodePort of the svc port on every node of
+// source.
+func instanceTargets(ctx context.Context, c client.Reader, source NodeSource, svc *corev1.Service, port corev1.ServicePort) ([]aws.Target, error) {
+	nodes, err := source.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return nodeTargets(ctx, c, nodes, svc, port)
+}
+
+// nodeTargets returns the NodePort of the svc port on every node of nodes.
+// With externalTrafficPolicy Local only nodes hosting a ready endpoint of
+// the svc are returned, since the others fail health checks and would drop
+// the traffic.
+func nodeTargets(ctx context.Context, c client.Reader, nodes []Node, svc *corev1.Service, port corev1.ServicePort) ([]aws.Target, error) {
+	var withEndpoints map[string]bool
+	var err error
+	if svc.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal {
+		withEndpoints, err = endpointNodes(ctx, c, svc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var targets []aws.Target
+	for _, node := range nodes {
+		if withEndpoints != nil && !withEndpoints[node.Name] {
+			continue
+		}
+		targets = append(targets, aws.Target{ID: node.InstanceID, Port: int(port.NodePort)})
+	}
+	return targets, nil
+}
+
+// instanceIDFromProviderID returns the EC2 instance id of a node provider id
+// of the form aws:///<zone>/<instance id>, or "" for other providers.
+func instanceIDFromProviderID(providerID string) string {
+	if !strings.HasPrefix(providerID, "aws://") {
+		return ""
+	}
+	instanceID := providerID[strings.LastIndex(providerID, "/")+1:]
+	if !strings.HasPrefix(instanceID, "i-") {
+		return ""
+	}
+	return instanceID
+}
+
+// SetupWithManager sets up the controller with the Manager. Only changes that
+// affect target registration trigger a sync: of the provider id, labels,
+// taints, readiness or cordoning of a node, not node status heartbeats.
+func (r *NodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Node{}).
+		WithEventFilter(predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				oldNode, newNode := e.ObjectOld.(*corev1.Node), e.ObjectNew.(*corev1.Node)
+				return oldNode.Spec.ProviderID != newNode.Spec.ProviderID ||
+					!reflect.DeepEqual(oldNode.Labels, newNode.Labels) ||
+					!reflect.DeepEqual(oldNode.Spec.Taints, newNode.Spec.Taints) ||
+					nodeReady(oldNode) != nodeReady(newNode) ||
+					oldNode.Spec.Unschedulable != newNode.Spec.Unschedulable
+			},
+		})
+	if r.Resync != nil {
+		b = b.Watches(&source.Channel{Source: r.Resync}, &handler.EnqueueRequestForObject{})
+	}
+	return b.Complete(traced("Node", r))
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Nodes with either label are never registered as targets, like with the
+// load balancers of the cloud provider.
+const (
+	labelExcludeFromLoadBalancers       = "node.kubernetes.io/exclude-from-external-load-balancers"
+	labelExcludeFromLoadBalancersLegacy = "alpha.service-controller.kubernetes.io/exclude-balancer"
+)
+
+// DefaultDrainTaints are the taints of nodes about to be interrupted or
+// terminated, set by aws-node-termination-handler for spot interruption
+// notices, scheduled maintenance events and rebalance recommendations, and
+// by cluster-autoscaler and Karpenter before they remove a node.
+var DefaultDrainTaints = []string{
+	"aws-node-termination-handler/spot-itn",
+	"aws-node-termination-handler/scheduled-maintenance",
+	"aws-node-termination-handler/rebalance-recommendation",
+	"aws-node-termination-handler/asg-lifecycle-termination",
+	"ToBeDeletedByClusterAutoscaler",
+	"karpenter.sh/disruption",
+}
+
+// Node is a node instance target groups forward to.
+type Node struct {
+	// Name is the name of the node in the cluster
+	Name string
+	// InstanceID is the EC2 instance of the node
+	InstanceID string
+}
+
+// NodeSource lists the nodes registered in instance target groups.
+type NodeSource interface {
+	Nodes(ctx context.Context) ([]Node, error)
+}
+
+// ClusterNodes lists the Nodes of the cluster that run on EC2, are not
+// excluded from load balancers or being drained, and are ready and
+// schedulable.
+type ClusterNodes struct {
+	client.Reader
+
+	// Selector, if set, limits the Nodes to those with matching labels, such
+	// as dedicated ingress nodes.
+	Selector labels.Selector
+
+	// DrainTaints are the keys of the taints of nodes about to go away. Such
+	// nodes are deregistered so that their connections drain before they
+	// do. Defaults to DefaultDrainTaints.
+	DrainTaints []string
+}
+
+func (s ClusterNodes) Nodes(ctx context.Context) ([]Node, error) {
+	var opts []client.ListOption
+	if s.Selector != nil && !s.Selector.Empty() {
+		opts = append(opts, client.MatchingLabelsSelector{Selector: s.Selector})
+	}
+	var nodes corev1.NodeList
+	if err := s.List(ctx, &nodes, opts...); err != nil {
+		return nil, err
+	}
+	drainTaints := s.DrainTaints
+	if drainTaints == nil {
+		drainTaints = DefaultDrainTaints
+	}
+	return targetNodes(nodes.Items, drainTaints), nil
+}
+
+// targetNodes returns the nodes that run on EC2, are not excluded from load
+// balancers and carry none of drainTaints. NotReady and cordoned nodes are
+// left out too, so that they stop receiving traffic before health checks
+// notice, unless no node is ready: the NLB then fails open as it does when
+// every target is unhealthy.
+func targetNodes(nodes []corev1.Node, drainTaints []string) []Node {
+	var all, ready []Node
+	for i := range nodes {
+		node := &nodes[i]
+		if excludedFromLoadBalancers(node) || hasTaint(node, drainTaints) {
+			continue
+		}
+		instanceID := instanceIDFromProviderID(node.Spec.ProviderID)
+		if instanceID == "" {
+			continue
+		}
+		all = append(all, Node{Name: node.Name, InstanceID: instanceID})
+		if nodeReady(node) && !node.Spec.Unschedulable {
+			ready = append(ready, all[len(all)-1])
+		}
+	}
+	if len(ready) == 0 {
+		return all
+	}
+	return ready
+}
+
+// hasTaint reports whether the node has a taint of one of keys.
+func hasTaint(node *corev1.Node, keys []string) bool {
+	for _, taint := range node.Spec.Taints {
+		for _, key := range keys {
+			if taint.Key == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nodeReady reports whether the Ready condition of the node is True.
+func nodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// excludedFromLoadBalancers reports whether the node carries a label
+// excluding it from load balancers. Any value excludes it.
+func excludedFromLoadBalancers(node *corev1.Node) bool {
+	_, excluded := node.Labels[labelExcludeFromLoadBalancers]
+	_, excludedLegacy := node.Labels[labelExcludeFromLoadBalancersLegacy]
+	return excluded || excludedLegacy
+}
+
+// EC2Instances lists the running instances tagged for the cluster in AWS,
+// for clusters whose Nodes carry no AWS provider id. Nodes are named after
+// the private DNS name of their instance, as on EKS.
+type EC2Instances struct {
+	AwsClient aws.Client
+}
+
+func (s EC2Instances) Nodes(ctx context.Context) ([]Node, error) {
+	instances, err := s.AwsClient.ListClusterInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]Node, 0, len(instances))
+	for _, instance := range instances {
+		nodes = append(nodes, Node{Name: instance.PrivateDNSName, InstanceID: instance.ID})
+	}
+	return nodes, nil
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testNode(name string, ready bool, mutate func(*corev1.Node)) corev1.Node {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	node := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.NodeSpec{ProviderID: "aws:///us-west-1a/i-" + name},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeReady, Status: status},
+		}},
+	}
+	if mutate != nil {
+		mutate(&node)
+	}
+	return node
+}
+
+func TestTargetNodes(t *testing.T) {
+	nodes := []corev1.Node{
+		testNode("ready", true, nil),
+		testNode("notready", false, nil),
+		testNode("cordoned", true, func(n *corev1.Node) { n.Spec.Unschedulable = true }),
+		testNode("excluded", true, func(n *corev1.Node) {
+			n.Labels = map[string]string{labelExcludeFromLoadBalancers: ""}
+		}),
+		testNode("fargate", true, func(n *corev1.Node) { n.Spec.ProviderID = "" }),
+		testNode("interrupted", true, func(n *corev1.Node) {
+			n.Spec.Taints = []corev1.Taint{{Key: "aws-node-termination-handler/spot-itn", Effect: corev1.TaintEffectNoSchedule}}
+		}),
+	}
+	want := []Node{{Name: "ready", InstanceID: "i-ready"}}
+	if got := targetNodes(nodes, DefaultDrainTaints); !reflect.DeepEqual(got, want) {
+		t.Errorf("targetNodes() = %v, want %v", got, want)
+	}
+
+	// without a ready node every node is kept, so that the nlb fails open
+	want = []Node{{Name: "notready", InstanceID: "i-notready"}, {Name: "cordoned", InstanceID: "i-cordoned"}}
+	if got := targetNodes(nodes[1:], DefaultDrainTaints); !reflect.DeepEqual(got, want) {
+		t.Errorf("targetNodes() without a ready node = %v, want %v", got, want)
+	}
+}
+
+// failingList fails every List with err.
+type failingList struct {
+	client.Reader
+	err error
+}
+
+func (f failingList) List(context.Context, client.ObjectList, ...client.ListOption) error {
+	return f.err
+}
+
+func TestClusterNodesSelector(t *testing.T) {
+	ctx := context.Background()
+	ingress := testNode("ingress", true, func(n *corev1.Node) { n.Labels = map[string]string{"role": "ingress"} })
+	worker := testNode("worker", true, func(n *corev1.Node) { n.Labels = map[string]string{"role": "worker"} })
+	c := fake.NewClientBuilder().WithObjects(&ingress, &worker).Build()
+
+	tests := map[string]struct {
+		selector labels.Selector
+		want     []Node
+	}{
+		"no selector":    {nil, []Node{{Name: "ingress", InstanceID: "i-ingress"}, {Name: "worker", InstanceID: "i-worker"}}},
+		"empty selector": {labels.Everything(), []Node{{Name: "ingress", InstanceID: "i-ingress"}, {Name: "worker", InstanceID: "i-worker"}}},
+		"role=ingress":   {labels.SelectorFromSet(labels.Set{"role": "ingress"}), []Node{{Name: "ingress", InstanceID: "i-ingress"}}},
+		"role=storage":   {labels.SelectorFromSet(labels.Set{"role": "storage"}), nil},
+	}
+	for name, tt := range tests {
+		got, err := ClusterNodes{Reader: c, Selector: tt.selector}.Nodes(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Nodes() with %s = %v, want %v", name, got, tt.want)
+		}
+	}
+
+	broken := ClusterNodes{Reader: failingList{Reader: c, err: errors.New("apiserver unavailable")}}
+	if _, err := broken.Nodes(ctx); err == nil {
+		t.Error("Nodes() error = nil with the nodes failing to list")
+	}
+}
+
+func TestExcludedFromLoadBalancers(t *testing.T) {
+	tests := map[string]struct {
+		labels map[string]string
+		want   bool
+	}{
+		"no labels":    {nil, false},
+		"other labels": {map[string]string{"role": "ingress"}, false},
+		"label":        {map[string]string{labelExcludeFromLoadBalancers: ""}, true},
+		"label false":  {map[string]string{labelExcludeFromLoadBalancers: "false"}, true},
+		"legacy label": {map[string]string{labelExcludeFromLoadBalancersLegacy: "true"}, true},
+	}
+	for name, tt := range tests {
+		node := testNode("node", true, func(n *corev1.Node) { n.Labels = tt.labels })
+		if got := excludedFromLoadBalancers(&node); got != tt.want {
+			t.Errorf("excludedFromLoadBalancers() with %s = %v, want %v", name, got, tt.want)
+		}
+	}
+
+	// an excluded node is left out even when it is the only one
+	nodes := []corev1.Node{testNode("excluded", true, func(n *corev1.Node) {
+		n.Labels = map[string]string{labelExcludeFromLoadBalancersLegacy: ""}
+	})}
+	if got := targetNodes(nodes, DefaultDrainTaints); len(got) != 0 {
+		t.Errorf("targetNodes() = %v, want the excluded node left out", got)
+	}
+}
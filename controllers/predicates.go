@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// servicePredicate passes the events of Services the controller manages or
+// allocated ports for before, so that opting out is noticed too. Updates
+// that change neither the spec nor the annotations, finalizers or deletion
+// of a Service, such as status updates and resyncs, are dropped.
+func servicePredicate(loadBalancerClass string) predicate.Predicate {
+	relevant := func(obj client.Object) bool {
+		svc, ok := obj.(*corev1.Service)
+		return ok && isTrackedService(svc, loadBalancerClass)
+	}
+	return predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return relevant(e.Object) },
+		DeleteFunc:  func(e event.DeleteEvent) bool { return relevant(e.Object) },
+		GenericFunc: func(e event.GenericEvent) bool { return relevant(e.Object) },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if !relevant(e.ObjectOld) && !relevant(e.ObjectNew) {
+				return false
+			}
+			old, ok := e.ObjectOld.(*corev1.Service)
+			if !ok {
+				return true
+			}
+			svc, ok := e.ObjectNew.(*corev1.Service)
+			return !ok || serviceChanged(old, svc)
+		},
+	}
+}
+
+// serviceChanged reports whether an update of a Service changed anything the
+// reconciler reads.
+func serviceChanged(old *corev1.Service, svc *corev1.Service) bool {
+	return !equality.Semantic.DeepEqual(old.Spec, svc.Spec) ||
+		!reflect.DeepEqual(old.Annotations, svc.Annotations) ||
+		!reflect.DeepEqual(old.Finalizers, svc.Finalizers) ||
+		!old.DeletionTimestamp.Equal(svc.DeletionTimestamp)
+}
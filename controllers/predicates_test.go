@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestServicePredicate(t *testing.T) {
+	p := servicePredicate("")
+	managed := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{serviceAnnotation: "true"}}}
+	unmanaged := &corev1.Service{}
+
+	if !p.Create(event.CreateEvent{Object: managed}) {
+		t.Errorf("Create() of a managed svc = false, want true")
+	}
+	if p.Create(event.CreateEvent{Object: unmanaged}) {
+		t.Errorf("Create() of an unmanaged svc = true, want false")
+	}
+
+	statusOnly := managed.DeepCopy()
+	statusOnly.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{Hostname: "shared.elb.amazonaws.com"}}
+	statusOnly.ResourceVersion = "2"
+	if p.Update(event.UpdateEvent{ObjectOld: managed, ObjectNew: statusOnly}) {
+		t.Errorf("Update() of the status only = true, want false")
+	}
+
+	optedOut := managed.DeepCopy()
+	optedOut.Annotations = map[string]string{nlbAnnotationNLBName: "shared"}
+	if !p.Update(event.UpdateEvent{ObjectOld: managed, ObjectNew: optedOut}) {
+		t.Errorf("Update() removing the opt-in annotation = false, want true")
+	}
+
+	retyped := unmanaged.DeepCopy()
+	retyped.Spec.Type = corev1.ServiceTypeNodePort
+	if p.Update(event.UpdateEvent{ObjectOld: unmanaged, ObjectNew: retyped}) {
+		t.Errorf("Update() of an unmanaged svc = true, want false")
+	}
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	nlbv1alpha1 "github.com/chinmayrelkar/aws-nlb-controller/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// labelScaledFrom holds the name of the NLBPool a pool was scaled out from.
+const labelScaledFrom = "nlb.chinmayrelkar.github.com/scaled-from"
+
+// NLBPoolScaler creates NLBPools from the pools that set scaleOut, so that
+// services still find a free port once every NLB is full.
+type NLBPoolScaler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Recorder, if set, emits Events on the pools scaled out.
+	Recorder record.EventRecorder
+}
+
+// ScaleOut creates an NLBPool from the first pool allowed accepts whose
+// scaleOut allows more NLBs. It reports whether an NLB is on its way, created
+// now or still being provisioned, so that the allocation is retried once it
+// is ready.
+func (s *NLBPoolScaler) ScaleOut(ctx context.Context, allowed func(*nlbv1alpha1.NLBPool) bool) (bool, error) {
+	var pools nlbv1alpha1.NLBPoolList
+	if err := s.List(ctx, &pools); err != nil {
+		return false, err
+	}
+	names := map[string]bool{}
+	scaled := map[string]int{}
+	for i := range pools.Items {
+		pool := &pools.Items[i]
+		names[pool.Name] = true
+		from, isScaled := pool.Labels[labelScaledFrom]
+		if isScaled {
+			scaled[from]++
+		}
+		// a pool without a Ready condition has not been provisioned yet
+		if (isScaled || pool.Spec.ScaleOut != nil) && allowed(pool) && pool.DeletionTimestamp.IsZero() &&
+			meta.FindStatusCondition(pool.Status.Conditions, nlbPoolConditionReady) == nil {
+			return true, nil
+		}
+	}
+
+	sort.Slice(pools.Items, func(i, j int) bool { return pools.Items[i].Name < pools.Items[j].Name })
+	for i := range pools.Items {
+		template := &pools.Items[i]
+		if template.Spec.ScaleOut == nil || !allowed(template) || !template.DeletionTimestamp.IsZero() ||
+			1+scaled[template.Name] >= template.Spec.ScaleOut.MaxLoadBalancers {
+			continue
+		}
+		pool, err := s.scaledPool(template, names)
+		if err != nil {
+			return false, err
+		}
+		err = s.Create(ctx, pool)
+		if apierrors.IsAlreadyExists(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		log.FromContext(ctx).Info("all nlbs are full. Scaled out", "nlbpool", template.Name, "created", pool.Name)
+		if s.Recorder != nil {
+			s.Recorder.Eventf(template, corev1.EventTypeNormal, "ScaledOut",
+				"every nlb is full. Created nlbpool %s", pool.Name)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// scaledPool returns the next NLBPool scaled out from template, named after
+// it with the first free suffix. It serves the same namespaces as template.
+// EIPs attach to a single NLB, so the pool has none.
+func (s *NLBPoolScaler) scaledPool(template *nlbv1alpha1.NLBPool, names map[string]bool) (*nlbv1alpha1.NLBPool, error) {
+	name := ""
+	for i := 2; name == "" || names[name]; i++ {
+		name = fmt.Sprintf("%s-%d", template.Name, i)
+	}
+	pool := &nlbv1alpha1.NLBPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{labelScaledFrom: template.Name},
+		},
+		Spec: *template.Spec.DeepCopy(),
+	}
+	pool.Spec.LoadBalancerName = ""
+	pool.Spec.EIPAllocations = nil
+	pool.Spec.ScaleOut = nil
+	if err := controllerutil.SetControllerReference(template, pool, s.Scheme); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"testing"
+
+	nlbv1alpha1 "github.com/chinmayrelkar/aws-nlb-controller/api/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestScaledPool(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := nlbv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	template := &nlbv1alpha1.NLBPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared", UID: "uid"},
+		Spec: nlbv1alpha1.NLBPoolSpec{
+			LoadBalancerName: "shared-public",
+			Subnets:          []string{"subnet-a", "subnet-b"},
+			EIPAllocations:   []string{"eipalloc-a", "eipalloc-b"},
+			ScaleOut:         &nlbv1alpha1.NLBPoolScaleOut{MaxLoadBalancers: 3},
+		},
+	}
+	s := &NLBPoolScaler{Scheme: scheme}
+	pool, err := s.scaledPool(template, map[string]bool{"shared": true, "shared-2": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool.Name != "shared-3" || pool.Labels[labelScaledFrom] != "shared" {
+		t.Errorf("scaledPool() = %s labeled %v, want shared-3 scaled from shared", pool.Name, pool.Labels)
+	}
+	if pool.Spec.LoadBalancerName != "" || pool.Spec.EIPAllocations != nil || pool.Spec.ScaleOut != nil {
+		t.Errorf("scaledPool() spec = %+v, want no load balancer name, EIPs or scale-out", pool.Spec)
+	}
+	if len(pool.Spec.Subnets) != 2 || len(pool.OwnerReferences) != 1 {
+		t.Errorf("scaledPool() = %+v, want the subnets and owner of the template", pool)
+	}
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// SeedStoreFromServices records the allocations found in the annotations of
+// existing Services in the store, so that ports still in use by those
+// Services are not handed out again after a restart. It must run before the
+// reconciler starts allocating.
+func SeedStoreFromServices(ctx context.Context, reader client.Reader, s store.Store) error {
+	logger := log.FromContext(ctx)
+
+	var services corev1.ServiceList
+	if err := reader.List(ctx, &services); err != nil {
+		return err
+	}
+
+	for _, svc := range services.Items {
+		serviceName := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}.String()
+		for idx, servicePort := range svc.Spec.Ports {
+			key := portKey(servicePort, idx)
+			nlb := getPortAnnotation(&svc, nlbAnnotationNLBName, key, idx)
+			if nlb == "" {
+				continue
+			}
+			port, err := strconv.Atoi(getPortAnnotation(&svc, nlbAnnotationPort, key, idx))
+			if err != nil {
+				logger.Error(err, "malformed port in svc annotations. skipping", "svc", serviceName, "port", key)
+				continue
+			}
+			err = s.AssignNLBAndPortToServiceInNamespace(
+				ctx,
+				nlb,
+				port,
+				allocationKey(serviceName, key),
+				getPortAnnotation(&svc, nlbAnnotationListener, key, idx),
+				getPortAnnotation(&svc, nlbAnnotationTarget, key, idx),
+			)
+			if err != nil {
+				logger.Error(err, "unable to seed allocation", "svc", serviceName, "port", key, "nlb", nlb, "nlbPort", port)
+			}
+		}
+	}
+	return nil
+}
+
+// SeedStoreFromAWS records the allocations found in the tags of the listeners
+// on the managed NLBs in the store. Unlike SeedStoreFromServices it does not
+// depend on Service annotations having been written.
+func SeedStoreFromAWS(ctx context.Context, awsClient aws.Client, s store.Store) error {
+	logger := log.FromContext(ctx)
+
+	allocations, err := awsClient.ListAllocations(ctx, s.ListNLBs())
+	if err != nil {
+		return err
+	}
+
+	for _, allocation := range allocations {
+		// assigning a retained allocation again would no longer mark it
+		// retained
+		if existing := s.GetAllocationForSVC(ctx, allocation.ServiceNamespacedName); existing != nil && existing.Retained {
+			continue
+		}
+		err := s.AssignNLBAndPortToServiceInNamespace(
+			ctx,
+			allocation.NLB,
+			allocation.Port,
+			allocation.ServiceNamespacedName,
+			allocation.ListenerArn,
+			allocation.TargetArn,
+		)
+		if err != nil {
+			logger.Error(err, "unable to seed allocation", "svc", allocation.ServiceNamespacedName, "nlb", allocation.NLB, "nlbPort", allocation.Port)
+		}
+	}
+	return nil
+}
@@ -18,17 +18,34 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/chinmayrelkar/aws-nlb-controller/audit"
 	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+	"github.com/chinmayrelkar/aws-nlb-controller/features"
+	"github.com/chinmayrelkar/aws-nlb-controller/hooks"
 	"github.com/chinmayrelkar/aws-nlb-controller/store"
 
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
@@ -38,6 +55,42 @@ const (
 	nlbAnnotationPort     = "service-nlb-port"
 	nlbAnnotationListener = "service-nlb-listener"
 	nlbAnnotationTarget   = "service-nlb-target"
+	// nlbAnnotationProtocol selects the protocol of the listeners and target
+	// groups of every port of the svc. One of TCP (default), UDP or TCP_UDP,
+	// or TLS for target groups behind a certificate.
+	nlbAnnotationProtocol = "service-nlb-protocol"
+	// nlbAnnotationCertificate is the ARN of a certificate. The listeners of
+	// the svc terminate TLS with it and forward to TCP or TLS target groups.
+	nlbAnnotationCertificate = "service-nlb-certificate-arn"
+	// nlbAnnotationTargetType selects how traffic reaches the svc. instance
+	// (default) targets the NodePort on every node, ip targets the pods
+	// directly and does not need a NodePort.
+	nlbAnnotationTargetType = "service-nlb-target-type"
+	// nlbAnnotationPaused set to "true" freezes the svc: its listeners,
+	// target groups, annotations and allocations are left as they are, so
+	// that operators can work on them without the controller reverting it.
+	nlbAnnotationPaused = "service-nlb-paused"
+	// nlbAnnotationDeletionPolicy selects what happens to the listeners and
+	// target groups of the svc when it is deleted. Delete (default) deletes
+	// them and frees their ports, Retain leaves them in place and keeps their
+	// ports allocated, to be picked up again by a svc of the same name.
+	nlbAnnotationDeletionPolicy = "service-nlb-deletion-policy"
+
+	deletionPolicyRetain = "Retain"
+
+	// nlbAnnotationAdoptListener is the ARN of an existing listener, such as
+	// one created by Terraform, that the controller adopts for a port of the
+	// svc instead of allocating one. Suffixed with the port like the
+	// allocation annotations.
+	nlbAnnotationAdoptListener = "service-nlb-adopt-listener"
+
+	// nlbAnnotationPinnedNLB is the name of the NLB the ports of the svc are
+	// allocated on when the store uses the annotation-pinned strategy.
+	nlbAnnotationPinnedNLB = "service-nlb-pinned-nlb"
+
+	// serviceFinalizer blocks deletion of an annotated svc until its
+	// listeners and target groups have been deleted
+	serviceFinalizer = "nlb.chinmayrelkar.github.com/cleanup"
 )
 
 // ServiceReconciler reconciles a Service object
@@ -46,44 +99,156 @@ type ServiceReconciler struct {
 	Scheme    *runtime.Scheme
 	Store     store.Store
 	AwsClient aws.Client
+
+	// StoreReady, if set, is closed once Store has been loaded. Reconciles
+	// wait for it so that no port is handed out before existing allocations
+	// are known.
+	StoreReady <-chan struct{}
+
+	// ManageDNS enables the Route53 records of the dns-name annotation.
+	ManageDNS bool
+
+	// ExternalDNSAnnotations enables the external-dns annotations of the svc,
+	// for clusters whose DNS records are managed by external-dns.
+	ExternalDNSAnnotations bool
+
+	// LoadBalancerClass, if set, makes the controller claim LoadBalancer
+	// Services of this spec.loadBalancerClass as if they were annotated.
+	LoadBalancerClass string
+
+	// MaxConcurrentReconciles is the number of services reconciled in
+	// parallel. The store serializes allocations, so any value is safe.
+	// Defaults to 1.
+	MaxConcurrentReconciles int
+
+	// Nodes lists the nodes registered in instance target groups. Defaults
+	// to the Nodes of the cluster.
+	Nodes NodeSource
+
+	// Recorder, if set, emits Events on the reconciled services.
+	Recorder record.EventRecorder
+
+	// Resync, if set, delivers services to reconcile outside of watch
+	// events, such as services whose allocations have drifted.
+	Resync <-chan event.GenericEvent
+
+	// Scaler, if set, provisions more NLBs from the NLBPools that allow it
+	// when no NLB has a free port left.
+	Scaler *NLBPoolScaler
+
+	// Hooks, if set, notifies external systems of allocated and released
+	// ports.
+	Hooks *hooks.Dispatcher
+
+	// Audit, if set, records allocated, reallocated and released ports.
+	Audit *audit.Trail
+
+	// StickyAllocations keeps the ports of deleted svcs bound to their
+	// names for the sticky retention of Store, so that a svc recreated in
+	// the meantime gets its NLB and port back.
+	StickyAllocations bool
+
+	// DrainTimeout is how long reconciles in flight when the manager shuts
+	// down may take to finish. Reconciles that did not start before the
+	// shutdown are not started. Zero cancels them with the manager.
+	DrainTimeout time.Duration
+
+	backoff requeueBackoff
 }
 
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=services/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=core,resources=services/finalizers,verbs=update
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;create;update
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;create;update;delete
+// +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
+// +kubebuilder:rbac:groups=nlb.chinmayrelkar.github.com,resources=nlballocations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=nlb.chinmayrelkar.github.com,resources=nlbpools,verbs=get;list;watch;create
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
 //
+// Failed reconciles are retried after an exponential backoff that depends on
+// the kind of error, instead of right away, so that an AWS outage or
+// throttling is not made worse by retries. Errors are still returned so that
+// controller-runtime logs and counts them; the backoff is the workqueue's
+// rate limiter. Terminal errors, which retrying does not fix, are logged and
+// not returned, so that the svc is reconciled again only once it changes.
+//
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
 func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	result, err := r.reconcile(ctx, req)
+	if err == nil && !result.Requeue {
+		return result, nil
+	}
+	if err == nil {
+		r.backoff.observe(req.NamespacedName, errors.New("requeue requested"))
+		return result, nil
+	}
+	if errors.Is(err, context.Canceled) {
+		return ctrl.Result{}, err
+	}
+	class := classifyError(err)
+	reconcileErrorsTotal.WithLabelValues(string(class)).Inc()
+	if class == errorClassTerminal {
+		r.backoff.forget(req.NamespacedName)
+		log.FromContext(ctx).Error(err, "reconcile failed. Not retrying until the svc changes",
+			"svc", req.NamespacedName.String(), "class", class)
+		return ctrl.Result{}, nil
+	}
+	r.backoff.observe(req.NamespacedName, err)
+	log.FromContext(ctx).V(1).Info("reconcile failed", "svc", req.NamespacedName.String(), "class", class)
+	return ctrl.Result{}, err
+}
+
+func (r *ServiceReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	serviceName := req.NamespacedName.String()
 	logger := log.FromContext(ctx)
 	logger = logger.WithValues("svc", serviceName)
+	ctx = log.IntoContext(ctx, logger)
+
+	if r.StoreReady != nil {
+		select {
+		case <-r.StoreReady:
+		case <-ctx.Done():
+			return ctrl.Result{}, ctx.Err()
+		}
+	}
+	if ctx.Err() != nil {
+		// shutting down. Left to the next leader
+		return ctrl.Result{}, ctx.Err()
+	}
+	if r.DrainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = withDrainTimeout(ctx, r.DrainTimeout)
+		defer cancel()
+	}
 
 	// got a svc event
 	// if svc exists then it was created/updated or controller has just started
-	// if svc doesn't exist then delete listener, target group, release port for nlb in memory
+	// if svc doesn't exist then delete listeners, target groups, release ports for nlb in memory
 
 	var svc corev1.Service
+	start := time.Now()
 	err := r.Get(ctx, req.NamespacedName, &svc)
+	observePhase(phaseFetchService, start)
 	if err != nil && apierrors.IsNotFound(err) {
 		logger.Info("svc does not exist")
 		logger.Info("Deleting listener and target groups")
-		allocation := r.Store.GetAllocationForSVC(ctx, serviceName)
-		if allocation == nil {
+		allocations := r.Store.GetAllocationsForSVC(ctx, serviceName)
+		if len(allocations) == 0 {
 			logger.Info("no allocation found")
 			return ctrl.Result{}, nil
 		}
 
-		err := r.AwsClient.DeleteListenerAndTargetArn(allocation.ListenerArn, allocation.TargetArn)
-		if err != nil {
-			return ctrl.Result{Requeue: true}, err
+		for _, allocation := range allocations {
+			if err := r.releaseDeleted(ctx, nil, allocation, "svc deleted"); err != nil {
+				return ctrl.Result{Requeue: true}, err
+			}
 		}
-
-		logger.Info("Releasing Port on NLB in memory")
-		r.Store.ReleaseNLBAndPortForService(ctx, serviceName, allocation.NLB, allocation.Port)
 		return ctrl.Result{}, nil
 	}
 
@@ -93,126 +258,726 @@ func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{Requeue: true}, err
 	}
 
+	// a paused svc is left alone, also when it is being deleted: its
+	// finalizer then holds the deletion until it is unpaused
+	if isPaused(&svc) {
+		logger.Info("svc is paused. Skipping")
+		return ctrl.Result{}, nil
+	}
+
+	// svc is being deleted. release its allocations before letting it go
+	if !svc.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&svc, serviceFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		logger.Info("svc is being deleted")
+		retain := svc.Annotations[nlbAnnotationDeletionPolicy] == deletionPolicyRetain
+		if retain {
+			logger.Info("Retaining listener and target groups")
+		} else {
+			logger.Info("Deleting listener and target groups")
+		}
+		for _, allocation := range r.Store.GetAllocationsForSVC(ctx, serviceName) {
+			if retain {
+				if err := r.Store.RetainNLBAndPortForService(ctx, allocation.ServiceNamespacedName); err != nil {
+					logger.Error(err, "unable to retain listener and target group", "allocation", allocation.ServiceNamespacedName)
+					return ctrl.Result{Requeue: true}, err
+				}
+				continue
+			}
+			if err := r.releaseDeleted(ctx, &svc, allocation, "svc deleted"); err != nil {
+				logger.Error(err, "unable to delete listener and target group", "allocation", allocation.ServiceNamespacedName)
+				return ctrl.Result{Requeue: true}, err
+			}
+		}
+		if err := r.deleteDNS(ctx, &svc, serviceName); err != nil {
+			return ctrl.Result{Requeue: true}, err
+		}
+		controllerutil.RemoveFinalizer(&svc, serviceFinalizer)
+		if err := r.Update(ctx, &svc); err != nil && !apierrors.IsNotFound(err) {
+			logger.Error(err, "unable to remove finalizer")
+			return ctrl.Result{Requeue: true}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
 	// svc found
-	svcIsOfTypeNodePort := svc.Spec.Type == corev1.ServiceTypeNodePort
-	if !svcIsOfTypeNodePort {
-		logger.Info("svc not of type NodePort. Skipping")
+	protocol, _ := annotation(&svc, nlbAnnotationProtocol)
+	if !aws.ValidProtocol(protocol) {
+		logger.Info("unsupported protocol in svc annotations. Skipping", "protocol", protocol)
+		return ctrl.Result{}, nil
+	}
+	certificate, _ := annotation(&svc, nlbAnnotationCertificate)
+	switch {
+	case protocol == "TLS" && certificate == "":
+		logger.Info("TLS target groups need a certificate in svc annotations. Skipping")
+		return ctrl.Result{}, nil
+	case certificate != "" && protocol != "" && protocol != "TCP" && protocol != "TLS":
+		logger.Info("listeners with a certificate need TCP or TLS target groups. Skipping", "protocol", protocol)
+		return ctrl.Result{}, nil
+	}
+	if (protocol == "TLS" || certificate != "") && !features.Enabled(features.TLSListeners) {
+		logger.Info("TLS listeners are disabled by the TLSListeners feature gate. Skipping")
+		return ctrl.Result{}, nil
+	}
+	targetType := svc.Annotations[nlbAnnotationTargetType]
+	if !aws.ValidTargetType(targetType) {
+		logger.Info("unsupported target type in svc annotations. Skipping", "targetType", targetType)
+		return ctrl.Result{}, nil
+	}
+	if isIPTargetType(&svc) && !features.Enabled(features.IPTargets) {
+		logger.Info("ip targets are disabled by the IPTargets feature gate. Skipping")
 		return ctrl.Result{}, nil
 	}
 
-	// check annotation
-	isNodePortService := svc.Annotations[serviceAnnotation] == "true"
-	isNLBPortAllocated := svc.Annotations[nlbAnnotationNLBName] != ""
+	if _, err := healthCheck(&svc); err != nil {
+		logger.Info("invalid health check in svc annotations. Skipping", "reason", err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	if _, err := targetGroupAttributes(&svc); err != nil {
+		logger.Info("invalid target group attributes in svc annotations. Skipping", "reason", err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	// ip targets are the pods themselves, so they work for any svc type
+	classLoadBalancer := isClassLoadBalancer(&svc, r.LoadBalancerClass)
+	svcIsOfTypeNodePort := svc.Spec.Type == corev1.ServiceTypeNodePort || classLoadBalancer
+	if !svcIsOfTypeNodePort && !isIPTargetType(&svc) {
+		logger.Info("svc not of type NodePort. Skipping")
+		return r.deallocate(ctx, &svc, serviceName)
+	}
+	if !isIPTargetType(&svc) && !hasNodePorts(&svc) {
+		logger.Info("svc has ports without a NodePort. Skipping")
+		return r.deallocate(ctx, &svc, serviceName)
+	}
 
+	// check annotation
+	isNodePortService := svc.Annotations[serviceAnnotation] == "true" || classLoadBalancer
 	if !isNodePortService {
+		// the opt-in annotation was removed or set to false. Whatever was
+		// allocated for the svc is deprovisioned
 		logger.Info("svc not a NodePort service. Skipping")
+		return r.deallocate(ctx, &svc, serviceName)
+	}
+
+	// make sure deletion of the svc waits for the listeners to be deleted
+	// before creating any
+	if isNodePortService && !controllerutil.ContainsFinalizer(&svc, serviceFinalizer) {
+		controllerutil.AddFinalizer(&svc, serviceFinalizer)
+		if err := r.Update(ctx, &svc); err != nil {
+			logger.Error(err, "unable to add finalizer")
+			return ctrl.Result{Requeue: true}, err
+		}
+	}
+
+	// If the label should be set but is not, set it.
+	if svc.Annotations == nil {
+		svc.Annotations = make(map[string]string)
+	}
+	original := make(map[string]string, len(svc.Annotations))
+	for k, v := range svc.Annotations {
+		original[k] = v
+	}
+
+	// every port of the svc gets its own nlb port, listener and target group
+	wanted := map[string]bool{}
+	var created []*store.Allocation
+	for idx, port := range svc.Spec.Ports {
+		key := portKey(port, idx)
+		wanted[allocationKey(serviceName, key)] = true
+
+		allocation, err := r.reconcilePort(ctx, &svc, serviceName, key, idx, port)
+		if allocation != nil {
+			created = append(created, allocation)
+		}
+		if err != nil {
+			r.rollback(ctx, created)
+			return ctrl.Result{Requeue: true}, err
+		}
+	}
+
+	// ports removed from the svc spec release their allocation
+	for _, allocation := range r.Store.GetAllocationsForSVC(ctx, serviceName) {
+		if wanted[allocation.ServiceNamespacedName] {
+			continue
+		}
+		logger.Info("port removed from svc. releasing", "allocation", allocation.ServiceNamespacedName)
+		if err := r.releaseAllocation(ctx, &svc, allocation, "port removed from svc"); err != nil {
+			r.rollback(ctx, created)
+			return ctrl.Result{Requeue: true}, err
+		}
+		_, key, _ := strings.Cut(allocation.ServiceNamespacedName, ":")
+		removePortAnnotations(&svc, key)
+	}
+
+	if err := r.reconcileDNS(ctx, &svc, serviceName); err != nil {
+		r.rollback(ctx, created)
+		return ctrl.Result{Requeue: true}, err
+	}
+	r.setExternalDNSAnnotations(&svc)
+
+	if reflect.DeepEqual(original, svc.Annotations) {
+		logger.Info("Validation successful. Skipping")
+		return ctrl.Result{}, r.updateStatus(ctx, &svc)
+	}
+
+	start = time.Now()
+	err = r.Update(ctx, &svc)
+	observePhase(phaseUpdateService, start)
+	if err != nil {
+		logger.Error(err, "unable to update svc")
+		if !r.rollback(ctx, created) {
+			return ctrl.Result{Requeue: false}, err
+		}
+
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{Requeue: false}, nil
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+	logger.Info("Load balancer assigned and label added")
+	return ctrl.Result{}, r.updateStatus(ctx, &svc)
+}
+
+// updateStatus reports the NLBs of the svc in its status, so that
+// `kubectl get svc` and tools reading status.loadBalancer find them like
+// for LoadBalancer Services.
+func (r *ServiceReconciler) updateStatus(ctx context.Context, svc *corev1.Service) error {
+	status := loadBalancerStatus(svc)
+	if equality.Semantic.DeepEqual(svc.Status.LoadBalancer, status) {
+		return nil
+	}
+	svc.Status.LoadBalancer = status
+	if err := r.Status().Update(ctx, svc); err != nil && !apierrors.IsNotFound(err) {
+		log.FromContext(ctx).Error(err, "unable to update svc status")
+		return err
+	}
+	return nil
+}
+
+// reconcilePort makes sure one port of the svc has a valid allocation and
+// that its annotations reflect it. The allocation is returned if a new one had
+// to be created, even if the port could not be fully reconciled.
+func (r *ServiceReconciler) reconcilePort(
+	ctx context.Context,
+	svc *corev1.Service,
+	serviceName string,
+	key string,
+	idx int,
+	port corev1.ServicePort,
+) (*store.Allocation, error) {
+	name := allocationKey(serviceName, key)
+	nodePort := int(port.NodePort)
+	logger := log.FromContext(ctx).WithValues("port", key)
+
+	isNLBPortAllocated := getPortAnnotation(svc, nlbAnnotationNLBName, key, idx) != ""
+	action, reason := audit.Allocated, "new svc port"
+
+	// a svc recreated after a deletion that retained its listeners gets them
+	// back. Checking them below assigns them to the svc again
+	if retained := r.Store.GetAllocationForSVC(ctx, name); !isNLBPortAllocated && retained != nil && retained.Retained {
+		logger.Info("Reusing retained listener", "listener", retained.ListenerArn)
+		setPortAnnotations(svc, key, idx, map[string]string{
+			nlbAnnotationNLBName:  retained.NLB,
+			nlbAnnotationNLBHost:  r.Store.GetNLBHost(retained.NLB),
+			nlbAnnotationPort:     strconv.Itoa(retained.Port),
+			nlbAnnotationListener: retained.ListenerArn,
+			nlbAnnotationTarget:   retained.TargetArn,
+		})
+		isNLBPortAllocated = true
+	}
+
+	// an existing listener is adopted once, after which it is checked like
+	// the listeners the controller created
+	if adopt := getPortAnnotation(svc, nlbAnnotationAdoptListener, key, idx); !isNLBPortAllocated && adopt != "" {
+		if err := r.adoptListener(ctx, svc, name, key, idx, adopt, listenerSpec(svc, name, "", 0, nodePort)); err != nil {
+			logger.Error(err, "unable to adopt listener", "listener", adopt)
+			return nil, err
+		}
+		isNLBPortAllocated = true
 	}
 
 	// svc is a Node Port svc
 	if isNLBPortAllocated {
 		logger.Info("NodePort already allocated.")
-		svcAllocatedListenerArn := svc.Annotations[nlbAnnotationListener]
-		svcAllocatedTargetArn := svc.Annotations[nlbAnnotationTarget]
-		svcAllocatedNLB := svc.Annotations[nlbAnnotationNLBName]
-		svcAllocatedNodePort := int(svc.Spec.Ports[0].NodePort)
+		svcAllocatedListenerArn := getPortAnnotation(svc, nlbAnnotationListener, key, idx)
+		svcAllocatedTargetArn := getPortAnnotation(svc, nlbAnnotationTarget, key, idx)
+		svcAllocatedNLB := getPortAnnotation(svc, nlbAnnotationNLBName, key, idx)
 
-		svcAllocatedPort, err := strconv.Atoi(svc.Annotations[nlbAnnotationPort])
+		svcAllocatedPort, err := strconv.Atoi(getPortAnnotation(svc, nlbAnnotationPort, key, idx))
 		if err != nil {
 			logger.Error(err, "malformed port in svc labels. reallocating")
+			action, reason = audit.Reallocated, "malformed port annotation"
 		} else {
+			start := time.Now()
 			err := r.checkAllocationValidity(
 				ctx,
-				serviceName,
+				name,
 				svcAllocatedListenerArn,
 				svcAllocatedTargetArn,
-				svcAllocatedNLB,
-				svcAllocatedPort,
-				svcAllocatedNodePort,
+				listenerSpec(svc, name, svcAllocatedNLB, svcAllocatedPort, nodePort),
 			)
-			if err != nil {
+			observePhase(phaseCheckListener, start)
+			if errors.Is(err, aws.ErrTargetPortChanged) {
+				svcAllocatedTargetArn, err = r.retarget(
+					ctx,
+					svc,
+					port,
+					name,
+					svcAllocatedListenerArn,
+					svcAllocatedTargetArn,
+					listenerSpec(svc, name, svcAllocatedNLB, svcAllocatedPort, nodePort),
+				)
+			}
+			if err != nil && !errors.Is(err, aws.ErrDrifted) && !errors.Is(err, store.ErrUnavailable) {
+				return nil, err
+			}
+			if errors.Is(err, store.ErrUnavailable) {
+				// the port belongs to another svc, such as the one this svc
+				// was cloned from with its nlb annotations. Its listener is
+				// left alone and this svc gets a port of its own
+				logger.Info("nlb port of svc annotations is not available. reallocating", "reason", err.Error())
+				removePortAnnotations(svc, key)
+				action, reason = audit.Reallocated, "port unavailable: "+err.Error()
+			} else if err != nil {
 				logger.Error(err, "reallocating")
+				if r.Recorder != nil {
+					r.Recorder.Eventf(svc, corev1.EventTypeWarning, "Drifted",
+						"listener %s of port %s no longer matches the svc: %s. Reallocating", svcAllocatedListenerArn, key, err)
+				}
+				action, reason = audit.Reallocated, "drifted: "+err.Error()
+				r.releaseDrifted(ctx, svc, name, svcAllocatedListenerArn, svcAllocatedTargetArn, reason)
 			} else {
-				logger.Info("Validation successful. Skipping")
-				return ctrl.Result{}, nil
+				setPortAnnotations(svc, key, idx, map[string]string{
+					nlbAnnotationNLBName:  svcAllocatedNLB,
+					nlbAnnotationNLBHost:  getPortAnnotation(svc, nlbAnnotationNLBHost, key, idx),
+					nlbAnnotationPort:     strconv.Itoa(svcAllocatedPort),
+					nlbAnnotationListener: svcAllocatedListenerArn,
+					nlbAnnotationTarget:   svcAllocatedTargetArn,
+				})
+				certificate, _ := annotation(svc, nlbAnnotationCertificate)
+				if err := r.AwsClient.SyncListenerCertificate(ctx, svcAllocatedListenerArn, certificate); err != nil {
+					logger.Error(err, "unable to sync listener certificate")
+					return nil, err
+				}
+				return nil, r.syncTargetGroup(ctx, svc, port, svcAllocatedTargetArn)
 			}
 		}
 	}
 
-	// If the label should be set but is not, set it.
-	if svc.Annotations == nil {
-		svc.Annotations = make(map[string]string)
+	binding, err := namespaceBinding(ctx, r, svc.Namespace)
+	if err != nil {
+		logger.Error(err, "unable to find the nlbpools of the namespace")
+		return nil, err
 	}
-
-	nlb, nlbPort, err := r.Store.GetVacantNLBAndPortForService(ctx, serviceName)
+	allocateCtx := ctx
+	if pinned, ok := annotation(svc, nlbAnnotationPinnedNLB); ok {
+		allocateCtx = store.WithPinnedNLB(ctx, pinned)
+	}
+	start := time.Now()
+	nlb, nlbPort, err := r.Store.GetVacantNLBAndPortForService(allocateCtx, name, binding.filter())
+	observePhase(phaseAllocate, start)
 	if err != nil {
 		logger.Error(err, "unable to get vacant nlb and port")
-		return ctrl.Result{Requeue: true}, err
+		full := errors.Is(err, store.ErrNoVacancy) || errors.Is(err, store.ErrListenerQuota)
+		if full && r.Scaler != nil {
+			scaling, scaleErr := r.Scaler.ScaleOut(ctx, binding.allowsPool)
+			if scaleErr != nil {
+				logger.Error(scaleErr, "unable to scale out nlbpools")
+			}
+			if scaling {
+				if r.Recorder != nil {
+					r.Recorder.Eventf(svc, corev1.EventTypeNormal, "WaitingForNLB",
+						"every nlb is full. Waiting for a new nlb for port %s", key)
+				}
+				return nil, err
+			}
+		}
+		switch {
+		case r.Recorder == nil:
+		case errors.Is(err, store.ErrListenerQuota):
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, "ListenerQuotaReached",
+				"no listener can be added for port %s: %s. Add an NLB or raise the listeners per NLB quota", key, err)
+		case errors.Is(err, store.ErrNoVacancy):
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, "PortExhausted",
+				"no free port for port %s on any NLB. Add an NLB or widen the port ranges", key)
+		}
+		return nil, err
 	}
 
-	nodePort := int(svc.Spec.Ports[0].NodePort)
 	logger = logger.WithValues("nlb", nlb, "nlbPort", nlbPort, "nodePort", nodePort)
 
-	listenerArn, targetArn, err := r.AwsClient.CreateNLBListenerForPort(
-		nlb,
-		nlbPort,
-		nodePort,
-		req.NamespacedName.String(),
-	)
+	start = time.Now()
+	listenerArn, targetArn, err := r.AwsClient.CreateNLBListenerForPort(ctx, listenerSpec(svc, name, nlb, nlbPort, nodePort))
+	observePhase(phaseCreateListener, start)
 	if err != nil {
 		logger.Error(err, "unable to create listener nlb ")
-		r.Store.ReleaseNLBAndPortForService(ctx, serviceName, nlb, nlbPort)
-		return ctrl.Result{Requeue: true}, err
+		r.Store.ReleaseNLBAndPortForService(ctx, name, nlb, nlbPort)
+		return nil, err
 	}
 
 	err = r.Store.AssignNLBAndPortToServiceInNamespace(
 		ctx,
 		nlb,
 		nlbPort,
-		serviceName,
+		name,
 		listenerArn,
 		targetArn,
 	)
 	if err != nil {
 		logger.Error(err, "unable to save listener nlb allocation")
-		r.Store.ReleaseNLBAndPortForService(ctx, serviceName, nlb, nlbPort)
-		err2 := r.AwsClient.DeleteListenerAndTargetArn(listenerArn, targetArn)
+		r.Store.ReleaseNLBAndPortForService(ctx, name, nlb, nlbPort)
+		err2 := r.AwsClient.DeleteListenerAndTargetArn(ctx, name, listenerArn, targetArn)
 		if err2 != nil {
 			logger.Error(err2, "SEV0: failed to delete listener for a failed allocation")
-			return ctrl.Result{Requeue: false}, err2
+			r.Hooks.Notify(ctx, hooks.Event{
+				Type: hooks.SEV0, Service: name, NLB: nlb, Port: nlbPort, ListenerArn: listenerArn, TargetArn: targetArn,
+				Message: "failed to delete listener for a failed allocation: " + err2.Error(),
+			})
+			return nil, err2
 		}
-		return ctrl.Result{Requeue: true}, err
+		return nil, err
 	}
+	r.notifyAllocated(ctx, action, reason, name, nlb, nlbPort, listenerArn, targetArn)
+
+	setPortAnnotations(svc, key, idx, map[string]string{
+		nlbAnnotationNLBName:  nlb,
+		nlbAnnotationNLBHost:  r.Store.GetNLBHost(nlb),
+		nlbAnnotationPort:     strconv.Itoa(nlbPort),
+		nlbAnnotationListener: listenerArn,
+		nlbAnnotationTarget:   targetArn,
+	})
+	allocation := r.Store.GetAllocationForSVC(ctx, name)
+	if err := r.syncTargetGroup(ctx, svc, port, targetArn); err != nil {
+		return allocation, err
+	}
+	return allocation, nil
+}
 
-	svc.Annotations[nlbAnnotationNLBName] = nlb
-	svc.Annotations[nlbAnnotationNLBHost] = r.Store.GetNLBHost(nlb)
-	svc.Annotations[nlbAnnotationPort] = strconv.Itoa(nlbPort)
-	svc.Annotations[nlbAnnotationListener] = listenerArn
-	svc.Annotations[nlbAnnotationTarget] = targetArn
+// adoptListener adopts an existing listener for a port of svc, records it in
+// the store and writes the allocation annotations of the port. Listeners that
+// do not match the port, are owned elsewhere or sit on an NLB the store does
+// not manage are left alone, with a Warning Event on svc.
+func (r *ServiceReconciler) adoptListener(
+	ctx context.Context,
+	svc *corev1.Service,
+	name string,
+	key string,
+	idx int,
+	listenerArn string,
+	spec aws.ListenerSpec,
+) error {
+	adopted, err := r.AwsClient.AdoptListener(ctx, listenerArn, spec)
+	if err == nil {
+		err = r.Store.AssignNLBAndPortToServiceInNamespace(ctx, adopted.NLB, adopted.Port, name, adopted.ListenerArn, adopted.TargetArn)
+	}
+	if err != nil {
+		if r.Recorder != nil {
+			r.Recorder.Eventf(svc, corev1.EventTypeWarning, "AdoptionFailed",
+				"unable to adopt listener %s for port %s: %s", listenerArn, key, err)
+		}
+		return err
+	}
+	log.FromContext(ctx).Info("Adopted listener", "listener", listenerArn, "nlb", adopted.NLB, "nlbPort", adopted.Port)
+	r.notifyAllocated(ctx, audit.Allocated, "adopted listener", name, adopted.NLB, adopted.Port, adopted.ListenerArn, adopted.TargetArn)
+	if r.Recorder != nil {
+		r.Recorder.Eventf(svc, corev1.EventTypeNormal, "Adopted",
+			"adopted listener %s on port %d of nlb %s for port %s", listenerArn, adopted.Port, adopted.NLB, key)
+	}
+	setPortAnnotations(svc, key, idx, map[string]string{
+		nlbAnnotationNLBName:  adopted.NLB,
+		nlbAnnotationNLBHost:  r.Store.GetNLBHost(adopted.NLB),
+		nlbAnnotationPort:     strconv.Itoa(adopted.Port),
+		nlbAnnotationListener: adopted.ListenerArn,
+		nlbAnnotationTarget:   adopted.TargetArn,
+	})
+	return nil
+}
 
-	if err := r.Update(ctx, &svc); err != nil {
-		logger.Error(err, "unable to update svc")
+// retarget points the listener of an allocation whose NodePort changed at a
+// target group on the new NodePort, so that the svc keeps its NLB port. The
+// port is assigned to the svc first, so that a svc carrying the annotations
+// of another, such as a clone of its manifest, fails with
+// store.ErrUnavailable before the listener of the other svc is touched. The
+// targets of the new target group are registered before the listener
+// forwards to it. It returns the new target group, or targetArn if it failed.
+func (r *ServiceReconciler) retarget(
+	ctx context.Context,
+	svc *corev1.Service,
+	port corev1.ServicePort,
+	name string,
+	listenerArn string,
+	targetArn string,
+	spec aws.ListenerSpec,
+) (string, error) {
+	err := r.Store.AssignNLBAndPortToServiceInNamespace(ctx, spec.NLB, spec.Port, name, listenerArn, targetArn)
+	if err != nil {
+		return targetArn, err
+	}
+	newTargetArn, err := r.AwsClient.EnsureTargetGroup(ctx, spec)
+	if err != nil {
+		return targetArn, err
+	}
+	if err := r.syncTargetGroup(ctx, svc, port, newTargetArn); err != nil {
+		return targetArn, err
+	}
+	if err := r.AwsClient.RetargetListener(ctx, listenerArn, targetArn, newTargetArn); err != nil {
+		return targetArn, err
+	}
+	err = r.Store.AssignNLBAndPortToServiceInNamespace(ctx, spec.NLB, spec.Port, name, listenerArn, newTargetArn)
+	if err != nil {
+		return newTargetArn, err
+	}
+	log.FromContext(ctx).Info("Moved listener to the target group of the new NodePort", "listener", listenerArn, "targetGroup", newTargetArn)
+	r.Audit.Record(ctx, audit.Record{
+		Action: audit.Reallocated, Reason: fmt.Sprintf("NodePort changed to %d", spec.NodePort),
+		Service: name, NLB: spec.NLB, Port: spec.Port, ListenerArn: listenerArn, TargetArn: newTargetArn,
+	})
+	if r.Recorder != nil {
+		r.Recorder.Eventf(svc, corev1.EventTypeNormal, "Retargeted",
+			"NodePort of port %s changed to %d. Listener %s keeps nlb port %d", name, spec.NodePort, listenerArn, spec.Port)
+	}
+	return newTargetArn, nil
+}
 
-		r.Store.ReleaseNLBAndPortForService(ctx, req.NamespacedName.String(), "", 0)
-		err2 := r.AwsClient.DeleteListenerAndTargetArn(listenerArn, targetArn)
-		if err2 != nil {
-			logger.Error(err2, "SEV0: failed to delete listener for a failed svc object update")
-			return ctrl.Result{Requeue: false}, err2
+// deallocate cleans up after a svc that is no longer served, such as after
+// its type changed away from NodePort or its opt-in annotation was removed:
+// its listeners, target groups and DNS record are deleted, its ports
+// released, and its nlb annotations and finalizer removed.
+func (r *ServiceReconciler) deallocate(ctx context.Context, svc *corev1.Service, serviceName string) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	allocations := r.Store.GetAllocationsForSVC(ctx, serviceName)
+	if len(allocations) == 0 && !hasNLBAnnotations(svc) && !controllerutil.ContainsFinalizer(svc, serviceFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	logger.Info("Deleting listener and target groups of unserved svc")
+	for _, allocation := range allocations {
+		if err := r.releaseAllocation(ctx, svc, allocation, "svc no longer served"); err != nil {
+			logger.Error(err, "unable to delete listener and target group", "allocation", allocation.ServiceNamespacedName)
+			return ctrl.Result{Requeue: true}, err
 		}
-
+	}
+	if err := r.deleteDNS(ctx, svc, serviceName); err != nil {
+		return ctrl.Result{Requeue: true}, err
+	}
+	removeNLBAnnotations(svc)
+	if r.ExternalDNSAnnotations {
+		if svc.Annotations[externalDNSAnnotationHostname] == svc.Annotations[nlbAnnotationDNSName] {
+			delete(svc.Annotations, externalDNSAnnotationHostname)
+		}
+		delete(svc.Annotations, externalDNSAnnotationTarget)
+	}
+	controllerutil.RemoveFinalizer(svc, serviceFinalizer)
+	if err := r.Update(ctx, svc); err != nil {
 		if apierrors.IsNotFound(err) {
-			return ctrl.Result{Requeue: false}, nil
+			return ctrl.Result{}, nil
 		}
-		return ctrl.Result{Requeue: true}, nil
+		logger.Error(err, "unable to remove nlb annotations")
+		return ctrl.Result{Requeue: true}, err
+	}
+	// the status of LoadBalancer Services belongs to their load balancer
+	// controller
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return ctrl.Result{}, r.updateStatus(ctx, svc)
 	}
-	logger.Info("Load balancer assigned and label added")
 	return ctrl.Result{}, nil
 }
 
+// releaseAllocation deletes the listener and target group of an allocation
+// and frees its port, for reason. Resources the controller does not own are
+// left in place, with a Warning Event on svc if it still exists.
+func (r *ServiceReconciler) releaseAllocation(ctx context.Context, svc *corev1.Service, allocation *store.Allocation, reason string) error {
+	if err := r.deleteListener(ctx, svc, allocation); err != nil {
+		return err
+	}
+
+	log.FromContext(ctx).Info("Releasing Port on NLB in memory", "allocation", allocation.ServiceNamespacedName)
+	r.Store.ReleaseNLBAndPortForService(ctx, allocation.ServiceNamespacedName, allocation.NLB, allocation.Port)
+	r.notifyReleased(ctx, reason, allocation.ServiceNamespacedName, allocation.NLB, allocation.Port, allocation.ListenerArn, allocation.TargetArn)
+	return nil
+}
+
+// releaseDeleted releases the allocation of a deleted svc like
+// releaseAllocation. With StickyAllocations its port stays bound to the svc
+// instead, once its listener and target group are deleted. Allocations that
+// are already sticky are left alone.
+func (r *ServiceReconciler) releaseDeleted(ctx context.Context, svc *corev1.Service, allocation *store.Allocation, reason string) error {
+	if allocation.Sticky {
+		return nil
+	}
+	if !r.StickyAllocations {
+		return r.releaseAllocation(ctx, svc, allocation, reason)
+	}
+	if err := r.deleteListener(ctx, svc, allocation); err != nil {
+		return err
+	}
+
+	log.FromContext(ctx).Info("Keeping port of deleted svc", "allocation", allocation.ServiceNamespacedName)
+	if err := r.Store.StickNLBAndPortForService(ctx, allocation.ServiceNamespacedName); err != nil {
+		return err
+	}
+	r.notifyReleased(ctx, reason+", port kept sticky", allocation.ServiceNamespacedName, allocation.NLB, allocation.Port, allocation.ListenerArn, allocation.TargetArn)
+	return nil
+}
+
+// deleteListener deletes the listener and target group of an allocation.
+// Sticky allocations have none.
+func (r *ServiceReconciler) deleteListener(ctx context.Context, svc *corev1.Service, allocation *store.Allocation) error {
+	if allocation.Sticky {
+		return nil
+	}
+	err := r.AwsClient.DeleteListenerAndTargetArn(ctx, allocation.ServiceNamespacedName, allocation.ListenerArn, allocation.TargetArn)
+	if errors.Is(err, aws.ErrNotOwned) {
+		log.FromContext(ctx).Info("refusing to delete listener", "allocation", allocation.ServiceNamespacedName, "reason", err.Error())
+		r.refusedDelete(svc, err)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// releaseDrifted frees the port of an allocation that no longer matches its
+// svc, and deletes what is left of its listener and target group so that the
+// port can be reallocated. Failures are logged only, as the resources may
+// already be gone.
+func (r *ServiceReconciler) releaseDrifted(ctx context.Context, svc *corev1.Service, name string, listenerArn string, targetArn string, reason string) {
+	if allocation := r.Store.GetAllocationForSVC(ctx, name); allocation != nil {
+		r.Store.ReleaseNLBAndPortForService(ctx, name, allocation.NLB, allocation.Port)
+		r.notifyReleased(ctx, reason, name, allocation.NLB, allocation.Port, listenerArn, targetArn)
+	}
+	err := r.AwsClient.DeleteListenerAndTargetArn(ctx, name, listenerArn, targetArn)
+	if errors.Is(err, aws.ErrNotOwned) {
+		r.refusedDelete(svc, err)
+	}
+	if err != nil {
+		log.FromContext(ctx).Info("unable to delete drifted listener", "listener", listenerArn, "reason", err.Error())
+	}
+}
+
+// refusedDelete records on svc that its resources were not deleted because
+// the controller does not own them.
+func (r *ServiceReconciler) refusedDelete(svc *corev1.Service, err error) {
+	if svc == nil || r.Recorder == nil {
+		return
+	}
+	r.Recorder.Eventf(svc, corev1.EventTypeWarning, "DeleteRefused",
+		"%s. Leaving it to its owner", err)
+}
+
+// rollback undoes allocations created during a reconcile that could not be
+// recorded on the svc. It returns false if AWS resources were left behind.
+func (r *ServiceReconciler) rollback(ctx context.Context, created []*store.Allocation) bool {
+	ok := true
+	for _, allocation := range created {
+		r.Store.ReleaseNLBAndPortForService(ctx, allocation.ServiceNamespacedName, allocation.NLB, allocation.Port)
+		err := r.AwsClient.DeleteListenerAndTargetArn(ctx, allocation.ServiceNamespacedName, allocation.ListenerArn, allocation.TargetArn)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "SEV0: failed to delete listener for a failed svc object update", "allocation", allocation.ServiceNamespacedName)
+			r.Hooks.Notify(ctx, hooks.Event{
+				Type: hooks.SEV0, Service: allocation.ServiceNamespacedName, NLB: allocation.NLB, Port: allocation.Port,
+				ListenerArn: allocation.ListenerArn, TargetArn: allocation.TargetArn,
+				Message: "failed to delete listener for a failed svc object update: " + err.Error(),
+			})
+			ok = false
+			continue
+		}
+		r.notifyReleased(ctx, "rollback of a failed svc update", allocation.ServiceNamespacedName, allocation.NLB, allocation.Port, allocation.ListenerArn, allocation.TargetArn)
+	}
+	return ok
+}
+
+// notifyAllocated notifies the hooks that a port was exposed and records it
+// in the audit trail, with action Allocated or Reallocated.
+func (r *ServiceReconciler) notifyAllocated(ctx context.Context, action audit.Action, reason string, name string, nlb string, port int, listenerArn string, targetArn string) {
+	r.Hooks.Notify(ctx, hooks.Event{
+		Type: hooks.Allocated, Service: name, NLB: nlb, Port: port, ListenerArn: listenerArn, TargetArn: targetArn,
+	})
+	r.Audit.Record(ctx, audit.Record{
+		Action: action, Reason: reason, Service: name, NLB: nlb, Port: port, ListenerArn: listenerArn, TargetArn: targetArn,
+	})
+}
+
+// notifyReleased notifies the hooks that the port of an allocation was freed
+// and records it in the audit trail.
+func (r *ServiceReconciler) notifyReleased(ctx context.Context, reason string, name string, nlb string, port int, listenerArn string, targetArn string) {
+	r.Hooks.Notify(ctx, hooks.Event{
+		Type: hooks.Released, Service: name, NLB: nlb, Port: port, ListenerArn: listenerArn, TargetArn: targetArn,
+	})
+	r.Audit.Record(ctx, audit.Record{
+		Action: audit.Released, Reason: reason, Service: name, NLB: nlb, Port: port, ListenerArn: listenerArn, TargetArn: targetArn,
+	})
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Service{}).
-		Complete(r)
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Service{}, builder.WithPredicates(servicePredicate(r.LoadBalancerClass))).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+			RateLimiter:             &r.backoff,
+		}).
+		Watches(
+			&source.Kind{Type: &discoveryv1.EndpointSlice{}},
+			handler.EnqueueRequestsFromMapFunc(serviceForEndpointSlice),
+		)
+	if r.Resync != nil {
+		b = b.Watches(&source.Channel{Source: r.Resync}, &handler.EnqueueRequestForObject{})
+	}
+	return b.Complete(traced("Service", r))
+}
+
+// syncTargetGroup brings the target group of a port of the svc in line with
+// the svc: its health check and attributes follow the annotations, and its targets are the
+// ready pods for ip target groups and the cluster nodes otherwise. Targets
+// depend on the EndpointSlices of the svc, which trigger a reconcile.
+func (r *ServiceReconciler) syncTargetGroup(
+	ctx context.Context,
+	svc *corev1.Service,
+	port corev1.ServicePort,
+	targetArn string,
+) error {
+	// the annotations have been validated by Reconcile
+	hc, _ := healthCheck(svc)
+	if err := r.AwsClient.SyncTargetGroupHealthCheck(ctx, targetArn, hc); err != nil {
+		return err
+	}
+	attributes, _ := targetGroupAttributes(svc)
+	if err := r.AwsClient.SyncTargetGroupAttributes(ctx, targetArn, attributes); err != nil {
+		return err
+	}
+
+	var targets []aws.Target
+	var err error
+	if isIPTargetType(svc) {
+		targets, err = ipTargets(ctx, r, svc, port)
+	} else {
+		targets, err = instanceTargets(ctx, r, nodeSource(r.Nodes, r), svc, port)
+	}
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("syncing targets", "port", port.Name, "targets", len(targets))
+	return r.AwsClient.SyncTargets(ctx, targetArn, targets)
+}
+
+// listenerSpec describes the listener of one port of the svc as requested by
+// its annotations.
+func listenerSpec(svc *corev1.Service, name string, nlb string, nlbPort int, nodePort int) aws.ListenerSpec {
+	// the annotations have been validated by Reconcile
+	hc, _ := healthCheck(svc)
+	protocol, _ := annotation(svc, nlbAnnotationProtocol)
+	certificate, _ := annotation(svc, nlbAnnotationCertificate)
+	return aws.ListenerSpec{
+		NLB:         nlb,
+		Port:        nlbPort,
+		NodePort:    nodePort,
+		ServiceName: name,
+		Protocol:    protocol,
+		Certificate: certificate,
+		TargetType:  svc.Annotations[nlbAnnotationTargetType],
+		HealthCheck: hc,
+	}
 }
 
 func (r *ServiceReconciler) checkAllocationValidity(
@@ -220,25 +985,21 @@ func (r *ServiceReconciler) checkAllocationValidity(
 	serviceName string,
 	svcAllocatedListenerArn string,
 	svcAllocatedTargetArn string,
-	svcAllocatedNLB string,
-	svcAllocatedPort int,
-	svcAllocatedNodePort int,
+	spec aws.ListenerSpec,
 ) error {
 	err := r.AwsClient.CheckListener(
 		ctx,
 		svcAllocatedListenerArn,
 		svcAllocatedTargetArn,
-		svcAllocatedNLB,
-		svcAllocatedPort,
-		svcAllocatedNodePort,
+		spec,
 	)
 	if err != nil {
 		return err
 	}
 	err = r.Store.AssignNLBAndPortToServiceInNamespace(
 		ctx,
-		svcAllocatedNLB,
-		svcAllocatedPort,
+		spec.NLB,
+		spec.Port,
 		serviceName,
 		svcAllocatedListenerArn,
 		svcAllocatedTargetArn,
@@ -0,0 +1,490 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	nlbv1alpha1 "github.com/chinmayrelkar/aws-nlb-controller/api/v1alpha1"
+	awsfake "github.com/chinmayrelkar/aws-nlb-controller/aws/fake"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+	storefake "github.com/chinmayrelkar/aws-nlb-controller/store/fake"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// newTestReconciler returns a ServiceReconciler of svc on the fakes of AWS
+// and the store, with the NLB shared.
+func newTestReconciler(t *testing.T, svc *corev1.Service) (*ServiceReconciler, *awsfake.Client, *storefake.Store) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := nlbv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	awsClient := awsfake.New("shared")
+	s := storefake.New(store.NLB{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: store.PortRange{Min: 9000, Max: 9099}})
+	return &ServiceReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(svc).Build(),
+		Scheme:    scheme,
+		Store:     s,
+		AwsClient: awsClient,
+		Nodes:     staticNodes{{Name: "a", InstanceID: "i-a"}},
+	}, awsClient, s
+}
+
+func nodePortService(ports ...corev1.ServicePort) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Annotations: map[string]string{serviceAnnotation: "true"}},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort, Ports: ports},
+	}
+}
+
+func TestReconcileCreatesListener(t *testing.T) {
+	ctx := context.Background()
+	r, awsClient, s := newTestReconciler(t, nodePortService(corev1.ServicePort{Name: "http", Port: 80, NodePort: 30080}))
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	listeners := awsClient.Listeners()
+	if len(listeners) != 1 || listeners[0].Port != 9000 || listeners[0].Service != "default/web:http" {
+		t.Fatalf("listeners %+v, want one of default/web:http on port 9000", listeners)
+	}
+	tg, ok := awsClient.TargetGroup(listeners[0].TargetArn)
+	if !ok || tg.Port != 30080 || len(tg.Targets) != 1 || tg.Targets[0].ID != "i-a" {
+		t.Errorf("target group %+v, want the node on port 30080", tg)
+	}
+	var svc corev1.Service
+	if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if got := svc.Annotations[annotationKey(nlbAnnotationListener, "http")]; got != listeners[0].Arn {
+		t.Errorf("listener annotation %q, want %q", got, listeners[0].Arn)
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation == nil || allocation.ListenerArn != listeners[0].Arn {
+		t.Errorf("allocation %+v, want the listener %s", allocation, listeners[0].Arn)
+	}
+
+	// a second reconcile only checks the listener
+	awsClient.ResetCalls()
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if calls := awsClient.Calls("CreateNLBListenerForPort"); len(calls) != 0 {
+		t.Errorf("created listeners %v on a reconcile of an allocated svc", calls)
+	}
+	if calls := awsClient.Calls("CheckListener"); len(calls) != 1 {
+		t.Errorf("checked %d listeners, want 1", len(calls))
+	}
+}
+
+func TestReconcileRollsBackFailedPorts(t *testing.T) {
+	ctx := context.Background()
+	r, awsClient, s := newTestReconciler(t, nodePortService(
+		corev1.ServicePort{Name: "http", Port: 80, NodePort: 30080},
+		corev1.ServicePort{Name: "https", Port: 443, NodePort: 30443},
+	))
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+
+	// the listener of the second port exceeds the quota of the nlb
+	awsClient.SetListenerQuota(1)
+	_, _ = r.Reconcile(ctx, req)
+	if listeners := awsClient.Listeners(); len(listeners) != 0 {
+		t.Errorf("listeners %+v left after a failed reconcile, want the first port rolled back", listeners)
+	}
+	if allocations := s.ListAllocations(ctx); len(allocations) != 0 {
+		t.Errorf("allocations %+v left after a failed reconcile", allocations)
+	}
+
+	// a listener that cannot be deleted is left behind for the SEV0
+	awsClient.SetError("DeleteListenerAndTargetArn", errors.New("AccessDenied"))
+	_, _ = r.Reconcile(ctx, req)
+	if listeners := awsClient.Listeners(); len(listeners) != 1 {
+		t.Errorf("listeners %+v after a failed rollback, want the first port left behind", listeners)
+	}
+
+	// it is reused once AWS recovers
+	awsClient.SetError("DeleteListenerAndTargetArn", nil)
+	awsClient.SetListenerQuota(awsfake.DefaultListenerQuota)
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if listeners := awsClient.Listeners(); len(listeners) != 2 || listeners[0].Service != "default/web:http" || listeners[1].Service != "default/web:https" {
+		t.Errorf("listeners %+v, want one per port", listeners)
+	}
+	if allocations := s.ListAllocations(ctx); len(allocations) != 2 {
+		t.Errorf("allocations %+v, want one per port", allocations)
+	}
+}
+
+func TestReconcileClonedAnnotationsKeepOtherListener(t *testing.T) {
+	ctx := context.Background()
+	r, awsClient, s := newTestReconciler(t, nodePortService(corev1.ServicePort{Name: "http", Port: 80, NodePort: 30080}))
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	var web corev1.Service
+	if err := r.Get(ctx, req.NamespacedName, &web); err != nil {
+		t.Fatal(err)
+	}
+	webListener := web.Annotations[annotationKey(nlbAnnotationListener, "http")]
+
+	// a svc created from the manifest of web, nlb annotations included
+	clone := nodePortService(corev1.ServicePort{Name: "http", Port: 80, NodePort: 30080})
+	clone.Name = "clone"
+	for k, v := range web.Annotations {
+		clone.Annotations[k] = v
+	}
+	if err := r.Create(ctx, clone); err != nil {
+		t.Fatal(err)
+	}
+	cloneReq := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "clone"}}
+	if _, err := r.Reconcile(ctx, cloneReq); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := awsClient.Calls("DeleteListenerAndTargetArn"); len(calls) != 0 {
+		t.Errorf("deleted listeners %v for a svc with the annotations of another", calls)
+	}
+	if _, ok := awsClient.Listener(webListener); !ok {
+		t.Errorf("listener %s of web deleted", webListener)
+	}
+	if err := r.Get(ctx, cloneReq.NamespacedName, clone); err != nil {
+		t.Fatal(err)
+	}
+	cloneListener := clone.Annotations[annotationKey(nlbAnnotationListener, "http")]
+	if cloneListener == "" || cloneListener == webListener {
+		t.Errorf("listener annotation %q of clone, want a listener of its own", cloneListener)
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation == nil || allocation.ListenerArn != webListener {
+		t.Errorf("allocation %+v of web, want the listener %s", allocation, webListener)
+	}
+}
+
+func TestReconcileRetargetsChangedNodePort(t *testing.T) {
+	ctx := context.Background()
+	r, awsClient, s := newTestReconciler(t, nodePortService(corev1.ServicePort{Name: "http", Port: 80, NodePort: 30080}))
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	listeners := awsClient.Listeners()
+	if len(listeners) != 1 {
+		t.Fatalf("listeners %+v, want one", listeners)
+	}
+	listener := listeners[0]
+
+	var svc corev1.Service
+	if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
+		t.Fatal(err)
+	}
+	svc.Spec.Ports[0].NodePort = 30081
+	if err := r.Update(ctx, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	// the listener keeps its nlb port and forwards to the new NodePort
+	retargeted, ok := awsClient.Listener(listener.Arn)
+	if !ok || retargeted.Port != listener.Port {
+		t.Fatalf("listener %+v, want %s kept on port %d", retargeted, listener.Arn, listener.Port)
+	}
+	if tg, ok := awsClient.TargetGroup(retargeted.TargetArn); !ok || tg.Port != 30081 {
+		t.Errorf("target group %+v, want one on the NodePort 30081", tg)
+	}
+	if _, ok := awsClient.TargetGroup(listener.TargetArn); ok {
+		t.Errorf("target group %s of the old NodePort kept", listener.TargetArn)
+	}
+	if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if got := svc.Annotations[annotationKey(nlbAnnotationTarget, "http")]; got != retargeted.TargetArn {
+		t.Errorf("target annotation %q, want %q", got, retargeted.TargetArn)
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation == nil || allocation.TargetArn != retargeted.TargetArn {
+		t.Errorf("allocation %+v, want the target group %s", allocation, retargeted.TargetArn)
+	}
+}
+
+func TestReconcileDoesNotRetargetListenerOfOtherService(t *testing.T) {
+	ctx := context.Background()
+	r, awsClient, _ := newTestReconciler(t, nodePortService(corev1.ServicePort{Name: "http", Port: 80, NodePort: 30080}))
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	var web corev1.Service
+	if err := r.Get(ctx, req.NamespacedName, &web); err != nil {
+		t.Fatal(err)
+	}
+	webListener, _ := awsClient.Listener(web.Annotations[annotationKey(nlbAnnotationListener, "http")])
+
+	// a clone of web with its nlb annotations, on another NodePort
+	clone := nodePortService(corev1.ServicePort{Name: "http", Port: 80, NodePort: 30081})
+	clone.Name = "clone"
+	for k, v := range web.Annotations {
+		clone.Annotations[k] = v
+	}
+	if err := r.Create(ctx, clone); err != nil {
+		t.Fatal(err)
+	}
+	awsClient.ResetCalls()
+	cloneReq := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "clone"}}
+	if _, err := r.Reconcile(ctx, cloneReq); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{"EnsureTargetGroup", "RetargetListener", "DeleteListenerAndTargetArn"} {
+		if calls := awsClient.Calls(method); len(calls) != 0 {
+			t.Errorf("%s calls %v for the listener of another svc", method, calls)
+		}
+	}
+	if got, ok := awsClient.Listener(webListener.Arn); !ok || got.TargetArn != webListener.TargetArn {
+		t.Errorf("listener %+v of web changed, want it to forward to %s", got, webListener.TargetArn)
+	}
+	if err := r.Get(ctx, cloneReq.NamespacedName, clone); err != nil {
+		t.Fatal(err)
+	}
+	cloneListener, ok := awsClient.Listener(clone.Annotations[annotationKey(nlbAnnotationListener, "http")])
+	if !ok || cloneListener.Arn == webListener.Arn {
+		t.Fatalf("listener %+v of clone, want one of its own", cloneListener)
+	}
+	if tg, ok := awsClient.TargetGroup(cloneListener.TargetArn); !ok || tg.Port != 30081 {
+		t.Errorf("target group %+v of clone, want one on the NodePort 30081", tg)
+	}
+}
+
+func TestReconcileAllocatesEveryPort(t *testing.T) {
+	ctx := context.Background()
+	r, awsClient, s := newTestReconciler(t, nodePortService(
+		corev1.ServicePort{Name: "http", Port: 80, NodePort: 30080},
+		corev1.ServicePort{Name: "https", Port: 443, NodePort: 30443},
+	))
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	var svc corev1.Service
+	if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
+		t.Fatal(err)
+	}
+	listeners := map[string]string{}
+	for _, key := range []string{"http", "https"} {
+		allocation := s.GetAllocationForSVC(ctx, "default/web:"+key)
+		if allocation == nil {
+			t.Fatalf("no allocation of default/web:%s", key)
+		}
+		if got := svc.Annotations[annotationKey(nlbAnnotationListener, key)]; got != allocation.ListenerArn {
+			t.Errorf("listener annotation of %s = %q, want %q", key, got, allocation.ListenerArn)
+		}
+		listeners[key] = allocation.ListenerArn
+	}
+	if listeners["http"] == listeners["https"] {
+		t.Errorf("ports share the listener %s, want one each", listeners["http"])
+	}
+	// the first port keeps the annotations of svcs from before multi-port
+	if got := svc.Annotations[nlbAnnotationListener]; got != listeners["http"] {
+		t.Errorf("unsuffixed listener annotation = %q, want the listener of the first port %q", got, listeners["http"])
+	}
+
+	// removing a port releases its allocation only
+	svc.Spec.Ports = svc.Spec.Ports[:1]
+	if err := r.Update(ctx, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := awsClient.Listener(listeners["https"]); ok {
+		t.Errorf("listener %s of the removed port kept", listeners["https"])
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:https"); allocation != nil {
+		t.Errorf("allocation %+v of the removed port kept", allocation)
+	}
+	if _, ok := awsClient.Listener(listeners["http"]); !ok {
+		t.Errorf("listener %s of the remaining port deleted", listeners["http"])
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation == nil || allocation.ListenerArn != listeners["http"] {
+		t.Errorf("allocation %+v of the remaining port, want the listener %s", allocation, listeners["http"])
+	}
+	if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := svc.Annotations[annotationKey(nlbAnnotationListener, "https")]; ok {
+		t.Errorf("listener annotation %q of the removed port kept", got)
+	}
+}
+
+func TestReconcileHoldsDeletionUntilReleased(t *testing.T) {
+	ctx := context.Background()
+	r, awsClient, s := newTestReconciler(t, nodePortService(corev1.ServicePort{Name: "http", Port: 80, NodePort: 30080}))
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	var svc corev1.Service
+	if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if !controllerutil.ContainsFinalizer(&svc, serviceFinalizer) {
+		t.Fatalf("finalizers %v after the first reconcile, want %s", svc.Finalizers, serviceFinalizer)
+	}
+	listener := awsClient.Listeners()[0]
+
+	if err := r.Delete(ctx, &svc); err != nil {
+		t.Fatal(err)
+	}
+	awsClient.SetError("DeleteListenerAndTargetArn", errors.New("Throttling"))
+	if _, err := r.Reconcile(ctx, req); err == nil {
+		t.Fatal("Reconcile() error = nil with the listener failing to delete")
+	}
+	if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
+		t.Fatalf("svc gone with its listener left: %v", err)
+	}
+	if !controllerutil.ContainsFinalizer(&svc, serviceFinalizer) {
+		t.Errorf("finalizer removed with the listener left")
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation == nil {
+		t.Error("allocation released with the listener left")
+	}
+
+	awsClient.SetError("DeleteListenerAndTargetArn", nil)
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := awsClient.Listener(listener.Arn); ok {
+		t.Errorf("listener %s kept after the svc was deleted", listener.Arn)
+	}
+	if _, ok := awsClient.TargetGroup(listener.TargetArn); ok {
+		t.Errorf("target group %s kept after the svc was deleted", listener.TargetArn)
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation != nil {
+		t.Errorf("allocation %+v kept after the svc was deleted", allocation)
+	}
+	err := r.Get(ctx, req.NamespacedName, &svc)
+	if err == nil && controllerutil.ContainsFinalizer(&svc, serviceFinalizer) {
+		t.Error("finalizer kept after the listener was deleted")
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcileLeavesPausedServiceAlone(t *testing.T) {
+	ctx := context.Background()
+	r, awsClient, s := newTestReconciler(t, nodePortService(corev1.ServicePort{Name: "http", Port: 80, NodePort: 30080}))
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	listener := awsClient.Listeners()[0]
+
+	var svc corev1.Service
+	if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
+		t.Fatal(err)
+	}
+	svc.Annotations[nlbAnnotationPaused] = "true"
+	svc.Spec.Ports[0].NodePort = 30081
+	if err := r.Update(ctx, &svc); err != nil {
+		t.Fatal(err)
+	}
+	awsClient.ResetCalls()
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if calls := awsClient.Calls(""); len(calls) != 0 {
+		t.Errorf("aws calls %v for a paused svc", calls)
+	}
+
+	// the finalizer holds the deletion of a paused svc
+	if err := r.Delete(ctx, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := awsClient.Listener(listener.Arn); !ok {
+		t.Error("listener of a paused svc deleted")
+	}
+	if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
+		t.Fatalf("paused svc gone before its listener was deleted: %v", err)
+	}
+
+	delete(svc.Annotations, nlbAnnotationPaused)
+	if err := r.Update(ctx, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := awsClient.Listener(listener.Arn); ok {
+		t.Error("listener kept after the deleted svc was unpaused")
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation != nil {
+		t.Errorf("allocation %+v kept after the deleted svc was unpaused", allocation)
+	}
+}
+
+func TestReconcileDeallocatesOptedOutService(t *testing.T) {
+	for _, value := range []string{"", "false"} {
+		ctx := context.Background()
+		r, awsClient, s := newTestReconciler(t, nodePortService(corev1.ServicePort{Name: "http", Port: 80, NodePort: 30080}))
+		req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		listener := awsClient.Listeners()[0]
+
+		var svc corev1.Service
+		if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
+			t.Fatal(err)
+		}
+		if value == "" {
+			delete(svc.Annotations, serviceAnnotation)
+		} else {
+			svc.Annotations[serviceAnnotation] = value
+		}
+		if err := r.Update(ctx, &svc); err != nil {
+			t.Fatal(err)
+		}
+
+		// a listener that cannot be deleted keeps the allocation
+		awsClient.FailNext("DeleteListenerAndTargetArn", errors.New("Throttling"))
+		if _, err := r.Reconcile(ctx, req); err == nil {
+			t.Fatalf("Reconcile() error = nil with the listener failing to delete, opt-in %q", value)
+		}
+		if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation == nil {
+			t.Errorf("allocation released with its listener left, opt-in %q", value)
+		}
+
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := awsClient.Listener(listener.Arn); ok {
+			t.Errorf("listener kept after the svc opted out with %q", value)
+		}
+		if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation != nil {
+			t.Errorf("allocation %+v kept after the svc opted out with %q", allocation, value)
+		}
+		if err := r.Get(ctx, req.NamespacedName, &svc); err != nil {
+			t.Fatal(err)
+		}
+		if hasNLBAnnotations(&svc) || controllerutil.ContainsFinalizer(&svc, serviceFinalizer) {
+			t.Errorf("svc annotations %v, finalizers %v after it opted out with %q, want the nlb annotations and finalizer removed", svc.Annotations, svc.Finalizers, value)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// namespaceExposeLabel opts every NodePort svc created in a namespace into
+// the controller
+const namespaceExposeLabel = "nlb.chinmayrelkar.github.com/expose"
+
+// ServiceWebhookPath is the path the ServiceAnnotator is served on.
+const ServiceWebhookPath = "/mutate-v1-service"
+
+// +kubebuilder:webhook:path=/mutate-v1-service,mutating=true,failurePolicy=ignore,sideEffects=None,groups="",resources=services,verbs=create,versions=v1,name=mservice.nlb.chinmayrelkar.github.com,admissionReviewVersions=v1
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+
+// ServiceAnnotator adds the opt-in annotation to NodePort services created in
+// namespaces labeled for NLB exposure. Services that already carry the
+// annotation, including ones opting out with "false", are left alone.
+type ServiceAnnotator struct {
+	Client  client.Client
+	decoder *admission.Decoder
+}
+
+// Handle implements admission.Handler.
+func (a *ServiceAnnotator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var svc corev1.Service
+	if err := a.decoder.Decode(req, &svc); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if svc.Spec.Type != corev1.ServiceTypeNodePort {
+		return admission.Allowed("svc not of type NodePort")
+	}
+	if _, ok := svc.Annotations[serviceAnnotation]; ok {
+		return admission.Allowed("svc already annotated")
+	}
+
+	var ns corev1.Namespace
+	if err := a.Client.Get(ctx, client.ObjectKey{Name: req.Namespace}, &ns); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if ns.Labels[namespaceExposeLabel] != "true" {
+		return admission.Allowed("namespace not labeled for nlb exposure")
+	}
+
+	if svc.Annotations == nil {
+		svc.Annotations = map[string]string{}
+	}
+	svc.Annotations[serviceAnnotation] = "true"
+	marshaled, err := json.Marshal(&svc)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	log.FromContext(ctx).Info("annotating svc", "svc", req.Namespace+"/"+svc.Name)
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (a *ServiceAnnotator) InjectDecoder(d *admission.Decoder) error {
+	a.decoder = d
+	return nil
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"context"
+	"time"
+)
+
+// withDrainTimeout returns a context with the values of ctx that is only
+// canceled timeout after ctx is, so that AWS calls and store writes started
+// before the manager shuts down are finished instead of canceled half-way.
+// The returned cancel func must be called once the work is done.
+func withDrainTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	drainCtx, cancel := context.WithCancel(valuesOnly{ctx})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-drainCtx.Done():
+			return
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-drainCtx.Done():
+		}
+	}()
+	return drainCtx, cancel
+}
+
+// valuesOnly is a context with the values of its Context, but without its
+// deadline and cancellation.
+type valuesOnly struct {
+	context.Context
+}
+
+func (valuesOnly) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (valuesOnly) Done() <-chan struct{}       { return nil }
+func (valuesOnly) Err() error                  { return nil }
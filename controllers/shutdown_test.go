@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type ctxKey struct{}
+
+func TestWithDrainTimeout(t *testing.T) {
+	parent, stop := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "value"))
+	ctx, cancel := withDrainTimeout(parent, 50*time.Millisecond)
+	defer cancel()
+	if ctx.Value(ctxKey{}) != "value" {
+		t.Error("values of the parent context are lost")
+	}
+
+	stop()
+	select {
+	case <-ctx.Done():
+		t.Fatal("canceled with its parent, want after the drain timeout")
+	case <-time.After(10 * time.Millisecond):
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("not canceled after the drain timeout")
+	}
+}
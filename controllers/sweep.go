@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ServiceSweeper periodically hands every Service the controller manages or
+// allocated ports for to the ServiceReconciler, which verifies its
+// allocations against the store and AWS. It catches Services whose watch
+// events were missed, which drift detection does not, as it only checks the
+// allocations the store knows of.
+type ServiceSweeper struct {
+	Client client.Reader
+	Period time.Duration
+
+	// LoadBalancerClass is the class of the LoadBalancer Services the
+	// controller claims.
+	LoadBalancerClass string
+
+	// Sweep receives the services to reconcile.
+	Sweep chan<- event.GenericEvent
+
+	// StoreReady, if set, is closed once the store has been loaded.
+	StoreReady <-chan struct{}
+}
+
+// Start sweeps all Services every Period until ctx is done.
+func (s *ServiceSweeper) Start(ctx context.Context) error {
+	if s.StoreReady != nil {
+		select {
+		case <-s.StoreReady:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	ticker := time.NewTicker(s.Period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.sweep(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *ServiceSweeper) sweep(ctx context.Context) {
+	logger := log.FromContext(ctx)
+	var services corev1.ServiceList
+	if err := s.Client.List(ctx, &services); err != nil {
+		logger.Error(err, "unable to list services for the sweep")
+		return
+	}
+	swept := 0
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if !isTrackedService(svc, s.LoadBalancerClass) || isPaused(svc) {
+			continue
+		}
+		select {
+		case s.Sweep <- event.GenericEvent{Object: svc}:
+			swept++
+		case <-ctx.Done():
+			return
+		}
+	}
+	logger.Info("swept services", "services", swept)
+}
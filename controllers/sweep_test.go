@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestServiceSweeperSweepsTrackedServices(t *testing.T) {
+	ctx := context.Background()
+	service := func(name string, annotations map[string]string, finalizers ...string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Annotations: annotations, Finalizers: finalizers},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort},
+		}
+	}
+	c := fake.NewClientBuilder().WithObjects(
+		service("managed", map[string]string{serviceAnnotation: "true"}),
+		// opted out, with allocations left to release
+		service("annotated", map[string]string{nlbAnnotationListener: "arn:aws:elasticloadbalancing:listener/net/shared/1"}),
+		service("finalized", nil, serviceFinalizer),
+		service("paused", map[string]string{serviceAnnotation: "true", nlbAnnotationPaused: "true"}),
+		service("unmanaged", nil),
+	).Build()
+	sweep := make(chan event.GenericEvent, 10)
+	s := &ServiceSweeper{Client: c, Sweep: sweep}
+
+	s.sweep(ctx)
+	close(sweep)
+	var swept []string
+	for e := range sweep {
+		swept = append(swept, e.Object.GetName())
+	}
+	sort.Strings(swept)
+	if got := strings.Join(swept, ","); got != "annotated,finalized,managed" {
+		t.Errorf("swept %s, want the tracked svcs that are not paused", got)
+	}
+
+	sweep = make(chan event.GenericEvent, 10)
+	s = &ServiceSweeper{Client: failingList{Reader: c, err: errors.New("apiserver unavailable")}, Sweep: sweep}
+	s.sweep(ctx)
+	if len(sweep) != 0 {
+		t.Errorf("swept %d svcs with the svcs failing to list", len(sweep))
+	}
+}
@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var tracer = otel.Tracer("github.com/chinmayrelkar/aws-nlb-controller/controllers")
+
+// traced records a span for every reconcile of r, the root of the spans of
+// the AWS and store calls made while reconciling. Without a tracer provider
+// the spans are dropped.
+func traced(kind string, r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		ctx, span := tracer.Start(ctx, "Reconcile "+kind, trace.WithAttributes(
+			attribute.String("namespace", req.Namespace),
+			attribute.String("name", req.Name),
+		))
+		defer span.End()
+		result, err := r.Reconcile(ctx, req)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.SetAttributes(attribute.Bool("requeue", result.Requeue || result.RequeueAfter > 0))
+		return result, err
+	})
+}
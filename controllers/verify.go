@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/admin"
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConsistencyChecker cross-checks the three sources of truth of the
+// allocations: the store, the annotations of the Services and the listeners
+// of the cluster in AWS. It only reports what it finds, with a suggested
+// remediation; it changes nothing.
+type ConsistencyChecker struct {
+	Client    client.Reader
+	Store     store.Store
+	AwsClient aws.Client
+}
+
+// Verify reports the mismatches between the store, the annotations of the
+// Services and the listeners on the NLBs of the store. Paused Services are
+// left out, as their annotations may be edited by hand.
+func (c *ConsistencyChecker) Verify(ctx context.Context) (admin.Report, error) {
+	report := admin.Report{Time: time.Now().UTC(), Mismatches: []admin.Mismatch{}}
+	if err := c.Store.Check(); err != nil {
+		report.Mismatches = append(report.Mismatches, admin.Mismatch{
+			Kind:        admin.StoreInconsistent,
+			Detail:      err.Error(),
+			Remediation: "restart the controller to load the store again",
+		})
+	}
+
+	var services corev1.ServiceList
+	if err := c.Client.List(ctx, &services); err != nil {
+		return report, fmt.Errorf("unable to list services: %w", err)
+	}
+	exists := map[types.NamespacedName]bool{}
+	for i := range services.Items {
+		svc := &services.Items[i]
+		serviceKey := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}
+		exists[serviceKey] = true
+		if !hasNLBAnnotations(svc) || isPaused(svc) {
+			continue
+		}
+		report.Services++
+		report.Mismatches = append(report.Mismatches, c.verifyAnnotations(ctx, svc, serviceKey.String())...)
+	}
+
+	listeners, err := c.AwsClient.ListAllocations(ctx, c.Store.ListNLBs())
+	if err != nil {
+		return report, fmt.Errorf("unable to list listeners: %w", err)
+	}
+	report.Listeners = len(listeners)
+	listenerArns := map[string]bool{}
+	for _, l := range listeners {
+		listenerArns[l.ListenerArn] = true
+	}
+
+	allocations := c.Store.ListAllocations(ctx)
+	report.Allocations = len(allocations)
+	for _, allocation := range allocations {
+		name := allocation.ServiceNamespacedName
+		serviceKey, _ := splitAllocationKey(name)
+		mismatch := admin.Mismatch{Allocation: name, NLB: allocation.NLB, Port: allocation.Port, ListenerArn: allocation.ListenerArn}
+		if !exists[serviceKey] && !allocation.Retained && !allocation.Sticky {
+			mismatch.Kind = admin.AllocationWithoutService
+			mismatch.Detail = fmt.Sprintf("svc %s does not exist", serviceKey)
+			mismatch.Remediation = fmt.Sprintf("nlbctl release %s", name)
+			report.Mismatches = append(report.Mismatches, mismatch)
+		}
+		if allocation.Sticky || listenerArns[allocation.ListenerArn] {
+			continue
+		}
+		// listeners that were adopted may not be tagged for the cluster
+		found, err := c.AwsClient.ListenerExists(ctx, allocation.ListenerArn)
+		if err != nil {
+			return report, fmt.Errorf("unable to check listener of %s: %w", name, err)
+		}
+		if found {
+			continue
+		}
+		mismatch.Kind = admin.AllocationWithoutListener
+		mismatch.Detail = "listener does not exist"
+		mismatch.Remediation = fmt.Sprintf("nlbctl release %s", name)
+		if exists[serviceKey] {
+			mismatch.Remediation = fmt.Sprintf("nlbctl resync %s, which reallocates the port", serviceKey)
+		}
+		report.Mismatches = append(report.Mismatches, mismatch)
+	}
+
+	for _, l := range listeners {
+		if allocation := c.Store.GetAllocationForSVC(ctx, l.ServiceNamespacedName); allocation != nil && allocation.ListenerArn == l.ListenerArn {
+			continue
+		}
+		serviceKey, _ := splitAllocationKey(l.ServiceNamespacedName)
+		mismatch := admin.Mismatch{Allocation: l.ServiceNamespacedName, NLB: l.NLB, Port: l.Port, ListenerArn: l.ListenerArn}
+		if exists[serviceKey] {
+			mismatch.Kind = admin.ListenerWithoutAllocation
+			mismatch.Detail = "the store holds no allocation of the listener"
+			mismatch.Remediation = fmt.Sprintf("delete the listener and target group %s if the svc no longer uses them, "+
+				"or restart the controller with --seed-from=aws to record them", l.TargetArn)
+		} else {
+			mismatch.Kind = admin.ListenerWithoutService
+			mismatch.Detail = fmt.Sprintf("svc %s does not exist and the store holds no allocation of the listener", serviceKey)
+			mismatch.Remediation = fmt.Sprintf("delete the listener and target group %s, "+
+				"or recreate the svc with the %s annotation to adopt them", l.TargetArn, nlbAnnotationAdoptListener)
+		}
+		report.Mismatches = append(report.Mismatches, mismatch)
+	}
+
+	sort.SliceStable(report.Mismatches, func(i, j int) bool {
+		return report.Mismatches[i].Allocation < report.Mismatches[j].Allocation
+	})
+	return report, nil
+}
+
+// verifyAnnotations reports the ports of svc whose annotations do not match
+// their allocation in the store.
+func (c *ConsistencyChecker) verifyAnnotations(ctx context.Context, svc *corev1.Service, serviceName string) []admin.Mismatch {
+	var mismatches []admin.Mismatch
+	for idx, port := range svc.Spec.Ports {
+		key := portKey(port, idx)
+		nlb := getPortAnnotation(svc, nlbAnnotationNLBName, key, idx)
+		if nlb == "" {
+			continue
+		}
+		name := allocationKey(serviceName, key)
+		nlbPort, _ := strconv.Atoi(getPortAnnotation(svc, nlbAnnotationPort, key, idx))
+		listenerArn := getPortAnnotation(svc, nlbAnnotationListener, key, idx)
+		mismatch := admin.Mismatch{
+			Allocation:  name,
+			NLB:         nlb,
+			Port:        nlbPort,
+			ListenerArn: listenerArn,
+			Remediation: fmt.Sprintf("nlbctl resync %s", serviceName),
+		}
+		allocation := c.Store.GetAllocationForSVC(ctx, name)
+		switch {
+		case allocation == nil:
+			mismatch.Kind = admin.AnnotationWithoutAllocation
+			mismatch.Detail = "the store holds no allocation of the annotated port"
+		case allocation.NLB != nlb || allocation.Port != nlbPort || allocation.ListenerArn != listenerArn:
+			mismatch.Kind = admin.AnnotationMismatch
+			mismatch.Detail = fmt.Sprintf("the store allocates port %d of nlb %s with listener %s",
+				allocation.Port, allocation.NLB, allocation.ListenerArn)
+		default:
+			continue
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+	return mismatches
+}
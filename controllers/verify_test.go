@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/admin"
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestConsistencyChecker(t *testing.T) {
+	ctx := context.Background()
+	s := store.New(store.NLB{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: store.PortRange{Min: 9000, Max: 9099}})
+	for _, a := range []struct {
+		name string
+		port int
+	}{
+		{"default/web:http", 9000},
+		{"default/api:http", 9001},
+		{"default/gone:http", 9002},
+	} {
+		if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "shared", a.port, a.name, "listener/"+a.name, "targetgroup/"+a.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	annotated := func(name string, port string, listener string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Annotations: map[string]string{
+				annotationKey(nlbAnnotationNLBName, "http"):  "shared",
+				annotationKey(nlbAnnotationPort, "http"):     port,
+				annotationKey(nlbAnnotationListener, "http"): listener,
+			}},
+			Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+		}
+	}
+	services := []*corev1.Service{
+		annotated("web", "9000", "listener/default/web:http"),
+		annotated("api", "9050", "listener/default/api:http"),
+		annotated("new", "9003", "listener/default/new:http"),
+	}
+	builder := fake.NewClientBuilder().WithScheme(scheme.Scheme)
+	for _, svc := range services {
+		builder = builder.WithObjects(svc)
+	}
+	tagged := []aws.ListenerAllocation{
+		{ServiceNamespacedName: "default/web:http", NLB: "shared", Port: 9000, ListenerArn: "listener/default/web:http"},
+		{ServiceNamespacedName: "default/new:http", NLB: "shared", Port: 9003, ListenerArn: "listener/default/new:http"},
+		{ServiceNamespacedName: "default/old:http", NLB: "shared", Port: 9004, ListenerArn: "listener/default/old:http"},
+	}
+	checker := &ConsistencyChecker{
+		Client:    builder.Build(),
+		Store:     s,
+		AwsClient: &listenerClient{tagged: tagged, listeners: map[string]bool{"listener/default/api:http": true}},
+	}
+
+	report, err := checker.Verify(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]admin.MismatchKind{}
+	for _, m := range report.Mismatches {
+		got[m.Allocation] = append(got[m.Allocation], m.Kind)
+		if m.Remediation == "" {
+			t.Errorf("mismatch %+v has no remediation", m)
+		}
+	}
+	want := map[string][]admin.MismatchKind{
+		"default/api:http":  {admin.AnnotationMismatch},
+		"default/new:http":  {admin.AnnotationWithoutAllocation, admin.ListenerWithoutAllocation},
+		"default/gone:http": {admin.AllocationWithoutService, admin.AllocationWithoutListener},
+		"default/old:http":  {admin.ListenerWithoutService},
+	}
+	if len(got) != len(want) {
+		t.Errorf("mismatches %v, want %v", got, want)
+	}
+	for name, kinds := range want {
+		if len(got[name]) != len(kinds) {
+			t.Errorf("mismatches of %s = %v, want %v", name, got[name], kinds)
+			continue
+		}
+		for i := range kinds {
+			if got[name][i] != kinds[i] {
+				t.Errorf("mismatches of %s = %v, want %v", name, got[name], kinds)
+				break
+			}
+		}
+	}
+	if report.Services != 3 || report.Allocations != 3 || report.Listeners != 3 {
+		t.Errorf("checked %d services, %d allocations and %d listeners, want 3 of each", report.Services, report.Allocations, report.Listeners)
+	}
+}
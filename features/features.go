@@ -0,0 +1,140 @@
+// Package features holds the feature gates of the controller. Subsystems
+// behind a gate can ship disabled by default and be enabled per cluster with
+// --feature-gates, such as --feature-gates=IPTargets=true,TLSListeners=false.
+package features
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Feature is the name of a feature gate.
+type Feature string
+
+const (
+	// IPTargets registers the pods of services annotated with
+	// service-nlb-target-type: ip in ip target groups.
+	IPTargets Feature = "IPTargets"
+
+	// TLSListeners terminates TLS on the listeners of services annotated with
+	// service-nlb-certificate-arn or service-nlb-protocol: TLS.
+	TLSListeners Feature = "TLSListeners"
+
+	// AutoProvisioning provisions more NLBs from the NLBPools that set
+	// scaleOut when every NLB is full.
+	AutoProvisioning Feature = "AutoProvisioning"
+)
+
+// Stages of a feature. Alpha features are disabled by default, beta features
+// are enabled by default.
+const (
+	Alpha = "ALPHA"
+	Beta  = "BETA"
+)
+
+type spec struct {
+	Default bool
+	Stage   string
+}
+
+// known are the feature gates and their defaults. Features that shipped
+// before the gates were introduced are beta, so that they stay enabled.
+var known = map[Feature]spec{
+	IPTargets:        {Default: true, Stage: Beta},
+	TLSListeners:     {Default: true, Stage: Beta},
+	AutoProvisioning: {Default: true, Stage: Beta},
+}
+
+// Gates are the features enabled or disabled explicitly. Features that are
+// not set use their default. Gates is a flag.Value.
+type Gates struct {
+	mu      sync.RWMutex
+	enabled map[Feature]bool
+}
+
+// Default are the gates of --feature-gates.
+var Default = &Gates{}
+
+// Enabled reports whether a feature is enabled by the Default gates.
+func Enabled(feature Feature) bool {
+	return Default.Enabled(feature)
+}
+
+// Enabled reports whether a feature is enabled.
+func (g *Gates) Enabled(feature Feature) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if enabled, ok := g.enabled[feature]; ok {
+		return enabled
+	}
+	return known[feature].Default
+}
+
+// Set enables and disables the features of a comma separated list of
+// Feature=true or Feature=false pairs. Unknown features are errors.
+func (g *Gates) Set(value string) error {
+	enabled := map[Feature]bool{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("feature gate %q is not of the form Feature=true or Feature=false", pair)
+		}
+		feature := Feature(strings.TrimSpace(name))
+		if _, ok := known[feature]; !ok {
+			return fmt.Errorf("unknown feature gate %q. Known feature gates are %s", feature, strings.Join(names(), ", "))
+		}
+		on, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("feature gate %s: %q is not true or false", feature, v)
+		}
+		enabled[feature] = on
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.enabled == nil {
+		g.enabled = map[Feature]bool{}
+	}
+	for feature, on := range enabled {
+		g.enabled[feature] = on
+	}
+	return nil
+}
+
+// String returns the features set explicitly, in the format of Set.
+func (g *Gates) String() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	pairs := make([]string, 0, len(g.enabled))
+	for feature, on := range g.enabled {
+		pairs = append(pairs, fmt.Sprintf("%s=%t", feature, on))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Usage describes the known feature gates, for the usage of --feature-gates.
+func Usage() string {
+	lines := make([]string, 0, len(known))
+	for _, name := range names() {
+		s := known[Feature(name)]
+		lines = append(lines, fmt.Sprintf("%s=true|false (%s - default=%t)", name, s.Stage, s.Default))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func names() []string {
+	names := make([]string, 0, len(known))
+	for feature := range known {
+		names = append(names, string(feature))
+	}
+	sort.Strings(names)
+	return names
+}
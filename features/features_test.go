@@ -0,0 +1,38 @@
+package features
+
+import "testing"
+
+func TestGatesSet(t *testing.T) {
+	g := &Gates{}
+	if !g.Enabled(IPTargets) {
+		t.Errorf("IPTargets disabled by default")
+	}
+	if err := g.Set("IPTargets=false, TLSListeners=true"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if g.Enabled(IPTargets) {
+		t.Errorf("IPTargets enabled after IPTargets=false")
+	}
+	if !g.Enabled(TLSListeners) {
+		t.Errorf("TLSListeners disabled after TLSListeners=true")
+	}
+	if got, want := g.String(), "IPTargets=false,TLSListeners=true"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestGatesSetErrors(t *testing.T) {
+	for _, value := range []string{"IPTargets", "IPTargets=maybe", "Unknown=true"} {
+		g := &Gates{}
+		if err := g.Set(value); err == nil {
+			t.Errorf("Set(%q) error = nil, want an error", value)
+		}
+	}
+	g := &Gates{}
+	if err := g.Set("IPTargets=false,Unknown=true"); err == nil {
+		t.Fatal("Set() error = nil, want an error")
+	}
+	if !g.Enabled(IPTargets) {
+		t.Errorf("invalid value partially applied")
+	}
+}
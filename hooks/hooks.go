@@ -0,0 +1,137 @@
+// Package hooks notifies external systems, such as firewalls or CMDBs, of the
+// ports the controller exposes on NLBs and releases again.
+package hooks
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// EventType is the kind of an Event.
+type EventType string
+
+const (
+	// Allocated is sent when a port of a Service is exposed on an NLB.
+	Allocated EventType = "allocated"
+	// Released is sent when a port is no longer exposed and has been freed.
+	Released EventType = "released"
+	// SEV0 is sent when a listener could not be deleted after a failed
+	// allocation, and may expose a port no Service owns.
+	SEV0 EventType = "sev0"
+)
+
+// queueSize is the number of events waiting for delivery before new events
+// are dropped
+const queueSize = 1024
+
+// Event is the payload sent to hooks.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Cluster is the cluster ID of the controller.
+	Cluster string `json:"cluster"`
+	// Service is the allocation key, of the form namespace/name:port.
+	Service     string `json:"service"`
+	NLB         string `json:"nlb"`
+	Port        int    `json:"port"`
+	ListenerArn string `json:"listenerArn,omitempty"`
+	TargetArn   string `json:"targetArn,omitempty"`
+	// Message describes SEV0 events.
+	Message string `json:"message,omitempty"`
+}
+
+// Hook delivers events to an external system.
+type Hook interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+var (
+	deliveryFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nlb_hook_delivery_failures_total",
+		Help: "Total number of allocation events a hook failed to deliver",
+	})
+	droppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nlb_hook_events_dropped_total",
+		Help: "Total number of allocation events dropped because the hooks fell behind",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(deliveryFailuresTotal, droppedTotal)
+}
+
+// Dispatcher delivers events to its hooks in the background, so that slow
+// hooks do not hold up reconciles. Delivery is best effort: events are not
+// retried, and are dropped while the queue is full or after the controller
+// stops.
+type Dispatcher struct {
+	// Cluster is set on every event.
+	Cluster string
+	Hooks   []Hook
+	// Timeout is the deadline of a delivery to a hook. Defaults to 10s.
+	Timeout time.Duration
+
+	events chan Event
+}
+
+// NewDispatcher returns a Dispatcher delivering the events of cluster to hooks.
+func NewDispatcher(cluster string, hooks ...Hook) *Dispatcher {
+	return &Dispatcher{Cluster: cluster, Hooks: hooks, events: make(chan Event, queueSize)}
+}
+
+// Notify queues event for delivery to every hook. A nil Dispatcher discards
+// events.
+func (d *Dispatcher) Notify(ctx context.Context, event Event) {
+	if d == nil {
+		return
+	}
+	event.Cluster = d.Cluster
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	select {
+	case d.events <- event:
+	default:
+		droppedTotal.Inc()
+		log.FromContext(ctx).Info("hooks fell behind. Dropping event", "type", event.Type, "allocation", event.Service)
+	}
+}
+
+// Start delivers the queued events until ctx is done.
+func (d *Dispatcher) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("hooks")
+	for {
+		select {
+		case event := <-d.events:
+			d.deliver(log.IntoContext(ctx, logger), event)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Events are
+// delivered on every replica that sends them.
+func (d *Dispatcher) NeedLeaderElection() bool {
+	return false
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, event Event) {
+	timeout := d.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	for _, hook := range d.Hooks {
+		hookCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := hook.Notify(hookCtx, event)
+		cancel()
+		if err != nil {
+			deliveryFailuresTotal.Inc()
+			log.FromContext(ctx).Error(err, "unable to deliver event", "hook", hook, "type", event.Type, "allocation", event.Service)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookSignsEvents(t *testing.T) {
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got, want := r.Header.Get(SignatureHeader), "sha256="+Sign("secret", body); got != want {
+			t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+		}
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	hook := &Webhook{URL: server.URL, Secret: "secret"}
+	event := Event{Type: Allocated, Cluster: "prod", Service: "default/web:http", NLB: "public", Port: 10000, ListenerArn: "listener"}
+	if err := hook.Notify(context.Background(), event); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	got := <-received
+	if got.Type != Allocated || got.Service != event.Service || got.Port != event.Port || got.ListenerArn != event.ListenerArn {
+		t.Errorf("received %+v, want %+v", got, event)
+	}
+}
+
+func TestWebhookFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	hook := &Webhook{URL: server.URL}
+	if err := hook.Notify(context.Background(), Event{Type: Released}); err == nil {
+		t.Error("Notify() error = nil, want an error for a 503")
+	}
+}
+
+type recordingHook struct {
+	events chan Event
+}
+
+func (h *recordingHook) Notify(_ context.Context, event Event) error {
+	h.events <- event
+	return nil
+}
+
+func TestDispatcherDeliversToEveryHook(t *testing.T) {
+	first := &recordingHook{events: make(chan Event, 1)}
+	second := &recordingHook{events: make(chan Event, 1)}
+	d := NewDispatcher("prod", first, second)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Start(ctx)
+
+	d.Notify(ctx, Event{Type: SEV0, Service: "default/web:http"})
+	for _, hook := range []*recordingHook{first, second} {
+		event := <-hook.events
+		if event.Cluster != "prod" || event.Type != SEV0 || event.Time.IsZero() {
+			t.Errorf("delivered %+v, want a sev0 event of cluster prod with a time", event)
+		}
+	}
+}
+
+func TestNilDispatcherDiscardsEvents(t *testing.T) {
+	var d *Dispatcher
+	d.Notify(context.Background(), Event{Type: Allocated})
+}
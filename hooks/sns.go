@@ -0,0 +1,45 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// SNSAPI is the part of the SNS client the SNS hook uses.
+type SNSAPI interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// SNS publishes events as JSON messages to an SNS topic. The type of the
+// event is also a message attribute, so that subscriptions can filter on it.
+type SNS struct {
+	Client   SNSAPI
+	TopicArn string
+}
+
+var _ Hook = &SNS{}
+
+// Notify implements Hook.
+func (s *SNS) Notify(ctx context.Context, event Event) error {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = s.Client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(s.TopicArn),
+		Message:  aws.String(string(message)),
+		MessageAttributes: map[string]snstypes.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String(string(event.Type))},
+		},
+	})
+	return err
+}
+
+// String returns the topic of the hook, for logs.
+func (s *SNS) String() string {
+	return "sns " + s.TopicArn
+}
@@ -0,0 +1,69 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// SignatureHeader holds the HMAC-SHA256 of the body of webhook requests,
+// hex encoded and prefixed with sha256=, if the Webhook has a Secret.
+const SignatureHeader = "X-NLB-Controller-Signature"
+
+// Webhook POSTs events as JSON to URL. Responses other than 2xx are errors.
+type Webhook struct {
+	URL string
+	// Secret, if set, signs the requests in SignatureHeader so that
+	// receivers can tell them from forged ones.
+	Secret string
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+var _ Hook = &Webhook{}
+
+// Notify implements Hook.
+func (w *Webhook) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.Secret, body))
+	}
+	c := w.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("hooks: webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// String returns the URL of the webhook, for logs.
+func (w *Webhook) String() string {
+	return "webhook " + w.URL
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
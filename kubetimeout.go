@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// timeoutClient puts a deadline on every call of its Client, so that a slow
+// API server cannot hold up a reconcile for minutes. Watches of the cache of
+// the manager are not made through it and are not limited.
+type timeoutClient struct {
+	client.Client
+	timeout time.Duration
+}
+
+func (t timeoutClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Client.Get(ctx, key, obj, opts...)
+}
+
+func (t timeoutClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Client.List(ctx, list, opts...)
+}
+
+func (t timeoutClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Client.Create(ctx, obj, opts...)
+}
+
+func (t timeoutClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Client.Update(ctx, obj, opts...)
+}
+
+func (t timeoutClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (t timeoutClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Client.Delete(ctx, obj, opts...)
+}
+
+func (t timeoutClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (t timeoutClient) Status() client.StatusWriter {
+	return timeoutStatusWriter{StatusWriter: t.Client.Status(), timeout: t.timeout}
+}
+
+type timeoutStatusWriter struct {
+	client.StatusWriter
+	timeout time.Duration
+}
+
+func (t timeoutStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (t timeoutStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	return t.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
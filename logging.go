@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	ctrlzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// logLevels are the levels of named loggers, such as aws or store, given with
+// --log-levels.
+type logLevels map[string]zapcore.Level
+
+// parseLogLevels parses a comma separated list of name=level pairs.
+func parseLogLevels(value string) (logLevels, error) {
+	levels := logLevels{}
+	for _, pair := range splitList(value) {
+		name, level, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q is not of the form name=level", pair)
+		}
+		l, err := parseLevel(level)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		levels[name] = l
+	}
+	return levels, nil
+}
+
+// parseLevel parses debug, info or error, or a verbosity N, which logs what
+// is logged with V(N) and below.
+func parseLevel(value string) (zapcore.Level, error) {
+	switch value {
+	case "debug":
+		return zapcore.DebugLevel, nil
+	case "info":
+		return zapcore.InfoLevel, nil
+	case "error":
+		return zapcore.ErrorLevel, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < 0 || v > 127 {
+		return 0, fmt.Errorf("level %q is not debug, info, error or a verbosity between 0 and 127", value)
+	}
+	return zapcore.Level(-v), nil
+}
+
+// withLogLevels makes the loggers named in levels log at their level, and the
+// other loggers at the level of opts. A logger is matched by any part of its
+// dotted name, so that aws also matches the aws logger of a controller.
+func withLogLevels(opts *ctrlzap.Options, levels logLevels) {
+	if len(levels) == 0 {
+		return
+	}
+	level := zapcore.InfoLevel
+	if opts.Development {
+		level = zapcore.DebugLevel
+	}
+	if atomic, ok := opts.Level.(zap.AtomicLevel); ok {
+		level = atomic.Level()
+	}
+	// the core logs down to the most verbose level, levelCore filters the rest
+	lowest := level
+	for _, l := range levels {
+		if l < lowest {
+			lowest = l
+		}
+	}
+	opts.Level = zap.NewAtomicLevelAt(lowest)
+	opts.ZapOpts = append(opts.ZapOpts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &levelCore{Core: core, level: level, levels: levels}
+	}))
+}
+
+// levelCore drops the entries below the level of their logger.
+type levelCore struct {
+	zapcore.Core
+	level  zapcore.Level
+	levels logLevels
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level, levels: c.levels}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.Level < c.levelOf(entry.LoggerName) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
+
+func (c *levelCore) levelOf(name string) zapcore.Level {
+	parts := strings.Split(name, ".")
+	// the innermost name wins, so that aws logs at its level in any controller
+	for i := len(parts) - 1; i >= 0; i-- {
+		if level, ok := c.levels[parts[i]]; ok {
+			return level
+		}
+	}
+	return c.level
+}
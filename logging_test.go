@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestParseLogLevels(t *testing.T) {
+	levels, err := parseLogLevels("aws=debug, store=error,setup=2")
+	if err != nil {
+		t.Fatalf("parseLogLevels() error = %v", err)
+	}
+	want := logLevels{"aws": zapcore.DebugLevel, "store": zapcore.ErrorLevel, "setup": zapcore.Level(-2)}
+	if len(levels) != len(want) {
+		t.Fatalf("parseLogLevels() = %v, want %v", levels, want)
+	}
+	for name, level := range want {
+		if levels[name] != level {
+			t.Errorf("level of %s = %v, want %v", name, levels[name], level)
+		}
+	}
+	for _, value := range []string{"aws", "=debug", "aws=verbose", "aws=-1"} {
+		if _, err := parseLogLevels(value); err == nil {
+			t.Errorf("parseLogLevels(%q) error = nil, want an error", value)
+		}
+	}
+}
+
+func TestLevelCore(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(&levelCore{
+		Core:   core,
+		level:  zapcore.InfoLevel,
+		levels: logLevels{"aws": zapcore.DebugLevel, "store": zapcore.ErrorLevel},
+	})
+
+	logger.Debug("dropped")
+	logger.Named("controller").Named("aws").Debug("aws debug")
+	logger.Named("aws").With(zap.String("nlb", "public")).Debug("aws debug with fields")
+	logger.Named("store").Info("dropped")
+	logger.Named("store").Error("store error")
+
+	var got []string
+	for _, entry := range logs.All() {
+		got = append(got, entry.Message)
+	}
+	want := []string{"aws debug", "aws debug with fields", "store error"}
+	if len(got) != len(want) {
+		t.Fatalf("logged %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("logged %v, want %v", got, want)
+		}
+	}
+}
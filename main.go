@@ -18,26 +18,57 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/chinmayrelkar/aws-nlb-controller/admin"
+	nlbv1alpha1 "github.com/chinmayrelkar/aws-nlb-controller/api/v1alpha1"
+	"github.com/chinmayrelkar/aws-nlb-controller/audit"
 	"github.com/chinmayrelkar/aws-nlb-controller/aws"
 	"github.com/chinmayrelkar/aws-nlb-controller/controllers"
+	"github.com/chinmayrelkar/aws-nlb-controller/features"
+	"github.com/chinmayrelkar/aws-nlb-controller/hooks"
 	"github.com/chinmayrelkar/aws-nlb-controller/store"
+	"github.com/chinmayrelkar/aws-nlb-controller/tracing"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/go-redis/redis/v8"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	// +kubebuilder:scaffold:imports
 )
 
+// awsSetupTimeout bounds the AWS calls made at startup, such as discovering
+// the region and VPC.
+const awsSetupTimeout = 2 * time.Minute
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -45,33 +76,391 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(nlbv1alpha1.AddToScheme(scheme))
 
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
+	var configFile string
+	var logLevelsFlag string
+	var tracingOptions tracing.Options
 	var metricsAddr string
 	var enableLeaderElection bool
+	var dryRun bool
+	var leaderElectionID string
+	var leaderElectionNamespace string
+	var leaseDuration time.Duration
+	var renewDeadline time.Duration
+	var retryPeriod time.Duration
 	var probeAddr string
+	var storeBackend string
+	var storeNamespace string
+	var storeConfigMapName string
+	var storeDynamoDBTable string
+	var storeRedisAddress string
+	var storeRedisPrefix string
+	var storeReservationTTL time.Duration
+	var storeStickyRetention time.Duration
+	var clusterID string
+	var clusterName string
+	var vpcID string
+	var seedFrom string
+	var awsRetryMode string
+	var awsMaxAttempts int
+	var awsRetryMaxBackoff time.Duration
+	var awsRegion string
+	var awsCredentials aws.Credentials
+	var awsEndpoints aws.Endpoints
+	var awsDisableIMDS bool
+	var awsRateLimit float64
+	var awsBurst int
+	var awsDescribeCacheTTL time.Duration
+	var awsReadTimeout time.Duration
+	var awsCircuitBreakerThreshold int
+	var awsCircuitBreakerCooldown time.Duration
+	var awsFaults string
+	var awsWriteTimeout time.Duration
+	var kubeAPITimeout time.Duration
+	var awsTags string
+	var targetGroupNameTemplate string
+	var nlbDiscoveryTag string
+	var nlbListConfigMap string
+	var enableServiceWebhook bool
+	var maxConcurrentReconciles int
+	var targetSyncConcurrency int
+	var listenerQuota int
+	var scaleInCooldown time.Duration
+	var listenerQuotaFromAWS bool
+	var selectionStrategy string
+	var portHashing bool
+	var loadBalancerClass string
+	var awsAnnotations bool
+	var route53HostedZoneID string
+	var externalDNSAnnotations bool
+	var resyncPeriod time.Duration
+	var sweepPeriod time.Duration
+	var staleAllocationTTL time.Duration
+	var staleAllocationCheckPeriod time.Duration
+	var cloudWatchNamespace string
+	var cloudWatchPeriod time.Duration
+	var healthCheckInterval time.Duration
+	var gracefulShutdownTimeout time.Duration
+	var hookWebhookURL string
+	var hookWebhookSecret string
+	var hookSNSTopicArn string
+	var auditLogFile string
+	var auditS3Bucket string
+	var auditS3Prefix string
+	var auditEvents bool
+	var adminAddr string
+	var adminToken string
+	var pprofAddr string
+	var nlbDiscoveryInterval time.Duration
+	var nodeSource string
+	var nodeSelector string
+	var drainTaints string
+	var lifecycleHook string
+	var lifecycleHookInterval time.Duration
+	flag.StringVar(&configFile, "config", "",
+		"A YAML or JSON file with the cluster, VPC, region and NLBs to manage. See config/manager/controller_config.yaml. "+
+			"Flags and the env vars they default to take precedence over it.")
+	flag.Var(features.Default, "feature-gates",
+		"A comma separated list of Feature=true or Feature=false pairs enabling or disabling features. Options are:\n"+
+			features.Usage())
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&adminAddr, "admin-bind-address", "0",
+		"The address the admin API binds to. Set to 0 to disable it.")
+	flag.StringVar(&adminToken, "admin-token", os.Getenv("ADMIN_TOKEN"),
+		"The bearer token clients of the admin API must present. Required when the admin API is enabled.")
+	flag.StringVar(&pprofAddr, "pprof-bind-address", "0",
+		"The loopback address, such as localhost:6060, net/http/pprof profiles are served on. Set to 0 to disable it.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.DurationVar(&healthCheckInterval, "health-check-interval", 30*time.Second,
+		"How long the result of the ELB API call of the health probes is reused.")
+	flag.DurationVar(&gracefulShutdownTimeout, "graceful-shutdown-timeout", 30*time.Second,
+		"How long reconciles in flight at shutdown may take to finish their AWS calls and store writes.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Log the AWS changes reconciles would make, and serve them on the admin API, without making them or updating "+
+			"services. Uses the memory store and a leader election ID of its own, and disables hooks, the audit trail, "+
+			"CloudWatch metrics and the service webhook.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&leaderElectionID, "leader-election-id", "aws-nlb-controller.chinmayrelkar.github.com",
+		"The name of the lease used for leader election.")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "",
+		"The namespace of the leader election lease. Defaults to the namespace the controller runs in.")
+	flag.DurationVar(&leaseDuration, "leader-election-lease-duration", 15*time.Second,
+		"How long non-leader replicas wait before trying to acquire a lease that has not been renewed.")
+	flag.DurationVar(&renewDeadline, "leader-election-renew-deadline", 10*time.Second,
+		"How long the leader keeps retrying to renew its lease before giving up leadership.")
+	flag.DurationVar(&retryPeriod, "leader-election-retry-period", 2*time.Second,
+		"How long replicas wait between leader election attempts.")
+	flag.StringVar(&storeBackend, "store", "memory",
+		"Where NLB port allocations are kept. One of: memory, configmap, crd, dynamodb, redis, lease.")
+	flag.StringVar(&storeNamespace, "store-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace of the allocation ConfigMap when --store=configmap, and of the port Leases when --store=lease.")
+	flag.StringVar(&storeConfigMapName, "store-configmap-name", "aws-nlb-controller-allocations",
+		"The name of the allocation ConfigMap when --store=configmap.")
+	flag.StringVar(&storeDynamoDBTable, "store-dynamodb-table", "aws-nlb-controller-allocations",
+		"The DynamoDB table allocations are kept in when --store=dynamodb. Clusters sharing NLBs share the table.")
+	flag.StringVar(&storeRedisAddress, "store-redis-address", "",
+		"The host:port of the Redis server allocations are kept in when --store=redis. The password is read from REDIS_PASSWORD.")
+	flag.StringVar(&storeRedisPrefix, "store-redis-prefix", "aws-nlb-controller",
+		"The prefix of the Redis keys of allocations when --store=redis.")
+	flag.DurationVar(&storeReservationTTL, "store-reservation-ttl", 5*time.Minute,
+		"How long a port reserved for a listener that is being created stays reserved without being assigned, before it is freed again.")
+	flag.DurationVar(&storeStickyRetention, "store-sticky-retention", 0,
+		"How long the NLB port of a deleted service stays bound to its name, so that a service recreated with the same name gets it back. "+
+			"Needs --store=configmap or --store=crd to survive restarts. 0 frees ports right away.")
+	flag.StringVar(&clusterID, "cluster-id", os.Getenv("CLUSTER_ID"),
+		"Identifies this cluster in the tags of the AWS resources the controller creates. Required.")
+	flag.StringVar(&vpcID, "vpc-id", os.Getenv("VPC_ID"),
+		"The VPC target groups are created in. Defaults to the VPC of the node the controller runs on, "+
+			"or else to the VPC of the subnets tagged kubernetes.io/cluster/<--cluster-name>.")
+	flag.StringVar(&clusterName, "cluster-name", os.Getenv("CLUSTER_NAME"),
+		"The EKS cluster name the subnets of the cluster are tagged with. Defaults to --cluster-id.")
+	flag.StringVar(&seedFrom, "seed-from", "annotations",
+		"Where existing allocations are read from at startup. One of: annotations, aws.")
+	flag.StringVar(&awsRetryMode, "aws-retry-mode", "standard",
+		"The AWS SDK retry mode. One of: standard, adaptive.")
+	flag.StringVar(&awsRegion, "aws-region", "",
+		"The AWS region of the managed NLBs. Defaults to AWS_REGION, the shared config or instance metadata.")
+	flag.StringVar(&awsCredentials.Source, "aws-credentials", aws.CredentialsDefault,
+		"Where the AWS credentials come from. One of: default (the SDK credential chain), irsa, profile, static.")
+	flag.StringVar(&awsCredentials.Profile, "aws-profile", os.Getenv("AWS_PROFILE"),
+		"The shared config profile when --aws-credentials=profile.")
+	flag.StringVar(&awsCredentials.RoleARN, "aws-role-arn", os.Getenv("AWS_ROLE_ARN"),
+		"The IAM role of the service account when --aws-credentials=irsa.")
+	flag.StringVar(&awsCredentials.WebIdentityTokenFile, "aws-web-identity-token-file", os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"),
+		"The service account token exchanged for credentials of --aws-role-arn when --aws-credentials=irsa.")
+	flag.StringVar(&awsCredentials.StaticDir, "aws-static-credentials-dir", "",
+		"The directory holding the files access-key-id, secret-access-key and optionally session-token "+
+			"when --aws-credentials=static, such as a mounted Secret. The files are read again every minute.")
+	flag.StringVar(&awsEndpoints.URL, "aws-endpoint-url", os.Getenv("AWS_ENDPOINT_URL"),
+		"Send AWS API calls to this endpoint instead of AWS, such as http://localstack:4566.")
+	flag.StringVar(&awsEndpoints.ELBv2, "aws-elbv2-endpoint-url", "",
+		"The endpoint of the ELBv2 API. Defaults to --aws-endpoint-url.")
+	flag.StringVar(&awsEndpoints.EC2, "aws-ec2-endpoint-url", "",
+		"The endpoint of the EC2 API. Defaults to --aws-endpoint-url.")
+	flag.BoolVar(&awsDisableIMDS, "aws-disable-imds", false,
+		"Never use the instance metadata service, for clusters that block it. "+
+			"--aws-region and --vpc-id are then required. The service is otherwise only used with IMDSv2 session tokens.")
+	flag.IntVar(&awsMaxAttempts, "aws-max-attempts", 0,
+		"The maximum number of attempts per AWS API call. 0 keeps the SDK default.")
+	flag.DurationVar(&awsRetryMaxBackoff, "aws-retry-max-backoff", 0,
+		"The longest wait between attempts of an AWS API call. 0 keeps the SDK default of 20s.")
+	flag.Float64Var(&awsRateLimit, "aws-rate-limit", 10,
+		"The maximum number of AWS API calls per second. 0 disables client-side rate limiting.")
+	flag.IntVar(&awsBurst, "aws-burst", 20,
+		"The number of AWS API calls that may exceed --aws-rate-limit at once.")
+	flag.DurationVar(&awsDescribeCacheTTL, "aws-describe-cache-ttl", 30*time.Second,
+		"How long described NLBs and target groups are reused before they are described again. 0 disables the cache.")
+	flag.DurationVar(&awsReadTimeout, "aws-read-timeout", 10*time.Second,
+		"The deadline of AWS Describe, Get and List calls, including retries. 0 disables it.")
+	flag.DurationVar(&awsWriteTimeout, "aws-write-timeout", 30*time.Second,
+		"The deadline of every other AWS API call, such as CreateListener, including retries. 0 disables it.")
+	flag.IntVar(&awsCircuitBreakerThreshold, "aws-circuit-breaker-threshold", 10,
+		"The number of ELB API calls in a row that must fail for the controller to stop changing listeners and target groups. "+
+			"0 disables the circuit breaker.")
+	flag.DurationVar(&awsCircuitBreakerCooldown, "aws-circuit-breaker-cooldown", time.Minute,
+		"How long the controller stops changing listeners and target groups once the circuit breaker opened, "+
+			"before a successful ELB API call resumes them.")
+	flag.StringVar(&awsFaults, "aws-faults", os.Getenv("AWS_FAULTS"),
+		"Failures to inject into AWS calls for testing, such as throttle=0.1,partial-create=1,delete-failure=0.5,"+
+			"consistency-delay=30s,seed=42. Empty injects none. Not for production.")
+	flag.DurationVar(&kubeAPITimeout, "kube-api-timeout", 15*time.Second,
+		"The deadline of Kubernetes API calls of the controllers. Watches are not limited. 0 disables it.")
+	flag.StringVar(&awsTags, "aws-tags", "",
+		"Extra tags for the listeners and target groups the controller creates, given as key=value,key=value.")
+	flag.StringVar(&targetGroupNameTemplate, "target-group-name-template", "",
+		"Template for target group names, such as {cluster}-{namespace}-{svc}-{port}. "+
+			"Supports {cluster}, {namespace}, {svc}, {port} and {protocol}; long names are shortened with a hash. "+
+			"If empty, instance target groups are named after their NodePort. "+
+			"ip target groups only use templates with both {namespace} and {svc}, and are otherwise named after a hash.")
+	flag.StringVar(&nlbDiscoveryTag, "nlb-discovery-tag", "",
+		"Manage the NLBs carrying this tag, given as key=value, in addition to NLB_LIST and NLBPools.")
+	flag.StringVar(&nlbListConfigMap, "nlb-list-configmap", "",
+		"Manage the NLBs listed under the nlbs key of this ConfigMap, given as namespace/name, in addition to NLB_LIST. "+
+			"The list is in the format of NLB_LIST and is reloaded when it changes; removed NLBs are drained. Empty disables it.")
+	flag.DurationVar(&nlbDiscoveryInterval, "nlb-discovery-interval", 5*time.Minute,
+		"How often NLBs are discovered by --nlb-discovery-tag.")
+	flag.BoolVar(&enableServiceWebhook, "enable-service-webhook", false,
+		"Serve the webhook that opts NodePort services into the controller by namespace label. "+
+			"Requires the webhook serving certificate.")
+	flag.StringVar(&loadBalancerClass, "load-balancer-class", "",
+		"Claim LoadBalancer services of this spec.loadBalancerClass, such as "+
+			"nlb-controller.chinmayrelkar.github.com/shared-nlb, and allocate them a port on a shared NLB. Empty disables it.")
+	flag.BoolVar(&awsAnnotations, "aws-load-balancer-annotations", false,
+		"Also read the health check, proxy-protocol, ssl-cert and backend-protocol "+
+			"service.beta.kubernetes.io/aws-load-balancer-* annotations of services that do not set the native ones.")
+	flag.StringVar(&route53HostedZoneID, "route53-hosted-zone-id", "",
+		"The Route53 hosted zone of the CNAMEs of the service-nlb-dns-name annotation. Empty disables DNS records.")
+	flag.BoolVar(&externalDNSAnnotations, "external-dns-annotations", false,
+		"Write the external-dns.alpha.kubernetes.io/target annotation, and the hostname annotation from "+
+			"service-nlb-dns-name, on services so that external-dns creates their DNS records.")
+	flag.StringVar(&nodeSource, "node-source", "kubernetes",
+		"Where the instances of instance target groups are listed from. One of: kubernetes (the Nodes of the cluster), "+
+			"ec2 (the running instances in the VPC tagged kubernetes.io/cluster/<--cluster-name>).")
+	flag.StringVar(&nodeSelector, "node-selector", "",
+		"Only register the Nodes matching this label selector, such as node-role.kubernetes.io/ingress, "+
+			"in instance target groups. Empty registers every Node.")
+	flag.StringVar(&drainTaints, "drain-node-taints", strings.Join(controllers.DefaultDrainTaints, ","),
+		"Deregister Nodes with a taint of one of these keys, set on nodes about to be interrupted or terminated, "+
+			"so that their connections drain before they go away.")
+	flag.StringVar(&lifecycleHook, "asg-lifecycle-hook", "",
+		"The name of the termination lifecycle hook of the Auto Scaling groups of the nodes. If set, instances "+
+			"held by the hook are deregistered and the hook is completed once their connections have drained.")
+	flag.DurationVar(&lifecycleHookInterval, "asg-lifecycle-hook-interval", 10*time.Second,
+		"How often instances held by --asg-lifecycle-hook are looked for.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The number of services reconciled in parallel.")
+	flag.IntVar(&listenerQuota, "nlb-listener-quota", store.DefaultListenerQuota,
+		"The number of listeners an NLB supports. No port is allocated on an NLB with this many listeners.")
+	flag.BoolVar(&listenerQuotaFromAWS, "nlb-listener-quota-from-service-quotas", false,
+		"Read the listeners per NLB quota of the account from Service Quotas at startup, instead of --nlb-listener-quota.")
+	flag.StringVar(&selectionStrategy, "nlb-selection-strategy", "least-loaded",
+		"How the NLB of a new allocation is chosen. One of: "+strings.Join(store.Strategies, ", ")+".")
+	flag.BoolVar(&portHashing, "nlb-port-hashing", false,
+		"Allocate the port derived from a hash of the service name, or the next free one after it, instead of the lowest free port, "+
+			"so that a service gets the same port across cluster rebuilds.")
+	flag.DurationVar(&scaleInCooldown, "nlb-scale-in-cooldown", time.Hour,
+		"How long an NLB provisioned by scaling out an NLBPool stays empty before it is deleted. 0 keeps them.")
+	flag.IntVar(&targetSyncConcurrency, "target-sync-concurrency", 4,
+		"The number of target groups whose targets are synced in parallel when nodes change.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute,
+		"How often listeners and target groups are checked against AWS for drift. 0 disables drift detection.")
+	flag.DurationVar(&sweepPeriod, "sweep-period", time.Hour,
+		"How often every managed service is reconciled, to catch missed watch events. 0 disables the sweep.")
+	flag.DurationVar(&staleAllocationTTL, "stale-allocation-ttl", 0,
+		"How long the service of an allocation is gone before the allocation is released, if its listener is gone too. "+
+			"0 disables releasing stale allocations.")
+	flag.DurationVar(&staleAllocationCheckPeriod, "stale-allocation-check-period", 10*time.Minute,
+		"How often the store is checked for stale allocations.")
+	flag.StringVar(&cloudWatchNamespace, "cloudwatch-namespace", "",
+		"The CloudWatch namespace the port utilization of the NLBs is published to. Empty disables publishing.")
+	flag.DurationVar(&cloudWatchPeriod, "cloudwatch-period", time.Minute,
+		"How often the port utilization of the NLBs is published to CloudWatch.")
+	flag.StringVar(&hookWebhookURL, "hook-webhook-url", "",
+		"The URL allocated, released and sev0 events are POSTed to as JSON. Empty disables the webhook.")
+	flag.StringVar(&hookWebhookSecret, "hook-webhook-secret", os.Getenv("HOOK_WEBHOOK_SECRET"),
+		"The secret webhook requests are signed with in the "+hooks.SignatureHeader+" header. Empty leaves them unsigned.")
+	flag.StringVar(&hookSNSTopicArn, "hook-sns-topic-arn", "",
+		"The SNS topic allocated, released and sev0 events are published to. Empty disables publishing.")
+	flag.StringVar(&auditLogFile, "audit-log-file", "",
+		"The file allocations and releases are appended to as JSON lines. Empty disables the audit log file.")
+	flag.StringVar(&auditS3Bucket, "audit-s3-bucket", "",
+		"The S3 bucket allocations and releases are written to, an object per record. Empty disables the S3 audit trail.")
+	flag.StringVar(&auditS3Prefix, "audit-s3-prefix", "audit/",
+		"The prefix of the keys of the audit records in --audit-s3-bucket.")
+	flag.BoolVar(&auditEvents, "audit-events", false,
+		"Record allocations and releases as Events on their services.")
+	flag.StringVar(&logLevelsFlag, "log-levels", "",
+		"The levels of named loggers, such as aws=debug,store=error, overriding --zap-log-level for them. "+
+			"Levels are debug, info, error or a verbosity.")
+	flag.StringVar(&tracingOptions.Endpoint, "tracing-endpoint", "",
+		"The host:port of the OTLP gRPC collector spans of reconciles, store, AWS and Kubernetes API calls are exported to. "+
+			"Empty disables tracing.")
+	flag.BoolVar(&tracingOptions.Insecure, "tracing-insecure", false,
+		"Export spans to --tracing-endpoint without TLS.")
+	flag.Float64Var(&tracingOptions.SampleRatio, "tracing-sample-ratio", 0.1,
+		"The fraction of reconciles that are traced, between 0 and 1.")
 	opts := zap.Options{
 		Development: true,
 	}
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
+	levels, err := parseLogLevels(logLevelsFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid --log-levels:", err)
+		os.Exit(1)
+	}
+	withLogLevels(&opts, levels)
+	faults, err := aws.ParseFaults(awsFaults)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid --aws-faults:", err)
+		os.Exit(1)
+	}
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	if gates := features.Default.String(); gates != "" {
+		setupLog.Info("feature gates set", "gates", gates)
+	}
+
+	var configNLBs []store.NLB
+	if configFile != "" {
+		cfg, err := loadConfig(configFile)
+		if err != nil {
+			setupLog.Error(err, "invalid --config")
+			os.Exit(1)
+		}
+		setFlags := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+		configDefault(&clusterID, cfg.ClusterID, "cluster-id", "CLUSTER_ID", setFlags)
+		configDefault(&clusterName, cfg.ClusterName, "cluster-name", "CLUSTER_NAME", setFlags)
+		configDefault(&vpcID, cfg.VPCID, "vpc-id", "VPC_ID", setFlags)
+		configDefault(&awsRegion, cfg.Region, "aws-region", "AWS_REGION", setFlags)
+		if os.Getenv("NLB_LIST") != "" {
+			setupLog.Info("NLB_LIST is set. Ignoring the nlbs of --config")
+		} else {
+			configNLBs = cfg.storeNLBs()
+		}
+	}
+	if dryRun {
+		setupLog.Info("dry run: neither aws nor services nor the store are changed")
+		storeBackend = "memory"
+		leaderElectionID += "-dry-run"
+		hookWebhookURL, hookSNSTopicArn = "", ""
+		auditLogFile, auditS3Bucket, auditEvents = "", "", false
+		cloudWatchNamespace = ""
+		enableServiceWebhook = false
+	}
+
+	// ctx is canceled on SIGTERM, also while the controller is being set up.
+	// AWS is set up with setupCtx, so that an unreachable API fails the
+	// startup instead of hanging it.
+	ctx := ctrl.SetupSignalHandler()
+	setupCtx, cancelSetup := context.WithTimeout(ctx, awsSetupTimeout)
+
+	var shutdownTracing func(context.Context) error
+	if tracingOptions.Endpoint != "" {
+		shutdownTracing, err = tracing.Setup(setupCtx, tracingOptions)
+		if err != nil {
+			setupLog.Error(err, "unable to set up tracing")
+			os.Exit(1)
+		}
+	}
+	newClient := func(clientCache cache.Cache, config *rest.Config, options client.Options, uncachedObjects ...client.Object) (client.Client, error) {
+		c, err := cluster.DefaultNewClient(clientCache, config, options, uncachedObjects...)
+		if err != nil {
+			return nil, err
+		}
+		if kubeAPITimeout > 0 {
+			c = timeoutClient{Client: c, timeout: kubeAPITimeout}
+		}
+		if tracingOptions.Endpoint != "" {
+			c = tracing.Client(c)
+		}
+		if dryRun {
+			c = client.NewDryRunClient(c)
+		}
+		return c, nil
+	}
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
+		Scheme:                  scheme,
+		NewClient:               newClient,
+		MetricsBindAddress:      metricsAddr,
+		Port:                    9443,
+		HealthProbeBindAddress:  probeAddr,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        leaderElectionID,
+		LeaderElectionNamespace: leaderElectionNamespace,
+		LeaseDuration:           &leaseDuration,
+		RenewDeadline:           &renewDeadline,
+		RetryPeriod:             &retryPeriod,
+		GracefulShutdownTimeout: &gracefulShutdownTimeout,
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -89,29 +478,631 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controllers.ServiceReconciler{
+	retryMode, err := awssdk.ParseRetryMode(awsRetryMode)
+	if err != nil {
+		setupLog.Error(err, "invalid --aws-retry-mode")
+		os.Exit(1)
+	}
+	strategy, err := store.NewStrategy(selectionStrategy)
+	if err != nil {
+		setupLog.Error(err, "invalid --nlb-selection-strategy")
+		os.Exit(1)
+	}
+	extraTags := map[string]string{}
+	for _, tag := range strings.Split(awsTags, ",") {
+		if tag == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok || key == "" {
+			setupLog.Error(errors.New("not of the form key=value"), "invalid --aws-tags", "tag", tag)
+			os.Exit(1)
+		}
+		extraTags[key] = value
+	}
+	if !aws.ValidTargetGroupNameTemplate(targetGroupNameTemplate) {
+		setupLog.Error(errors.New("unknown placeholder"), "invalid --target-group-name-template")
+		os.Exit(1)
+	}
+	awsOptions := aws.Options{
+		ClusterID:   clusterID,
+		ClusterName: clusterName,
+		VPC:         vpcID,
+		Tags:        extraTags,
+		Region:      awsRegion,
+		Credentials: awsCredentials,
+		Endpoints:   awsEndpoints,
+		DisableIMDS: awsDisableIMDS,
+		RetryMode:   retryMode,
+		MaxAttempts: awsMaxAttempts,
+		RateLimit:   awsRateLimit,
+		Burst:       awsBurst,
+
+		TargetGroupNameTemplate: targetGroupNameTemplate,
+		Route53HostedZoneID:     route53HostedZoneID,
+		DescribeCacheTTL:        awsDescribeCacheTTL,
+		RetryMaxBackoff:         awsRetryMaxBackoff,
+		ReadTimeout:             awsReadTimeout,
+		WriteTimeout:            awsWriteTimeout,
+
+		CircuitBreakerThreshold: awsCircuitBreakerThreshold,
+		CircuitBreakerCooldown:  awsCircuitBreakerCooldown,
+		OnCircuitBreaker: controllers.CircuitBreakerEvents(mgr.GetEventRecorderFor("aws-nlb-controller"),
+			os.Getenv("POD_NAMESPACE"), os.Getenv("POD_NAME")),
+	}
+	awsClient, err := aws.New(setupCtx, awsOptions)
+	if err != nil {
+		setupLog.Error(err, "unable to create aws client")
+		os.Exit(1)
+	}
+	if faults.Enabled() {
+		setupLog.Info("injecting faults into aws calls", "faults", awsFaults)
+		awsClient = aws.NewFaultInjector(awsClient, faults)
+	}
+	if tracingOptions.Endpoint != "" {
+		awsClient = aws.WithTracing(awsClient)
+	}
+	var dryRunClient *aws.DryRun
+	if dryRun {
+		dryRunClient = aws.NewDryRun(awsClient)
+		awsClient = dryRunClient
+	}
+	if awsAnnotations {
+		controllers.EnableAWSAnnotations()
+	}
+	storeOpts := storeOptions{
+		Backend:       storeBackend,
+		Namespace:     storeNamespace,
+		ConfigMapName: storeConfigMapName,
+		DynamoDBTable: storeDynamoDBTable,
+		ClusterID:     clusterID,
+
+		ReservationTTL: storeReservationTTL,
+	}
+	if storeBackend == "dynamodb" {
+		cfg, err := aws.LoadConfig(setupCtx, awsOptions)
+		if err != nil {
+			setupLog.Error(err, "unable to load aws config for the dynamodb store")
+			os.Exit(1)
+		}
+		storeOpts.DynamoDB = dynamodb.NewFromConfig(cfg)
+	}
+	if storeBackend == "redis" {
+		if storeRedisAddress == "" {
+			setupLog.Error(errors.New("--store-redis-address is required"), "unable to set up the redis store")
+			os.Exit(1)
+		}
+		storeOpts.Redis = redis.NewClient(&redis.Options{
+			Addr:     storeRedisAddress,
+			Password: os.Getenv("REDIS_PASSWORD"),
+		})
+		storeOpts.RedisPrefix = storeRedisPrefix
+	}
+
+	selector, err := labels.Parse(nodeSelector)
+	if err != nil {
+		setupLog.Error(err, "invalid --node-selector")
+		os.Exit(1)
+	}
+	var nodes controllers.NodeSource
+	switch nodeSource {
+	case "kubernetes":
+		nodes = controllers.ClusterNodes{
+			Reader:      mgr.GetClient(),
+			Selector:    selector,
+			DrainTaints: splitList(drainTaints),
+		}
+	case "ec2":
+		if nodeSelector != "" {
+			setupLog.Error(errors.New("node labels are not known for ec2 instances"), "--node-selector needs --node-source=kubernetes")
+			os.Exit(1)
+		}
+		nodes = controllers.EC2Instances{AwsClient: awsClient}
+	default:
+		setupLog.Error(fmt.Errorf("unknown node source %q", nodeSource), "invalid --node-source")
+		os.Exit(1)
+	}
+	// nodeResync delivers node syncs from the lifecycle hook
+	var nodeResync chan event.GenericEvent
+	var lifecycleHooks *controllers.LifecycleHooks
+	if lifecycleHook != "" {
+		nodeResync = make(chan event.GenericEvent)
+		lifecycleHooks = &controllers.LifecycleHooks{
+			Reader:            mgr.GetClient(),
+			AwsClient:         awsClient,
+			LoadBalancerClass: loadBalancerClass,
+			Hook:              lifecycleHook,
+			Interval:          lifecycleHookInterval,
+			Resync:            nodeResync,
+		}
+		nodes = lifecycleHooks.NodeSource(nodes)
+	}
+
+	// The store is only loaded once this replica leads, so that a replica taking
+	// over from a previous leader starts from the latest allocations.
+	storeReady := make(chan struct{})
+	// loadedStore is the store once storeReady is closed
+	var loadedStore store.Store
+	serviceReconciler := &controllers.ServiceReconciler{
+		Client:     mgr.GetClient(),
+		Scheme:     mgr.GetScheme(),
+		AwsClient:  awsClient,
+		StoreReady: storeReady,
+		Recorder:   mgr.GetEventRecorderFor("aws-nlb-controller"),
+		Nodes:      nodes,
+
+		ManageDNS:               route53HostedZoneID != "",
+		ExternalDNSAnnotations:  externalDNSAnnotations,
+		LoadBalancerClass:       loadBalancerClass,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		StickyAllocations:       storeStickyRetention > 0,
+		DrainTimeout:            gracefulShutdownTimeout,
+	}
+	if features.Enabled(features.AutoProvisioning) {
+		serviceReconciler.Scaler = &controllers.NLBPoolScaler{
+			Client:   mgr.GetClient(),
+			Scheme:   mgr.GetScheme(),
+			Recorder: mgr.GetEventRecorderFor("aws-nlb-controller"),
+		}
+	}
+	// resync delivers services to reconcile from drift detection, the sweep
+	// and the admin API
+	resync := make(chan event.GenericEvent)
+	serviceReconciler.Resync = resync
+	var driftDetector *controllers.DriftDetector
+	if resyncPeriod > 0 {
+		driftDetector = &controllers.DriftDetector{
+			Client:     mgr.GetClient(),
+			AwsClient:  awsClient,
+			Period:     resyncPeriod,
+			Drifted:    resync,
+			StoreReady: storeReady,
+		}
+	}
+	var sweeper *controllers.ServiceSweeper
+	if sweepPeriod > 0 {
+		sweeper = &controllers.ServiceSweeper{
+			Client:            mgr.GetClient(),
+			Period:            sweepPeriod,
+			LoadBalancerClass: loadBalancerClass,
+			Sweep:             resync,
+			StoreReady:        storeReady,
+		}
+	}
+	var janitor *controllers.StoreJanitor
+	if staleAllocationTTL > 0 {
+		janitor = &controllers.StoreJanitor{
+			Client:     mgr.GetClient(),
+			AwsClient:  awsClient,
+			Period:     staleAllocationCheckPeriod,
+			TTL:        staleAllocationTTL,
+			StoreReady: storeReady,
+		}
+	}
+	var cloudWatchPublisher *controllers.CloudWatchPublisher
+	if cloudWatchNamespace != "" {
+		cfg, err := aws.LoadConfig(setupCtx, awsOptions)
+		if err != nil {
+			setupLog.Error(err, "unable to load aws config for cloudwatch")
+			os.Exit(1)
+		}
+		cloudWatchPublisher = &controllers.CloudWatchPublisher{
+			CloudWatch: cloudwatch.NewFromConfig(cfg),
+			Namespace:  cloudWatchNamespace,
+			ClusterID:  clusterID,
+			Period:     cloudWatchPeriod,
+			StoreReady: storeReady,
+		}
+	}
+	healthChecks := &controllers.HealthChecks{
+		AwsClient:  awsClient,
+		StoreReady: storeReady,
+		Interval:   healthCheckInterval,
+	}
+	var hookList []hooks.Hook
+	if hookWebhookURL != "" {
+		hookList = append(hookList, &hooks.Webhook{URL: hookWebhookURL, Secret: hookWebhookSecret})
+	}
+	if hookSNSTopicArn != "" {
+		cfg, err := aws.LoadConfig(setupCtx, awsOptions)
+		if err != nil {
+			setupLog.Error(err, "unable to load aws config for sns")
+			os.Exit(1)
+		}
+		hookList = append(hookList, &hooks.SNS{Client: sns.NewFromConfig(cfg), TopicArn: hookSNSTopicArn})
+	}
+	var hookDispatcher *hooks.Dispatcher
+	if len(hookList) > 0 {
+		hookDispatcher = hooks.NewDispatcher(clusterID, hookList...)
+		serviceReconciler.Hooks = hookDispatcher
+	}
+	var auditTrail *audit.Trail
+	if auditLogFile != "" || auditS3Bucket != "" || auditEvents {
+		auditTrail = &audit.Trail{Cluster: clusterID}
+		if auditLogFile != "" {
+			file := &audit.File{Path: auditLogFile}
+			auditTrail.Sinks = append(auditTrail.Sinks, file)
+			auditTrail.Querier = file
+		}
+		if auditS3Bucket != "" {
+			cfg, err := aws.LoadConfig(setupCtx, awsOptions)
+			if err != nil {
+				setupLog.Error(err, "unable to load aws config for s3")
+				os.Exit(1)
+			}
+			bucket := &audit.S3{Client: s3.NewFromConfig(cfg), Bucket: auditS3Bucket, Prefix: auditS3Prefix}
+			auditTrail.Sinks = append(auditTrail.Sinks, bucket)
+			if auditTrail.Querier == nil {
+				auditTrail.Querier = bucket
+			}
+		}
+		if auditEvents {
+			auditTrail.Sinks = append(auditTrail.Sinks, &audit.Events{Recorder: mgr.GetEventRecorderFor("aws-nlb-controller")})
+		}
+		serviceReconciler.Audit = auditTrail
+		if janitor != nil {
+			janitor.Audit = auditTrail
+		}
+	}
+	serviceAdmin := &controllers.ServiceAdmin{
+		Client:     mgr.GetClient(),
+		Reconciler: serviceReconciler,
+		Resync:     resync,
+	}
+	consistencyChecker := &controllers.ConsistencyChecker{
+		Client:    mgr.GetClient(),
+		AwsClient: awsClient,
+	}
+	var adminServer *admin.Server
+	if adminAddr != "0" {
+		if adminToken == "" {
+			setupLog.Error(errors.New("--admin-token is empty"), "unable to enable the admin api")
+			os.Exit(1)
+		}
+		adminServer = &admin.Server{
+			Addr:       adminAddr,
+			Token:      adminToken,
+			Release:    serviceAdmin.Release,
+			Resync:     serviceAdmin.ResyncService,
+			Verify:     consistencyChecker.Verify,
+			StoreReady: storeReady,
+		}
+		if auditTrail != nil && auditTrail.Querier != nil {
+			adminServer.Audit = auditTrail
+		}
+		if dryRunClient != nil {
+			adminServer.Plan = dryRunClient.Mutations
+		}
+	}
+	var discoverer *controllers.NLBDiscoverer
+	if nlbDiscoveryTag != "" {
+		key, value, ok := strings.Cut(nlbDiscoveryTag, "=")
+		if !ok || key == "" {
+			setupLog.Error(errors.New("not of the form key=value"), "invalid --nlb-discovery-tag")
+			os.Exit(1)
+		}
+		discoverer = &controllers.NLBDiscoverer{
+			AwsClient:  awsClient,
+			TagKey:     key,
+			TagValue:   value,
+			Interval:   nlbDiscoveryInterval,
+			StoreReady: storeReady,
+		}
+	}
+	var nlbListReconciler *controllers.NLBListReconciler
+	if nlbListConfigMap != "" {
+		namespace, name, ok := strings.Cut(nlbListConfigMap, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(errors.New("not of the form namespace/name"), "invalid --nlb-list-configmap")
+			os.Exit(1)
+		}
+		nlbListReconciler = &controllers.NLBListReconciler{
+			Client:     mgr.GetClient(),
+			Key:        types.NamespacedName{Namespace: namespace, Name: name},
+			Admin:      serviceAdmin,
+			StoreReady: storeReady,
+		}
+	}
+	nlbPoolReconciler := &controllers.NLBPoolReconciler{
+		Client:          mgr.GetClient(),
+		Scheme:          mgr.GetScheme(),
+		AwsClient:       awsClient,
+		StoreReady:      storeReady,
+		ScaleInCooldown: scaleInCooldown,
+	}
+	err = mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		nlbs, err := controllers.PoolNLBs(ctx, mgr.GetAPIReader())
+		if err != nil {
+			return fmt.Errorf("unable to list nlbpools: %w", err)
+		}
+		nlbs = append(nlbs, configNLBs...)
+		if nlbListReconciler != nil {
+			listed, err := controllers.ListedNLBs(ctx, mgr.GetAPIReader(), nlbListReconciler.Key)
+			if err != nil {
+				return fmt.Errorf("unable to read nlbs of configmap %s: %w", nlbListReconciler.Key, err)
+			}
+			nlbs = append(nlbs, listed...)
+		}
+		if err := controllers.AssumePoolRoles(ctx, mgr.GetAPIReader(), awsClient); err != nil {
+			return fmt.Errorf("unable to list nlbpools: %w", err)
+		}
+		allocationStore, err := newStore(ctx, mgr, storeOpts, nlbs)
+		if err != nil {
+			return fmt.Errorf("unable to create store %s: %w", storeOpts.Backend, err)
+		}
+		if tracingOptions.Endpoint != "" {
+			allocationStore = store.WithTracing(allocationStore)
+		}
+		if err := metrics.Registry.Register(&controllers.AllocationCollector{Store: allocationStore}); err != nil {
+			return fmt.Errorf("unable to register allocation metrics: %w", err)
+		}
+		if listenerQuotaFromAWS {
+			quota, err := awsClient.ListenerQuota(ctx)
+			if err != nil {
+				setupLog.Error(err, "unable to read the listener quota from service quotas. Using --nlb-listener-quota")
+			} else {
+				listenerQuota = quota
+			}
+		}
+		allocationStore.SetListenerQuota(listenerQuota)
+		allocationStore.SetReservationTTL(storeReservationTTL)
+		allocationStore.SetStickyRetention(storeStickyRetention)
+		allocationStore.SetStrategy(strategy)
+		allocationStore.SetPortHashing(portHashing)
+		if discoverer != nil {
+			discoverer.Store = allocationStore
+			if err := discoverer.Refresh(ctx); err != nil {
+				return fmt.Errorf("unable to discover nlbs: %w", err)
+			}
+		}
+		switch seedFrom {
+		case "annotations":
+			err = controllers.SeedStoreFromServices(ctx, mgr.GetAPIReader(), allocationStore)
+		case "aws":
+			err = controllers.SeedStoreFromAWS(ctx, awsClient, allocationStore)
+		default:
+			err = fmt.Errorf("unknown seed source %q", seedFrom)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to seed store from %s: %w", seedFrom, err)
+		}
+		serviceReconciler.Store = allocationStore
+		nlbPoolReconciler.Store = allocationStore
+		if nlbListReconciler != nil {
+			nlbListReconciler.Store = allocationStore
+		}
+		if driftDetector != nil {
+			driftDetector.Store = allocationStore
+		}
+		if janitor != nil {
+			janitor.Store = allocationStore
+		}
+		consistencyChecker.Store = allocationStore
+		if cloudWatchPublisher != nil {
+			cloudWatchPublisher.Store = allocationStore
+		}
+		healthChecks.Store = allocationStore
+		loadedStore = allocationStore
+		if adminServer != nil {
+			adminServer.Store = allocationStore
+		}
+		close(storeReady)
+		return nil
+	}))
+	if err != nil {
+		setupLog.Error(err, "unable to set up store")
+		os.Exit(1)
+	}
+
+	if discoverer != nil {
+		if err := mgr.Add(discoverer); err != nil {
+			setupLog.Error(err, "unable to set up nlb discovery")
+			os.Exit(1)
+		}
+	}
+
+	if pprofAddr != "0" {
+		if err := validPprofAddr(pprofAddr); err != nil {
+			setupLog.Error(err, "invalid --pprof-bind-address")
+			os.Exit(1)
+		}
+		if err := mgr.Add(&pprofServer{Addr: pprofAddr}); err != nil {
+			setupLog.Error(err, "unable to set up pprof")
+			os.Exit(1)
+		}
+	}
+
+	if adminServer != nil {
+		if err := mgr.Add(adminServer); err != nil {
+			setupLog.Error(err, "unable to set up admin api")
+			os.Exit(1)
+		}
+	}
+
+	if driftDetector != nil {
+		if err := mgr.Add(driftDetector); err != nil {
+			setupLog.Error(err, "unable to set up drift detection")
+			os.Exit(1)
+		}
+	}
+
+	if sweeper != nil {
+		if err := mgr.Add(sweeper); err != nil {
+			setupLog.Error(err, "unable to set up the service sweep")
+			os.Exit(1)
+		}
+	}
+
+	if janitor != nil {
+		if err := mgr.Add(janitor); err != nil {
+			setupLog.Error(err, "unable to set up the store janitor")
+			os.Exit(1)
+		}
+	}
+
+	if hookDispatcher != nil {
+		if err := mgr.Add(hookDispatcher); err != nil {
+			setupLog.Error(err, "unable to set up hooks")
+			os.Exit(1)
+		}
+	}
+
+	if cloudWatchPublisher != nil {
+		if err := mgr.Add(cloudWatchPublisher); err != nil {
+			setupLog.Error(err, "unable to set up cloudwatch metrics")
+			os.Exit(1)
+		}
+	}
+
+	if lifecycleHooks != nil {
+		if err := mgr.Add(lifecycleHooks); err != nil {
+			setupLog.Error(err, "unable to set up lifecycle hook")
+			os.Exit(1)
+		}
+	}
+
+	if err = serviceReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Service")
+		os.Exit(1)
+	}
+	if err = nlbPoolReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "NLBPool")
+		os.Exit(1)
+	}
+	if nlbListReconciler != nil {
+		if err = nlbListReconciler.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "NLBList")
+			os.Exit(1)
+		}
+	}
+	if err = (&controllers.NodeReconciler{
 		Client:    mgr.GetClient(),
 		Scheme:    mgr.GetScheme(),
-		Store:     store.New(),
-		AwsClient: aws.New(context.Background()),
+		AwsClient: awsClient,
+		Nodes:     nodes,
+		Resync:    nodeResync,
+
+		LoadBalancerClass:     loadBalancerClass,
+		TargetSyncConcurrency: targetSyncConcurrency,
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Service")
+		setupLog.Error(err, "unable to create controller", "controller", "Node")
 		os.Exit(1)
 	}
+	if enableServiceWebhook {
+		mgr.GetWebhookServer().Register(controllers.ServiceWebhookPath, &webhook.Admission{
+			Handler: &controllers.ServiceAnnotator{Client: mgr.GetClient()},
+		})
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
+	if err := mgr.AddHealthzCheck("aws-store", healthChecks.Live); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("aws-store", healthChecks.Ready); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	cancelSetup()
+	err = mgr.Start(ctx)
+	select {
+	case <-storeReady:
+		// releases that failed to reach the backend are written once more,
+		// so that their ports are free for the next leader
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := loadedStore.Flush(ctx); err != nil {
+			setupLog.Error(err, "unable to flush store")
+		}
+		cancel()
+	default:
+	}
+	if shutdownTracing != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := shutdownTracing(ctx); err != nil {
+			setupLog.Error(err, "unable to flush spans")
+		}
+		cancel()
+	}
+	if err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// splitList splits a comma separated flag value, dropping empty items.
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// storeOptions selects and configures the allocation store.
+type storeOptions struct {
+	Backend       string
+	Namespace     string
+	ConfigMapName string
+	DynamoDBTable string
+	DynamoDB      store.DynamoDBAPI
+	ClusterID     string
+
+	Redis       redis.UniversalClient
+	RedisPrefix string
+
+	// ReservationTTL is how long the redis and lease stores keep ports
+	// reserved.
+	ReservationTTL time.Duration
+}
+
+// newStore builds the allocation store selected by the --store flag, managing
+// the NLBs of NLB_LIST and nlbs.
+func newStore(ctx context.Context, mgr ctrl.Manager, opts storeOptions, nlbs []store.NLB) (store.Store, error) {
+	switch opts.Backend {
+	case "memory":
+		return store.New(nlbs...), nil
+	case "configmap":
+		if opts.Namespace == "" {
+			return nil, errors.New("--store-namespace or POD_NAMESPACE is required for the configmap store")
+		}
+		// the manager's cached client cannot serve reads before the manager is started
+		c, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme()})
+		if err != nil {
+			return nil, err
+		}
+		return store.NewConfigMapStore(ctx, c, opts.Namespace, opts.ConfigMapName, nlbs...)
+	case "crd":
+		c, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme()})
+		if err != nil {
+			return nil, err
+		}
+		return store.NewCRDStore(ctx, c, nlbs...)
+	case "dynamodb":
+		return store.NewDynamoDBStore(ctx, opts.DynamoDB, opts.DynamoDBTable, opts.ClusterID, nlbs...)
+	case "redis":
+		return store.NewRedisStore(ctx, opts.Redis, opts.RedisPrefix, opts.ReservationTTL, opts.ClusterID, nlbs...)
+	case "lease":
+		if opts.Namespace == "" {
+			return nil, errors.New("--store-namespace or POD_NAMESPACE is required for the lease store")
+		}
+		c, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme()})
+		if err != nil {
+			return nil, err
+		}
+		return store.NewLeaseStore(ctx, c, opts.Namespace, opts.ReservationTTL, opts.ClusterID, nlbs...)
+	default:
+		return nil, fmt.Errorf("unknown store %q", opts.Backend)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestValidPprofAddr(t *testing.T) {
+	for _, addr := range []string{"localhost:6060", "127.0.0.1:6060", "[::1]:6060"} {
+		if err := validPprofAddr(addr); err != nil {
+			t.Errorf("validPprofAddr(%q) error = %v", addr, err)
+		}
+	}
+	for _, addr := range []string{":6060", "0.0.0.0:6060", "10.0.0.1:6060", "localhost"} {
+		if err := validPprofAddr(addr); err == nil {
+			t.Errorf("validPprofAddr(%q) error = nil, want an error", addr)
+		}
+	}
+}
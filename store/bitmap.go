@@ -0,0 +1,99 @@
+package store
+
+import "math/bits"
+
+// portBitmap has a bit for every port of a port range, set while the port is
+// allocated or reserved, and counts the free ones. A free port is found a
+// word of 64 ports at a time, starting at the first word that may have one,
+// so that allocating stays fast on port ranges of thousands of ports.
+type portBitmap struct {
+	portRange PortRange
+	words     []uint64
+	free      int
+	// first is the index of the first word that may have a free port. Every
+	// word before it is full.
+	first int
+}
+
+func newPortBitmap(portRange PortRange) *portBitmap {
+	size := portRange.Max - portRange.Min + 1
+	b := &portBitmap{
+		portRange: portRange,
+		words:     make([]uint64, (size+63)/64),
+		free:      size,
+	}
+	// the ports past the end of the range in the last word are never free
+	if tail := size % 64; tail != 0 {
+		b.words[len(b.words)-1] = ^uint64(0) << tail
+	}
+	return b
+}
+
+// set marks a port taken. Ports outside the range are ignored.
+func (b *portBitmap) set(port int) {
+	if b == nil || port < b.portRange.Min || port > b.portRange.Max {
+		return
+	}
+	i, mask := b.bit(port)
+	if b.words[i]&mask == 0 {
+		b.words[i] |= mask
+		b.free--
+	}
+}
+
+// clear marks a port free. Ports outside the range are ignored.
+func (b *portBitmap) clear(port int) {
+	if b == nil || port < b.portRange.Min || port > b.portRange.Max {
+		return
+	}
+	i, mask := b.bit(port)
+	if b.words[i]&mask != 0 {
+		b.words[i] &^= mask
+		b.free++
+		if i < b.first {
+			b.first = i
+		}
+	}
+}
+
+// lowestFree returns the lowest free port, or false if every port is taken.
+func (b *portBitmap) lowestFree() (int, bool) {
+	if b == nil || b.free == 0 {
+		return 0, false
+	}
+	for ; b.first < len(b.words); b.first++ {
+		if word := b.words[b.first]; word != ^uint64(0) {
+			return b.portRange.Min + b.first*64 + bits.TrailingZeros64(^word), true
+		}
+	}
+	return 0, false
+}
+
+// nextFree returns the first free port at or after port, wrapping around to
+// the start of the range, or false if every port is taken. Ports outside the
+// range start at its start.
+func (b *portBitmap) nextFree(port int) (int, bool) {
+	if b == nil || b.free == 0 {
+		return 0, false
+	}
+	if port < b.portRange.Min || port > b.portRange.Max {
+		port = b.portRange.Min
+	}
+	i, mask := b.bit(port)
+	// the ports before port in its word are only free on the way back
+	word := b.words[i] | (mask - 1)
+	for n := 0; n <= len(b.words); n++ {
+		if word != ^uint64(0) {
+			return b.portRange.Min + i*64 + bits.TrailingZeros64(^word), true
+		}
+		i = (i + 1) % len(b.words)
+		word = b.words[i]
+	}
+	return 0, false
+}
+
+// bit returns the word and the mask of the bit of a port.
+func (b *portBitmap) bit(port int) (int, uint64) {
+	offset := port - b.portRange.Min
+	return offset / 64, 1 << (offset % 64)
+}
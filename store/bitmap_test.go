@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestPortBitmap(t *testing.T) {
+	b := newPortBitmap(PortRange{Min: 9000, Max: 9129})
+	if b.free != 130 {
+		t.Fatalf("free = %d, want 130", b.free)
+	}
+	for port := 9000; port <= 9129; port++ {
+		if port != 9100 && port != 9129 {
+			b.set(port)
+		}
+	}
+	b.set(8999)
+	b.set(9130)
+	if port, ok := b.lowestFree(); !ok || port != 9100 {
+		t.Errorf("lowestFree() = %d, %v, want 9100", port, ok)
+	}
+	b.set(9100)
+	if port, ok := b.lowestFree(); !ok || port != 9129 {
+		t.Errorf("lowestFree() = %d, %v, want 9129", port, ok)
+	}
+	b.set(9129)
+	if port, ok := b.lowestFree(); ok {
+		t.Errorf("lowestFree() = %d of a full range", port)
+	}
+	b.clear(9001)
+	b.clear(9001)
+	if port, ok := b.lowestFree(); !ok || port != 9001 || b.free != 1 {
+		t.Errorf("lowestFree() = %d, %v with %d free, want 9001 with 1 free", port, ok, b.free)
+	}
+}
+
+func TestPortBitmapNextFree(t *testing.T) {
+	b := newPortBitmap(PortRange{Min: 9000, Max: 9129})
+	for _, port := range []int{9010, 9011, 9070, 9128, 9129} {
+		b.set(port)
+	}
+	for _, tt := range []struct{ from, want int }{
+		{9010, 9012},
+		{9070, 9071},
+		{9128, 9000},
+		{9005, 9005},
+		{8000, 9000},
+	} {
+		if port, ok := b.nextFree(tt.from); !ok || port != tt.want {
+			t.Errorf("nextFree(%d) = %d, %v, want %d", tt.from, port, ok, tt.want)
+		}
+	}
+	for port := 9000; port <= 9129; port++ {
+		b.set(port)
+	}
+	b.clear(9011)
+	if port, ok := b.nextFree(9100); !ok || port != 9011 {
+		t.Errorf("nextFree(9100) = %d, %v, want 9011", port, ok)
+	}
+	b.set(9011)
+	if port, ok := b.nextFree(9100); ok {
+		t.Errorf("nextFree(9100) = %d of a full range", port)
+	}
+}
+
+func TestPortHashing(t *testing.T) {
+	ctx := context.Background()
+	nlbs := []NLB{{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: PortRange{Min: 9000, Max: 9999}}}
+	first, second := newStore(nlbs), newStore(nlbs)
+	first.SetPortHashing(true)
+	second.SetPortHashing(true)
+	names := []string{"default/web:http", "default/api:http", "shop/checkout:https"}
+	ports := map[string]int{}
+	for _, name := range names {
+		_, port, err := first.GetVacantNLBAndPortForService(ctx, name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ports[name] = port
+	}
+	// a rebuilt cluster allocates the same ports in any order
+	for i := len(names) - 1; i >= 0; i-- {
+		if _, port, err := second.GetVacantNLBAndPortForService(ctx, names[i], nil); err != nil || port != ports[names[i]] {
+			t.Errorf("GetVacantNLBAndPortForService(%s) = %d, %v after a rebuild, want %d", names[i], port, err, ports[names[i]])
+		}
+	}
+	// a svc whose port is taken gets the next free one
+	want := hashPort("default/other:http", nlbs[0].PortRange)
+	first.take("shared", want, &names[0])
+	if _, port, err := first.GetVacantNLBAndPortForService(ctx, "default/other:http", nil); err != nil || port == want {
+		t.Errorf("GetVacantNLBAndPortForService() = %d, %v, want a port other than the taken %d", port, err, want)
+	}
+}
+
+func TestVacantPicksLowestFreePort(t *testing.T) {
+	s := newStore([]NLB{{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: PortRange{Min: 9000, Max: 9099}}})
+	s.SetListenerQuota(1000)
+	for i := 0; i < 70; i++ {
+		if _, _, err := s.vacant(context.Background(), fmt.Sprintf("default/web-%d:http", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.release("default/web-3:http", "shared", 9003)
+	s.release("default/web-65:http", "shared", 9065)
+	for _, want := range []int{9003, 9065, 9070} {
+		if _, port, err := s.vacant(context.Background(), "default/api:http", nil); err != nil || port != want {
+			t.Errorf("vacant() = %d, %v, want %d", port, err, want)
+		}
+	}
+	s.AddNLB(NLB{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: PortRange{Min: 9000, Max: 9199}})
+	if allocated, free := s.PoolUsage("shared"); allocated != 71 || free != 129 {
+		t.Errorf("PoolUsage() = %d, %d after growing the range, want 71, 129", allocated, free)
+	}
+}
+
+// benchmarkVacant reserves and releases a port on the last free NLB of a
+// pool of nlbs NLBs of ports ports each, all others full.
+func benchmarkVacant(b *testing.B, nlbs int, ports int) {
+	ctx := context.Background()
+	pool := make([]NLB, nlbs)
+	for i := range pool {
+		pool[i] = NLB{Name: fmt.Sprintf("nlb-%d", i), Host: "elb.amazonaws.com", PortRange: PortRange{Min: 10000, Max: 10000 + ports - 1}}
+	}
+	s := newStore(pool)
+	s.SetListenerQuota(ports)
+	for i := 0; i < nlbs*ports-1; i++ {
+		if _, _, err := s.GetVacantNLBAndPortForService(ctx, fmt.Sprintf("default/web-%d:http", i), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nlb, port, err := s.GetVacantNLBAndPortForService(ctx, "default/api:http", nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		s.ReleaseNLBAndPortForService(ctx, "default/api:http", nlb, port)
+	}
+}
+
+func BenchmarkVacant10NLBs50Ports(b *testing.B)     { benchmarkVacant(b, 10, 50) }
+func BenchmarkVacant10NLBs5000Ports(b *testing.B)   { benchmarkVacant(b, 10, 5000) }
+func BenchmarkVacant200NLBs5000Ports(b *testing.B)  { benchmarkVacant(b, 200, 5000) }
+func BenchmarkVacant1000NLBs1000Ports(b *testing.B) { benchmarkVacant(b, 1000, 1000) }
@@ -0,0 +1,297 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const configMapDataKey = "allocations.json"
+
+type snapshot struct {
+	ServiceAllocationMap typeServiceAllocationMap `json:"services"`
+	NlbAllocationMap     typeNlbAllocationMap     `json:"nlbs"`
+}
+
+// configMapStore is an in-memory store whose allocations are written through
+// to a ConfigMap so that they survive controller restarts.
+type configMapStore struct {
+	*store
+	client          client.Client
+	key             types.NamespacedName
+	resourceVersion string
+
+	// unmanaged are the allocations of the ConfigMap on NLBs the store does
+	// not manage. They are written back unchanged so that they are not lost
+	// while their NLB is missing from the configuration, and adopted once it
+	// is added.
+	unmanaged typeServiceAllocationMap
+
+	// unreleased are the releases that could not be written to the
+	// ConfigMap, written again by Flush.
+	unreleased typeServiceAllocationMap
+}
+
+// NewConfigMapStore returns a Store backed by the ConfigMap namespace/name,
+// managing the NLBs of NLB_LIST and nlbs. The ConfigMap is created if it does
+// not exist, otherwise the allocations it holds are loaded into memory.
+func NewConfigMapStore(ctx context.Context, c client.Client, namespace string, name string, nlbs ...NLB) (Store, error) {
+	s := &configMapStore{
+		store:      newStore(nlbs),
+		client:     c,
+		key:        types.NamespacedName{Namespace: namespace, Name: name},
+		unmanaged:  typeServiceAllocationMap{},
+		unreleased: typeServiceAllocationMap{},
+	}
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *configMapStore) load(ctx context.Context) error {
+	var cm corev1.ConfigMap
+	err := s.client.Get(ctx, s.key, &cm)
+	if apierrors.IsNotFound(err) {
+		cm = corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: s.key.Namespace, Name: s.key.Name},
+		}
+		if err := s.client.Create(ctx, &cm); err != nil {
+			return classify(fmt.Errorf("store: unable to create configmap %s: %w", s.key, err))
+		}
+		s.resourceVersion = cm.ResourceVersion
+		return nil
+	}
+	if err != nil {
+		return classify(fmt.Errorf("store: unable to fetch configmap %s: %w", s.key, err))
+	}
+	return s.apply(ctx, &cm)
+}
+
+// apply replaces the allocations in memory with the ones of cm. Ports that are
+// only reserved by GetVacantNLBAndPortForService stay reserved. The caller
+// must hold mu.
+func (s *configMapStore) apply(ctx context.Context, cm *corev1.ConfigMap) error {
+	var snap snapshot
+	if raw, ok := cm.Data[configMapDataKey]; ok {
+		if err := json.Unmarshal([]byte(raw), &snap); err != nil {
+			return fmt.Errorf("store: malformed data in configmap %s: %w", s.key, err)
+		}
+	}
+	s.resourceVersion = cm.ResourceVersion
+
+	for nlb, ports := range s.NlbAllocationMap {
+		for port, name := range ports {
+			if allocation, ok := s.ServiceAllocationMap[*name]; ok && allocation.NLB == nlb && allocation.Port == port {
+				s.free(nlb, port)
+			}
+		}
+	}
+	s.ServiceAllocationMap = typeServiceAllocationMap{}
+	s.unmanaged = typeServiceAllocationMap{}
+	for name, allocation := range snap.ServiceAllocationMap {
+		if _, ok := s.NlbAllocationMap[allocation.NLB]; !ok {
+			loggerFrom(ctx).Info("store: keeping allocation for unmanaged nlb", "svc", name, "nlb", allocation.NLB)
+			s.unmanaged[name] = allocation
+			continue
+		}
+		s.ServiceAllocationMap[name] = allocation
+		s.take(allocation.NLB, allocation.Port, &allocation.ServiceNamespacedName)
+		if allocation.Sticky {
+			s.stickies[name] = true
+		}
+	}
+	s.observePools()
+	return nil
+}
+
+// reload fetches the ConfigMap and applies it. The caller must hold mu.
+func (s *configMapStore) reload(ctx context.Context) error {
+	var cm corev1.ConfigMap
+	if err := s.client.Get(ctx, s.key, &cm); err != nil {
+		return classify(fmt.Errorf("store: unable to fetch configmap %s: %w", s.key, err))
+	}
+	return s.apply(ctx, &cm)
+}
+
+// update applies change to the allocations in memory and writes them to the
+// ConfigMap. The update carries the last seen resourceVersion. When another
+// writer updated the ConfigMap in the meantime, its allocations are loaded and
+// change is applied on top of them again, so that neither write is lost. If
+// the write fails, the undo function returned by change is called. The caller
+// must hold mu.
+func (s *configMapStore) update(ctx context.Context, change func() (func(), error)) error {
+	var undo func()
+	attempt := 0
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if attempt > 0 {
+			if err := s.reload(ctx); err != nil {
+				return err
+			}
+		}
+		attempt++
+		var err error
+		if undo, err = change(); err != nil {
+			undo = nil
+			return err
+		}
+		return s.persist(ctx)
+	})
+	if err != nil && undo != nil {
+		undo()
+	}
+	return err
+}
+
+// persist writes the current allocations to the ConfigMap. The caller must
+// hold mu.
+func (s *configMapStore) persist(ctx context.Context) error {
+	allocations := typeServiceAllocationMap{}
+	for name, allocation := range s.unmanaged {
+		allocations[name] = allocation
+	}
+	for name, allocation := range s.ServiceAllocationMap {
+		allocations[name] = allocation
+	}
+	raw, err := json.Marshal(snapshot{
+		ServiceAllocationMap: allocations,
+		NlbAllocationMap:     s.committedNlbAllocations(),
+	})
+	if err != nil {
+		return err
+	}
+	cm := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       s.key.Namespace,
+			Name:            s.key.Name,
+			ResourceVersion: s.resourceVersion,
+		},
+		Data: map[string]string{configMapDataKey: string(raw)},
+	}
+	if err := s.client.Update(ctx, &cm); err != nil {
+		return classify(fmt.Errorf("store: unable to update configmap %s: %w", s.key, err))
+	}
+	s.resourceVersion = cm.ResourceVersion
+	return nil
+}
+
+// committedNlbAllocations returns the NLB port map without ports that are only
+// reserved by GetVacantNLBAndPortForService and not yet assigned. The caller
+// must hold mu.
+func (s *configMapStore) committedNlbAllocations() typeNlbAllocationMap {
+	committed := typeNlbAllocationMap{}
+	for nlb := range s.NlbAllocationMap {
+		committed[nlb] = map[int]*string{}
+	}
+	for _, allocations := range []typeServiceAllocationMap{s.unmanaged, s.ServiceAllocationMap} {
+		for _, allocation := range allocations {
+			if _, ok := committed[allocation.NLB]; !ok {
+				committed[allocation.NLB] = map[int]*string{}
+			}
+			committed[allocation.NLB][allocation.Port] = &allocation.ServiceNamespacedName
+		}
+	}
+	return committed
+}
+
+// AddNLB adds an NLB like the in-memory store, and adopts the allocations of
+// the ConfigMap on it.
+func (s *configMapStore) AddNLB(nlb NLB) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store.addNLB(nlb)
+	s.store.adopt(s.unmanaged, nlb.Name)
+}
+
+func (s *configMapStore) AssignNLBAndPortToServiceInNamespace(
+	ctx context.Context,
+	nlb string,
+	port int,
+	serviceNamespacedName string,
+	listenerArn string,
+	targetArn string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(ctx, func() (func(), error) {
+		return s.store.assignWithUndo(nlb, port, serviceNamespacedName, listenerArn, targetArn)
+	})
+}
+
+func (s *configMapStore) ReleaseNLBAndPortForService(ctx context.Context, serviceNamespacedName string, nlb string, port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.update(ctx, func() (func(), error) {
+		s.store.release(serviceNamespacedName, nlb, port)
+		return nil, nil
+	})
+	if err != nil {
+		loggerFrom(ctx).Error(err, "store: unable to persist release", "svc", serviceNamespacedName)
+		s.unreleased[serviceNamespacedName] = &Allocation{ServiceNamespacedName: serviceNamespacedName, NLB: nlb, Port: port}
+		return
+	}
+	delete(s.unreleased, serviceNamespacedName)
+}
+
+// Flush releases the unreleased allocations again and writes them to the
+// ConfigMap.
+func (s *configMapStore) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.unreleased) == 0 {
+		return nil
+	}
+	err := s.update(ctx, func() (func(), error) {
+		for name, allocation := range s.unreleased {
+			s.store.release(name, allocation.NLB, allocation.Port)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	s.unreleased = typeServiceAllocationMap{}
+	return nil
+}
+
+func (s *configMapStore) RetainNLBAndPortForService(ctx context.Context, serviceNamespacedName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(ctx, func() (func(), error) {
+		return s.store.retain(serviceNamespacedName)
+	})
+}
+
+func (s *configMapStore) StickNLBAndPortForService(ctx context.Context, serviceNamespacedName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(ctx, func() (func(), error) {
+		return s.store.stick(serviceNamespacedName)
+	})
+}
+
+// GetVacantNLBAndPortForService reserves a port like the in-memory store, and
+// writes the sticky allocations that expired to the ConfigMap first.
+func (s *configMapStore) GetVacantNLBAndPortForService(ctx context.Context, serviceNamespacedName string, allowed NLBFilter) (string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if expired := s.store.expireSticky(ctx); len(expired) > 0 {
+		err := s.update(ctx, func() (func(), error) {
+			// a reload brings back the expired allocations
+			s.store.expireSticky(ctx)
+			return nil, nil
+		})
+		if err != nil {
+			loggerFrom(ctx).Error(err, "store: unable to persist expired sticky allocations")
+		}
+	}
+	s.store.expireReservations(ctx)
+	return s.store.vacant(ctx, serviceNamespacedName, allowed)
+}
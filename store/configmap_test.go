@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// failingUpdates fails every Update with err.
+type failingUpdates struct {
+	client.Client
+	err error
+}
+
+func (f failingUpdates) Update(context.Context, client.Object, ...client.UpdateOption) error {
+	return f.err
+}
+
+func newTestConfigMapStore(t *testing.T, c client.Client) Store {
+	t.Helper()
+	s, err := NewConfigMapStore(context.Background(), c, "kube-system", "allocations",
+		NLB{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: PortRange{Min: 9000, Max: 9009}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestConfigMapStoreMergesConflictingWrites(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().Build()
+	first := newTestConfigMapStore(t, c)
+	// second loaded the ConfigMap before first wrote to it, as a previous
+	// leader may have
+	second := newTestConfigMapStore(t, c)
+
+	if err := first.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9000, "default/web:http", "listener-web", "target-web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9001, "default/api:http", "listener-api", "target-api"); err != nil {
+		t.Fatalf("AssignNLBAndPortToServiceInNamespace() error = %v on a stale ConfigMap, want the write merged", err)
+	}
+	if err := second.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9000, "default/other:http", "listener-other", "target-other"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("AssignNLBAndPortToServiceInNamespace() error = %v for the port of default/web, want ErrUnavailable", err)
+	}
+
+	reloaded := newTestConfigMapStore(t, c)
+	for name, port := range map[string]int{"default/web:http": 9000, "default/api:http": 9001} {
+		if allocation := reloaded.GetAllocationForSVC(ctx, name); allocation == nil || allocation.Port != port {
+			t.Errorf("allocation of %s = %+v after the conflict, want port %d", name, allocation, port)
+		}
+	}
+}
+
+func TestConfigMapStoreUndoesFailedWrites(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().Build()
+	s := newTestConfigMapStore(t, c)
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9000, "default/web:http", "listener-web", "target-web"); err != nil {
+		t.Fatal(err)
+	}
+
+	broken := s.(*configMapStore)
+	broken.client = failingUpdates{Client: c, err: errors.New("etcd unavailable")}
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9001, "default/api:http", "listener-api", "target-api"); err == nil {
+		t.Fatal("AssignNLBAndPortToServiceInNamespace() error = nil with the ConfigMap failing to update")
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/api:http"); allocation != nil {
+		t.Errorf("allocation %+v kept after the write failed, want it undone", allocation)
+	}
+	if err := s.RetainNLBAndPortForService(ctx, "default/web:http"); err == nil {
+		t.Fatal("RetainNLBAndPortForService() error = nil with the ConfigMap failing to update")
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation == nil || allocation.Retained {
+		t.Errorf("allocation %+v after the write failed, want it not retained", allocation)
+	}
+
+	broken.client = c
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9001, "default/other:http", "listener-other", "target-other"); err != nil {
+		t.Errorf("AssignNLBAndPortToServiceInNamespace() error = %v, want the port of the undone write free", err)
+	}
+}
+
+func TestConfigMapStoreReloadsOnRestart(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().Build()
+	s := newTestConfigMapStore(t, c)
+	s.SetStickyRetention(time.Hour)
+	for name, port := range map[string]int{"default/web:http": 9000, "default/old:http": 9001} {
+		if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "shared", port, name, "listener-"+name, "target-"+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.StickNLBAndPortForService(ctx, "default/old:http"); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newTestConfigMapStore(t, c)
+	if allocation := restarted.GetAllocationForSVC(ctx, "default/web:http"); allocation == nil || allocation.Port != 9000 || allocation.ListenerArn != "listener-default/web:http" {
+		t.Errorf("allocation of default/web = %+v after a restart, want port 9000 and its listener", allocation)
+	}
+	if allocation := restarted.GetAllocationForSVC(ctx, "default/old:http"); allocation == nil || !allocation.Sticky {
+		t.Errorf("allocation of default/old = %+v after a restart, want it sticky", allocation)
+	}
+	if _, port, err := restarted.GetVacantNLBAndPortForService(ctx, "default/new:http", nil); err != nil || port == 9000 || port == 9001 {
+		t.Errorf("GetVacantNLBAndPortForService() = %d, %v, want a port other than the reloaded ones", port, err)
+	}
+	if _, port, err := restarted.GetVacantNLBAndPortForService(ctx, "default/old:http", nil); err != nil || port != 9001 {
+		t.Errorf("GetVacantNLBAndPortForService() = %d, %v for the svc of the sticky port, want 9001", port, err)
+	}
+}
@@ -0,0 +1,269 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	nlbv1alpha1 "github.com/chinmayrelkar/aws-nlb-controller/api/v1alpha1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// crdStore is an in-memory store that mirrors every allocation into an
+// NLBAllocation custom resource in the namespace of the Service.
+type crdStore struct {
+	*store
+	client client.Client
+
+	// legacyKeys are the NLBAllocations of loaded allocations whose
+	// resource is not named by allocationObjectKey, such as resources
+	// created before it separated the port with a dot.
+	legacyKeys map[string]client.ObjectKey
+
+	// unmanaged are the allocations of resources on NLBs the store does not
+	// manage yet. They are adopted once their NLB is added.
+	unmanaged typeServiceAllocationMap
+
+	// unreleased are the released allocations whose NLBAllocation could not
+	// be deleted, deleted again by Flush.
+	unreleased map[string]client.ObjectKey
+}
+
+// NewCRDStore returns a Store backed by NLBAllocation resources, managing the
+// NLBs of NLB_LIST and nlbs. Existing resources are loaded into memory before
+// the store is returned.
+func NewCRDStore(ctx context.Context, c client.Client, nlbs ...NLB) (Store, error) {
+	s := &crdStore{
+		store:      newStore(nlbs),
+		client:     c,
+		legacyKeys: map[string]client.ObjectKey{},
+		unmanaged:  typeServiceAllocationMap{},
+		unreleased: map[string]client.ObjectKey{},
+	}
+	var list nlbv1alpha1.NLBAllocationList
+	if err := c.List(ctx, &list); err != nil {
+		return nil, classify(fmt.Errorf("store: unable to list nlballocations: %w", err))
+	}
+	for _, item := range list.Items {
+		spec := item.Spec
+		allocation := &Allocation{
+			ListenerArn:           spec.ListenerArn,
+			TargetArn:             spec.TargetGroupArn,
+			NLB:                   spec.NLB,
+			Port:                  spec.Port,
+			ServiceNamespacedName: spec.ServiceName,
+			Retained:              spec.Retained,
+			Sticky:                spec.Sticky,
+		}
+		if spec.StickyUntil != nil {
+			allocation.StickyUntil = spec.StickyUntil.Time
+		}
+		if key := client.ObjectKeyFromObject(&item); key != allocationObjectKey(spec.ServiceName) {
+			s.legacyKeys[spec.ServiceName] = key
+		}
+		if _, ok := s.NlbAllocationMap[spec.NLB]; !ok {
+			loggerFrom(ctx).Info("store: keeping allocation for unmanaged nlb", "svc", spec.ServiceName, "nlb", spec.NLB)
+			s.unmanaged[spec.ServiceName] = allocation
+			continue
+		}
+		s.ServiceAllocationMap[spec.ServiceName] = allocation
+		s.take(spec.NLB, spec.Port, &allocation.ServiceNamespacedName)
+		if allocation.Sticky {
+			s.stickies[spec.ServiceName] = true
+		}
+	}
+	s.observePools()
+	return s, nil
+}
+
+// allocationObjectKey maps an allocation key of the form namespace/name:port
+// to the namespace and name of its NLBAllocation, name.port. Neither Service
+// names nor port names can contain a dot, so every allocation gets its own
+// resource.
+func allocationObjectKey(serviceNamespacedName string) client.ObjectKey {
+	name := strings.Replace(serviceNamespacedName, ":", ".", 1)
+	namespace, name, found := strings.Cut(name, "/")
+	if !found {
+		return client.ObjectKey{Name: name}
+	}
+	return client.ObjectKey{Namespace: namespace, Name: name}
+}
+
+// objectKey returns the NLBAllocation of an allocation.
+func (s *crdStore) objectKey(serviceNamespacedName string) client.ObjectKey {
+	if key, ok := s.legacyKeys[serviceNamespacedName]; ok {
+		return key
+	}
+	return allocationObjectKey(serviceNamespacedName)
+}
+
+// AddNLB adds an NLB like the in-memory store, and adopts the allocations of
+// the NLBAllocations on it.
+func (s *crdStore) AddNLB(nlb NLB) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store.addNLB(nlb)
+	s.store.adopt(s.unmanaged, nlb.Name)
+}
+
+func (s *crdStore) AssignNLBAndPortToServiceInNamespace(
+	ctx context.Context,
+	nlb string,
+	port int,
+	serviceNamespacedName string,
+	listenerArn string,
+	targetArn string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	undo, err := s.store.assignWithUndo(nlb, port, serviceNamespacedName, listenerArn, targetArn)
+	if err != nil {
+		return err
+	}
+
+	key := s.objectKey(serviceNamespacedName)
+	allocation := &nlbv1alpha1.NLBAllocation{
+		ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, s.client, allocation, func() error {
+		allocation.Spec = nlbv1alpha1.NLBAllocationSpec{
+			ServiceName:    serviceNamespacedName,
+			NLB:            nlb,
+			Port:           port,
+			ListenerArn:    listenerArn,
+			TargetGroupArn: targetArn,
+		}
+		return nil
+	})
+	if err != nil {
+		undo()
+		return classify(fmt.Errorf("store: unable to save nlballocation %s: %w", key, err))
+	}
+	return nil
+}
+
+// RetainNLBAndPortForService marks the allocation retained in memory and on
+// its NLBAllocation.
+func (s *crdStore) RetainNLBAndPortForService(ctx context.Context, serviceNamespacedName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	undo, err := s.store.retain(serviceNamespacedName)
+	if err != nil {
+		return err
+	}
+
+	key := s.objectKey(serviceNamespacedName)
+	retained := s.ServiceAllocationMap[serviceNamespacedName]
+	allocation := &nlbv1alpha1.NLBAllocation{
+		ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, s.client, allocation, func() error {
+		allocation.Spec = nlbv1alpha1.NLBAllocationSpec{
+			ServiceName:    serviceNamespacedName,
+			NLB:            retained.NLB,
+			Port:           retained.Port,
+			ListenerArn:    retained.ListenerArn,
+			TargetGroupArn: retained.TargetArn,
+			Retained:       true,
+		}
+		return nil
+	})
+	if err != nil {
+		undo()
+		return classify(fmt.Errorf("store: unable to save nlballocation %s: %w", key, err))
+	}
+	return nil
+}
+
+// StickNLBAndPortForService marks the allocation sticky in memory and on its
+// NLBAllocation.
+func (s *crdStore) StickNLBAndPortForService(ctx context.Context, serviceNamespacedName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	undo, err := s.store.stick(serviceNamespacedName)
+	if err != nil {
+		return err
+	}
+
+	key := s.objectKey(serviceNamespacedName)
+	sticky := s.ServiceAllocationMap[serviceNamespacedName]
+	allocation := &nlbv1alpha1.NLBAllocation{
+		ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, s.client, allocation, func() error {
+		allocation.Spec = nlbv1alpha1.NLBAllocationSpec{
+			ServiceName: serviceNamespacedName,
+			NLB:         sticky.NLB,
+			Port:        sticky.Port,
+			Sticky:      true,
+			StickyUntil: &metav1.Time{Time: sticky.StickyUntil},
+		}
+		return nil
+	})
+	if err != nil {
+		undo()
+		return classify(fmt.Errorf("store: unable to save nlballocation %s: %w", key, err))
+	}
+	return nil
+}
+
+// GetVacantNLBAndPortForService reserves a port like the in-memory store,
+// after deleting the NLBAllocations of the sticky allocations that expired.
+func (s *crdStore) GetVacantNLBAndPortForService(ctx context.Context, serviceNamespacedName string, allowed NLBFilter) (string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, expired := range s.store.expireSticky(ctx) {
+		s.deleteAllocation(ctx, expired.ServiceNamespacedName)
+	}
+	s.store.expireReservations(ctx)
+	return s.store.vacant(ctx, serviceNamespacedName, allowed)
+}
+
+func (s *crdStore) ReleaseNLBAndPortForService(ctx context.Context, serviceNamespacedName string, nlb string, port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store.release(serviceNamespacedName, nlb, port)
+	s.deleteAllocation(ctx, serviceNamespacedName)
+}
+
+// deleteAllocation deletes the NLBAllocation of a released allocation. If
+// that fails, it is deleted again by Flush. The caller must hold mu.
+func (s *crdStore) deleteAllocation(ctx context.Context, serviceNamespacedName string) {
+	key := s.objectKey(serviceNamespacedName)
+	allocation := &nlbv1alpha1.NLBAllocation{
+		ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+	}
+	if err := s.client.Delete(ctx, allocation); err != nil && !apierrors.IsNotFound(err) {
+		loggerFrom(ctx).Error(err, "store: unable to delete nlballocation", "svc", serviceNamespacedName)
+		s.unreleased[serviceNamespacedName] = key
+		return
+	}
+	delete(s.legacyKeys, serviceNamespacedName)
+	delete(s.unreleased, serviceNamespacedName)
+}
+
+// Flush deletes the NLBAllocations of the unreleased allocations again,
+// unless their ports were assigned again in the meantime.
+func (s *crdStore) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, key := range s.unreleased {
+		if _, ok := s.ServiceAllocationMap[name]; ok {
+			delete(s.unreleased, name)
+			continue
+		}
+		allocation := &nlbv1alpha1.NLBAllocation{
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+		}
+		if err := s.client.Delete(ctx, allocation); err != nil && !apierrors.IsNotFound(err) {
+			return classify(fmt.Errorf("store: unable to delete nlballocation %s: %w", key, err))
+		}
+		delete(s.legacyKeys, name)
+		delete(s.unreleased, name)
+	}
+	return nil
+}
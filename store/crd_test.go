@@ -0,0 +1,142 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	nlbv1alpha1 "github.com/chinmayrelkar/aws-nlb-controller/api/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// failingDeletes fails every Delete with err.
+type failingDeletes struct {
+	client.Client
+	err error
+}
+
+func (f failingDeletes) Delete(context.Context, client.Object, ...client.DeleteOption) error {
+	return f.err
+}
+
+func newCRDClient(t *testing.T, allocations ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := nlbv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(allocations...).Build()
+}
+
+func newTestCRDStore(t *testing.T, c client.Client, nlbs ...NLB) *crdStore {
+	t.Helper()
+	s, err := NewCRDStore(context.Background(), c, nlbs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*crdStore)
+}
+
+var crdTestNLB = NLB{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: PortRange{Min: 9000, Max: 9009}}
+
+func TestCRDStoreUpdatesLegacyAllocationsInPlace(t *testing.T) {
+	ctx := context.Background()
+	// named before allocationObjectKey separated the port with a dot
+	legacy := &nlbv1alpha1.NLBAllocation{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: nlbv1alpha1.NLBAllocationSpec{
+			ServiceName: "default/web:http", NLB: "shared", Port: 9000,
+			ListenerArn: "listener-web", TargetGroupArn: "target-web",
+		},
+	}
+	c := newCRDClient(t, legacy)
+	s := newTestCRDStore(t, c, crdTestNLB)
+
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation == nil || allocation.Port != 9000 {
+		t.Fatalf("allocation of default/web = %+v, want the legacy allocation loaded", allocation)
+	}
+	if err := s.RetainNLBAndPortForService(ctx, "default/web:http"); err != nil {
+		t.Fatal(err)
+	}
+	var updated nlbv1alpha1.NLBAllocation
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if !updated.Spec.Retained {
+		t.Errorf("legacy nlballocation spec = %+v, want it retained", updated.Spec)
+	}
+	if err := c.Get(ctx, allocationObjectKey("default/web:http"), &nlbv1alpha1.NLBAllocation{}); err == nil {
+		t.Errorf("nlballocation %s created next to the legacy one", allocationObjectKey("default/web:http"))
+	}
+
+	s.ReleaseNLBAndPortForService(ctx, "default/web:http", "shared", 9000)
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "web"}, &nlbv1alpha1.NLBAllocation{}); err == nil {
+		t.Error("legacy nlballocation kept after the release")
+	}
+	if _, ok := s.legacyKeys["default/web:http"]; ok {
+		t.Error("legacy key kept after the release")
+	}
+}
+
+func TestCRDStoreAdoptsAllocationsOfAddedNLB(t *testing.T) {
+	ctx := context.Background()
+	c := newCRDClient(t, &nlbv1alpha1.NLBAllocation{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web.http"},
+		Spec: nlbv1alpha1.NLBAllocationSpec{
+			ServiceName: "default/web:http", NLB: "other", Port: 9100,
+			ListenerArn: "listener-web", TargetGroupArn: "target-web",
+		},
+	})
+	s := newTestCRDStore(t, c, crdTestNLB)
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation != nil {
+		t.Fatalf("allocation %+v on an unmanaged nlb, want it kept aside", allocation)
+	}
+
+	s.AddNLB(NLB{Name: "other", Host: "other.elb.amazonaws.com", PortRange: PortRange{Min: 9100, Max: 9109}})
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation == nil || allocation.NLB != "other" || allocation.Port != 9100 {
+		t.Errorf("allocation of default/web = %+v after its nlb was added, want port 9100 of other", allocation)
+	}
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "other", 9100, "default/api:http", "listener-api", "target-api"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("AssignNLBAndPortToServiceInNamespace() error = %v for the adopted port, want ErrUnavailable", err)
+	}
+}
+
+func TestCRDStoreFlushDeletesUnreleasedAllocations(t *testing.T) {
+	ctx := context.Background()
+	c := newCRDClient(t)
+	s := newTestCRDStore(t, c, crdTestNLB)
+	for name, port := range map[string]int{"default/web:http": 9000, "default/api:http": 9001} {
+		if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "shared", port, name, "listener-"+name, "target-"+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.client = failingDeletes{Client: c, err: errors.New("apiserver unavailable")}
+	s.ReleaseNLBAndPortForService(ctx, "default/web:http", "shared", 9000)
+	s.ReleaseNLBAndPortForService(ctx, "default/api:http", "shared", 9001)
+	if err := s.Flush(ctx); err == nil {
+		t.Fatal("Flush() error = nil with deletes failing")
+	}
+
+	s.client = c
+	// the port of default/api was assigned again before the flush
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9001, "default/api:http", "listener-api", "target-api"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(s.unreleased) != 0 {
+		t.Errorf("unreleased = %v after the flush, want none", s.unreleased)
+	}
+	if err := c.Get(ctx, allocationObjectKey("default/web:http"), &nlbv1alpha1.NLBAllocation{}); err == nil {
+		t.Error("nlballocation of default/web kept after the flush")
+	}
+	if err := c.Get(ctx, allocationObjectKey("default/api:http"), &nlbv1alpha1.NLBAllocation{}); err != nil {
+		t.Errorf("nlballocation of default/api: %v, want it kept for its new assignment", err)
+	}
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDBAPI is the part of the DynamoDB client the DynamoDB store uses.
+type DynamoDBAPI interface {
+	dynamodb.ScanAPIClient
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// Attributes of the items of the DynamoDB table. Every item is a claim.
+const (
+	dynamoDBKey         = "id"
+	dynamoDBCluster     = "cluster"
+	dynamoDBService     = "service"
+	dynamoDBNLB         = "nlb"
+	dynamoDBPort        = "port"
+	dynamoDBListener    = "listener_arn"
+	dynamoDBTargetGroup = "target_group_arn"
+	dynamoDBRetained    = "retained"
+)
+
+// dynamoDBClaims keeps claims in a DynamoDB table, with one item per port
+// of an NLB. Claims are conditional writes.
+type dynamoDBClaims struct {
+	client DynamoDBAPI
+	table  string
+}
+
+// NewDynamoDBStore returns a Store backed by the DynamoDB table, managing the
+// NLBs of NLB_LIST and nlbs for the cluster. The table must have a string
+// partition key named id. Existing claims are loaded into memory before the
+// store is returned, and reservations this cluster left behind are deleted.
+func NewDynamoDBStore(ctx context.Context, c DynamoDBAPI, table string, cluster string, nlbs ...NLB) (Store, error) {
+	return newSharedStore(ctx, &dynamoDBClaims{client: c, table: table}, cluster, nlbs)
+}
+
+func (d *dynamoDBClaims) list(ctx context.Context) ([]claim, error) {
+	var claims []claim
+	pages := dynamodb.NewScanPaginator(d.client, &dynamodb.ScanInput{
+		TableName:      aws.String(d.table),
+		ConsistentRead: aws.Bool(true),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, classify(fmt.Errorf("store: unable to scan dynamodb table %s: %w", d.table, err))
+		}
+		for _, item := range page.Items {
+			c, err := dynamoDBClaim(item)
+			if err != nil {
+				loggerFrom(ctx).Error(err, "store: skipping malformed item", "table", d.table)
+				continue
+			}
+			claims = append(claims, c)
+		}
+	}
+	return claims, nil
+}
+
+// dynamoDBClaim parses an item of the table.
+func dynamoDBClaim(item map[string]types.AttributeValue) (claim, error) {
+	str := func(name string) string {
+		if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+			return v.Value
+		}
+		return ""
+	}
+	n, ok := item[dynamoDBPort].(*types.AttributeValueMemberN)
+	if !ok {
+		return claim{}, fmt.Errorf("item %s has no port", str(dynamoDBKey))
+	}
+	port, err := strconv.Atoi(n.Value)
+	if err != nil {
+		return claim{}, fmt.Errorf("item %s: %w", str(dynamoDBKey), err)
+	}
+	retained, _ := item[dynamoDBRetained].(*types.AttributeValueMemberBOOL)
+	return claim{
+		Allocation: Allocation{
+			ListenerArn:           str(dynamoDBListener),
+			TargetArn:             str(dynamoDBTargetGroup),
+			NLB:                   str(dynamoDBNLB),
+			Port:                  port,
+			ServiceNamespacedName: str(dynamoDBService),
+			Retained:              retained != nil && retained.Value,
+		},
+		Cluster: str(dynamoDBCluster),
+	}, nil
+}
+
+func dynamoDBItemKey(nlb string, port int) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		dynamoDBKey: &types.AttributeValueMemberS{Value: nlb + ":" + strconv.Itoa(port)},
+	}
+}
+
+// dynamoDBOwned matches items that are claimed by a cluster for a svc, given
+// as :cluster and :service.
+const dynamoDBOwned = "#cluster = :cluster AND #service = :service"
+
+func dynamoDBOwnedValues(cluster string, serviceNamespacedName string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		":cluster": &types.AttributeValueMemberS{Value: cluster},
+		":service": &types.AttributeValueMemberS{Value: serviceNamespacedName},
+	}
+}
+
+func (d *dynamoDBClaims) put(ctx context.Context, c claim) error {
+	item := dynamoDBItemKey(c.NLB, c.Port)
+	item[dynamoDBCluster] = &types.AttributeValueMemberS{Value: c.Cluster}
+	item[dynamoDBService] = &types.AttributeValueMemberS{Value: c.ServiceNamespacedName}
+	item[dynamoDBNLB] = &types.AttributeValueMemberS{Value: c.NLB}
+	item[dynamoDBPort] = &types.AttributeValueMemberN{Value: strconv.Itoa(c.Port)}
+	if c.ListenerArn != "" {
+		item[dynamoDBListener] = &types.AttributeValueMemberS{Value: c.ListenerArn}
+		item[dynamoDBTargetGroup] = &types.AttributeValueMemberS{Value: c.TargetArn}
+	}
+	if c.Retained {
+		item[dynamoDBRetained] = &types.AttributeValueMemberBOOL{Value: true}
+	}
+	_, err := d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(d.table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#id) OR (" + dynamoDBOwned + ")"),
+		ExpressionAttributeNames: map[string]string{
+			"#id":      dynamoDBKey,
+			"#cluster": dynamoDBCluster,
+			"#service": dynamoDBService,
+		},
+		ExpressionAttributeValues: dynamoDBOwnedValues(c.Cluster, c.ServiceNamespacedName),
+	})
+	var conflict *types.ConditionalCheckFailedException
+	if errors.As(err, &conflict) {
+		return claimConflict(c.NLB, c.Port)
+	}
+	if err != nil {
+		return classify(fmt.Errorf("store: unable to claim port %d of nlb %s: %w", c.Port, c.NLB, err))
+	}
+	return nil
+}
+
+func (d *dynamoDBClaims) delete(ctx context.Context, cluster string, serviceNamespacedName string, nlb string, port int) error {
+	_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(d.table),
+		Key:                 dynamoDBItemKey(nlb, port),
+		ConditionExpression: aws.String(dynamoDBOwned),
+		ExpressionAttributeNames: map[string]string{
+			"#cluster": dynamoDBCluster,
+			"#service": dynamoDBService,
+		},
+		ExpressionAttributeValues: dynamoDBOwnedValues(cluster, serviceNamespacedName),
+	})
+	var conflict *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &conflict) {
+		return classify(fmt.Errorf("store: unable to delete claim of port %d of nlb %s: %w", port, nlb, err))
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeDynamoDB is a DynamoDB table in memory. Its conditions only support
+// the expressions of the DynamoDB store: writes and deletes of items claimed
+// by another cluster or svc fail the conditional check.
+type fakeDynamoDB struct {
+	mu    sync.Mutex
+	items map[string]map[string]types.AttributeValue
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{items: map[string]map[string]types.AttributeValue{}}
+}
+
+// claim writes a claim of another store to the table.
+func (f *fakeDynamoDB) claim(t *testing.T, c claim) {
+	t.Helper()
+	d := &dynamoDBClaims{client: f, table: "allocations"}
+	if err := d.put(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f *fakeDynamoDB) has(nlb string, port int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.items[nlb+":"+strconv.Itoa(port)]
+	return ok
+}
+
+func (f *fakeDynamoDB) Scan(_ context.Context, _ *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &dynamodb.ScanOutput{}
+	for _, item := range f.items {
+		out.Items = append(out.Items, item)
+	}
+	return out, nil
+}
+
+// owned reports whether the item of key is missing or claimed by the cluster
+// and svc of values.
+func (f *fakeDynamoDB) owned(key map[string]types.AttributeValue, values map[string]types.AttributeValue) (map[string]types.AttributeValue, bool) {
+	item, ok := f.items[key[dynamoDBKey].(*types.AttributeValueMemberS).Value]
+	if !ok {
+		return nil, true
+	}
+	cluster := item[dynamoDBCluster].(*types.AttributeValueMemberS).Value
+	service := item[dynamoDBService].(*types.AttributeValueMemberS).Value
+	return item, cluster == values[":cluster"].(*types.AttributeValueMemberS).Value &&
+		service == values[":service"].(*types.AttributeValueMemberS).Value
+}
+
+func (f *fakeDynamoDB) PutItem(_ context.Context, params *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.owned(params.Item, params.ExpressionAttributeValues); !ok {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("the conditional request failed")}
+	}
+	f.items[params.Item[dynamoDBKey].(*types.AttributeValueMemberS).Value] = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) DeleteItem(_ context.Context, params *dynamodb.DeleteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item, ok := f.owned(params.Key, params.ExpressionAttributeValues)
+	if item == nil || !ok {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("the conditional request failed")}
+	}
+	delete(f.items, params.Key[dynamoDBKey].(*types.AttributeValueMemberS).Value)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+var dynamoDBTestNLB = NLB{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: PortRange{Min: 9000, Max: 9009}}
+
+func newTestDynamoDBStore(t *testing.T, table *fakeDynamoDB, nlbs ...NLB) Store {
+	t.Helper()
+	s, err := NewDynamoDBStore(context.Background(), table, "allocations", "blue", nlbs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDynamoDBStoreSkipsPortsClaimedByOtherClusters(t *testing.T) {
+	ctx := context.Background()
+	table := newFakeDynamoDB()
+	table.claim(t, claim{
+		Allocation: Allocation{NLB: "shared", Port: 9000, ServiceNamespacedName: "default/web:http", ListenerArn: "listener-green", TargetArn: "target-green"},
+		Cluster:    "green",
+	})
+	s := newTestDynamoDBStore(t, table, dynamoDBTestNLB)
+
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9000, "default/web:http", "listener-blue", "target-blue"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("AssignNLBAndPortToServiceInNamespace() error = %v for the port of green, want ErrUnavailable", err)
+	}
+	// green claims the next port after blue loaded the table
+	table.claim(t, claim{
+		Allocation: Allocation{NLB: "shared", Port: 9001, ServiceNamespacedName: "default/api:http"},
+		Cluster:    "green",
+	})
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9001, "default/api:http", "listener-blue", "target-blue"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("AssignNLBAndPortToServiceInNamespace() error = %v on a failed conditional check, want ErrUnavailable", err)
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/api:http"); allocation != nil {
+		t.Errorf("allocation %+v kept after the conditional check failed", allocation)
+	}
+	if _, port, err := s.GetVacantNLBAndPortForService(ctx, "default/web:http", nil); err != nil || port != 9002 {
+		t.Errorf("GetVacantNLBAndPortForService() = %d, %v, want 9002 past the ports of green", port, err)
+	}
+}
+
+func TestDynamoDBStoreDeletesStaleReservations(t *testing.T) {
+	ctx := context.Background()
+	table := newFakeDynamoDB()
+	// reserved by a previous leader of blue that never created its listener
+	table.claim(t, claim{
+		Allocation: Allocation{NLB: "shared", Port: 9000, ServiceNamespacedName: "default/web:http"},
+		Cluster:    "blue",
+	})
+	s := newTestDynamoDBStore(t, table, dynamoDBTestNLB)
+
+	if table.has("shared", 9000) {
+		t.Error("stale reservation kept in the table after the store loaded it")
+	}
+	if _, port, err := s.GetVacantNLBAndPortForService(ctx, "default/api:http", nil); err != nil || port != 9000 {
+		t.Errorf("GetVacantNLBAndPortForService() = %d, %v, want the port of the stale reservation", port, err)
+	}
+}
+
+func TestDynamoDBStoreMarksForeignClaimsOfAddedNLB(t *testing.T) {
+	ctx := context.Background()
+	table := newFakeDynamoDB()
+	table.claim(t, claim{
+		Allocation: Allocation{NLB: "other", Port: 9100, ServiceNamespacedName: "default/web:http", ListenerArn: "listener-green", TargetArn: "target-green"},
+		Cluster:    "green",
+	})
+	s := newTestDynamoDBStore(t, table, dynamoDBTestNLB)
+
+	s.AddNLB(NLB{Name: "other", Host: "other.elb.amazonaws.com", PortRange: PortRange{Min: 9100, Max: 9109}})
+	only := func(nlb string) bool { return nlb == "other" }
+	if nlb, port, err := s.GetVacantNLBAndPortForService(ctx, "default/api:http", only); err != nil || nlb != "other" || port != 9101 {
+		t.Errorf("GetVacantNLBAndPortForService() = %s, %d, %v, want port 9101 past the claim of green", nlb, port, err)
+	}
+	if allocated, _ := s.PoolUsage("other"); allocated != 2 {
+		t.Errorf("PoolUsage(other) allocated = %d, want the claim of green and the reservation", allocated)
+	}
+}
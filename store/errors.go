@@ -0,0 +1,67 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/aws/smithy-go"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// The classes of errors of a Store. Errors of a Store that fall in a class
+// wrap it, whether they come from the allocations in memory or from the
+// backend of the store, so that callers can choose with errors.Is how to
+// retry.
+var (
+	// ErrThrottled is the backend of the store rejecting requests because
+	// they are too many.
+	ErrThrottled = errors.New("store: backend throttled")
+	// ErrNotFound is an allocation, or an object of the backend, that does
+	// not exist.
+	ErrNotFound = errors.New("store: not found")
+	// ErrConflict is a change conflicting with another allocation or with
+	// another writer of the backend.
+	ErrConflict = errors.New("store: conflict")
+	// ErrQuotaExceeded is no port being left within the port ranges and
+	// listener quotas of the NLBs.
+	ErrQuotaExceeded = errors.New("store: quota exceeded")
+)
+
+// classifiedError is an error of the backend of a store, of a class.
+type classifiedError struct {
+	error
+	class error
+}
+
+func (e classifiedError) Unwrap() error { return e.error }
+
+func (e classifiedError) Is(target error) bool { return target == e.class }
+
+// classify wraps an error of the backend of a store in its class, if it has
+// one.
+func classify(err error) error {
+	var class error
+	var apiErr smithy.APIError
+	switch {
+	case err == nil:
+		return nil
+	case apierrors.IsTooManyRequests(err):
+		class = ErrThrottled
+	case apierrors.IsNotFound(err):
+		class = ErrNotFound
+	case apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		class = ErrConflict
+	case errors.As(err, &apiErr):
+		switch apiErr.ErrorCode() {
+		case "ProvisionedThroughputExceededException", "ThrottlingException", "RequestLimitExceeded":
+			class = ErrThrottled
+		case "ResourceNotFoundException":
+			class = ErrNotFound
+		case "ConditionalCheckFailedException", "TransactionConflictException":
+			class = ErrConflict
+		}
+	}
+	if class == nil {
+		return err
+	}
+	return classifiedError{error: err, class: class}
+}
@@ -0,0 +1,225 @@
+// Package fake provides a store.Store for tests of code that allocates NLB
+// ports, such as the reconcilers of the controller. It allocates with the
+// in-memory store of package store, so ports are chosen like the controller
+// chooses them, records every call, and fails the calls it is told to fail.
+package fake
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+)
+
+// Call is a call of a method of the fake, with its arguments other than the
+// context.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// Store is a store.Store that records its calls and fails the calls it is
+// told to fail. Methods without an error result cannot fail.
+type Store struct {
+	store.Store
+
+	mu    sync.Mutex
+	calls []Call
+	errs  map[string]error
+	next  map[string][]error
+}
+
+var _ store.Store = &Store{}
+
+// New returns a Store managing nlbs. Like store.New, it also manages the
+// NLBs of NLB_LIST.
+func New(nlbs ...store.NLB) *Store {
+	return &Store{Store: store.New(nlbs...), errs: map[string]error{}, next: map[string][]error{}}
+}
+
+// SetError makes every call of method fail with err, without changing
+// anything, until it is set to nil.
+func (s *Store) SetError(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.errs, method)
+		return
+	}
+	s.errs[method] = err
+}
+
+// FailNext makes the next call of method fail with err, without changing
+// anything. Errors of several FailNext calls fail the following calls in
+// turn, before the error of SetError. A nil err lets its call through, to
+// fail a later call.
+func (s *Store) FailNext(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next[method] = append(s.next[method], err)
+}
+
+// Calls returns the calls of method, or of every method if method is empty,
+// in the order they were made.
+func (s *Store) Calls(method string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var calls []Call
+	for _, call := range s.calls {
+		if method == "" || call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// ResetCalls forgets the calls made so far.
+func (s *Store) ResetCalls() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+}
+
+// call records a call and returns the error it fails with, if any.
+func (s *Store) call(method string, args ...interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, Call{Method: method, Args: args})
+	if next := s.next[method]; len(next) > 0 {
+		s.next[method] = next[1:]
+		return next[0]
+	}
+	return s.errs[method]
+}
+
+func (s *Store) AssignNLBAndPortToServiceInNamespace(
+	ctx context.Context,
+	nlb string,
+	port int,
+	serviceNamespacedName string,
+	listenerArn string,
+	targetArn string,
+) error {
+	if err := s.call("AssignNLBAndPortToServiceInNamespace", nlb, port, serviceNamespacedName, listenerArn, targetArn); err != nil {
+		return err
+	}
+	return s.Store.AssignNLBAndPortToServiceInNamespace(ctx, nlb, port, serviceNamespacedName, listenerArn, targetArn)
+}
+
+func (s *Store) GetVacantNLBAndPortForService(ctx context.Context, serviceNamespacedName string, allowed store.NLBFilter) (string, int, error) {
+	if err := s.call("GetVacantNLBAndPortForService", serviceNamespacedName); err != nil {
+		return "", 0, err
+	}
+	return s.Store.GetVacantNLBAndPortForService(ctx, serviceNamespacedName, allowed)
+}
+
+func (s *Store) ReleaseNLBAndPortForService(ctx context.Context, serviceNamespacedName string, nlb string, port int) {
+	_ = s.call("ReleaseNLBAndPortForService", serviceNamespacedName, nlb, port)
+	s.Store.ReleaseNLBAndPortForService(ctx, serviceNamespacedName, nlb, port)
+}
+
+func (s *Store) RetainNLBAndPortForService(ctx context.Context, serviceNamespacedName string) error {
+	if err := s.call("RetainNLBAndPortForService", serviceNamespacedName); err != nil {
+		return err
+	}
+	return s.Store.RetainNLBAndPortForService(ctx, serviceNamespacedName)
+}
+
+func (s *Store) StickNLBAndPortForService(ctx context.Context, serviceNamespacedName string) error {
+	if err := s.call("StickNLBAndPortForService", serviceNamespacedName); err != nil {
+		return err
+	}
+	return s.Store.StickNLBAndPortForService(ctx, serviceNamespacedName)
+}
+
+func (s *Store) GetListenerArnFor(ctx context.Context, serviceNamespacedName string) string {
+	_ = s.call("GetListenerArnFor", serviceNamespacedName)
+	return s.Store.GetListenerArnFor(ctx, serviceNamespacedName)
+}
+
+func (s *Store) GetAllocationForSVC(ctx context.Context, name string) *store.Allocation {
+	_ = s.call("GetAllocationForSVC", name)
+	return s.Store.GetAllocationForSVC(ctx, name)
+}
+
+func (s *Store) GetAllocationsForSVC(ctx context.Context, serviceNamespacedName string) []*store.Allocation {
+	_ = s.call("GetAllocationsForSVC", serviceNamespacedName)
+	return s.Store.GetAllocationsForSVC(ctx, serviceNamespacedName)
+}
+
+func (s *Store) ListAllocations(ctx context.Context) []*store.Allocation {
+	_ = s.call("ListAllocations")
+	return s.Store.ListAllocations(ctx)
+}
+
+func (s *Store) GetNLBHost(nlb string) string {
+	_ = s.call("GetNLBHost", nlb)
+	return s.Store.GetNLBHost(nlb)
+}
+
+func (s *Store) ListNLBs() []string {
+	_ = s.call("ListNLBs")
+	return s.Store.ListNLBs()
+}
+
+func (s *Store) PoolUsage(nlb string) (int, int) {
+	_ = s.call("PoolUsage", nlb)
+	return s.Store.PoolUsage(nlb)
+}
+
+func (s *Store) AddNLB(nlb store.NLB) {
+	_ = s.call("AddNLB", nlb)
+	s.Store.AddNLB(nlb)
+}
+
+func (s *Store) RemoveNLB(nlb string) error {
+	if err := s.call("RemoveNLB", nlb); err != nil {
+		return err
+	}
+	return s.Store.RemoveNLB(nlb)
+}
+
+func (s *Store) DrainNLB(nlb string) {
+	_ = s.call("DrainNLB", nlb)
+	s.Store.DrainNLB(nlb)
+}
+
+func (s *Store) SetListenerQuota(quota int) {
+	_ = s.call("SetListenerQuota", quota)
+	s.Store.SetListenerQuota(quota)
+}
+
+func (s *Store) SetReservationTTL(ttl time.Duration) {
+	_ = s.call("SetReservationTTL", ttl)
+	s.Store.SetReservationTTL(ttl)
+}
+
+func (s *Store) SetStickyRetention(retention time.Duration) {
+	_ = s.call("SetStickyRetention", retention)
+	s.Store.SetStickyRetention(retention)
+}
+
+func (s *Store) SetStrategy(strategy store.Strategy) {
+	_ = s.call("SetStrategy", strategy)
+	s.Store.SetStrategy(strategy)
+}
+
+func (s *Store) SetPortHashing(enabled bool) {
+	_ = s.call("SetPortHashing", enabled)
+	s.Store.SetPortHashing(enabled)
+}
+
+func (s *Store) Check() error {
+	if err := s.call("Check"); err != nil {
+		return err
+	}
+	return s.Store.Check()
+}
+
+func (s *Store) Flush(ctx context.Context) error {
+	if err := s.call("Flush"); err != nil {
+		return err
+	}
+	return s.Store.Flush(ctx)
+}
@@ -0,0 +1,38 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	s := New(store.NLB{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: store.PortRange{Min: 9000, Max: 9099}})
+	unavailable := errors.New("store unavailable")
+	s.FailNext("GetVacantNLBAndPortForService", unavailable)
+	if _, _, err := s.GetVacantNLBAndPortForService(ctx, "default/web:http", nil); !errors.Is(err, unavailable) {
+		t.Errorf("GetVacantNLBAndPortForService() error = %v, want the error of FailNext", err)
+	}
+	nlb, port, err := s.GetVacantNLBAndPortForService(ctx, "default/web:http", nil)
+	if err != nil || nlb != "shared" || port != 9000 {
+		t.Fatalf("GetVacantNLBAndPortForService() = %s, %d, %v, want port 9000 of shared", nlb, port, err)
+	}
+
+	s.SetError("AssignNLBAndPortToServiceInNamespace", unavailable)
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, nlb, port, "default/web:http", "listener", "targetgroup"); !errors.Is(err, unavailable) {
+		t.Errorf("AssignNLBAndPortToServiceInNamespace() error = %v, want the error of SetError", err)
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation != nil && allocation.ListenerArn != "" {
+		t.Errorf("failed assignment recorded %+v", allocation)
+	}
+	s.SetError("AssignNLBAndPortToServiceInNamespace", nil)
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, nlb, port, "default/web:http", "listener", "targetgroup"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := s.Calls("AssignNLBAndPortToServiceInNamespace"); len(calls) != 2 || calls[1].Args[2] != "default/web:http" {
+		t.Errorf("Calls() = %+v, want both assignments", calls)
+	}
+}
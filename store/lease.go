@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Labels and annotations of the Leases that claim NLB ports.
+const (
+	leaseLabel              = "nlb.chinmayrelkar.github.com/port-claim"
+	leaseAnnotationService  = "nlb.chinmayrelkar.github.com/service"
+	leaseAnnotationNLB      = "nlb.chinmayrelkar.github.com/nlb"
+	leaseAnnotationPort     = "nlb.chinmayrelkar.github.com/port"
+	leaseAnnotationListener = "nlb.chinmayrelkar.github.com/listener-arn"
+	leaseAnnotationTarget   = "nlb.chinmayrelkar.github.com/target-group-arn"
+	leaseAnnotationRetained = "nlb.chinmayrelkar.github.com/retained"
+)
+
+// leaseClaims keeps claims in Lease objects, one per port of an NLB. The API
+// server creates and updates a Lease for one writer at a time, so replicas
+// that share the namespace never claim the same port. The holder of a Lease
+// is the cluster, and the allocation is kept in its annotations. Reservations
+// expire after reservationTTL.
+type leaseClaims struct {
+	client         client.Client
+	namespace      string
+	reservationTTL time.Duration
+}
+
+// NewLeaseStore returns a Store whose port claims are Lease objects in
+// namespace, managing the NLBs of NLB_LIST and nlbs. Reservations of ports
+// for listeners being created expire after reservationTTL. Existing claims
+// are loaded into memory before the store is returned.
+func NewLeaseStore(ctx context.Context, c client.Client, namespace string, reservationTTL time.Duration, cluster string, nlbs ...NLB) (Store, error) {
+	return newSharedStore(ctx, &leaseClaims{client: c, namespace: namespace, reservationTTL: reservationTTL}, cluster, nlbs)
+}
+
+// leaseName names the Lease of nlb/port. NLB names are unique regardless of
+// case and only hold alphanumerics and hyphens.
+func leaseName(nlb string, port int) string {
+	return "nlb-port-" + strings.ToLower(nlb) + "-" + strconv.Itoa(port)
+}
+
+func (l *leaseClaims) list(ctx context.Context) ([]claim, error) {
+	var leases coordinationv1.LeaseList
+	if err := l.client.List(ctx, &leases, client.InNamespace(l.namespace), client.HasLabels{leaseLabel}); err != nil {
+		return nil, classify(fmt.Errorf("store: unable to list leases: %w", err))
+	}
+	var claims []claim
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		if l.expired(lease) {
+			continue
+		}
+		port, err := strconv.Atoi(lease.Annotations[leaseAnnotationPort])
+		if err != nil {
+			continue
+		}
+		claims = append(claims, claim{
+			Allocation: Allocation{
+				ListenerArn:           lease.Annotations[leaseAnnotationListener],
+				TargetArn:             lease.Annotations[leaseAnnotationTarget],
+				NLB:                   lease.Annotations[leaseAnnotationNLB],
+				Port:                  port,
+				ServiceNamespacedName: lease.Annotations[leaseAnnotationService],
+				Retained:              lease.Annotations[leaseAnnotationRetained] == "true",
+			},
+			Cluster: pointer.StringDeref(lease.Spec.HolderIdentity, ""),
+		})
+	}
+	return claims, nil
+}
+
+// expired reports whether lease is a reservation that was not turned into an
+// allocation in time.
+func (l *leaseClaims) expired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.LeaseDurationSeconds == nil || lease.Spec.RenewTime == nil {
+		return false
+	}
+	duration := time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	return time.Since(lease.Spec.RenewTime.Time) > duration
+}
+
+func (l *leaseClaims) put(ctx context.Context, c claim) error {
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: l.namespace, Name: leaseName(c.NLB, c.Port)},
+	}
+	l.fill(lease, c)
+	err := l.client.Create(ctx, lease)
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return classify(fmt.Errorf("store: unable to claim port %d of nlb %s: %w", c.Port, c.NLB, err))
+	}
+
+	if err := l.client.Get(ctx, client.ObjectKeyFromObject(lease), lease); err != nil {
+		return classify(fmt.Errorf("store: unable to claim port %d of nlb %s: %w", c.Port, c.NLB, err))
+	}
+	if !l.expired(lease) && !l.owned(lease, c.Cluster, c.ServiceNamespacedName) {
+		return claimConflict(c.NLB, c.Port)
+	}
+	l.fill(lease, c)
+	// the update carries the resourceVersion that was read, so a concurrent
+	// claim makes it fail
+	err = l.client.Update(ctx, lease)
+	if apierrors.IsConflict(err) {
+		return claimConflict(c.NLB, c.Port)
+	}
+	if err != nil {
+		return classify(fmt.Errorf("store: unable to claim port %d of nlb %s: %w", c.Port, c.NLB, err))
+	}
+	return nil
+}
+
+// fill sets the fields of lease to claim c. Reservations expire after the
+// reservationTTL, allocations do not.
+func (l *leaseClaims) fill(lease *coordinationv1.Lease, c claim) {
+	if lease.Labels == nil {
+		lease.Labels = map[string]string{}
+	}
+	if lease.Annotations == nil {
+		lease.Annotations = map[string]string{}
+	}
+	lease.Labels[leaseLabel] = ""
+	lease.Annotations[leaseAnnotationService] = c.ServiceNamespacedName
+	lease.Annotations[leaseAnnotationNLB] = c.NLB
+	lease.Annotations[leaseAnnotationPort] = strconv.Itoa(c.Port)
+	lease.Annotations[leaseAnnotationListener] = c.ListenerArn
+	lease.Annotations[leaseAnnotationTarget] = c.TargetArn
+	if c.Retained {
+		lease.Annotations[leaseAnnotationRetained] = "true"
+	} else {
+		delete(lease.Annotations, leaseAnnotationRetained)
+	}
+	lease.Spec.HolderIdentity = pointer.String(c.Cluster)
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.AcquireTime = &now
+	if c.ListenerArn == "" {
+		lease.Spec.RenewTime = &now
+		lease.Spec.LeaseDurationSeconds = pointer.Int32(int32(l.reservationTTL / time.Second))
+	} else {
+		lease.Spec.RenewTime = nil
+		lease.Spec.LeaseDurationSeconds = nil
+	}
+}
+
+func (l *leaseClaims) owned(lease *coordinationv1.Lease, cluster string, serviceNamespacedName string) bool {
+	return pointer.StringDeref(lease.Spec.HolderIdentity, "") == cluster &&
+		lease.Annotations[leaseAnnotationService] == serviceNamespacedName
+}
+
+func (l *leaseClaims) delete(ctx context.Context, cluster string, serviceNamespacedName string, nlb string, port int) error {
+	var lease coordinationv1.Lease
+	err := l.client.Get(ctx, client.ObjectKey{Namespace: l.namespace, Name: leaseName(nlb, port)}, &lease)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return classify(fmt.Errorf("store: unable to delete claim of port %d of nlb %s: %w", port, nlb, err))
+	}
+	if !l.owned(&lease, cluster, serviceNamespacedName) {
+		return nil
+	}
+	err = l.client.Delete(ctx, &lease, client.Preconditions{ResourceVersion: &lease.ResourceVersion})
+	if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+		return classify(fmt.Errorf("store: unable to delete claim of port %d of nlb %s: %w", port, nlb, err))
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var leaseTestNLB = NLB{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: PortRange{Min: 9000, Max: 9009}}
+
+func newTestLeaseStore(t *testing.T, c client.Client, reservationTTL time.Duration, cluster string) Store {
+	t.Helper()
+	s, err := NewLeaseStore(context.Background(), c, "kube-system", reservationTTL, cluster, leaseTestNLB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func getLease(t *testing.T, c client.Client, nlb string, port int) *coordinationv1.Lease {
+	t.Helper()
+	var lease coordinationv1.Lease
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "kube-system", Name: leaseName(nlb, port)}, &lease); err != nil {
+		t.Fatal(err)
+	}
+	return &lease
+}
+
+func TestLeaseStoreChecksOwnership(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().Build()
+	blue := newTestLeaseStore(t, c, time.Minute, "blue")
+	green := newTestLeaseStore(t, c, time.Minute, "green")
+	if err := green.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9000, "default/web:http", "listener-green", "target-green"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := blue.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9000, "default/web:http", "listener-blue", "target-blue"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("AssignNLBAndPortToServiceInNamespace() error = %v for the lease of another cluster, want ErrUnavailable", err)
+	}
+	if err := green.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9000, "default/api:http", "listener-api", "target-api"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("AssignNLBAndPortToServiceInNamespace() error = %v for the lease of another svc, want ErrUnavailable", err)
+	}
+	// blue releasing a port it never claimed leaves the lease of green
+	blue.ReleaseNLBAndPortForService(ctx, "default/web:http", "shared", 9000)
+	lease := getLease(t, c, "shared", 9000)
+	if holder := pointer.StringDeref(lease.Spec.HolderIdentity, ""); holder != "green" || lease.Annotations[leaseAnnotationService] != "default/web:http" {
+		t.Errorf("lease held by %s for %s after blue released the port, want green's claim kept", holder, lease.Annotations[leaseAnnotationService])
+	}
+	if lease.Spec.LeaseDurationSeconds != nil {
+		t.Errorf("lease duration = %d, want none on an allocation", *lease.Spec.LeaseDurationSeconds)
+	}
+
+	green.ReleaseNLBAndPortForService(ctx, "default/web:http", "shared", 9000)
+	if err := c.Get(ctx, client.ObjectKeyFromObject(lease), &coordinationv1.Lease{}); err == nil {
+		t.Error("lease kept after its holder released the port")
+	}
+}
+
+func TestLeaseStoreTakesOverExpiredReservations(t *testing.T) {
+	ctx := context.Background()
+	renewed := metav1.NewMicroTime(time.Now().Add(-2 * time.Minute))
+	// reserved by green two minutes ago, for a minute
+	c := fake.NewClientBuilder().WithObjects(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "kube-system",
+			Name:      leaseName("shared", 9000),
+			Labels:    map[string]string{leaseLabel: ""},
+			Annotations: map[string]string{
+				leaseAnnotationService: "default/web:http",
+				leaseAnnotationNLB:     "shared",
+				leaseAnnotationPort:    "9000",
+			},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       pointer.String("green"),
+			LeaseDurationSeconds: pointer.Int32(60),
+			RenewTime:            &renewed,
+		},
+	}).Build()
+	blue := newTestLeaseStore(t, c, time.Minute, "blue")
+
+	if _, port, err := blue.GetVacantNLBAndPortForService(ctx, "default/api:http", nil); err != nil || port != 9000 {
+		t.Fatalf("GetVacantNLBAndPortForService() = %d, %v, want the port of the expired reservation", port, err)
+	}
+	lease := getLease(t, c, "shared", 9000)
+	if holder := pointer.StringDeref(lease.Spec.HolderIdentity, ""); holder != "blue" || lease.Annotations[leaseAnnotationService] != "default/api:http" {
+		t.Errorf("lease held by %s for %s, want blue's reservation", holder, lease.Annotations[leaseAnnotationService])
+	}
+	if lease.Spec.LeaseDurationSeconds == nil || *lease.Spec.LeaseDurationSeconds != 60 {
+		t.Errorf("lease duration = %v, want the reservation TTL", lease.Spec.LeaseDurationSeconds)
+	}
+}
+
+func TestLeaseStoreWithoutReservationTTL(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().Build()
+	blue := newTestLeaseStore(t, c, 0, "blue")
+	green := newTestLeaseStore(t, c, 0, "green")
+
+	if _, port, err := blue.GetVacantNLBAndPortForService(ctx, "default/web:http", nil); err != nil || port != 9000 {
+		t.Fatalf("GetVacantNLBAndPortForService() = %d, %v, want 9000", port, err)
+	}
+	time.Sleep(time.Millisecond)
+	// with --store-reservation-ttl=0 every reservation is expired at once
+	if _, port, err := green.GetVacantNLBAndPortForService(ctx, "default/api:http", nil); err != nil || port != 9000 {
+		t.Errorf("GetVacantNLBAndPortForService() = %d, %v, want the port of the expired reservation", port, err)
+	}
+	if err := green.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9000, "default/api:http", "listener-api", "target-api"); err != nil {
+		t.Fatal(err)
+	}
+	// allocations do not expire
+	time.Sleep(time.Millisecond)
+	if err := blue.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9000, "default/web:http", "listener-web", "target-web"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("AssignNLBAndPortToServiceInNamespace() error = %v for the allocation of green, want ErrUnavailable", err)
+	}
+}
@@ -0,0 +1,14 @@
+package store
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// loggerFrom returns the logger of ctx named store, so that the level of the
+// store package can be set on its own with --log-levels.
+func loggerFrom(ctx context.Context) logr.Logger {
+	return log.FromContext(ctx).WithName("store")
+}
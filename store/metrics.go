@@ -0,0 +1,79 @@
+package store
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	allocatedPorts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nlb_port_pool_allocated_ports",
+		Help: "Number of ports allocated or reserved on an NLB",
+	}, []string{"nlb"})
+	freePorts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nlb_port_pool_free_ports",
+		Help: "Number of ports still free in the port range of an NLB",
+	}, []string{"nlb"})
+	allocationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nlb_port_allocations_total",
+		Help: "Total number of ports assigned to services on an NLB",
+	}, []string{"nlb"})
+	releasesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nlb_port_releases_total",
+		Help: "Total number of ports released on an NLB",
+	}, []string{"nlb"})
+	allocationFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nlb_port_allocation_failures_total",
+		Help: "Total number of failed port allocations by reason",
+	}, []string{"reason"})
+	reservationsExpiredTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nlb_port_reservations_expired_total",
+		Help: "Total number of ports reserved for a listener that were freed because they were neither assigned nor released in time",
+	}, []string{"nlb"})
+	poolExhaustedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nlb_port_pool_exhausted_total",
+		Help: "Total number of port allocations that found no free port on any NLB below its listener quota",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		allocatedPorts,
+		freePorts,
+		allocationsTotal,
+		releasesTotal,
+		allocationFailuresTotal,
+		reservationsExpiredTotal,
+		poolExhaustedTotal,
+	)
+}
+
+// usage returns the number of allocated and free ports of an NLB. Ports
+// allocated outside the port range count as allocated but do not reduce the
+// free ports. The caller must hold mu.
+func (s *store) usage(nlb string) (int, int) {
+	free := 0
+	if b := s.bitmaps[nlb]; b != nil {
+		free = b.free
+	}
+	return len(s.NlbAllocationMap[nlb]), free
+}
+
+// observePool updates the utilization gauges of an NLB. The caller must
+// hold mu.
+func (s *store) observePool(nlb string) {
+	if _, ok := s.NlbAllocationMap[nlb]; !ok {
+		return
+	}
+	allocated, free := s.usage(nlb)
+	allocatedPorts.WithLabelValues(nlb).Set(float64(allocated))
+	freePorts.WithLabelValues(nlb).Set(float64(free))
+}
+
+// observePools updates the utilization gauges of every NLB. The caller must
+// hold mu.
+func (s *store) observePools() {
+	for nlb := range s.NlbAllocationMap {
+		s.observePool(nlb)
+	}
+}
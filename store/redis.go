@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisClaim is the value of the key of a claimed port.
+type redisClaim struct {
+	Cluster     string `json:"cluster"`
+	Service     string `json:"service"`
+	NLB         string `json:"nlb"`
+	Port        int    `json:"port"`
+	ListenerArn string `json:"listenerArn,omitempty"`
+	TargetArn   string `json:"targetArn,omitempty"`
+	Retained    bool   `json:"retained,omitempty"`
+}
+
+// redisPut sets KEYS[1] to the claim ARGV[1] unless it holds the claim of
+// another cluster ARGV[2] or svc ARGV[3]. Allocations do not expire.
+var redisPut = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current then
+	local claim = cjson.decode(current)
+	if claim.cluster ~= ARGV[2] or claim.service ~= ARGV[3] then
+		return 0
+	end
+end
+redis.call('SET', KEYS[1], ARGV[1])
+return 1
+`)
+
+// redisDelete deletes KEYS[1] if it holds a claim of cluster ARGV[1] for svc
+// ARGV[2].
+var redisDelete = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current then
+	local claim = cjson.decode(current)
+	if claim.cluster == ARGV[1] and claim.service == ARGV[2] then
+		return redis.call('DEL', KEYS[1])
+	end
+end
+return 0
+`)
+
+// redisClaims keeps claims in Redis, with one key per port of an NLB.
+// Reservations are claimed with SETNX and expire after reservationTTL, so
+// that ports reserved by a controller that died are freed.
+type redisClaims struct {
+	client         redis.UniversalClient
+	prefix         string
+	reservationTTL time.Duration
+}
+
+// NewRedisStore returns a Store backed by Redis, managing the NLBs of
+// NLB_LIST and nlbs for the cluster. Keys are named prefix:nlb:port.
+// Reservations expire after reservationTTL. Existing claims are loaded into
+// memory before the store is returned.
+func NewRedisStore(ctx context.Context, c redis.UniversalClient, prefix string, reservationTTL time.Duration, cluster string, nlbs ...NLB) (Store, error) {
+	return newSharedStore(ctx, &redisClaims{client: c, prefix: prefix, reservationTTL: reservationTTL}, cluster, nlbs)
+}
+
+func (r *redisClaims) key(nlb string, port int) string {
+	return r.prefix + ":" + nlb + ":" + strconv.Itoa(port)
+}
+
+func (r *redisClaims) list(ctx context.Context) ([]claim, error) {
+	var claims []claim
+	keys := r.client.Scan(ctx, 0, r.prefix+":*", 100).Iterator()
+	for keys.Next(ctx) {
+		value, err := r.client.Get(ctx, keys.Val()).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, classify(fmt.Errorf("store: unable to get redis key %s: %w", keys.Val(), err))
+		}
+		var c redisClaim
+		if err := json.Unmarshal([]byte(value), &c); err != nil {
+			loggerFrom(ctx).Error(err, "store: skipping malformed key", "key", keys.Val())
+			continue
+		}
+		claims = append(claims, claim{
+			Allocation: Allocation{
+				ListenerArn:           c.ListenerArn,
+				TargetArn:             c.TargetArn,
+				NLB:                   c.NLB,
+				Port:                  c.Port,
+				ServiceNamespacedName: c.Service,
+				Retained:              c.Retained,
+			},
+			Cluster: c.Cluster,
+		})
+	}
+	if err := keys.Err(); err != nil {
+		return nil, classify(fmt.Errorf("store: unable to scan redis keys %s:*: %w", r.prefix, err))
+	}
+	return claims, nil
+}
+
+func (r *redisClaims) put(ctx context.Context, c claim) error {
+	value, err := json.Marshal(redisClaim{
+		Cluster:     c.Cluster,
+		Service:     c.ServiceNamespacedName,
+		NLB:         c.NLB,
+		Port:        c.Port,
+		ListenerArn: c.ListenerArn,
+		TargetArn:   c.TargetArn,
+		Retained:    c.Retained,
+	})
+	if err != nil {
+		return err
+	}
+	key := r.key(c.NLB, c.Port)
+	var ok bool
+	if c.ListenerArn == "" {
+		ok, err = r.client.SetNX(ctx, key, value, r.reservationTTL).Result()
+	} else {
+		ok, err = redisPut.Run(ctx, r.client, []string{key}, value, c.Cluster, c.ServiceNamespacedName).Bool()
+	}
+	if err != nil {
+		return classify(fmt.Errorf("store: unable to claim port %d of nlb %s: %w", c.Port, c.NLB, err))
+	}
+	if !ok {
+		return claimConflict(c.NLB, c.Port)
+	}
+	return nil
+}
+
+func (r *redisClaims) delete(ctx context.Context, cluster string, serviceNamespacedName string, nlb string, port int) error {
+	err := redisDelete.Run(ctx, r.client, []string{r.key(nlb, port)}, cluster, serviceNamespacedName).Err()
+	if err != nil && err != redis.Nil {
+		return classify(fmt.Errorf("store: unable to delete claim of port %d of nlb %s: %w", port, nlb, err))
+	}
+	return nil
+}
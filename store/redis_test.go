@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+var redisTestNLB = NLB{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: PortRange{Min: 9000, Max: 9009}}
+
+func newTestRedisStore(t *testing.T, server *miniredis.Miniredis, cluster string) Store {
+	t.Helper()
+	c := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { c.Close() })
+	s, err := NewRedisStore(context.Background(), c, "nlb", time.Minute, cluster, redisTestNLB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRedisStoreSkipsPortsReservedByOtherClusters(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	blue := newTestRedisStore(t, server, "blue")
+	green := newTestRedisStore(t, server, "green")
+
+	if _, port, err := green.GetVacantNLBAndPortForService(ctx, "default/web:http", nil); err != nil || port != 9000 {
+		t.Fatalf("GetVacantNLBAndPortForService() = %d, %v, want 9000", port, err)
+	}
+	// blue loaded the keys before green reserved 9000, so SETNX fails on it
+	if _, port, err := blue.GetVacantNLBAndPortForService(ctx, "default/web:http", nil); err != nil || port != 9001 {
+		t.Errorf("GetVacantNLBAndPortForService() = %d, %v, want 9001 past the reservation of green", port, err)
+	}
+	if err := blue.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9000, "default/api:http", "listener-blue", "target-blue"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("AssignNLBAndPortToServiceInNamespace() error = %v for the port of green, want ErrUnavailable", err)
+	}
+}
+
+func TestRedisStoreExpiresReservations(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	blue := newTestRedisStore(t, server, "blue")
+	green := newTestRedisStore(t, server, "green")
+
+	for _, name := range []string{"default/web:http", "default/api:http"} {
+		if _, _, err := blue.GetVacantNLBAndPortForService(ctx, name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ttl := server.TTL("nlb:shared:9000"); ttl != time.Minute {
+		t.Errorf("TTL of the reservation = %s, want the reservation TTL", ttl)
+	}
+	if err := blue.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9001, "default/api:http", "listener-api", "target-api"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := server.TTL("nlb:shared:9001"); ttl != 0 {
+		t.Errorf("TTL of the allocation = %s, want it kept until released", ttl)
+	}
+
+	// blue died before creating the listener of default/web
+	server.FastForward(2 * time.Minute)
+	if server.Exists("nlb:shared:9000") {
+		t.Error("reservation kept after its TTL")
+	}
+	if _, port, err := green.GetVacantNLBAndPortForService(ctx, "default/other:http", nil); err != nil || port != 9000 {
+		t.Errorf("GetVacantNLBAndPortForService() = %d, %v, want the port of the expired reservation", port, err)
+	}
+}
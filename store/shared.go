@@ -0,0 +1,244 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// claim is a port of an NLB claimed for a svc of a cluster. Claims without a
+// listener are reservations made by GetVacantNLBAndPortForService.
+type claim struct {
+	Allocation
+	Cluster string
+}
+
+// claimBackend keeps the claims of the clusters sharing NLBs.
+type claimBackend interface {
+	// list returns every claim.
+	list(ctx context.Context) ([]claim, error)
+	// put writes a claim, unless another svc or cluster claimed its port. It
+	// returns an error wrapping ErrUnavailable if so.
+	put(ctx context.Context, c claim) error
+	// delete deletes the claim of nlb/port if cluster claimed it for svc.
+	delete(ctx context.Context, cluster string, serviceNamespacedName string, nlb string, port int) error
+}
+
+// claimedElsewhere marks ports in memory that another cluster claimed.
+var claimedElsewhere = "(claimed by another cluster)"
+
+// sharedStore is an in-memory store whose allocations are written through to
+// a backend shared by several clusters. Every port is claimed in the backend
+// before it is used, so two clusters never claim the same port of an NLB.
+type sharedStore struct {
+	*store
+	backend claimBackend
+	cluster string
+
+	// unmanaged are the allocations of this cluster on NLBs the store does
+	// not manage yet. They are adopted once their NLB is added.
+	unmanaged typeServiceAllocationMap
+
+	// foreign are the ports other clusters claimed on NLBs the store does not
+	// manage yet. They are marked taken once their NLB is added.
+	foreign map[string][]int
+
+	// unreleased are the released allocations whose claim could not be
+	// deleted from the backend, deleted again by Flush.
+	unreleased typeServiceAllocationMap
+}
+
+func newSharedStore(ctx context.Context, backend claimBackend, cluster string, nlbs []NLB) (*sharedStore, error) {
+	if cluster == "" {
+		return nil, errors.New("store: a cluster ID is required for a shared store")
+	}
+	s := &sharedStore{
+		store:      newStore(nlbs),
+		backend:    backend,
+		cluster:    cluster,
+		unmanaged:  typeServiceAllocationMap{},
+		foreign:    map[string][]int{},
+		unreleased: typeServiceAllocationMap{},
+	}
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sharedStore) load(ctx context.Context) error {
+	logger := loggerFrom(ctx)
+	claims, err := s.backend.list(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range claims {
+		allocation := &claims[i].Allocation
+		_, managed := s.NlbAllocationMap[allocation.NLB]
+		switch {
+		case claims[i].Cluster != s.cluster && managed:
+			s.take(allocation.NLB, allocation.Port, &claimedElsewhere)
+		case claims[i].Cluster != s.cluster:
+			s.foreign[allocation.NLB] = append(s.foreign[allocation.NLB], allocation.Port)
+		case allocation.ListenerArn == "":
+			// only the leader reserves ports, so this reservation was left
+			// behind by a previous leader
+			if err := s.backend.delete(ctx, s.cluster, allocation.ServiceNamespacedName, allocation.NLB, allocation.Port); err != nil {
+				logger.Error(err, "store: unable to delete stale reservation", "svc", allocation.ServiceNamespacedName)
+			}
+		case !managed:
+			logger.Info("store: keeping allocation for unmanaged nlb", "svc", allocation.ServiceNamespacedName, "nlb", allocation.NLB)
+			s.unmanaged[allocation.ServiceNamespacedName] = allocation
+		default:
+			s.ServiceAllocationMap[allocation.ServiceNamespacedName] = allocation
+			s.take(allocation.NLB, allocation.Port, &allocation.ServiceNamespacedName)
+		}
+	}
+	s.observePools()
+	return nil
+}
+
+// GetVacantNLBAndPortForService reserves a free port in memory and claims it
+// in the backend. Ports another cluster claimed in the meantime are marked
+// taken and the next free port is tried. The claims of expired reservations
+// are deleted first.
+func (s *sharedStore) GetVacantNLBAndPortForService(ctx context.Context, serviceNamespacedName string, allowed NLBFilter) (string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, expired := range s.store.expireReservations(ctx) {
+		if err := s.backend.delete(ctx, s.cluster, expired.ServiceNamespacedName, expired.NLB, expired.Port); err != nil {
+			loggerFrom(ctx).Error(err, "store: unable to delete expired reservation", "svc", expired.ServiceNamespacedName)
+		}
+	}
+	for {
+		nlb, port, err := s.store.vacant(ctx, serviceNamespacedName, allowed)
+		if err != nil {
+			return "", 0, err
+		}
+		err = s.backend.put(ctx, claim{
+			Allocation: Allocation{NLB: nlb, Port: port, ServiceNamespacedName: serviceNamespacedName},
+			Cluster:    s.cluster,
+		})
+		if err == nil {
+			return nlb, port, nil
+		}
+		if !errors.Is(err, ErrUnavailable) {
+			s.store.release(serviceNamespacedName, nlb, port)
+			return "", 0, err
+		}
+		s.take(nlb, port, &claimedElsewhere)
+		s.observePool(nlb)
+	}
+}
+
+func (s *sharedStore) AssignNLBAndPortToServiceInNamespace(
+	ctx context.Context,
+	nlb string,
+	port int,
+	serviceNamespacedName string,
+	listenerArn string,
+	targetArn string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	undo, err := s.store.assignWithUndo(nlb, port, serviceNamespacedName, listenerArn, targetArn)
+	if err != nil {
+		return err
+	}
+	err = s.backend.put(ctx, claim{
+		Allocation: *s.ServiceAllocationMap[serviceNamespacedName],
+		Cluster:    s.cluster,
+	})
+	if err != nil {
+		undo()
+		if errors.Is(err, ErrUnavailable) {
+			s.take(nlb, port, &claimedElsewhere)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *sharedStore) ReleaseNLBAndPortForService(ctx context.Context, serviceNamespacedName string, nlb string, port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if allocation, ok := s.ServiceAllocationMap[serviceNamespacedName]; ok {
+		nlb, port = allocation.NLB, allocation.Port
+	}
+	s.store.release(serviceNamespacedName, nlb, port)
+	if err := s.backend.delete(ctx, s.cluster, serviceNamespacedName, nlb, port); err != nil {
+		loggerFrom(ctx).Error(err, "store: unable to release port", "svc", serviceNamespacedName)
+		s.unreleased[serviceNamespacedName] = &Allocation{ServiceNamespacedName: serviceNamespacedName, NLB: nlb, Port: port}
+		return
+	}
+	delete(s.unreleased, serviceNamespacedName)
+}
+
+// Flush deletes the claims of the unreleased allocations again, unless their
+// ports were claimed again in the meantime.
+func (s *sharedStore) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, allocation := range s.unreleased {
+		if claimed, ok := s.NlbAllocationMap[allocation.NLB][allocation.Port]; ok && *claimed == name {
+			delete(s.unreleased, name)
+			continue
+		}
+		if err := s.backend.delete(ctx, s.cluster, name, allocation.NLB, allocation.Port); err != nil {
+			return classify(fmt.Errorf("store: unable to release port %d of nlb %s: %w", allocation.Port, allocation.NLB, err))
+		}
+		delete(s.unreleased, name)
+	}
+	return nil
+}
+
+// RetainNLBAndPortForService marks the allocation retained in memory and in
+// the backend.
+func (s *sharedStore) RetainNLBAndPortForService(ctx context.Context, serviceNamespacedName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	undo, err := s.store.retain(serviceNamespacedName)
+	if err != nil {
+		return err
+	}
+	err = s.backend.put(ctx, claim{
+		Allocation: *s.ServiceAllocationMap[serviceNamespacedName],
+		Cluster:    s.cluster,
+	})
+	if err != nil {
+		undo()
+		return err
+	}
+	return nil
+}
+
+// StickNLBAndPortForService releases the allocation. Claims without a
+// listener are reservations that expire, so shared stores keep no sticky
+// allocations.
+func (s *sharedStore) StickNLBAndPortForService(ctx context.Context, serviceNamespacedName string) error {
+	allocation := s.GetAllocationForSVC(ctx, serviceNamespacedName)
+	if allocation == nil {
+		return fmt.Errorf("%w: svc %s has no allocation", ErrNotFound, serviceNamespacedName)
+	}
+	s.ReleaseNLBAndPortForService(ctx, serviceNamespacedName, allocation.NLB, allocation.Port)
+	return nil
+}
+
+// AddNLB adds an NLB like the in-memory store, adopts the allocations of this
+// cluster on it and marks the ports other clusters claimed on it taken.
+func (s *sharedStore) AddNLB(nlb NLB) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store.addNLB(nlb)
+	for _, port := range s.foreign[nlb.Name] {
+		s.take(nlb.Name, port, &claimedElsewhere)
+	}
+	delete(s.foreign, nlb.Name)
+	s.store.adopt(s.unmanaged, nlb.Name)
+}
+
+// claimConflict returns the error of a claim of nlb/port that is held
+// elsewhere.
+func claimConflict(nlb string, port int) error {
+	return fmt.Errorf("%w: port %d of nlb %s is claimed elsewhere", ErrUnavailable, port, nlb)
+}
@@ -2,10 +2,16 @@ package store
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"hash/fnv"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 )
 
 type Store interface {
@@ -17,11 +23,97 @@ type Store interface {
 		listenerArn string,
 		targetArn string,
 	) error
-	GetVacantNLBAndPortForService(ctx context.Context, serviceNamespacedName string) (string, int, error)
+	// GetVacantNLBAndPortForService reserves a free port for a svc on an NLB
+	// allowed accepts.
+	GetVacantNLBAndPortForService(ctx context.Context, serviceNamespacedName string, allowed NLBFilter) (string, int, error)
 	ReleaseNLBAndPortForService(ctx context.Context, serviceNamespacedName string, nlb string, port int)
+	// RetainNLBAndPortForService marks the allocation of a svc retained. Its
+	// port stays allocated after the svc is deleted, until it is released or
+	// assigned again to a svc of the same name.
+	RetainNLBAndPortForService(ctx context.Context, serviceNamespacedName string) error
+	// StickNLBAndPortForService marks the allocation of a deleted svc, whose
+	// listener and target group were deleted, sticky. Its port stays bound
+	// to the svc for the sticky retention, and
+	// GetVacantNLBAndPortForService reserves it again for a svc of the same
+	// name.
+	StickNLBAndPortForService(ctx context.Context, serviceNamespacedName string) error
 	GetListenerArnFor(ctx context.Context, s string) string
 	GetAllocationForSVC(ctx context.Context, name string) *Allocation
+	GetAllocationsForSVC(ctx context.Context, serviceNamespacedName string) []*Allocation
+	// ListAllocations returns every allocation.
+	ListAllocations(ctx context.Context) []*Allocation
 	GetNLBHost(nlb string) string
+	ListNLBs() []string
+	// AddNLB adds an NLB to the pool, or updates its host and port range if
+	// it is already managed. A zero port range means the default range.
+	AddNLB(nlb NLB)
+	// RemoveNLB removes an NLB without allocations from the pool.
+	RemoveNLB(nlb string) error
+	// DrainNLB stops allocating ports on an NLB, until it is added again.
+	// Its allocations are kept until they are released.
+	DrainNLB(nlb string)
+	// PoolUsage returns the number of allocated and free ports of an NLB.
+	PoolUsage(nlb string) (int, int)
+	// SetListenerQuota sets the number of listeners an NLB supports. No port
+	// is allocated on an NLB at its quota, even if its port range has free
+	// ports. Zero means DefaultListenerQuota.
+	SetListenerQuota(quota int)
+	// SetReservationTTL sets how long a port reserved by
+	// GetVacantNLBAndPortForService stays reserved without being assigned or
+	// released, such as after a reconcile crashed between reserving the port
+	// and creating its listener. Expired reservations are freed before the
+	// next port is reserved. Zero keeps reservations until they are assigned
+	// or released.
+	SetReservationTTL(ttl time.Duration)
+	// SetStickyRetention sets how long the port of a sticky allocation stays
+	// bound to its svc. Sticky allocations are released once it is over.
+	SetStickyRetention(retention time.Duration)
+	// SetStrategy sets the strategy that chooses the NLB of new
+	// allocations. The default is LeastLoaded.
+	SetStrategy(strategy Strategy)
+	// SetPortHashing makes new allocations take the port derived from a hash
+	// of the svc name, or the next free one after it, instead of the lowest
+	// free port, so that a svc gets the same port across cluster rebuilds.
+	SetPortHashing(enabled bool)
+	// Check returns an error if an allocation is not recorded on the port
+	// of its NLB, or is on an NLB that is not managed.
+	Check() error
+	// Flush writes the releases that could not be written to the backend of
+	// the store when they were made, so that their ports are free after a
+	// restart. It is called before the controller exits.
+	Flush(ctx context.Context) error
+}
+
+// ErrUnavailable is returned when asked to assign a port that is allocated
+// to another service or is on an NLB that is not managed.
+var ErrUnavailable = fmt.Errorf("%w: port is not available", ErrConflict)
+
+// ErrNoVacancy is returned by GetVacantNLBAndPortForService when every port
+// of every NLB is allocated.
+var ErrNoVacancy = fmt.Errorf("%w: no vacancy found", ErrQuotaExceeded)
+
+// ErrListenerQuota is returned by GetVacantNLBAndPortForService when the only
+// free ports are on NLBs that reached their listener quota.
+var ErrListenerQuota = fmt.Errorf("%w: nlb listener quota reached", ErrQuotaExceeded)
+
+// DefaultListenerQuota is the default number of listeners per NLB of AWS.
+const DefaultListenerQuota = 50
+
+// NLBFilter reports whether ports may be allocated on an NLB. A nil
+// NLBFilter accepts every NLB.
+type NLBFilter func(nlb string) bool
+
+// NLB is an NLB ports are allocated on.
+type NLB struct {
+	Name      string
+	Host      string
+	PortRange PortRange
+	// Weight is the share of new allocations the NLB takes relative to the
+	// other NLBs under the Weighted strategy. Zero is a weight of 1.
+	Weight int
+	// ExcludedPorts are ports of the range that are never allocated, such as
+	// the ports of listeners created by hand.
+	ExcludedPorts []int
 }
 
 type Allocation struct {
@@ -30,30 +122,155 @@ type Allocation struct {
 	NLB                   string
 	Port                  int
 	ServiceNamespacedName string
+	// Retained marks the allocation of a deleted svc whose listener and
+	// target group were left in place.
+	Retained bool
+	// Sticky marks the allocation of a deleted svc whose listener and target
+	// group were deleted, but whose port stays bound to the svc until
+	// StickyUntil.
+	Sticky      bool
+	StickyUntil time.Time
+}
+
+// PortRange is the inclusive range of listener ports allocated on an NLB.
+type PortRange struct {
+	Min int
+	Max int
 }
 
+// defaultPortRange is used for NLBs that have no range in NLB_LIST when
+// NLB_PORT_RANGE is not set.
+var defaultPortRange = PortRange{Min: 9000, Max: 9049}
+
 type typeNlbAllocationMap map[string]map[int]*string
 type typeServiceAllocationMap map[string]*Allocation
 
+// store keeps allocations in memory. mu guards every map, as NLBs are added
+// and removed while services are being reconciled.
 type store struct {
+	mu                   sync.Mutex
 	ServiceAllocationMap typeServiceAllocationMap
 	NlbAllocationMap     typeNlbAllocationMap
 	NlbHosts             map[string]string
+	NlbPortRanges        map[string]PortRange
+	NlbWeights           map[string]int
+	NlbExcludedPorts     map[string]map[int]bool
+	DefaultPortRange     PortRange
+	ListenerQuota        int
+	// strategy chooses the NLB of new allocations.
+	strategy Strategy
+	// hashPorts allocates the port of hashPort instead of the lowest one.
+	hashPorts bool
+	// Draining are the NLBs no port is allocated on anymore.
+	Draining map[string]bool
+
+	// bitmaps are the ports of NlbAllocationMap within the port range of
+	// each NLB, to find free ports without scanning the range. Ports are
+	// taken and freed with take and free to keep both in sync.
+	bitmaps map[string]*portBitmap
+
+	// reservations are the ports reserved by vacant and not yet assigned or
+	// released, which expire after reservationTTL.
+	reservations   map[nlbPort]reservation
+	reservationTTL time.Duration
+	now            func() time.Time
+
+	// stickies are the svcs that may have a sticky allocation, to expire
+	// them without scanning every allocation. Svcs whose allocation is no
+	// longer sticky are dropped when expiring.
+	stickies        map[string]bool
+	stickyRetention time.Duration
+}
+
+// nlbPort is a port of an NLB.
+type nlbPort struct {
+	nlb  string
+	port int
 }
 
-func (s store) GetNLBHost(nlb string) string {
+// reservation is a port reserved for a svc at a time.
+type reservation struct {
+	serviceNamespacedName string
+	at                    time.Time
+}
+
+func (s *store) GetNLBHost(nlb string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.NlbHosts[nlb]
 }
 
-func (s store) GetAllocationForSVC(_ context.Context, name string) *Allocation {
+func (s *store) ListNLBs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nlbs := make([]string, 0, len(s.NlbHosts))
+	for nlb := range s.NlbHosts {
+		nlbs = append(nlbs, nlb)
+	}
+	return nlbs
+}
+
+func (s *store) GetAllocationForSVC(_ context.Context, name string) *Allocation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.ServiceAllocationMap[name]
 }
 
-func (s store) GetListenerArnFor(_ context.Context, serviceNamespacedName string) string {
+// GetAllocationsForSVC returns the allocations of every port of a Service.
+// Allocations are keyed by the namespaced name of the Service followed by
+// ":" and the port.
+func (s *store) GetAllocationsForSVC(_ context.Context, serviceNamespacedName string) []*Allocation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var allocations []*Allocation
+	for name, allocation := range s.ServiceAllocationMap {
+		if strings.HasPrefix(name, serviceNamespacedName+":") {
+			allocations = append(allocations, allocation)
+		}
+	}
+	return allocations
+}
+
+func (s *store) ListAllocations(_ context.Context) []*Allocation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	allocations := make([]*Allocation, 0, len(s.ServiceAllocationMap))
+	for _, allocation := range s.ServiceAllocationMap {
+		allocations = append(allocations, allocation)
+	}
+	return allocations
+}
+
+func (s *store) Check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, allocation := range s.ServiceAllocationMap {
+		if allocation.ServiceNamespacedName != name {
+			return fmt.Errorf("store: allocation %s is recorded for %s", name, allocation.ServiceNamespacedName)
+		}
+		ports, ok := s.NlbAllocationMap[allocation.NLB]
+		if !ok {
+			return fmt.Errorf("store: allocation %s is on nlb %s, which is not managed", name, allocation.NLB)
+		}
+		if owner, ok := ports[allocation.Port]; !ok || *owner != name {
+			return fmt.Errorf("store: port %d of nlb %s is not recorded for allocation %s", allocation.Port, allocation.NLB, name)
+		}
+	}
+	return nil
+}
+
+// Flush has nothing to write for the in-memory store.
+func (s *store) Flush(_ context.Context) error {
+	return nil
+}
+
+func (s *store) GetListenerArnFor(_ context.Context, serviceNamespacedName string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.ServiceAllocationMap[serviceNamespacedName].ListenerArn
 }
 
-func (s store) AssignNLBAndPortToServiceInNamespace(
+func (s *store) AssignNLBAndPortToServiceInNamespace(
 	_ context.Context,
 	nlb string,
 	port int,
@@ -61,8 +278,31 @@ func (s store) AssignNLBAndPortToServiceInNamespace(
 	listenerArn string,
 	targetArn string,
 ) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.assign(nlb, port, serviceNamespacedName, listenerArn, targetArn)
+}
+
+// assign records an allocation. The caller must hold mu.
+func (s *store) assign(nlb string, port int, serviceNamespacedName string, listenerArn string, targetArn string) error {
+	if _, ok := s.NlbAllocationMap[nlb]; !ok {
+		return fmt.Errorf("%w: nlb %s is not managed", ErrUnavailable, nlb)
+	}
 	if val, ok := s.NlbAllocationMap[nlb][port]; ok && *val != serviceNamespacedName {
-		return fmt.Errorf("port reserved for svc %s", *s.NlbAllocationMap[nlb][port])
+		return fmt.Errorf("%w: port reserved for svc %s", ErrUnavailable, *s.NlbAllocationMap[nlb][port])
+	}
+	if s.NlbExcludedPorts[nlb][port] {
+		return fmt.Errorf("%w: port %d of nlb %s is excluded", ErrUnavailable, port, nlb)
+	}
+	if previous, ok := s.ServiceAllocationMap[serviceNamespacedName]; !ok || previous.NLB != nlb || previous.Port != port {
+		allocationsTotal.WithLabelValues(nlb).Inc()
+		// a svc that got another port than its sticky one frees it
+		if ok && previous.Sticky {
+			if owner, held := s.NlbAllocationMap[previous.NLB][previous.Port]; held && *owner == serviceNamespacedName {
+				s.free(previous.NLB, previous.Port)
+				s.observePool(previous.NLB)
+			}
+		}
 	}
 	value := Allocation{
 		ListenerArn:           listenerArn,
@@ -72,57 +312,565 @@ func (s store) AssignNLBAndPortToServiceInNamespace(
 		ServiceNamespacedName: serviceNamespacedName,
 	}
 	s.ServiceAllocationMap[serviceNamespacedName] = &value
-	s.NlbAllocationMap[nlb][port] = &value.ServiceNamespacedName
+	s.take(nlb, port, &value.ServiceNamespacedName)
+	delete(s.reservations, nlbPort{nlb: nlb, port: port})
+	s.observePool(nlb)
 	return nil
 }
 
-func (s store) ReleaseNLBAndPortForService(ctx context.Context, serviceNamespacedName string, nlb string, port int) {
+// assignWithUndo records an allocation like assign, and returns a function
+// that restores both maps to their state before the allocation. The caller
+// must hold mu.
+func (s *store) assignWithUndo(nlb string, port int, serviceNamespacedName string, listenerArn string, targetArn string) (func(), error) {
+	previous := s.ServiceAllocationMap[serviceNamespacedName]
+	reserved, wasReserved := s.NlbAllocationMap[nlb][port]
+	previousReservation, hadReservation := s.reservations[nlbPort{nlb: nlb, port: port}]
+	if err := s.assign(nlb, port, serviceNamespacedName, listenerArn, targetArn); err != nil {
+		return nil, err
+	}
+	return func() {
+		if hadReservation {
+			s.reservations[nlbPort{nlb: nlb, port: port}] = previousReservation
+		}
+		if wasReserved {
+			s.take(nlb, port, reserved)
+		} else {
+			s.free(nlb, port)
+		}
+		if previous == nil {
+			delete(s.ServiceAllocationMap, serviceNamespacedName)
+		} else {
+			s.ServiceAllocationMap[serviceNamespacedName] = previous
+			if _, ok := s.NlbAllocationMap[previous.NLB]; ok {
+				s.take(previous.NLB, previous.Port, &previous.ServiceNamespacedName)
+			}
+		}
+		s.observePool(nlb)
+	}, nil
+}
+
+func (s *store) ReleaseNLBAndPortForService(_ context.Context, serviceNamespacedName string, nlb string, port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.release(serviceNamespacedName, nlb, port)
+}
+
+// release frees the port of an allocation. Without an allocation, the port
+// nlb/port is freed if GetVacantNLBAndPortForService reserved it for the
+// svc, as happens when creating its listener failed. The caller must hold mu.
+func (s *store) release(serviceNamespacedName string, nlb string, port int) {
 	if val, ok := s.ServiceAllocationMap[serviceNamespacedName]; ok {
 		if _, ok := s.NlbAllocationMap[val.NLB][val.Port]; ok {
-			delete(s.NlbAllocationMap[val.NLB], val.Port)
+			s.free(val.NLB, val.Port)
 		}
 		delete(s.ServiceAllocationMap, serviceNamespacedName)
+		releasesTotal.WithLabelValues(val.NLB).Inc()
+		s.observePool(val.NLB)
+		return
+	}
+	if reserved, ok := s.NlbAllocationMap[nlb][port]; ok && *reserved == serviceNamespacedName {
+		s.free(nlb, port)
+		delete(s.reservations, nlbPort{nlb: nlb, port: port})
+		s.observePool(nlb)
+	}
+}
+
+func (s *store) RetainNLBAndPortForService(_ context.Context, serviceNamespacedName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.retain(serviceNamespacedName)
+	return err
+}
+
+// retain marks the allocation of a svc retained, and returns a function that
+// restores it. Assigning the port again replaces the retained allocation. The
+// caller must hold mu.
+func (s *store) retain(serviceNamespacedName string) (func(), error) {
+	previous, ok := s.ServiceAllocationMap[serviceNamespacedName]
+	if !ok {
+		return nil, fmt.Errorf("%w: svc %s has no allocation", ErrNotFound, serviceNamespacedName)
+	}
+	value := *previous
+	value.Retained = true
+	s.ServiceAllocationMap[serviceNamespacedName] = &value
+	s.take(value.NLB, value.Port, &value.ServiceNamespacedName)
+	return func() {
+		s.ServiceAllocationMap[serviceNamespacedName] = previous
+		s.take(previous.NLB, previous.Port, &previous.ServiceNamespacedName)
+	}, nil
+}
+
+func (s *store) StickNLBAndPortForService(_ context.Context, serviceNamespacedName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.stick(serviceNamespacedName)
+	return err
+}
+
+// stick replaces the allocation of a svc by a sticky one on its port, and
+// returns a function that restores it. The caller must hold mu.
+func (s *store) stick(serviceNamespacedName string) (func(), error) {
+	previous, ok := s.ServiceAllocationMap[serviceNamespacedName]
+	if !ok {
+		return nil, fmt.Errorf("%w: svc %s has no allocation", ErrNotFound, serviceNamespacedName)
+	}
+	value := Allocation{
+		NLB:                   previous.NLB,
+		Port:                  previous.Port,
+		ServiceNamespacedName: serviceNamespacedName,
+		Sticky:                true,
+		StickyUntil:           s.now().Add(s.stickyRetention),
+	}
+	s.ServiceAllocationMap[serviceNamespacedName] = &value
+	s.take(value.NLB, value.Port, &value.ServiceNamespacedName)
+	s.stickies[serviceNamespacedName] = true
+	return func() {
+		s.ServiceAllocationMap[serviceNamespacedName] = previous
+		s.take(previous.NLB, previous.Port, &previous.ServiceNamespacedName)
+	}, nil
+}
+
+func (s *store) SetStickyRetention(retention time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stickyRetention = retention
+}
+
+// expireSticky releases the sticky allocations whose retention is over and
+// returns them. The caller must hold mu.
+func (s *store) expireSticky(ctx context.Context) []Allocation {
+	var expired []Allocation
+	for name := range s.stickies {
+		allocation, ok := s.ServiceAllocationMap[name]
+		if !ok || !allocation.Sticky {
+			delete(s.stickies, name)
+			continue
+		}
+		if s.now().Before(allocation.StickyUntil) {
+			continue
+		}
+		delete(s.stickies, name)
+		s.release(name, allocation.NLB, allocation.Port)
+		loggerFrom(ctx).Info("store: sticky allocation expired. Port freed", "svc", name, "nlb", allocation.NLB, "port", allocation.Port)
+		expired = append(expired, *allocation)
+	}
+	return expired
+}
+
+func (s *store) GetVacantNLBAndPortForService(ctx context.Context, serviceNamespacedName string, allowed NLBFilter) (string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireSticky(ctx)
+	s.expireReservations(ctx)
+	return s.vacant(ctx, serviceNamespacedName, allowed)
+}
+
+func (s *store) SetReservationTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reservationTTL = ttl
+}
+
+// expireReservations frees the ports reserved for longer than the
+// reservation TTL, and returns them as allocations without a listener. The
+// caller must hold mu.
+func (s *store) expireReservations(ctx context.Context) []Allocation {
+	if s.reservationTTL <= 0 {
+		return nil
 	}
+	var expired []Allocation
+	for p, r := range s.reservations {
+		if s.now().Sub(r.at) < s.reservationTTL {
+			continue
+		}
+		delete(s.reservations, p)
+		// the port may have been taken by other means in the meantime
+		name, ok := s.NlbAllocationMap[p.nlb][p.port]
+		if !ok || *name != r.serviceNamespacedName {
+			continue
+		}
+		if allocation, assigned := s.ServiceAllocationMap[*name]; assigned && allocation.NLB == p.nlb && allocation.Port == p.port {
+			continue
+		}
+		s.free(p.nlb, p.port)
+		reservationsExpiredTotal.WithLabelValues(p.nlb).Inc()
+		s.observePool(p.nlb)
+		loggerFrom(ctx).Info("store: reservation expired. Port freed", "svc", *name, "nlb", p.nlb, "port", p.port)
+		expired = append(expired, Allocation{NLB: p.nlb, Port: p.port, ServiceNamespacedName: *name})
+	}
+	return expired
 }
 
-func (s store) GetVacantNLBAndPortForService(_ context.Context, serviceNamespacedName string) (string, int, error) {
+// vacant reserves the free port of freePort for a svc on the NLB the
+// strategy of the store chooses among the NLBs allowed accepts that are
+// below their listener quota and not draining. Every allocated port, including ports
+// claimed by other clusters, is a listener on the NLB. Free ports are looked
+// up in the bitmap of each NLB. The caller must hold mu.
+func (s *store) vacant(ctx context.Context, serviceNamespacedName string, allowed NLBFilter) (string, int, error) {
+	// a svc recreated within the sticky retention gets its port back, if its
+	// NLB may still be allocated on
+	if sticky := s.ServiceAllocationMap[serviceNamespacedName]; sticky != nil && sticky.Sticky {
+		if (allowed == nil || allowed(sticky.NLB)) && !s.Draining[sticky.NLB] {
+			return sticky.NLB, sticky.Port, nil
+		}
+		s.release(serviceNamespacedName, sticky.NLB, sticky.Port)
+	}
+	quota := s.listenerQuota()
+	atQuota := false
+	var candidates []Candidate
 	for nlb, ports := range s.NlbAllocationMap {
-		for port := 9000; port < 9050; port++ {
-			if value, ok := ports[port]; !ok && value == nil {
-				s.NlbAllocationMap[nlb][port] = &serviceNamespacedName
-				return nlb, port, nil
-			}
+		if allowed != nil && !allowed(nlb) || s.Draining[nlb] {
+			continue
+		}
+		allocated, free := s.usage(nlb)
+		if len(ports) >= quota {
+			atQuota = atQuota || free > 0
+			continue
+		}
+		if free > 0 {
+			candidates = append(candidates, Candidate{NLB: nlb, Allocated: allocated, Free: free, Weight: weight(s.NlbWeights[nlb])})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].NLB < candidates[j].NLB })
+	if chosen := s.selectionStrategy().Choose(ctx, serviceNamespacedName, candidates); chosen >= 0 {
+		nlb := candidates[chosen].NLB
+		if port, ok := s.freePort(nlb, serviceNamespacedName); ok {
+			s.take(nlb, port, &serviceNamespacedName)
+			s.reservations[nlbPort{nlb: nlb, port: port}] = reservation{serviceNamespacedName: serviceNamespacedName, at: s.now()}
+			s.observePool(nlb)
+			return nlb, port, nil
+		}
+	}
+	poolExhaustedTotal.Inc()
+	if atQuota {
+		allocationFailuresTotal.WithLabelValues("listener_quota").Inc()
+		return "", 0, fmt.Errorf("%w: every nlb with free ports has %d listeners", ErrListenerQuota, quota)
+	}
+	allocationFailuresTotal.WithLabelValues("exhausted").Inc()
+	if len(candidates) > 0 {
+		return "", 0, fmt.Errorf("%w: no nlb with free ports chosen for svc %s", ErrNoVacancy, serviceNamespacedName)
+	}
+	return "", 0, ErrNoVacancy
+}
+
+// freePort returns the free port of an NLB a svc is allocated: the lowest
+// one, or the one at or after its hashPort. The caller must hold mu.
+func (s *store) freePort(nlb string, serviceNamespacedName string) (int, bool) {
+	if s.hashPorts {
+		return s.bitmaps[nlb].nextFree(hashPort(serviceNamespacedName, s.NlbPortRanges[nlb]))
+	}
+	return s.bitmaps[nlb].lowestFree()
+}
+
+// hashPort returns the port of a port range derived from the FNV-1a hash of
+// a svc name.
+func hashPort(serviceNamespacedName string, portRange PortRange) int {
+	h := fnv.New32a()
+	h.Write([]byte(serviceNamespacedName))
+	return portRange.Min + int(h.Sum32()%uint32(portRange.Max-portRange.Min+1))
+}
+
+func (s *store) SetPortHashing(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hashPorts = enabled
+}
+
+func (s *store) SetStrategy(strategy Strategy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.strategy = strategy
+}
+
+// selectionStrategy returns the strategy of the store, LeastLoaded if none
+// is set. The caller must hold mu.
+func (s *store) selectionStrategy() Strategy {
+	if s.strategy == nil {
+		return LeastLoaded{}
+	}
+	return s.strategy
+}
+
+func (s *store) SetListenerQuota(quota int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ListenerQuota = quota
+}
+
+// listenerQuota returns the listener quota of the NLBs. The caller must hold
+// mu.
+func (s *store) listenerQuota() int {
+	if s.ListenerQuota <= 0 {
+		return DefaultListenerQuota
+	}
+	return s.ListenerQuota
+}
+
+func (s *store) AddNLB(nlb NLB) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addNLB(nlb)
+	s.observePool(nlb.Name)
+}
+
+// addNLB adds or updates an NLB. The caller must hold mu.
+func (s *store) addNLB(nlb NLB) {
+	if nlb.PortRange == (PortRange{}) {
+		nlb.PortRange = s.DefaultPortRange
+	}
+	if _, ok := s.NlbAllocationMap[nlb.Name]; !ok {
+		s.NlbAllocationMap[nlb.Name] = map[int]*string{}
+	}
+	s.NlbHosts[nlb.Name] = nlb.Host
+	s.NlbPortRanges[nlb.Name] = nlb.PortRange
+	s.NlbWeights[nlb.Name] = weight(nlb.Weight)
+	excluded := portSet(nlb.ExcludedPorts)
+	if b := s.bitmaps[nlb.Name]; b == nil || b.portRange != nlb.PortRange || !reflect.DeepEqual(excluded, s.NlbExcludedPorts[nlb.Name]) {
+		s.NlbExcludedPorts[nlb.Name] = excluded
+		s.indexPorts(nlb.Name)
+	}
+	delete(s.Draining, nlb.Name)
+}
+
+// indexPorts builds the bitmap of an NLB from its ports and excluded ports.
+// The caller must hold mu.
+func (s *store) indexPorts(nlb string) {
+	b := newPortBitmap(s.NlbPortRanges[nlb])
+	for port := range s.NlbAllocationMap[nlb] {
+		b.set(port)
+	}
+	for port := range s.NlbExcludedPorts[nlb] {
+		b.set(port)
+	}
+	s.bitmaps[nlb] = b
+}
+
+// portSet returns the set of ports, nil if there are none.
+func portSet(ports []int) map[int]bool {
+	if len(ports) == 0 {
+		return nil
+	}
+	set := make(map[int]bool, len(ports))
+	for _, port := range ports {
+		set[port] = true
+	}
+	return set
+}
+
+// take records a port of an NLB as allocated or reserved for name. The
+// caller must hold mu.
+func (s *store) take(nlb string, port int, name *string) {
+	s.NlbAllocationMap[nlb][port] = name
+	s.bitmaps[nlb].set(port)
+}
+
+// free frees a port of an NLB. Excluded ports stay taken in the bitmap. The
+// caller must hold mu.
+func (s *store) free(nlb string, port int) {
+	delete(s.NlbAllocationMap[nlb], port)
+	if !s.NlbExcludedPorts[nlb][port] {
+		s.bitmaps[nlb].clear(port)
+	}
+}
+
+// adopt moves the allocations on nlb out of unmanaged into the store, once
+// nlb is managed. Allocations whose port is taken in the meantime are
+// dropped. The caller must hold mu.
+func (s *store) adopt(unmanaged typeServiceAllocationMap, nlb string) {
+	ports, ok := s.NlbAllocationMap[nlb]
+	if !ok {
+		return
+	}
+	for name, allocation := range unmanaged {
+		if allocation.NLB != nlb {
+			continue
+		}
+		delete(unmanaged, name)
+		if _, taken := ports[allocation.Port]; taken {
+			continue
 		}
+		if _, ok := s.ServiceAllocationMap[name]; ok {
+			continue
+		}
+		s.ServiceAllocationMap[name] = allocation
+		s.take(nlb, allocation.Port, &allocation.ServiceNamespacedName)
+		if allocation.Sticky {
+			s.stickies[name] = true
+		}
+	}
+	s.observePool(nlb)
+}
+
+func (s *store) RemoveNLB(nlb string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.NlbAllocationMap[nlb]) > 0 {
+		return fmt.Errorf("%w: nlb %s has %d allocated ports", ErrConflict, nlb, len(s.NlbAllocationMap[nlb]))
 	}
-	return "", 0, errors.New("no vacancy found")
+	delete(s.NlbAllocationMap, nlb)
+	delete(s.NlbHosts, nlb)
+	delete(s.NlbPortRanges, nlb)
+	delete(s.NlbWeights, nlb)
+	delete(s.NlbExcludedPorts, nlb)
+	delete(s.Draining, nlb)
+	delete(s.bitmaps, nlb)
+	allocatedPorts.DeleteLabelValues(nlb)
+	freePorts.DeleteLabelValues(nlb)
+	return nil
+}
+
+func (s *store) DrainNLB(nlb string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.NlbAllocationMap[nlb]; ok {
+		s.Draining[nlb] = true
+	}
+}
+
+func (s *store) PoolUsage(nlb string) (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage(nlb)
 }
 
-func New() Store {
-	nlbData, nlbHostData := loadNlbData()
-	return &store{
+// New returns an in-memory store managing the NLBs of NLB_LIST and nlbs.
+func New(nlbs ...NLB) Store {
+	return newStore(nlbs)
+}
+
+func newStore(nlbs []NLB) *store {
+	nlbData, nlbHostData, nlbPortRanges, nlbWeights, defaultRange := loadNlbData()
+	s := &store{
 		ServiceAllocationMap: typeServiceAllocationMap{},
 		NlbAllocationMap:     nlbData,
 		NlbHosts:             nlbHostData,
+		NlbPortRanges:        nlbPortRanges,
+		NlbWeights:           nlbWeights,
+		NlbExcludedPorts:     map[string]map[int]bool{},
+		DefaultPortRange:     defaultRange,
+		Draining:             map[string]bool{},
+		bitmaps:              map[string]*portBitmap{},
+		reservations:         map[nlbPort]reservation{},
+		now:                  time.Now,
+		stickies:             map[string]bool{},
+	}
+	for nlb := range s.NlbAllocationMap {
+		s.indexPorts(nlb)
+	}
+	for _, nlb := range nlbs {
+		s.addNLB(nlb)
+	}
+	s.observePools()
+	return s
+}
+
+// ParsePortRange parses a port range of the form min-max.
+func ParsePortRange(value string) (PortRange, error) {
+	bounds := strings.Split(value, "-")
+	if len(bounds) != 2 {
+		return PortRange{}, fmt.Errorf("port range %q is not of the form min-max", value)
+	}
+	min, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return PortRange{}, fmt.Errorf("port range %q: %w", value, err)
 	}
+	max, err := strconv.Atoi(bounds[1])
+	if err != nil {
+		return PortRange{}, fmt.Errorf("port range %q: %w", value, err)
+	}
+	if min < 1 || max > 65535 || min > max {
+		return PortRange{}, fmt.Errorf("port range %q is not within 1-65535", value)
+	}
+	return PortRange{Min: min, Max: max}, nil
 }
 
-func loadNlbData() (typeNlbAllocationMap, map[string]string) {
+// loadNlbData reads the managed NLBs from NLB_LIST, a comma separated list of
+// name:host, name:host:min-max or name:host:min-max:weight entries. NLBs
+// without a range use
+// NLB_PORT_RANGE, or 9000-9049 if that is not set either. NLB_LIST may be
+// empty when all NLBs come from NLBPools.
+func loadNlbData() (typeNlbAllocationMap, map[string]string, map[string]PortRange, map[string]int, PortRange) {
 	nlbData := typeNlbAllocationMap{}
 	nlbHosts := map[string]string{}
+	nlbPortRanges := map[string]PortRange{}
+	nlbWeights := map[string]int{}
+
+	portRange := defaultPortRange
+	if value := os.Getenv("NLB_PORT_RANGE"); value != "" {
+		var err error
+		portRange, err = ParsePortRange(value)
+		if err != nil {
+			panic(fmt.Sprintf("env var NLB_PORT_RANGE is malformed: %s", err))
+		}
+	}
+
+	nlbs, err := ParseNLBList(os.Getenv("NLB_LIST"))
+	if err != nil {
+		panic(fmt.Sprintf("env var NLB_LIST is malformed: %s", err))
+	}
+	for _, nlb := range nlbs {
+		if nlb.PortRange == (PortRange{}) {
+			nlb.PortRange = portRange
+		}
+		nlbData[nlb.Name] = map[int]*string{}
+		nlbHosts[nlb.Name] = nlb.Host
+		nlbPortRanges[nlb.Name] = nlb.PortRange
+		nlbWeights[nlb.Name] = weight(nlb.Weight)
+	}
+	return nlbData, nlbHosts, nlbPortRanges, nlbWeights, portRange
+}
 
-	nlbCommaSeperatedList := os.Getenv("NLB_LIST")
-	nlbList := strings.Split(nlbCommaSeperatedList, ",")
-	if len(nlbList) == 0 {
-		panic("env var NLB_LIST is empty. Needs comma seperated list as of key:value pair. No load balancers to manage.")
+// weight returns the weight of an NLB of the given Weight.
+func weight(weight int) int {
+	if weight <= 0 {
+		return 1
 	}
-	for _, nlbWithHost := range nlbList {
-		nlb := strings.Split(nlbWithHost, ":")[0]
-		nlbHost := strings.Split(nlbWithHost, ":")[1]
-		if nlb != "" {
-			nlbData[nlb] = map[int]*string{}
-			nlbHosts[nlb] = nlbHost
+	return weight
+}
+
+// ParseNLBList parses a list of NLBs in the format of NLB_LIST: name:host,
+// name:host:min-max or name:host:min-max:weight entries, separated by commas
+// or whitespace. The range of an entry with a weight may be empty, as in
+// name:host::weight. NLBs without a range have a zero PortRange, and NLBs
+// without a weight a zero Weight.
+func ParseNLBList(value string) ([]NLB, error) {
+	entries := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	var nlbs []NLB
+	for _, entry := range entries {
+		fields := strings.Split(entry, ":")
+		if len(fields) < 2 || len(fields) > 4 || fields[0] == "" {
+			return nil, fmt.Errorf("%q is not of the form name:host, name:host:min-max or name:host:min-max:weight", entry)
+		}
+		nlb := NLB{Name: fields[0], Host: fields[1]}
+		if len(fields) == 3 || len(fields) == 4 && fields[2] != "" {
+			var err error
+			nlb.PortRange, err = ParsePortRange(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", nlb.Name, err)
+			}
 		}
+		if len(fields) == 4 {
+			var err error
+			nlb.Weight, err = ParseWeight(fields[3])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", nlb.Name, err)
+			}
+		}
+		nlbs = append(nlbs, nlb)
+	}
+	return nlbs, nil
+}
 
+// ParseWeight parses the weight of an NLB, a positive integer.
+func ParseWeight(value string) (int, error) {
+	weight, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("weight %q: %w", value, err)
+	}
+	if weight < 1 {
+		return 0, fmt.Errorf("weight %q is not positive", value)
 	}
-	return nlbData, nlbHosts
+	return weight, nil
 }
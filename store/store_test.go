@@ -0,0 +1,341 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    PortRange
+		wantErr bool
+	}{
+		{value: "9000-9049", want: PortRange{Min: 9000, Max: 9049}},
+		{value: "1-65535", want: PortRange{Min: 1, Max: 65535}},
+		{value: "8080-8080", want: PortRange{Min: 8080, Max: 8080}},
+		{value: "9000", wantErr: true},
+		{value: "9000-9049-9099", wantErr: true},
+		{value: "a-9049", wantErr: true},
+		{value: "9000-b", wantErr: true},
+		{value: "0-100", wantErr: true},
+		{value: "100-65536", wantErr: true},
+		{value: "9049-9000", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePortRange(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePortRange(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePortRange(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestVacantRespectsListenerQuota(t *testing.T) {
+	s := newStore([]NLB{{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: PortRange{Min: 9000, Max: 9009}}})
+	s.SetListenerQuota(2)
+	for i := 0; i < 2; i++ {
+		if _, _, err := s.vacant(context.Background(), fmt.Sprintf("default/web-%d:http", i), nil); err != nil {
+			t.Fatalf("vacant() error = %v", err)
+		}
+	}
+	if _, _, err := s.vacant(context.Background(), "default/web-2:http", nil); !errors.Is(err, ErrListenerQuota) {
+		t.Errorf("vacant() error = %v, want %v", err, ErrListenerQuota)
+	}
+}
+
+func TestVacantOnlyOnAllowedNLBs(t *testing.T) {
+	s := newStore([]NLB{{Name: "public", Host: "public.elb.amazonaws.com"}, {Name: "internal", Host: "internal.elb.amazonaws.com"}})
+	for i := 0; i < 10; i++ {
+		nlb, _, err := s.vacant(context.Background(), fmt.Sprintf("dev/web-%d:http", i), func(nlb string) bool { return nlb == "internal" })
+		if err != nil {
+			t.Fatalf("vacant() error = %v", err)
+		}
+		if nlb != "internal" {
+			t.Errorf("vacant() = %s, want internal", nlb)
+		}
+	}
+	if _, _, err := s.vacant(context.Background(), "dev/web:http", func(string) bool { return false }); !errors.Is(err, ErrNoVacancy) {
+		t.Errorf("vacant() error = %v, want %v", err, ErrNoVacancy)
+	}
+}
+
+func TestRetainKeepsPortAllocated(t *testing.T) {
+	s := newStore([]NLB{{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: PortRange{Min: 9000, Max: 9001}}})
+	nlb, port, err := s.vacant(context.Background(), "default/web:http", nil)
+	if err != nil {
+		t.Fatalf("vacant() error = %v", err)
+	}
+	if err := s.assign(nlb, port, "default/web:http", "listener", "target"); err != nil {
+		t.Fatalf("assign() error = %v", err)
+	}
+	if _, err := s.retain("default/web:http"); err != nil {
+		t.Fatalf("retain() error = %v", err)
+	}
+	if allocation := s.ServiceAllocationMap["default/web:http"]; !allocation.Retained {
+		t.Errorf("allocation retained = false, want true")
+	}
+	if _, next, err := s.vacant(context.Background(), "default/api:http", nil); err != nil || next == port {
+		t.Errorf("vacant() = %d, %v, want a port other than %d", next, err, port)
+	}
+	if err := s.assign(nlb, port, "default/web:http", "listener", "target"); err != nil {
+		t.Fatalf("assign() error = %v", err)
+	}
+	if allocation := s.ServiceAllocationMap["default/web:http"]; allocation.Retained {
+		t.Errorf("allocation retained = true after assigning it again, want false")
+	}
+	if _, err := s.retain("default/missing:http"); err == nil {
+		t.Errorf("retain() of a svc without allocation error = nil, want an error")
+	}
+}
+
+func TestReservationsExpire(t *testing.T) {
+	ctx := context.Background()
+	s := newStore([]NLB{{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: PortRange{Min: 9000, Max: 9001}}})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	s.SetReservationTTL(time.Minute)
+
+	_, crashed, err := s.GetVacantNLBAndPortForService(ctx, "default/crashed:http", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nlb, confirmed, err := s.GetVacantNLBAndPortForService(ctx, "default/web:http", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, nlb, confirmed, "default/web:http", "listener", "target"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.GetVacantNLBAndPortForService(ctx, "default/api:http", nil); !errors.Is(err, ErrNoVacancy) {
+		t.Fatalf("GetVacantNLBAndPortForService() error = %v before the reservation expired, want %v", err, ErrNoVacancy)
+	}
+
+	now = now.Add(time.Minute)
+	_, port, err := s.GetVacantNLBAndPortForService(ctx, "default/api:http", nil)
+	if err != nil || port != crashed {
+		t.Errorf("GetVacantNLBAndPortForService() = %d, %v, want the expired port %d", port, err, crashed)
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation == nil || allocation.Port != confirmed {
+		t.Errorf("assigned allocation = %v after reservations expired, want port %d", allocation, confirmed)
+	}
+}
+
+func TestStickyAllocations(t *testing.T) {
+	ctx := context.Background()
+	s := newStore([]NLB{{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: PortRange{Min: 9000, Max: 9009}}})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	s.SetStickyRetention(time.Hour)
+	for i, name := range []string{"default/web:http", "default/api:http"} {
+		if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9000+i, name, "listener", "target"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.StickNLBAndPortForService(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation == nil || !allocation.Sticky || allocation.ListenerArn != "" {
+		t.Errorf("GetAllocationForSVC() = %+v, want a sticky allocation without listener", allocation)
+	}
+	if _, port, err := s.GetVacantNLBAndPortForService(ctx, "default/other:http", nil); err != nil || port != 9002 {
+		t.Errorf("GetVacantNLBAndPortForService() = %d, %v for another svc, want 9002", port, err)
+	}
+
+	now = now.Add(30 * time.Minute)
+	if _, port, err := s.GetVacantNLBAndPortForService(ctx, "default/web:http", nil); err != nil || port != 9000 {
+		t.Errorf("GetVacantNLBAndPortForService() = %d, %v for the recreated svc, want its sticky port 9000", port, err)
+	}
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9000, "default/web:http", "listener", "target"); err != nil {
+		t.Fatal(err)
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation == nil || allocation.Sticky {
+		t.Errorf("GetAllocationForSVC() = %+v after assigning, want an allocation that is not sticky", allocation)
+	}
+
+	now = now.Add(time.Hour)
+	if _, port, err := s.GetVacantNLBAndPortForService(ctx, "default/api:http", func(string) bool { return true }); err != nil || port != 9001 {
+		t.Errorf("GetVacantNLBAndPortForService() = %d, %v after the retention, want the lowest free port 9001", port, err)
+	}
+	if allocation := s.GetAllocationForSVC(ctx, "default/web:http"); allocation == nil || allocation.Port != 9000 {
+		t.Errorf("GetAllocationForSVC() = %+v, want the assigned allocation to outlive the retention", allocation)
+	}
+	if err := s.Check(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseNLBList(t *testing.T) {
+	nlbs, err := ParseNLBList("public:public.elb.amazonaws.com,\ninternal:internal.elb.amazonaws.com:9100-9199\nnew:new.elb.amazonaws.com::3\n")
+	if err != nil {
+		t.Fatalf("ParseNLBList() error = %v", err)
+	}
+	want := []NLB{
+		{Name: "public", Host: "public.elb.amazonaws.com"},
+		{Name: "internal", Host: "internal.elb.amazonaws.com", PortRange: PortRange{Min: 9100, Max: 9199}},
+		{Name: "new", Host: "new.elb.amazonaws.com", Weight: 3},
+	}
+	if len(nlbs) != len(want) {
+		t.Fatalf("ParseNLBList() = %+v, want %+v", nlbs, want)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(nlbs[i], want[i]) {
+			t.Errorf("ParseNLBList()[%d] = %+v, want %+v", i, nlbs[i], want[i])
+		}
+	}
+	for _, value := range []string{"public", ":host", "public:host:9000", "public:host:9000-9049:extra", "public:host:", "public:host:9000-9049:0", "public:host:9000-9049:1:extra"} {
+		if _, err := ParseNLBList(value); err == nil {
+			t.Errorf("ParseNLBList(%q) error = nil, want an error", value)
+		}
+	}
+}
+
+func TestExcludedPorts(t *testing.T) {
+	ctx := context.Background()
+	nlb := NLB{Name: "shared", Host: "shared.elb.amazonaws.com", PortRange: PortRange{Min: 9000, Max: 9003}, ExcludedPorts: []int{9000, 9001}}
+	s := newStore([]NLB{nlb})
+	if _, port, err := s.GetVacantNLBAndPortForService(ctx, "default/web:http", nil); err != nil || port != 9002 {
+		t.Errorf("GetVacantNLBAndPortForService() = %d, %v, want 9002", port, err)
+	}
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, "shared", 9001, "default/api:http", "listener", "target"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("AssignNLBAndPortToServiceInNamespace() of an excluded port error = %v, want ErrUnavailable", err)
+	}
+	if allocated, free := s.PoolUsage("shared"); allocated != 1 || free != 1 {
+		t.Errorf("PoolUsage() = %d, %d, want 1, 1", allocated, free)
+	}
+
+	nlb.ExcludedPorts = []int{9000}
+	s.AddNLB(nlb)
+	if _, port, err := s.GetVacantNLBAndPortForService(ctx, "default/api:http", nil); err != nil || port != 9001 {
+		t.Errorf("GetVacantNLBAndPortForService() = %d, %v after 9001 is no longer excluded, want 9001", port, err)
+	}
+}
+
+func TestVacantSkipsDrainingNLBs(t *testing.T) {
+	s := newStore([]NLB{{Name: "old", Host: "old.elb.amazonaws.com"}, {Name: "new", Host: "new.elb.amazonaws.com"}})
+	s.DrainNLB("old")
+	for i := 0; i < 10; i++ {
+		nlb, _, err := s.vacant(context.Background(), fmt.Sprintf("default/web-%d:http", i), nil)
+		if err != nil {
+			t.Fatalf("vacant() error = %v", err)
+		}
+		if nlb != "new" {
+			t.Errorf("vacant() = %s, want new", nlb)
+		}
+	}
+	s.AddNLB(NLB{Name: "old", Host: "old.elb.amazonaws.com"})
+	if s.Draining["old"] {
+		t.Errorf("nlb still draining after it was added again")
+	}
+}
+
+func TestCheckFindsUnrecordedAllocations(t *testing.T) {
+	s := newStore([]NLB{{Name: "public", Host: "public.elb.amazonaws.com"}})
+	if err := s.assign("public", 10000, "default/web:http", "listener", "target"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Check(); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	delete(s.NlbAllocationMap["public"], 10000)
+	if err := s.Check(); err == nil {
+		t.Error("Check() error = nil for an allocation whose port is not recorded")
+	}
+}
+
+// flakyClaims is a claimBackend whose deletes fail while failDeletes is set.
+type flakyClaims struct {
+	claims      map[string]claim
+	failDeletes bool
+}
+
+func (f *flakyClaims) list(context.Context) ([]claim, error) {
+	var claims []claim
+	for _, c := range f.claims {
+		claims = append(claims, c)
+	}
+	return claims, nil
+}
+
+func (f *flakyClaims) put(_ context.Context, c claim) error {
+	f.claims[fmt.Sprintf("%s/%d", c.NLB, c.Port)] = c
+	return nil
+}
+
+func (f *flakyClaims) delete(_ context.Context, _ string, _ string, nlb string, port int) error {
+	if f.failDeletes {
+		return errors.New("backend unavailable")
+	}
+	delete(f.claims, fmt.Sprintf("%s/%d", nlb, port))
+	return nil
+}
+
+func TestFlushRetriesFailedReleases(t *testing.T) {
+	ctx := context.Background()
+	backend := &flakyClaims{claims: map[string]claim{}}
+	s, err := newSharedStore(ctx, backend, "prod", []NLB{{Name: "public", Host: "public.elb.amazonaws.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nlb, port, err := s.GetVacantNLBAndPortForService(ctx, "default/web:http", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, nlb, port, "default/web:http", "listener", "target"); err != nil {
+		t.Fatal(err)
+	}
+
+	backend.failDeletes = true
+	s.ReleaseNLBAndPortForService(ctx, "default/web:http", nlb, port)
+	if len(backend.claims) != 1 {
+		t.Fatalf("claims = %d after a failed release, want 1", len(backend.claims))
+	}
+	if err := s.Flush(ctx); err == nil {
+		t.Error("Flush() error = nil while the backend is unavailable")
+	}
+
+	backend.failDeletes = false
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(backend.claims) != 0 {
+		t.Errorf("claims = %d after Flush, want 0", len(backend.claims))
+	}
+}
+
+func TestErrorClasses(t *testing.T) {
+	configMaps := schema.GroupResource{Resource: "configmaps"}
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{ErrUnavailable, ErrConflict},
+		{ErrNoVacancy, ErrQuotaExceeded},
+		{ErrListenerQuota, ErrQuotaExceeded},
+		{classify(apierrors.NewConflict(configMaps, "allocations", errors.New("modified"))), ErrConflict},
+		{classify(apierrors.NewTooManyRequests("slow down", 1)), ErrThrottled},
+		{classify(fmt.Errorf("store: unable to claim port: %w", &smithy.GenericAPIError{Code: "ProvisionedThroughputExceededException"})), ErrThrottled},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("errors.Is(%v, %v) = false", tt.err, tt.want)
+		}
+	}
+	if err := classify(errors.New("boom")); errors.Is(err, ErrConflict) || errors.Is(err, ErrThrottled) {
+		t.Errorf("classify() gave %v a class", err)
+	}
+	if !apierrors.IsConflict(classify(apierrors.NewConflict(configMaps, "allocations", errors.New("modified")))) {
+		t.Error("classify() hides kubernetes conflicts from RetryOnConflict")
+	}
+}
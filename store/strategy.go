@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Candidate is an NLB a new allocation can be made on: it is allowed for the
+// svc, not draining, below its listener quota and has a free port.
+type Candidate struct {
+	NLB string
+	// Allocated and Free are the allocated and free ports of the NLB, as
+	// returned by PoolUsage.
+	Allocated int
+	Free      int
+	// Weight is the Weight of the NLB, at least 1.
+	Weight int
+}
+
+// Strategy chooses the NLB a new allocation is made on. Choose is called with
+// the store locked and candidates sorted by name, and returns the index of
+// the chosen candidate, or -1 to allocate none.
+type Strategy interface {
+	Choose(ctx context.Context, serviceNamespacedName string, candidates []Candidate) int
+}
+
+// LeastLoaded allocates on the NLB with the fewest allocated ports, spreading
+// services evenly over the NLBs.
+type LeastLoaded struct{}
+
+func (LeastLoaded) Choose(_ context.Context, _ string, candidates []Candidate) int {
+	chosen := -1
+	for i, c := range candidates {
+		if chosen < 0 || c.Allocated < candidates[chosen].Allocated {
+			chosen = i
+		}
+	}
+	return chosen
+}
+
+// BinPacking allocates on the NLB with the most allocated ports, filling one
+// NLB before the next, so that NLBs that are not needed can be drained.
+type BinPacking struct{}
+
+func (BinPacking) Choose(_ context.Context, _ string, candidates []Candidate) int {
+	chosen := -1
+	for i, c := range candidates {
+		if chosen < 0 || c.Allocated > candidates[chosen].Allocated {
+			chosen = i
+		}
+	}
+	return chosen
+}
+
+// RoundRobin allocates on the NLBs in turn, in the order of their names.
+type RoundRobin struct {
+	last string
+}
+
+func (r *RoundRobin) Choose(_ context.Context, _ string, candidates []Candidate) int {
+	if len(candidates) == 0 {
+		return -1
+	}
+	chosen := sort.Search(len(candidates), func(i int) bool { return candidates[i].NLB > r.last })
+	if chosen == len(candidates) {
+		chosen = 0
+	}
+	r.last = candidates[chosen].NLB
+	return chosen
+}
+
+// Weighted allocates on each NLB a share of new allocations proportional to
+// its weight, such as 7 in 10 on an NLB of weight 7 next to one of weight 3.
+// Allocations are interleaved by smooth weighted round-robin: every NLB gains
+// its weight in credit on each allocation, and the NLB with the most credit
+// is chosen and pays back the total weight.
+type Weighted struct {
+	credit map[string]int
+}
+
+func (w *Weighted) Choose(_ context.Context, _ string, candidates []Candidate) int {
+	if w.credit == nil {
+		w.credit = map[string]int{}
+	}
+	chosen, total := -1, 0
+	for i, c := range candidates {
+		w.credit[c.NLB] += c.Weight
+		total += c.Weight
+		if chosen < 0 || w.credit[c.NLB] > w.credit[candidates[chosen].NLB] {
+			chosen = i
+		}
+	}
+	if chosen >= 0 {
+		w.credit[candidates[chosen].NLB] -= total
+	}
+	return chosen
+}
+
+// Pinned allocates the ports of a svc on the NLB pinned with WithPinnedNLB,
+// and on none if that NLB is not a candidate. The ports of svcs without a
+// pinned NLB are allocated by Fallback, LeastLoaded if nil.
+type Pinned struct {
+	Fallback Strategy
+}
+
+func (p Pinned) Choose(ctx context.Context, serviceNamespacedName string, candidates []Candidate) int {
+	nlb, ok := ctx.Value(pinnedNLBKey{}).(string)
+	if !ok {
+		fallback := p.Fallback
+		if fallback == nil {
+			fallback = LeastLoaded{}
+		}
+		return fallback.Choose(ctx, serviceNamespacedName, candidates)
+	}
+	for i, c := range candidates {
+		if c.NLB == nlb {
+			return i
+		}
+	}
+	return -1
+}
+
+type pinnedNLBKey struct{}
+
+// WithPinnedNLB returns a context that pins the allocations made with it to
+// an NLB, when the store uses the Pinned strategy.
+func WithPinnedNLB(ctx context.Context, nlb string) context.Context {
+	return context.WithValue(ctx, pinnedNLBKey{}, nlb)
+}
+
+// Strategies are the names of the strategies of NewStrategy.
+var Strategies = []string{"least-loaded", "round-robin", "bin-packing", "weighted", "annotation-pinned"}
+
+// NewStrategy returns the strategy of a name of Strategies. Pinned svcs of
+// annotation-pinned are pinned by the service-nlb-pinned-nlb annotation.
+func NewStrategy(name string) (Strategy, error) {
+	switch name {
+	case "least-loaded":
+		return LeastLoaded{}, nil
+	case "round-robin":
+		return &RoundRobin{}, nil
+	case "bin-packing":
+		return BinPacking{}, nil
+	case "weighted":
+		return &Weighted{}, nil
+	case "annotation-pinned":
+		return Pinned{}, nil
+	}
+	return nil, fmt.Errorf("unknown nlb selection strategy %q, want one of %v", name, Strategies)
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestStrategies(t *testing.T) {
+	candidates := []Candidate{
+		{NLB: "a", Allocated: 5, Free: 45},
+		{NLB: "b", Allocated: 2, Free: 48},
+		{NLB: "c", Allocated: 9, Free: 41},
+	}
+	ctx := context.Background()
+	if got := (LeastLoaded{}).Choose(ctx, "default/web:http", candidates); got != 1 {
+		t.Errorf("LeastLoaded.Choose() = %d, want 1", got)
+	}
+	if got := (BinPacking{}).Choose(ctx, "default/web:http", candidates); got != 2 {
+		t.Errorf("BinPacking.Choose() = %d, want 2", got)
+	}
+
+	var roundRobin RoundRobin
+	for _, want := range []int{0, 1, 2, 0} {
+		if got := roundRobin.Choose(ctx, "default/web:http", candidates); got != want {
+			t.Errorf("RoundRobin.Choose() = %d, want %d", got, want)
+		}
+	}
+	if got := roundRobin.Choose(ctx, "default/web:http", candidates[1:]); got != 0 {
+		t.Errorf("RoundRobin.Choose() = %d without the last chosen nlb, want 0", got)
+	}
+
+	weighted := []Candidate{{NLB: "new", Weight: 7}, {NLB: "old", Weight: 3}}
+	var w Weighted
+	chosen := map[string]int{}
+	for i := 0; i < 10; i++ {
+		chosen[weighted[w.Choose(ctx, "default/web:http", weighted)].NLB]++
+	}
+	if chosen["new"] != 7 || chosen["old"] != 3 {
+		t.Errorf("Weighted chose %v in 10 allocations, want new 7 times and old 3 times", chosen)
+	}
+
+	if got := (Pinned{}).Choose(WithPinnedNLB(ctx, "c"), "default/web:http", candidates); got != 2 {
+		t.Errorf("Pinned.Choose() = %d, want the pinned nlb 2", got)
+	}
+	if got := (Pinned{}).Choose(WithPinnedNLB(ctx, "full"), "default/web:http", candidates); got != -1 {
+		t.Errorf("Pinned.Choose() = %d for an nlb that is not a candidate, want -1", got)
+	}
+	if got := (Pinned{Fallback: BinPacking{}}).Choose(ctx, "default/web:http", candidates); got != 2 {
+		t.Errorf("Pinned.Choose() = %d without a pinned nlb, want the fallback 2", got)
+	}
+}
+
+func TestVacantUsesStrategy(t *testing.T) {
+	ctx := context.Background()
+	s := newStore([]NLB{{Name: "a", Host: "a.elb.amazonaws.com"}, {Name: "b", Host: "b.elb.amazonaws.com"}})
+	for i := 0; i < 4; i++ {
+		nlb, _, err := s.GetVacantNLBAndPortForService(ctx, fmt.Sprintf("default/web-%d:http", i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"a", "b"}[i%2]; nlb != want {
+			t.Errorf("least loaded allocation %d on %s, want %s", i, nlb, want)
+		}
+	}
+
+	s.SetStrategy(BinPacking{})
+	if nlb, _, err := s.GetVacantNLBAndPortForService(ctx, "default/api:http", nil); err != nil || nlb != "a" {
+		t.Errorf("GetVacantNLBAndPortForService() = %s, %v, want a", nlb, err)
+	}
+
+	s.SetStrategy(Pinned{})
+	if nlb, _, err := s.GetVacantNLBAndPortForService(WithPinnedNLB(ctx, "b"), "default/pinned:http", nil); err != nil || nlb != "b" {
+		t.Errorf("GetVacantNLBAndPortForService() = %s, %v, want the pinned nlb b", nlb, err)
+	}
+	if _, err := NewStrategy("random"); err == nil {
+		t.Error("NewStrategy() error = nil for an unknown strategy")
+	}
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/chinmayrelkar/aws-nlb-controller/store")
+
+// WithTracing returns a Store that records a span for every call of s that
+// takes a context, so that time spent waiting for the store, on its lock or
+// on its backend, shows in the traces of reconciles.
+func WithTracing(s Store) Store {
+	return tracedStore{Store: s}
+}
+
+// tracedStore passes the methods without a context, which only read or
+// update memory, through to Store.
+type tracedStore struct {
+	Store
+}
+
+func startSpan(ctx context.Context, method string, serviceNamespacedName string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "store."+method, trace.WithAttributes(attribute.String("allocation", serviceNamespacedName)))
+}
+
+// endSpan records err on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t tracedStore) AssignNLBAndPortToServiceInNamespace(
+	ctx context.Context,
+	nlb string,
+	port int,
+	serviceNamespacedName string,
+	listenerArn string,
+	targetArn string,
+) error {
+	ctx, span := startSpan(ctx, "AssignNLBAndPortToServiceInNamespace", serviceNamespacedName)
+	span.SetAttributes(attribute.String("nlb", nlb), attribute.Int("nlb.port", port))
+	err := t.Store.AssignNLBAndPortToServiceInNamespace(ctx, nlb, port, serviceNamespacedName, listenerArn, targetArn)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedStore) GetVacantNLBAndPortForService(ctx context.Context, serviceNamespacedName string, allowed NLBFilter) (string, int, error) {
+	ctx, span := startSpan(ctx, "GetVacantNLBAndPortForService", serviceNamespacedName)
+	nlb, port, err := t.Store.GetVacantNLBAndPortForService(ctx, serviceNamespacedName, allowed)
+	span.SetAttributes(attribute.String("nlb", nlb), attribute.Int("nlb.port", port))
+	endSpan(span, err)
+	return nlb, port, err
+}
+
+func (t tracedStore) ReleaseNLBAndPortForService(ctx context.Context, serviceNamespacedName string, nlb string, port int) {
+	ctx, span := startSpan(ctx, "ReleaseNLBAndPortForService", serviceNamespacedName)
+	span.SetAttributes(attribute.String("nlb", nlb), attribute.Int("nlb.port", port))
+	t.Store.ReleaseNLBAndPortForService(ctx, serviceNamespacedName, nlb, port)
+	span.End()
+}
+
+func (t tracedStore) RetainNLBAndPortForService(ctx context.Context, serviceNamespacedName string) error {
+	ctx, span := startSpan(ctx, "RetainNLBAndPortForService", serviceNamespacedName)
+	err := t.Store.RetainNLBAndPortForService(ctx, serviceNamespacedName)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedStore) StickNLBAndPortForService(ctx context.Context, serviceNamespacedName string) error {
+	ctx, span := startSpan(ctx, "StickNLBAndPortForService", serviceNamespacedName)
+	err := t.Store.StickNLBAndPortForService(ctx, serviceNamespacedName)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedStore) GetListenerArnFor(ctx context.Context, s string) string {
+	ctx, span := startSpan(ctx, "GetListenerArnFor", s)
+	defer span.End()
+	return t.Store.GetListenerArnFor(ctx, s)
+}
+
+func (t tracedStore) GetAllocationForSVC(ctx context.Context, name string) *Allocation {
+	ctx, span := startSpan(ctx, "GetAllocationForSVC", name)
+	defer span.End()
+	return t.Store.GetAllocationForSVC(ctx, name)
+}
+
+func (t tracedStore) GetAllocationsForSVC(ctx context.Context, serviceNamespacedName string) []*Allocation {
+	ctx, span := startSpan(ctx, "GetAllocationsForSVC", serviceNamespacedName)
+	defer span.End()
+	return t.Store.GetAllocationsForSVC(ctx, serviceNamespacedName)
+}
+
+func (t tracedStore) ListAllocations(ctx context.Context) []*Allocation {
+	ctx, span := tracer.Start(ctx, "store.ListAllocations")
+	defer span.End()
+	return t.Store.ListAllocations(ctx)
+}
+
+func (t tracedStore) Flush(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "store.Flush")
+	err := t.Store.Flush(ctx)
+	endSpan(span, err)
+	return err
+}
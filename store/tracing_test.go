@@ -0,0 +1,34 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWithTracingRecordsSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	s := WithTracing(newStore([]NLB{{Name: "public", Host: "public.elb.amazonaws.com"}}))
+	ctx := context.Background()
+	nlb, port, err := s.GetVacantNLBAndPortForService(ctx, "default/web:http", nil)
+	if err != nil {
+		t.Fatalf("GetVacantNLBAndPortForService() error = %v", err)
+	}
+	if err := s.AssignNLBAndPortToServiceInNamespace(ctx, nlb, port, "default/web:http", "listener", "target"); err != nil {
+		t.Fatalf("AssignNLBAndPortToServiceInNamespace() error = %v", err)
+	}
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+	}
+	want := []string{"store.GetVacantNLBAndPortForService", "store.AssignNLBAndPortToServiceInNamespace"}
+	if len(names) != len(want) || names[0] != want[0] || names[1] != want[1] {
+		t.Errorf("spans = %v, want %v", names, want)
+	}
+}
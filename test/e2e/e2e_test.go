@@ -0,0 +1,543 @@
+//go:build e2e
+// +build e2e
+
+// Package e2e deploys the controller into a kind cluster with LocalStack as
+// AWS, and checks what it does to NodePort Services: their annotations and
+// Events, and the listeners and target groups it leaves in LocalStack. Run
+// it with make test-e2e, which creates the cluster and loads the image.
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// clusterName is the name the VPC and instances of the e2e cluster are
+	// tagged with, as set in config/e2e.
+	clusterName = "e2e"
+	region      = "us-east-1"
+
+	controllerNamespace  = "aws-nlb-controller-system"
+	controllerDeployment = "aws-nlb-controller-controller-manager"
+	nlbListConfigMap     = "aws-nlb-controller-nlbs"
+
+	nlbName = "e2e"
+	// the port range of the NLB, narrow enough to exhaust
+	portMin = 9000
+	portMax = 9009
+
+	serviceAnnotation  = "github.com/chinmayrelkar/service"
+	listenerAnnotation = "service-nlb-listener"
+	portAnnotation     = "service-nlb-port"
+	serviceFinalizer   = "nlb.chinmayrelkar.github.com/cleanup"
+
+	// timeout bounds every wait for the controller
+	timeout = 3 * time.Minute
+)
+
+var (
+	k8sClient client.Client
+	elbClient *elbv2.Client
+	nlb       aws.NLB
+
+	instanceID string
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+// run deploys LocalStack, prepares the VPC, instance and NLB of the cluster
+// in it, then deploys the controller and runs the tests.
+func run(m *testing.M) int {
+	ctx := context.Background()
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to load kubeconfig:", err)
+		return 1
+	}
+	k8sClient, err = client.New(cfg, client.Options{Scheme: clientgoscheme.Scheme})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := deploy("../../config/e2e/localstack", "localstack", "localstack", false); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to deploy LocalStack:", err)
+		return 1
+	}
+	endpoint := os.Getenv("LOCALSTACK_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "http://localhost:4566"
+	}
+	if err := waitForLocalStack(endpoint); err != nil {
+		fmt.Fprintf(os.Stderr, "LocalStack is not reachable at %s: %s\n", endpoint, err)
+		return 1
+	}
+	if err := prepareAWS(ctx, endpoint); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to prepare AWS in LocalStack:", err)
+		return 1
+	}
+
+	if err := deploy("../../config/e2e", controllerNamespace, controllerDeployment, true); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to deploy the controller:", err)
+		return 1
+	}
+	if err := writeNLBList(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to write the nlb list:", err)
+		return 1
+	}
+	return m.Run()
+}
+
+// deploy applies the kustomization in dir and waits for the rollout of
+// deployment. restart rolls out a fresh pod, so that a rebuilt image is
+// picked up.
+func deploy(dir string, namespace string, deployment string, restart bool) error {
+	kustomize := os.Getenv("KUSTOMIZE")
+	if kustomize == "" {
+		kustomize = "kustomize"
+	}
+	manifests, err := command(nil, kustomize, "build", dir)
+	if err != nil {
+		return err
+	}
+	if _, err := command(manifests, "kubectl", "apply", "-f", "-"); err != nil {
+		return err
+	}
+	if restart {
+		if _, err := command(nil, "kubectl", "-n", namespace, "rollout", "restart", "deployment/"+deployment); err != nil {
+			return err
+		}
+	}
+	_, err = command(nil, "kubectl", "-n", namespace, "rollout", "status", "deployment/"+deployment, "--timeout=5m")
+	return err
+}
+
+// command runs name with args and stdin, and returns its output.
+func command(stdin []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, stderr.String())
+	}
+	return out, nil
+}
+
+func waitForLocalStack(endpoint string) error {
+	httpClient := http.Client{Timeout: 5 * time.Second}
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := httpClient.Get(strings.TrimSuffix(endpoint, "/") + "/_localstack/health")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("health check returned %s", resp.Status)
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// prepareAWS creates the VPC, subnet and node instance of the cluster and
+// the NLB the controller manages, unless an earlier run left them in
+// LocalStack.
+func prepareAWS(ctx context.Context, endpoint string) error {
+	opts := aws.Options{
+		ClusterID:   clusterName,
+		ClusterName: clusterName,
+		Region:      region,
+		DisableIMDS: true,
+		Endpoints:   aws.Endpoints{URL: endpoint},
+	}
+	// LocalStack accepts any credentials
+	for key, value := range map[string]string{"AWS_ACCESS_KEY_ID": "test", "AWS_SECRET_ACCESS_KEY": "test"} {
+		if os.Getenv(key) == "" {
+			os.Setenv(key, value)
+		}
+	}
+	cfg, err := aws.LoadConfig(ctx, opts)
+	if err != nil {
+		return err
+	}
+	elbClient = elbv2.NewFromConfig(cfg)
+	ec2Client := ec2.NewFromConfig(cfg)
+	clusterTag := ec2types.Tag{Key: awssdk.String("kubernetes.io/cluster/" + clusterName), Value: awssdk.String("owned")}
+	clusterFilter := ec2types.Filter{Name: awssdk.String("tag-key"), Values: []string{*clusterTag.Key}}
+
+	subnets, err := ec2Client.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{Filters: []ec2types.Filter{clusterFilter}})
+	if err != nil {
+		return err
+	}
+	var subnet ec2types.Subnet
+	if len(subnets.Subnets) > 0 {
+		subnet = subnets.Subnets[0]
+	} else {
+		vpc, err := ec2Client.CreateVpc(ctx, &ec2.CreateVpcInput{CidrBlock: awssdk.String("10.0.0.0/16")})
+		if err != nil {
+			return err
+		}
+		created, err := ec2Client.CreateSubnet(ctx, &ec2.CreateSubnetInput{
+			VpcId:     vpc.Vpc.VpcId,
+			CidrBlock: awssdk.String("10.0.1.0/24"),
+			TagSpecifications: []ec2types.TagSpecification{
+				{ResourceType: ec2types.ResourceTypeSubnet, Tags: []ec2types.Tag{clusterTag}},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		subnet = *created.Subnet
+	}
+	opts.VPC = awssdk.ToString(subnet.VpcId)
+
+	instances, err := ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{Filters: []ec2types.Filter{
+		clusterFilter,
+		{Name: awssdk.String("instance-state-name"), Values: []string{string(ec2types.InstanceStateNameRunning)}},
+	}})
+	if err != nil {
+		return err
+	}
+	if len(instances.Reservations) > 0 && len(instances.Reservations[0].Instances) > 0 {
+		instanceID = awssdk.ToString(instances.Reservations[0].Instances[0].InstanceId)
+	} else {
+		// any of the images LocalStack comes with runs the node
+		images, err := ec2Client.DescribeImages(ctx, &ec2.DescribeImagesInput{})
+		if err != nil {
+			return err
+		}
+		if len(images.Images) == 0 {
+			return errors.New("no image to run an instance from")
+		}
+		created, err := ec2Client.RunInstances(ctx, &ec2.RunInstancesInput{
+			ImageId:  images.Images[0].ImageId,
+			MinCount: awssdk.Int32(1),
+			MaxCount: awssdk.Int32(1),
+			SubnetId: subnet.SubnetId,
+			TagSpecifications: []ec2types.TagSpecification{
+				{ResourceType: ec2types.ResourceTypeInstance, Tags: []ec2types.Tag{clusterTag}},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		instanceID = awssdk.ToString(created.Instances[0].InstanceId)
+	}
+
+	awsClient, err := aws.New(ctx, opts)
+	if err != nil {
+		return err
+	}
+	nlb, err = awsClient.EnsureNLB(ctx, aws.NLBSpec{Pool: "e2e", Name: nlbName, Subnets: []string{awssdk.ToString(subnet.SubnetId)}})
+	return err
+}
+
+// writeNLBList hands the NLB to the controller through its NLB list
+// ConfigMap.
+func writeNLBList(ctx context.Context) error {
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: controllerNamespace, Name: nlbListConfigMap}}
+	data := map[string]string{"nlbs": fmt.Sprintf("%s:%s:%d-%d", nlbName, nlb.DNSName, portMin, portMax)}
+	err := k8sClient.Get(ctx, client.ObjectKeyFromObject(cm), cm)
+	switch {
+	case apierrors.IsNotFound(err):
+		cm.Data = data
+		return k8sClient.Create(ctx, cm)
+	case err != nil:
+		return err
+	}
+	cm.Data = data
+	return k8sClient.Update(ctx, cm)
+}
+
+// eventually retries check until it succeeds, and fails t with its last
+// error if it does not within timeout.
+func eventually(t *testing.T, what string, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: %s", what, err)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// createService creates an annotated NodePort svc named web in a namespace
+// of its own, and deletes both when t ends.
+func createService(t *testing.T, ports ...corev1.ServicePort) types.NamespacedName {
+	t.Helper()
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "e2e-"}}
+	if err := k8sClient.Create(ctx, ns); err != nil {
+		t.Fatal(err)
+	}
+	name := types.NamespacedName{Namespace: ns.Name, Name: "web"}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   name.Namespace,
+			Name:        name.Name,
+			Annotations: map[string]string{serviceAnnotation: "true"},
+		},
+		Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort, Ports: ports},
+	}
+	if err := k8sClient.Create(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		deleteService(t, name)
+		_ = k8sClient.Delete(ctx, ns)
+	})
+	return name
+}
+
+// deleteService deletes the svc and waits for the controller to let it go.
+func deleteService(t *testing.T, name types.NamespacedName) {
+	t.Helper()
+	ctx := context.Background()
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}}
+	if err := k8sClient.Delete(ctx, svc); err != nil && !apierrors.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	eventually(t, "svc not deleted", func() error {
+		err := k8sClient.Get(ctx, name, svc)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("svc still has finalizers %v", svc.Finalizers)
+	})
+}
+
+func service(t *testing.T, name types.NamespacedName) *corev1.Service {
+	t.Helper()
+	var svc corev1.Service
+	if err := k8sClient.Get(context.Background(), name, &svc); err != nil {
+		t.Fatal(err)
+	}
+	return &svc
+}
+
+// waitForListener waits for the controller to annotate the listener of the
+// port with key, and returns it.
+func waitForListener(t *testing.T, name types.NamespacedName, key string) string {
+	t.Helper()
+	var listenerArn string
+	eventually(t, "listener not annotated", func() error {
+		var svc corev1.Service
+		if err := k8sClient.Get(context.Background(), name, &svc); err != nil {
+			return err
+		}
+		listenerArn = svc.Annotations[listenerAnnotation+"."+key]
+		if listenerArn == "" {
+			return fmt.Errorf("annotations %v", svc.Annotations)
+		}
+		return nil
+	})
+	return listenerArn
+}
+
+// listeners returns the ARNs of the listeners of the NLB by their port.
+func listeners(t *testing.T) map[int32]string {
+	t.Helper()
+	out, err := elbClient.DescribeListeners(context.Background(), &elbv2.DescribeListenersInput{
+		LoadBalancerArn: awssdk.String(nlb.Arn),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	arns := map[int32]string{}
+	for _, listener := range out.Listeners {
+		arns[awssdk.ToInt32(listener.Port)] = awssdk.ToString(listener.ListenerArn)
+	}
+	return arns
+}
+
+// events returns the reasons of the Events of the svc.
+func events(name types.NamespacedName) ([]string, error) {
+	var list corev1.EventList
+	if err := k8sClient.List(context.Background(), &list, client.InNamespace(name.Namespace)); err != nil {
+		return nil, err
+	}
+	var reasons []string
+	for _, event := range list.Items {
+		if event.InvolvedObject.Kind == "Service" && event.InvolvedObject.Name == name.Name {
+			reasons = append(reasons, event.Reason)
+		}
+	}
+	return reasons, nil
+}
+
+func waitForEvent(t *testing.T, name types.NamespacedName, reason string) {
+	t.Helper()
+	eventually(t, "no "+reason+" event", func() error {
+		reasons, err := events(name)
+		if err != nil {
+			return err
+		}
+		for _, r := range reasons {
+			if r == reason {
+				return nil
+			}
+		}
+		return fmt.Errorf("events %v", reasons)
+	})
+}
+
+func TestServiceGetsListener(t *testing.T) {
+	ctx := context.Background()
+	name := createService(t, corev1.ServicePort{Name: "http", Port: 80})
+	listenerArn := waitForListener(t, name, "http")
+
+	svc := service(t, name)
+	port, err := strconv.Atoi(svc.Annotations[portAnnotation+".http"])
+	if err != nil || port < portMin || port > portMax {
+		t.Errorf("port annotation %q, want a port of %d-%d", svc.Annotations[portAnnotation+".http"], portMin, portMax)
+	}
+	if got := listeners(t)[int32(port)]; got != listenerArn {
+		t.Errorf("listener %q on port %d, want the annotated %q", got, port, listenerArn)
+	}
+	if !containsString(svc.Finalizers, serviceFinalizer) {
+		t.Errorf("finalizers %v, want %s", svc.Finalizers, serviceFinalizer)
+	}
+
+	// the target group forwards to the NodePort of the node
+	described, err := elbClient.DescribeListeners(ctx, &elbv2.DescribeListenersInput{ListenerArns: []string{listenerArn}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(described.Listeners) != 1 || len(described.Listeners[0].DefaultActions) == 0 {
+		t.Fatalf("listener %s has no action", listenerArn)
+	}
+	targetArn := described.Listeners[0].DefaultActions[0].TargetGroupArn
+	groups, err := elbClient.DescribeTargetGroups(ctx, &elbv2.DescribeTargetGroupsInput{TargetGroupArns: []string{awssdk.ToString(targetArn)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodePort := svc.Spec.Ports[0].NodePort; len(groups.TargetGroups) != 1 || awssdk.ToInt32(groups.TargetGroups[0].Port) != nodePort {
+		t.Errorf("target groups %+v, want one on the NodePort %d", groups.TargetGroups, nodePort)
+	}
+	eventually(t, "node not registered", func() error {
+		health, err := elbClient.DescribeTargetHealth(ctx, &elbv2.DescribeTargetHealthInput{TargetGroupArn: targetArn})
+		if err != nil {
+			return err
+		}
+		for _, target := range health.TargetHealthDescriptions {
+			if awssdk.ToString(target.Target.Id) == instanceID {
+				return nil
+			}
+		}
+		return fmt.Errorf("targets %+v, want %s", health.TargetHealthDescriptions, instanceID)
+	})
+
+	// deleting the svc deletes its listener
+	deleteService(t, name)
+	for port, arn := range listeners(t) {
+		if arn == listenerArn {
+			t.Errorf("listener %s left on port %d after the svc was deleted", arn, port)
+		}
+	}
+}
+
+func TestDriftedListenerIsRecreated(t *testing.T) {
+	ctx := context.Background()
+	name := createService(t, corev1.ServicePort{Name: "http", Port: 80})
+	deleted := waitForListener(t, name, "http")
+
+	// the listener is deleted behind the back of the controller, which finds
+	// out on the next reconcile of the svc
+	if _, err := elbClient.DeleteListener(ctx, &elbv2.DeleteListenerInput{ListenerArn: awssdk.String(deleted)}); err != nil {
+		t.Fatal(err)
+	}
+	svc := service(t, name)
+	svc.Annotations["e2e/touched"] = time.Now().Format(time.RFC3339Nano)
+	if err := k8sClient.Update(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForEvent(t, name, "Drifted")
+	eventually(t, "listener not recreated", func() error {
+		var svc corev1.Service
+		if err := k8sClient.Get(ctx, name, &svc); err != nil {
+			return err
+		}
+		recreated := svc.Annotations[listenerAnnotation+".http"]
+		if recreated == "" || recreated == deleted {
+			return fmt.Errorf("listener annotation %q", recreated)
+		}
+		port, _ := strconv.Atoi(svc.Annotations[portAnnotation+".http"])
+		if got := listeners(t)[int32(port)]; got != recreated {
+			return fmt.Errorf("listener %q on port %d, want the annotated %q", got, port, recreated)
+		}
+		return nil
+	})
+}
+
+func TestPortExhaustion(t *testing.T) {
+	before := len(listeners(t))
+
+	// one port more than the NLB has
+	var ports []corev1.ServicePort
+	for i := 0; i <= portMax-portMin+1; i++ {
+		ports = append(ports, corev1.ServicePort{Name: fmt.Sprintf("p%d", i), Port: int32(8000 + i)})
+	}
+	name := createService(t, ports...)
+
+	waitForEvent(t, name, "PortExhausted")
+	if svc := service(t, name); svc.Annotations[listenerAnnotation+".p0"] != "" {
+		t.Errorf("annotations %v of a svc whose ports did not all fit", svc.Annotations)
+	}
+
+	// the listeners of the ports that fit are rolled back
+	deleteService(t, name)
+	if after := len(listeners(t)); after != before {
+		t.Errorf("%d listeners left after the svc was deleted, want %d", after, before)
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,505 @@
+//go:build integration
+// +build integration
+
+// Package integration runs the ServiceReconciler end to end against a real
+// API server from envtest and the ELBv2 and EC2 APIs of LocalStack. Run it
+// with make test-integration, after make localstack.
+package integration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	nlbv1alpha1 "github.com/chinmayrelkar/aws-nlb-controller/api/v1alpha1"
+	"github.com/chinmayrelkar/aws-nlb-controller/aws"
+	"github.com/chinmayrelkar/aws-nlb-controller/controllers"
+	"github.com/chinmayrelkar/aws-nlb-controller/store"
+	storefake "github.com/chinmayrelkar/aws-nlb-controller/store/fake"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// The annotations and finalizer of the controller, as the users of the
+// controller see them.
+const (
+	serviceAnnotation    = "github.com/chinmayrelkar/service"
+	protocolAnnotation   = "service-nlb-protocol"
+	listenerAnnotation   = "service-nlb-listener"
+	serviceFinalizer     = "nlb.chinmayrelkar.github.com/cleanup"
+	defaultLocalStack    = "http://localhost:4566"
+	integrationClusterID = "integration"
+	integrationRegion    = "us-east-1"
+	integrationPortMin   = 9000
+	integrationPortMax   = 9099
+)
+
+var (
+	k8sClient client.Client
+	scheme    = runtime.NewScheme()
+
+	awsOptions aws.Options
+	elbClient  *elbv2.Client
+	subnet     string
+	instanceID string
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+// run starts envtest and prepares a VPC in LocalStack, then runs the tests.
+func run(m *testing.M) int {
+	ctx := context.Background()
+	endpoint := os.Getenv("LOCALSTACK_ENDPOINT")
+	if endpoint == "" {
+		endpoint = defaultLocalStack
+	}
+	if err := ping(endpoint); err != nil {
+		fmt.Fprintf(os.Stderr, "LocalStack is not reachable at %s, start it with make localstack: %s\n", endpoint, err)
+		return 1
+	}
+	// LocalStack accepts any credentials
+	for key, value := range map[string]string{"AWS_ACCESS_KEY_ID": "test", "AWS_SECRET_ACCESS_KEY": "test"} {
+		if os.Getenv(key) == "" {
+			os.Setenv(key, value)
+		}
+	}
+
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := nlbv1alpha1.AddToScheme(scheme); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "config", "crd", "bases")},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := testEnv.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to start envtest:", err)
+		return 1
+	}
+	defer testEnv.Stop()
+	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	awsOptions = aws.Options{
+		ClusterID:   integrationClusterID,
+		Region:      integrationRegion,
+		DisableIMDS: true,
+		Endpoints:   aws.Endpoints{URL: endpoint},
+	}
+	if err := prepareVPC(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to prepare the VPC in LocalStack:", err)
+		return 1
+	}
+	return m.Run()
+}
+
+func ping(endpoint string) error {
+	httpClient := http.Client{Timeout: 5 * time.Second}
+	resp, err := httpClient.Get(strings.TrimSuffix(endpoint, "/") + "/_localstack/health")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned %s", resp.Status)
+	}
+	return nil
+}
+
+// prepareVPC creates the VPC, subnet and instance the NLBs of the tests run
+// in and forward to.
+func prepareVPC(ctx context.Context) error {
+	cfg, err := aws.LoadConfig(ctx, awsOptions)
+	if err != nil {
+		return err
+	}
+	elbClient = elbv2.NewFromConfig(cfg)
+	ec2Client := ec2.NewFromConfig(cfg)
+
+	vpc, err := ec2Client.CreateVpc(ctx, &ec2.CreateVpcInput{CidrBlock: awssdk.String("10.0.0.0/16")})
+	if err != nil {
+		return err
+	}
+	awsOptions.VPC = awssdk.ToString(vpc.Vpc.VpcId)
+	sn, err := ec2Client.CreateSubnet(ctx, &ec2.CreateSubnetInput{
+		VpcId:     vpc.Vpc.VpcId,
+		CidrBlock: awssdk.String("10.0.1.0/24"),
+	})
+	if err != nil {
+		return err
+	}
+	subnet = awssdk.ToString(sn.Subnet.SubnetId)
+
+	// any of the images LocalStack comes with runs the node
+	images, err := ec2Client.DescribeImages(ctx, &ec2.DescribeImagesInput{})
+	if err != nil {
+		return err
+	}
+	if len(images.Images) == 0 {
+		return errors.New("no image to run an instance from")
+	}
+	instances, err := ec2Client.RunInstances(ctx, &ec2.RunInstancesInput{
+		ImageId:  images.Images[0].ImageId,
+		MinCount: awssdk.Int32(1),
+		MaxCount: awssdk.Int32(1),
+		SubnetId: sn.Subnet.SubnetId,
+	})
+	if err != nil {
+		return err
+	}
+	instanceID = awssdk.ToString(instances.Instances[0].InstanceId)
+	return nil
+}
+
+type staticNodes []controllers.Node
+
+func (s staticNodes) Nodes(context.Context) ([]controllers.Node, error) {
+	return append([]controllers.Node(nil), s...), nil
+}
+
+// harness is a ServiceReconciler of its own NLB and namespace, so that tests
+// do not see the listeners and Services of each other.
+type harness struct {
+	t         *testing.T
+	nlb       aws.NLB
+	namespace string
+	store     *storefake.Store
+	faults    *aws.FaultInjector
+	r         *controllers.ServiceReconciler
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+	ctx := context.Background()
+	name := strings.ToLower(strings.ReplaceAll(t.Name(), "/", "-"))
+	if len(name) > 32 {
+		name = name[:32]
+	}
+
+	awsClient, err := aws.New(ctx, awsOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nlb, err := awsClient.EnsureNLB(ctx, aws.NLBSpec{Pool: "integration", Name: name, Subnets: []string{subnet}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = elbClient.DeleteLoadBalancer(ctx, &elbv2.DeleteLoadBalancerInput{LoadBalancerArn: awssdk.String(nlb.Arn)})
+	})
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if err := k8sClient.Create(ctx, ns); err != nil {
+		t.Fatal(err)
+	}
+
+	s := storefake.New(store.NLB{
+		Name:      name,
+		Host:      nlb.DNSName,
+		PortRange: store.PortRange{Min: integrationPortMin, Max: integrationPortMax},
+	})
+	faults := aws.NewFaultInjector(awsClient, aws.Faults{})
+	return &harness{
+		t:         t,
+		nlb:       nlb,
+		namespace: name,
+		store:     s,
+		faults:    faults,
+		r: &controllers.ServiceReconciler{
+			Client:    k8sClient,
+			Scheme:    scheme,
+			Store:     s,
+			AwsClient: faults,
+			Nodes:     staticNodes{{Name: "node", InstanceID: instanceID}},
+		},
+	}
+}
+
+// createService creates an annotated NodePort svc named web with ports,
+// whose NodePorts the API server allocates.
+func (h *harness) createService(annotations map[string]string, ports ...corev1.ServicePort) types.NamespacedName {
+	h.t.Helper()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   h.namespace,
+			Name:        "web",
+			Annotations: map[string]string{serviceAnnotation: "true"},
+		},
+		Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort, Ports: ports},
+	}
+	for k, v := range annotations {
+		svc.Annotations[k] = v
+	}
+	if err := k8sClient.Create(context.Background(), svc); err != nil {
+		h.t.Fatal(err)
+	}
+	return types.NamespacedName{Namespace: h.namespace, Name: "web"}
+}
+
+func (h *harness) reconcile(name types.NamespacedName) error {
+	_, err := h.r.Reconcile(context.Background(), ctrl.Request{NamespacedName: name})
+	return err
+}
+
+func (h *harness) service(name types.NamespacedName) *corev1.Service {
+	h.t.Helper()
+	var svc corev1.Service
+	if err := k8sClient.Get(context.Background(), name, &svc); err != nil {
+		h.t.Fatal(err)
+	}
+	return &svc
+}
+
+// listeners returns the ARNs of the listeners of the NLB by their port.
+func (h *harness) listeners() map[int32]string {
+	h.t.Helper()
+	out, err := elbClient.DescribeListeners(context.Background(), &elbv2.DescribeListenersInput{
+		LoadBalancerArn: awssdk.String(h.nlb.Arn),
+	})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	listeners := map[int32]string{}
+	for _, listener := range out.Listeners {
+		listeners[awssdk.ToInt32(listener.Port)] = awssdk.ToString(listener.ListenerArn)
+	}
+	return listeners
+}
+
+// targets returns the instances registered in the target group of the
+// listener.
+func (h *harness) targets(listenerArn string) []string {
+	h.t.Helper()
+	ctx := context.Background()
+	out, err := elbClient.DescribeListeners(ctx, &elbv2.DescribeListenersInput{ListenerArns: []string{listenerArn}})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if len(out.Listeners) != 1 || len(out.Listeners[0].DefaultActions) == 0 {
+		h.t.Fatalf("listener %s has no action", listenerArn)
+	}
+	health, err := elbClient.DescribeTargetHealth(ctx, &elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: out.Listeners[0].DefaultActions[0].TargetGroupArn,
+	})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	var targets []string
+	for _, target := range health.TargetHealthDescriptions {
+		targets = append(targets, awssdk.ToString(target.Target.Id))
+	}
+	return targets
+}
+
+func TestCreate(t *testing.T) {
+	h := newHarness(t)
+	name := h.createService(nil, corev1.ServicePort{Name: "http", Port: 80})
+	if err := h.reconcile(name); err != nil {
+		t.Fatal(err)
+	}
+
+	listeners := h.listeners()
+	if len(listeners) != 1 || listeners[integrationPortMin] == "" {
+		t.Fatalf("listeners %v, want one on port %d", listeners, integrationPortMin)
+	}
+	svc := h.service(name)
+	if got := svc.Annotations[listenerAnnotation+".http"]; got != listeners[integrationPortMin] {
+		t.Errorf("listener annotation %q, want %q", got, listeners[integrationPortMin])
+	}
+	if !containsString(svc.Finalizers, serviceFinalizer) {
+		t.Errorf("finalizers %v, want %s", svc.Finalizers, serviceFinalizer)
+	}
+	if targets := h.targets(listeners[integrationPortMin]); len(targets) != 1 || targets[0] != instanceID {
+		t.Errorf("targets %v, want the node %s", targets, instanceID)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	h := newHarness(t)
+	name := h.createService(nil, corev1.ServicePort{Name: "http", Port: 80})
+	if err := h.reconcile(name); err != nil {
+		t.Fatal(err)
+	}
+	want := h.listeners()
+
+	// a svc that is in sync is left as it is
+	if err := h.reconcile(name); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.listeners(); len(got) != 1 || got[integrationPortMin] != want[integrationPortMin] {
+		t.Errorf("listeners %v after a second reconcile, want %v", got, want)
+	}
+	if calls := h.store.Calls("GetVacantNLBAndPortForService"); len(calls) != 1 {
+		t.Errorf("allocated %d ports, want only the first reconcile to allocate", len(calls))
+	}
+
+	// a svc with an unsupported protocol gets no listener
+	if err := k8sClient.Delete(context.Background(), h.service(name)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.reconcile(name); err != nil {
+		t.Fatal(err)
+	}
+	invalid := h.createService(map[string]string{protocolAnnotation: "HTTP"}, corev1.ServicePort{Name: "http", Port: 80})
+	if err := h.reconcile(invalid); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.listeners(); len(got) != 0 {
+		t.Errorf("listeners %v for a svc with an unsupported protocol", got)
+	}
+	if got := h.service(invalid).Annotations[listenerAnnotation+".http"]; got != "" {
+		t.Errorf("listener annotation %q for a svc with an unsupported protocol", got)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	h := newHarness(t)
+	name := h.createService(nil, corev1.ServicePort{Name: "http", Port: 80})
+	if err := h.reconcile(name); err != nil {
+		t.Fatal(err)
+	}
+	deleted := h.service(name).Annotations[listenerAnnotation+".http"]
+
+	// the listener is deleted behind the back of the controller
+	if _, err := elbClient.DeleteListener(context.Background(), &elbv2.DeleteListenerInput{ListenerArn: awssdk.String(deleted)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.reconcile(name); err != nil {
+		t.Fatal(err)
+	}
+
+	listeners := h.listeners()
+	if len(listeners) != 1 {
+		t.Fatalf("listeners %v, want the deleted listener recreated", listeners)
+	}
+	got := h.service(name).Annotations[listenerAnnotation+".http"]
+	if got == deleted {
+		t.Errorf("listener annotation %q still names the deleted listener", got)
+	}
+	for _, arn := range listeners {
+		if arn != got {
+			t.Errorf("listener annotation %q, want the recreated listener %q", got, arn)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	h := newHarness(t)
+	name := h.createService(nil, corev1.ServicePort{Name: "http", Port: 80})
+	if err := h.reconcile(name); err != nil {
+		t.Fatal(err)
+	}
+
+	// the finalizer holds the svc until its listener is deleted
+	if err := k8sClient.Delete(ctx, h.service(name)); err != nil {
+		t.Fatal(err)
+	}
+	if svc := h.service(name); svc.DeletionTimestamp == nil {
+		t.Fatal("svc deleted before its listener")
+	}
+	if err := h.reconcile(name); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := h.listeners(); len(got) != 0 {
+		t.Errorf("listeners %v left after the svc was deleted", got)
+	}
+	if allocations := h.store.ListAllocations(ctx); len(allocations) != 0 {
+		t.Errorf("allocations %+v left after the svc was deleted", allocations)
+	}
+	var svc corev1.Service
+	if err := k8sClient.Get(ctx, name, &svc); !apierrors.IsNotFound(err) {
+		t.Errorf("svc %+v still exists after its finalizer ran, err %v", svc.ObjectMeta, err)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	ctx := context.Background()
+	h := newHarness(t)
+	name := h.createService(nil,
+		corev1.ServicePort{Name: "http", Port: 80},
+		corev1.ServicePort{Name: "https", Port: 443},
+	)
+
+	// the second port cannot be allocated, so the listener of the first one
+	// is deleted again
+	h.store.FailNext("GetVacantNLBAndPortForService", nil)
+	h.store.FailNext("GetVacantNLBAndPortForService", errors.New("store unavailable"))
+	if err := h.reconcile(name); err == nil {
+		t.Fatal("reconcile succeeded with a port that cannot be allocated")
+	}
+	if got := h.listeners(); len(got) != 0 {
+		t.Errorf("listeners %v left after a failed reconcile, want the first port rolled back", got)
+	}
+	if allocations := h.store.ListAllocations(ctx); len(allocations) != 0 {
+		t.Errorf("allocations %+v left after a failed reconcile", allocations)
+	}
+
+	// a listener that cannot be deleted is left behind for the SEV0
+	h.faults.SetFaults(aws.Faults{DeleteFailure: 1})
+	h.store.FailNext("GetVacantNLBAndPortForService", nil)
+	h.store.FailNext("GetVacantNLBAndPortForService", errors.New("store unavailable"))
+	if err := h.reconcile(name); err == nil {
+		t.Fatal("reconcile succeeded with a port that cannot be allocated")
+	}
+	leftover := h.listeners()
+	if len(leftover) != 1 {
+		t.Fatalf("listeners %v after a failed rollback, want the first port left behind", leftover)
+	}
+
+	// it is reused once AWS recovers
+	h.faults.SetFaults(aws.Faults{})
+	if err := h.reconcile(name); err != nil {
+		t.Fatal(err)
+	}
+	listeners := h.listeners()
+	if len(listeners) != 2 {
+		t.Fatalf("listeners %v, want one per port", listeners)
+	}
+	svc := h.service(name)
+	for port, arn := range leftover {
+		if listeners[port] != arn || svc.Annotations[listenerAnnotation+".http"] != arn {
+			t.Errorf("listeners %v and annotations %v, want the listener %s left behind reused", listeners, svc.Annotations, arn)
+		}
+	}
+	if allocations := h.store.ListAllocations(ctx); len(allocations) != 2 {
+		t.Errorf("allocations %+v, want one per port", allocations)
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+var tracer = otel.Tracer("github.com/chinmayrelkar/aws-nlb-controller/tracing")
+
+// Client returns a client that records a span for every call of c. Reads
+// served from the cache of the manager are traced too, so their spans are
+// short.
+func Client(c client.Client) client.Client {
+	return tracedClient{Client: c}
+}
+
+type tracedClient struct {
+	client.Client
+}
+
+// start starts the span of a call on obj, named after the verb and the kind
+// of obj.
+func (t tracedClient) start(ctx context.Context, verb string, obj client.Object, key client.ObjectKey) (context.Context, trace.Span) {
+	kind := "unknown"
+	if gvk, err := apiutil.GVKForObject(obj, t.Scheme()); err == nil {
+		kind = gvk.Kind
+	}
+	return tracer.Start(ctx, "kube."+verb+" "+kind, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("namespace", key.Namespace),
+		attribute.String("name", key.Name),
+	))
+}
+
+// end records err on span and ends it.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t tracedClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	ctx, span := t.start(ctx, "Get", obj, key)
+	err := t.Client.Get(ctx, key, obj, opts...)
+	end(span, err)
+	return err
+}
+
+func (t tracedClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	ctx, span := tracer.Start(ctx, "kube.List", trace.WithSpanKind(trace.SpanKindClient))
+	err := t.Client.List(ctx, list, opts...)
+	end(span, err)
+	return err
+}
+
+func (t tracedClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	ctx, span := t.start(ctx, "Create", obj, client.ObjectKeyFromObject(obj))
+	err := t.Client.Create(ctx, obj, opts...)
+	end(span, err)
+	return err
+}
+
+func (t tracedClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	ctx, span := t.start(ctx, "Update", obj, client.ObjectKeyFromObject(obj))
+	err := t.Client.Update(ctx, obj, opts...)
+	end(span, err)
+	return err
+}
+
+func (t tracedClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	ctx, span := t.start(ctx, "Patch", obj, client.ObjectKeyFromObject(obj))
+	err := t.Client.Patch(ctx, obj, patch, opts...)
+	end(span, err)
+	return err
+}
+
+func (t tracedClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	ctx, span := t.start(ctx, "Delete", obj, client.ObjectKeyFromObject(obj))
+	err := t.Client.Delete(ctx, obj, opts...)
+	end(span, err)
+	return err
+}
+
+func (t tracedClient) Status() client.StatusWriter {
+	return tracedStatusWriter{StatusWriter: t.Client.Status(), client: t}
+}
+
+type tracedStatusWriter struct {
+	client.StatusWriter
+	client tracedClient
+}
+
+func (t tracedStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	ctx, span := t.client.start(ctx, "UpdateStatus", obj, client.ObjectKeyFromObject(obj))
+	err := t.StatusWriter.Update(ctx, obj, opts...)
+	end(span, err)
+	return err
+}
+
+func (t tracedStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	ctx, span := t.client.start(ctx, "PatchStatus", obj, client.ObjectKeyFromObject(obj))
+	err := t.StatusWriter.Patch(ctx, obj, patch, opts...)
+	end(span, err)
+	return err
+}
@@ -0,0 +1,57 @@
+// Package tracing exports the OpenTelemetry spans of the controller over
+// OTLP. Reconciles, store calls, AWS calls and Kubernetes API calls each get
+// a span, so that a slow reconcile shows where its time went.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// ServiceName is the service.name of the spans of the controller.
+const ServiceName = "aws-nlb-controller"
+
+// Options configure the export of spans.
+type Options struct {
+	// Endpoint is the host:port of the OTLP gRPC collector.
+	Endpoint string
+	// Insecure sends spans without TLS.
+	Insecure bool
+	// SampleRatio is the fraction of reconciles that are traced, between 0
+	// and 1.
+	SampleRatio float64
+}
+
+// Setup exports spans to the collector of opts through the global tracer
+// provider. The returned function flushes the spans not exported yet and
+// stops the export.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	clientOptions := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(opts.Endpoint)}
+	if opts.Insecure {
+		clientOptions = append(clientOptions, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, clientOptions...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceNameKey.String(ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}